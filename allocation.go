@@ -0,0 +1,154 @@
+package tome
+
+import (
+	"errors"
+	"math/bits"
+)
+
+var ErrInvalidFIFOPercent = errors.New("FIFO percent has to be between 0 and 100")
+
+// Allocator distributes an incoming quantity between resting orders at a single price level.
+//
+// Resting quantities are unfilled quantities of the resting orders sorted by time priority (oldest first).
+// Returned allocations have to be in the same order, must not exceed the resting quantities and their sum must not
+// exceed qty.
+type Allocator interface {
+	Allocate(qty int64, resting []int64) []int64
+}
+
+// FIFO allocation - the oldest order at a price level is filled first. Default order book allocation.
+type FIFOAllocator struct{}
+
+func (f FIFOAllocator) Allocate(qty int64, resting []int64) []int64 {
+	allocations := make([]int64, len(resting))
+	allocateFIFO(qty, resting, allocations)
+	return allocations
+}
+
+// Pro-rata allocation - each order receives a share of the incoming quantity proportional to its resting quantity.
+// Residual lots left after rounding down are allocated one by one in time priority.
+type ProRataAllocator struct{}
+
+func (p ProRataAllocator) Allocate(qty int64, resting []int64) []int64 {
+	allocations := make([]int64, len(resting))
+	allocateProRata(qty, resting, allocations)
+	return allocations
+}
+
+// Pro-rata allocation with top order priority - the oldest order at a price level is filled first (up to TopOrderMax
+// lots, unlimited if TopOrderMax is 0), the rest of the quantity is allocated pro-rata between all remaining quantities.
+type TopOrderProRataAllocator struct {
+	TopOrderMax int64
+}
+
+func (t TopOrderProRataAllocator) Allocate(qty int64, resting []int64) []int64 {
+	allocations := make([]int64, len(resting))
+	if len(resting) == 0 {
+		return allocations
+	}
+	top := min(qty, resting[0])
+	if t.TopOrderMax > 0 {
+		top = min(top, t.TopOrderMax)
+	}
+	allocations[0] = top
+	allocateProRata(qty-top, remaining(resting, allocations), allocations)
+	return allocations
+}
+
+// FIFO/pro-rata split - FIFOPercent of the incoming quantity (rounded down) is allocated in time priority,
+// the rest is allocated pro-rata between remaining resting quantities. Create it with NewSplitAllocator to validate
+// the percentage.
+type SplitAllocator struct {
+	FIFOPercent int64 // 0-100
+}
+
+// Create a FIFO/pro-rata split allocator, returns ErrInvalidFIFOPercent if fifoPercent is outside of [0, 100].
+func NewSplitAllocator(fifoPercent int64) (SplitAllocator, error) {
+	if fifoPercent < 0 || fifoPercent > 100 {
+		return SplitAllocator{}, ErrInvalidFIFOPercent
+	}
+	return SplitAllocator{FIFOPercent: fifoPercent}, nil
+}
+
+func (s SplitAllocator) Allocate(qty int64, resting []int64) []int64 {
+	allocations := make([]int64, len(resting))
+	percent := min(s.FIFOPercent, 100) // a percentage outside of the range would over-allocate or overflow
+	if percent < 0 {
+		percent = 0
+	}
+	allocateFIFO(mulDiv(qty, percent, 100), resting, allocations)
+	allocateProRata(qty-sum(allocations), remaining(resting, allocations), allocations)
+	return allocations
+}
+
+// allocate qty in time priority and add it to allocations
+func allocateFIFO(qty int64, resting, allocations []int64) {
+	for i, r := range resting {
+		if qty <= 0 {
+			return
+		}
+		allocated := min(qty, r)
+		allocations[i] += allocated
+		qty -= allocated
+	}
+}
+
+// allocate qty proportionally to resting quantities and add it to allocations
+func allocateProRata(qty int64, resting, allocations []int64) {
+	total := sum(resting)
+	if qty <= 0 || total == 0 {
+		return
+	}
+	if qty >= total { // everything can be filled
+		for i, r := range resting {
+			allocations[i] += r
+		}
+		return
+	}
+	shares := make([]int64, len(resting))
+	residual := qty
+	for i, r := range resting {
+		shares[i] = mulDiv(qty, r, total) // rounded down, never exceeds r because qty < total
+		residual -= shares[i]
+	}
+	// residual lots are allocated one by one in time priority, skipping orders which have already been allocated in full
+	for residual > 0 {
+		for i, r := range resting {
+			if residual == 0 {
+				break
+			}
+			if shares[i] < r {
+				shares[i] += 1
+				residual -= 1
+			}
+		}
+	}
+	for i, share := range shares {
+		allocations[i] += share
+	}
+}
+
+// returns resting quantities reduced by allocations
+func remaining(resting, allocations []int64) []int64 {
+	result := make([]int64, len(resting))
+	for i := range resting {
+		result[i] = resting[i] - allocations[i]
+	}
+	return result
+}
+
+// returns a*b/c rounded down without overflowing the intermediate product, all values have to be positive
+func mulDiv(a, b, c int64) int64 {
+	hi, lo := bits.Mul64(uint64(a), uint64(b))
+	quo, _ := bits.Div64(hi, lo, uint64(c))
+	return int64(quo)
+}
+
+// return a sum of int64s
+func sum(values []int64) int64 {
+	var total int64
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
@@ -0,0 +1,87 @@
+package tome
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAllocators(t *testing.T) {
+	tests := []struct {
+		name      string
+		allocator Allocator
+		qty       int64
+		resting   []int64
+		expected  []int64
+	}{
+		{"fifo", FIFOAllocator{}, 25, []int64{10, 10, 10}, []int64{10, 10, 5}},
+		{"pro rata", ProRataAllocator{}, 50, []int64{100, 300, 600}, []int64{5, 15, 30}},
+		{"pro rata residual", ProRataAllocator{}, 10, []int64{10, 10, 10}, []int64{4, 3, 3}},
+		{"pro rata fill all", ProRataAllocator{}, 40, []int64{10, 10, 10}, []int64{10, 10, 10}},
+		{"pro rata residual skips full orders", ProRataAllocator{}, 6, []int64{1, 1, 5}, []int64{1, 1, 4}},
+		{"top order", TopOrderProRataAllocator{}, 30, []int64{10, 20, 60}, []int64{10, 5, 15}},
+		{"top order capped", TopOrderProRataAllocator{TopOrderMax: 5}, 30, []int64{10, 20, 60}, []int64{7, 6, 17}},
+		{"split", SplitAllocator{FIFOPercent: 40}, 50, []int64{20, 40, 40}, []int64{20, 15, 15}},
+		{"split no fifo", SplitAllocator{}, 50, []int64{100, 300, 600}, []int64{5, 15, 30}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			allocations := test.allocator.Allocate(test.qty, test.resting)
+			if !reflect.DeepEqual(allocations, test.expected) {
+				t.Errorf("expected allocations %v, got %v", test.expected, allocations)
+			}
+		})
+	}
+}
+
+func TestAllocators_Bounds(t *testing.T) {
+	allocators := []Allocator{FIFOAllocator{}, ProRataAllocator{}, TopOrderProRataAllocator{TopOrderMax: 3}, SplitAllocator{FIFOPercent: 33}}
+	resting := []int64{7, 1, 13, 2, 29, 5}
+	for _, allocator := range allocators {
+		for qty := int64(0); qty <= sum(resting)+5; qty++ {
+			allocations := allocator.Allocate(qty, resting)
+			for i := range allocations {
+				if allocations[i] < 0 || allocations[i] > resting[i] {
+					t.Fatalf("%T: invalid allocation %d for resting qty %d", allocator, allocations[i], resting[i])
+				}
+			}
+			if expected := min(qty, sum(resting)); sum(allocations) != expected {
+				t.Fatalf("%T: expected %d allocated, got %d", allocator, expected, sum(allocations))
+			}
+		}
+	}
+}
+
+func TestNewSplitAllocator(t *testing.T) {
+	for _, test := range []struct {
+		percent int64
+		err     error
+	}{
+		{0, nil},
+		{100, nil},
+		{-1, ErrInvalidFIFOPercent},
+		{101, ErrInvalidFIFOPercent},
+	} {
+		allocator, err := NewSplitAllocator(test.percent)
+		if err != test.err {
+			t.Errorf("%d%%: expected %v, got %v", test.percent, test.err, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		expected := []int64{5, 15, 30} // pro rata
+		if test.percent == 100 {
+			expected = []int64{50, 0, 0}
+		}
+		if allocations := allocator.Allocate(50, []int64{100, 300, 600}); !reflect.DeepEqual(allocations, expected) {
+			t.Errorf("%d%%: expected allocations %v, got %v", test.percent, expected, allocations)
+		}
+	}
+
+	// allocators created without validation don't over-allocate
+	for _, percent := range []int64{-1, 101} {
+		if allocations := (SplitAllocator{FIFOPercent: percent}).Allocate(10, []int64{5, 5}); sum(allocations) != 10 {
+			t.Errorf("%d%%: expected 10 allocated, got %v", percent, allocations)
+		}
+	}
+}
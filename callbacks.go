@@ -10,3 +10,11 @@ type OrderCallback interface {
 type TradeCallback interface {
 	Execute(trade Trade)
 }
+
+func (f OrderCallbackFunc) Execute(order Order) {
+	f(order)
+}
+
+func (f TradeCallbackFunc) Execute(trade Trade) {
+	f(trade)
+}
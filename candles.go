@@ -0,0 +1,214 @@
+package tome
+
+import (
+	"github.com/cockroachdb/apd"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Common candle intervals.
+const (
+	Interval1s = time.Second
+	Interval1m = time.Minute
+	Interval5m = 5 * time.Minute
+	Interval1h = time.Hour
+	Interval1d = 24 * time.Hour
+)
+
+// Number of closed candles kept per interval by default, e.g. a day of 1 minute candles.
+const DefaultCandleRetention = 1440
+
+// used for divisions which can't be exact (e.g. VWAP)
+var divisionContext = BaseContext.WithPrecision(34)
+
+// OHLCV bar of all trades in a time interval.
+type Candle struct {
+	Start    time.Time // start of the interval, intervals are aligned to the Unix epoch in UTC
+	Interval time.Duration
+
+	Open, High, Low, Close apd.Decimal
+	Volume                 int64       // traded quantity
+	Turnover               apd.Decimal // sum of trade totals
+	VWAP                   apd.Decimal // volume weighted average price
+	Trades                 int         // number of trades
+}
+
+// Returns the end of the candle interval (exclusive).
+func (c Candle) End() time.Time {
+	return c.Start.Add(c.Interval)
+}
+
+func (c *Candle) add(trade Trade) {
+	if c.Trades == 0 {
+		c.Open, c.High, c.Low = trade.Price, trade.Price, trade.Price
+	}
+	if trade.Price.Cmp(&c.High) > 0 {
+		c.High = trade.Price
+	}
+	if trade.Price.Cmp(&c.Low) < 0 {
+		c.Low = trade.Price
+	}
+	c.Close = trade.Price
+	c.Volume += trade.Qty
+	c.Trades += 1
+
+	// new decimals, copies of the candle handed out earlier share the coefficients of the previous ones
+	var turnover, vwap apd.Decimal
+	_, _ = BaseContext.Add(&turnover, &c.Turnover, &trade.Total)
+	_, _ = divisionContext.Quo(&vwap, &turnover, apd.New(c.Volume, 0))
+	c.Turnover, c.VWAP = turnover, vwap
+}
+
+// Handles candles when their interval ends.
+type CandleHandler func(candle Candle)
+
+// Aggregates trades into candles of configured intervals. Register it on a trade book with WithTradeCallback.
+//
+// Trades are expected in timestamp order (the order in which an order book executes them). A candle is closed when the
+// first trade of a later interval arrives or when Advance is called with a time after its end. Intervals without trades
+// don't have candles. Only the latest DefaultCandleRetention closed candles of each interval are kept.
+type CandleAggregator struct {
+	mutex     sync.RWMutex
+	intervals []time.Duration
+	retention int
+	closed    map[time.Duration][]Candle
+	current   map[time.Duration]*Candle
+	handlers  []CandleHandler
+}
+
+// Create a new candle aggregator for the intervals.
+func NewCandleAggregator(intervals ...time.Duration) *CandleAggregator {
+	c := &CandleAggregator{
+		intervals: intervals,
+		retention: DefaultCandleRetention,
+		closed:    make(map[time.Duration][]Candle),
+		current:   make(map[time.Duration]*Candle),
+	}
+	return c
+}
+
+// Keep the latest n closed candles of each interval, older candles are evicted. Candles are kept forever if n is 0.
+func (c *CandleAggregator) SetRetention(n int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.retention = n
+	for interval := range c.closed {
+		c.evict(interval)
+	}
+}
+
+// Stream closed candles to the handler. Handlers are called synchronously when a candle is closed.
+func (c *CandleAggregator) Subscribe(handler CandleHandler) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.handlers = append(c.handlers, handler)
+}
+
+// Add a trade to the candles of all intervals, implements TradeCallback.
+func (c *CandleAggregator) Execute(trade Trade) {
+	closed := c.add(trade)
+	c.notify(closed)
+}
+
+func (c *CandleAggregator) add(trade Trade) []Candle {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	closed := make([]Candle, 0)
+	for _, interval := range c.intervals {
+		start := intervalStart(trade.Timestamp, interval)
+		candle := c.current[interval]
+		if candle != nil && start.After(candle.Start) {
+			closed = append(closed, c.close(interval))
+			candle = nil
+		}
+		if candle == nil {
+			candle = &Candle{Start: start, Interval: interval}
+			c.current[interval] = candle
+		}
+		candle.add(trade)
+	}
+	return closed
+}
+
+// Close all candles which end before or at now, e.g. on a timer when there are no trades.
+func (c *CandleAggregator) Advance(now time.Time) {
+	c.mutex.Lock()
+	closed := make([]Candle, 0)
+	for _, interval := range c.intervals {
+		if candle := c.current[interval]; candle != nil && !now.Before(candle.End()) {
+			closed = append(closed, c.close(interval))
+		}
+	}
+	c.mutex.Unlock()
+	c.notify(closed)
+}
+
+// close the current candle of an interval, has to be called under the lock
+func (c *CandleAggregator) close(interval time.Duration) Candle {
+	candle := *c.current[interval]
+	c.closed[interval] = append(c.closed[interval], candle)
+	c.evict(interval)
+	delete(c.current, interval)
+	return candle
+}
+
+// drop the oldest closed candles of an interval over the retention, has to be called under the lock
+func (c *CandleAggregator) evict(interval time.Duration) {
+	if closed := c.closed[interval]; c.retention > 0 && len(closed) > c.retention {
+		c.closed[interval] = closed[len(closed)-c.retention:] // the evicted prefix is released once the slice grows
+	}
+}
+
+// returns the start of the interval containing t, intervals are aligned to the Unix epoch
+func intervalStart(t time.Time, interval time.Duration) time.Time {
+	ns := t.UnixNano()
+	offset := ns % int64(interval)
+	if offset < 0 { // times before the epoch
+		offset += int64(interval)
+	}
+	return time.Unix(0, ns-offset).UTC()
+}
+
+func (c *CandleAggregator) notify(closed []Candle) {
+	if len(closed) == 0 {
+		return
+	}
+	c.mutex.RLock()
+	handlers := c.handlers
+	c.mutex.RUnlock()
+	for _, candle := range closed {
+		for _, handler := range handlers {
+			handler(candle)
+		}
+	}
+}
+
+// Get candles of an interval which start in the [from, to) time range, including the current (not closed) candle.
+// Zero times are unbounded.
+func (c *CandleAggregator) Candles(interval time.Duration, from, to time.Time) []Candle {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	all := c.closed[interval]
+	if current := c.current[interval]; current != nil {
+		all = append(all[:len(all):len(all)], *current)
+	}
+	first := 0
+	if !from.IsZero() {
+		first = sort.Search(len(all), func(i int) bool {
+			return !all[i].Start.Before(from)
+		})
+	}
+	last := len(all)
+	if !to.IsZero() {
+		last = sort.Search(len(all), func(i int) bool {
+			return !all[i].Start.Before(to)
+		})
+	}
+	if last < first {
+		last = first
+	}
+	return append([]Candle(nil), all[first:last]...)
+}
@@ -0,0 +1,113 @@
+package tome
+
+import (
+	"github.com/cockroachdb/apd"
+	"testing"
+	"time"
+)
+
+func TestCandleAggregator(t *testing.T) {
+	candles := NewCandleAggregator(Interval1m, Interval1h)
+	closed := make([]Candle, 0)
+	candles.Subscribe(func(candle Candle) {
+		closed = append(closed, candle)
+	})
+	tb := NewTradeBook(instrument, WithTradeCallback(candles))
+
+	start := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	for _, trade := range []struct {
+		offset time.Duration
+		price  int64
+		qty    int64
+	}{
+		{5 * time.Second, 2010, 10},
+		{20 * time.Second, 2015, 5},
+		{40 * time.Second, 2005, 5},
+		{50 * time.Second, 2008, 20},
+		{3*time.Minute + time.Second, 2020, 10},
+	} {
+		tb.Enter(Trade{Instrument: instrument, Qty: trade.qty, Price: *apd.New(trade.price, -2), Total: *apd.New(trade.price*trade.qty, -2), Timestamp: start.Add(trade.offset)})
+	}
+
+	if len(closed) != 1 {
+		t.Fatalf("expected 1 closed candle, got %d", len(closed))
+	}
+	c := closed[0]
+	expectDecimal := func(name string, actual apd.Decimal, expected *apd.Decimal) {
+		if actual.Cmp(expected) != 0 {
+			t.Errorf("expected %s %s, got %s", name, expected.String(), actual.String())
+		}
+	}
+	if !c.Start.Equal(start) || c.Interval != Interval1m {
+		t.Errorf("expected a 1m candle starting at %s, got %s %s", start, c.Interval, c.Start)
+	}
+	expectDecimal("open", c.Open, apd.New(2010, -2))
+	expectDecimal("high", c.High, apd.New(2015, -2))
+	expectDecimal("low", c.Low, apd.New(2005, -2))
+	expectDecimal("close", c.Close, apd.New(2008, -2))
+	expectDecimal("turnover", c.Turnover, apd.New(80360, -2))
+	expectDecimal("vwap", c.VWAP, apd.New(2009, -2))
+	if c.Volume != 40 || c.Trades != 4 {
+		t.Errorf("expected volume 40 from 4 trades, got %d from %d", c.Volume, c.Trades)
+	}
+
+	hourly := candles.Candles(Interval1h, time.Time{}, time.Time{})
+	if len(hourly) != 1 || hourly[0].Trades != 5 || hourly[0].Volume != 50 {
+		t.Errorf("expected the current hourly candle with 5 trades, got %+v", hourly)
+	}
+	minutes := candles.Candles(Interval1m, start.Add(time.Minute), start.Add(time.Hour))
+	if len(minutes) != 1 || !minutes[0].Start.Equal(start.Add(3*time.Minute)) {
+		t.Errorf("expected the current 1m candle, got %+v", minutes)
+	}
+
+	candles.Advance(start.Add(time.Hour))
+	if len(closed) != 3 {
+		t.Fatalf("expected all candles to be closed, got %d", len(closed))
+	}
+	if len(candles.Candles(Interval1m, time.Time{}, time.Time{})) != 2 {
+		t.Errorf("expected 2 closed 1m candles")
+	}
+}
+
+func TestCandleAggregator_Alignment(t *testing.T) {
+	candles := NewCandleAggregator(7 * time.Hour)
+	tb := NewTradeBook(instrument, WithTradeCallback(candles))
+	tb.Enter(Trade{Instrument: instrument, Qty: 10, Price: *apd.New(2010, -2), Total: *apd.New(20100, -2), Timestamp: time.Unix(100000, 0)})
+
+	expected := time.Unix(7*3600*3, 0).UTC() // 100000 s is in the 4th 7h interval since the epoch
+	if current := candles.Candles(7*time.Hour, time.Time{}, time.Time{}); len(current) != 1 || !current[0].Start.Equal(expected) {
+		t.Errorf("expected a candle starting at %s, got %+v", expected, current)
+	}
+}
+
+func TestCandleAggregator_Retention(t *testing.T) {
+	candles := NewCandleAggregator(Interval1m)
+	candles.SetRetention(2)
+	tb := NewTradeBook(instrument, WithTradeCallback(candles))
+
+	start := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		tb.Enter(Trade{Instrument: instrument, Qty: 10, Price: *apd.New(2010, -2), Total: *apd.New(20100, -2), Timestamp: start.Add(time.Duration(i) * time.Minute)})
+	}
+	candles.Advance(start.Add(time.Hour))
+
+	kept := candles.Candles(Interval1m, time.Time{}, time.Time{})
+	if len(kept) != 2 || !kept[0].Start.Equal(start.Add(3*time.Minute)) || !kept[1].Start.Equal(start.Add(4*time.Minute)) {
+		t.Errorf("expected the last 2 candles to be kept, got %+v", kept)
+	}
+}
+
+func TestCandleAggregator_CandlesDontChange(t *testing.T) {
+	candles := NewCandleAggregator(Interval1m)
+	tb := NewTradeBook(instrument, WithTradeCallback(candles))
+
+	start := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	tb.Enter(Trade{Instrument: instrument, Qty: 10, Price: *apd.New(2010, -2), Total: *apd.New(20100, -2), Timestamp: start})
+	current := candles.Candles(Interval1m, time.Time{}, time.Time{})[0]
+	turnover, vwap := current.Turnover.String(), current.VWAP.String()
+
+	tb.Enter(Trade{Instrument: instrument, Qty: 10, Price: *apd.New(2030, -2), Total: *apd.New(20300, -2), Timestamp: start.Add(time.Second)})
+	if current.Turnover.String() != turnover || current.VWAP.String() != vwap {
+		t.Errorf("expected a returned candle to keep turnover %s and VWAP %s, got %s and %s", turnover, vwap, current.Turnover.String(), current.VWAP.String())
+	}
+}
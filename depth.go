@@ -0,0 +1,128 @@
+package tome
+
+import "github.com/cockroachdb/apd"
+
+var half = apd.New(5, -1)
+
+// Aggregated price level, contains only displayed quantities.
+type DepthLevel struct {
+	Price apd.Decimal
+	Qty   int64 // total displayed quantity
+	Count int   // number of orders with a displayed quantity
+}
+
+// Level 2 market depth - displayed price levels from the best to the worst price. Doesn't contain any order or customer
+// data, so it can be published as market data.
+type Depth struct {
+	Bids, Asks []DepthLevel
+}
+
+// Get up to levels best displayed price levels of each side, all levels if levels <= 0.
+// Hidden orders and hidden parts of iceberg orders aren't included, market orders don't have a price level.
+func (o *OrderBook) Depth(levels int) Depth {
+	o.orderMutex.RLock()
+	defer o.orderMutex.RUnlock()
+	return Depth{
+		Bids: o.depth(SideBuy, levels),
+		Asks: o.depth(SideSell, levels),
+	}
+}
+
+func (o *OrderBook) depth(side OrderSide, levels int) []DepthLevel {
+	depth := make([]DepthLevel, 0)
+	for iter := o.orders.Levels(side); iter.Valid() && (levels <= 0 || len(depth) < levels); iter.Next() {
+		level := iter.Value()
+		if level.Type != TypeLimit || level.DisplayQty == 0 {
+			continue
+		}
+		depth = append(depth, DepthLevel{
+			Price: FromFixedPrice(level.Price, o.priceScale),
+			Qty:   level.DisplayQty,
+			Count: level.DisplayCount,
+		})
+	}
+	return depth
+}
+
+// Top of book - best displayed bid and ask levels read at the same time.
+type Quote struct {
+	Bid, Ask       DepthLevel
+	HasBid, HasAsk bool // false if a side doesn't have any displayed limit orders
+}
+
+// Returns the difference between the best ask and the best bid. Returns false if any side is empty.
+func (q Quote) Spread() (apd.Decimal, bool) {
+	var spread apd.Decimal
+	if !q.HasBid || !q.HasAsk {
+		return spread, false
+	}
+	_, err := BaseContext.Sub(&spread, &q.Ask.Price, &q.Bid.Price)
+	return spread, err == nil
+}
+
+// Returns the midpoint between the best bid and the best ask. Returns false if any side is empty.
+func (q Quote) Mid() (apd.Decimal, bool) {
+	var sum, mid apd.Decimal
+	if !q.HasBid || !q.HasAsk {
+		return mid, false
+	}
+	if _, err := BaseContext.Add(&sum, &q.Ask.Price, &q.Bid.Price); err != nil {
+		return mid, false
+	}
+	_, err := BaseContext.Mul(&mid, &sum, half)
+	return mid, err == nil
+}
+
+// Get the best bid and ask, consistent with each other. Resting market orders and hidden quantities are ignored.
+func (o *OrderBook) Quote() Quote {
+	o.orderMutex.RLock()
+	defer o.orderMutex.RUnlock()
+	var q Quote
+	q.Bid, q.HasBid = o.best(SideBuy)
+	q.Ask, q.HasAsk = o.best(SideSell)
+	return q
+}
+
+// Get the best displayed bid level. Returns false if there are no displayed limit bids.
+func (o *OrderBook) BestBid() (DepthLevel, bool) {
+	o.orderMutex.RLock()
+	defer o.orderMutex.RUnlock()
+	return o.best(SideBuy)
+}
+
+// Get the best displayed ask level. Returns false if there are no displayed limit asks.
+func (o *OrderBook) BestAsk() (DepthLevel, bool) {
+	o.orderMutex.RLock()
+	defer o.orderMutex.RUnlock()
+	return o.best(SideSell)
+}
+
+// Returns the difference between the best ask and the best bid. Returns false if any side is empty.
+func (o *OrderBook) Spread() (apd.Decimal, bool) {
+	return o.Quote().Spread()
+}
+
+// Returns the midpoint between the best bid and the best ask. Returns false if any side is empty.
+func (o *OrderBook) Mid() (apd.Decimal, bool) {
+	return o.Quote().Mid()
+}
+
+// returns the best displayed limit price level, has to be called under the order lock
+func (o *OrderBook) best(side OrderSide) (DepthLevel, bool) {
+	level := o.bestLevel(side)
+	if level == nil {
+		return DepthLevel{}, false
+	}
+	return DepthLevel{Price: FromFixedPrice(level.Price, o.priceScale), Qty: level.DisplayQty, Count: level.DisplayCount}, true
+}
+
+// returns the best price level with displayed limit orders or nil, has to be called under the order lock.
+// Market orders are always in the first level, so at most a few levels are visited unless levels are fully hidden.
+func (o *OrderBook) bestLevel(side OrderSide) *priceLevel {
+	for iter := o.orders.Levels(side); iter.Valid(); iter.Next() {
+		if level := iter.Value(); level.Type == TypeLimit && level.DisplayQty > 0 {
+			return level
+		}
+	}
+	return nil
+}
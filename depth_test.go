@@ -0,0 +1,106 @@
+package tome
+
+import (
+	"github.com/cockroachdb/apd"
+	"testing"
+)
+
+func TestOrderBook_Depth(t *testing.T) {
+	_, ob := setup(2025, -2)
+
+	orders := []Order{
+		createOrder(1, TypeLimit, 0, 5, *apd.New(2010, -2), apd.Decimal{}, SideSell),
+		createOrder(2, TypeLimit, 0, 5, *apd.New(2011, -2), apd.Decimal{}, SideSell),
+		createOrder(3, TypeLimit, 0, 10, *apd.New(2010, -2), apd.Decimal{}, SideSell),
+		createOrder(4, TypeLimit, ParamHidden, 7, *apd.New(2012, -2), apd.Decimal{}, SideSell),
+		createOrder(5, TypeLimit, 0, 4, *apd.New(2000, -2), apd.Decimal{}, SideBuy),
+		createOrder(6, TypeLimit, ParamHidden, 4, *apd.New(2000, -2), apd.Decimal{}, SideBuy),
+		createOrder(7, TypeLimit, 0, 3, *apd.New(1990, -2), apd.Decimal{}, SideBuy),
+	}
+	orders[2].DisplayQty = 2 // iceberg order
+	for _, order := range orders {
+		if _, err := ob.Add(order); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assertLevels := func(name string, levels []DepthLevel, expected [][3]int64) {
+		if len(levels) != len(expected) {
+			t.Fatalf("%s: expected %d levels, got %+v", name, len(expected), levels)
+		}
+		for i, level := range levels {
+			price := apd.New(expected[i][0], -2)
+			if level.Price.Cmp(price) != 0 || level.Qty != expected[i][1] || int64(level.Count) != expected[i][2] {
+				t.Errorf("%s: expected level %v, got %s %d %d", name, expected[i], level.Price.String(), level.Qty, level.Count)
+			}
+		}
+	}
+
+	depth := ob.Depth(0)
+	assertLevels("asks", depth.Asks, [][3]int64{{2010, 7, 2}, {2011, 5, 1}}) // hidden level 20.12 isn't displayed
+	assertLevels("bids", depth.Bids, [][3]int64{{2000, 4, 1}, {1990, 3, 1}})
+
+	// the visible order at 20.10 is filled, the iceberg displays at most 2 of its remaining quantity
+	if _, err := ob.Add(createOrder(8, TypeLimit, 0, 12, *apd.New(2010, -2), apd.Decimal{}, SideBuy)); err != nil {
+		t.Fatal(err)
+	}
+	depth = ob.Depth(1)
+	assertLevels("asks after a match", depth.Asks, [][3]int64{{2010, 2, 1}})
+	assertLevels("bids after a match", depth.Bids, [][3]int64{{2000, 4, 1}})
+
+	if _, err := ob.Add(createOrder(9, TypeLimit, 0, 13, *apd.New(2010, -2), apd.Decimal{}, SideBuy)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ob.Amend(9, 13, *apd.New(2009, -2)); err != nil {
+		t.Fatal(err)
+	}
+	depth = ob.Depth(2)
+	assertLevels("bids after an amendment", depth.Bids, [][3]int64{{2009, 10, 1}, {2000, 4, 1}})
+}
+
+func TestOrderBook_Quote(t *testing.T) {
+	_, ob := setup(2025, -2)
+
+	if _, ok := ob.Spread(); ok {
+		t.Error("expected no spread in an empty book")
+	}
+	orders := []Order{
+		createOrder(1, TypeMarket, 0, 5, apd.Decimal{}, apd.Decimal{}, SideBuy), // resting market orders are ignored
+		createOrder(2, TypeLimit, 0, 5, *apd.New(2000, -2), apd.Decimal{}, SideBuy),
+		createOrder(3, TypeLimit, ParamHidden, 5, *apd.New(2005, -2), apd.Decimal{}, SideBuy),
+	}
+	for _, order := range orders {
+		if _, err := ob.Add(order); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bid, ok := ob.BestBid()
+	if !ok || bid.Price.Cmp(apd.New(2000, -2)) != 0 || bid.Qty != 5 {
+		t.Errorf("expected best bid 5 at 20.00, got %+v", bid)
+	}
+	if _, ok := ob.BestAsk(); ok {
+		t.Error("expected no asks")
+	}
+
+	// the market order is filled first, the rest of the ask stays in the book
+	if _, err := ob.Add(createOrder(4, TypeLimit, 0, 8, *apd.New(2011, -2), apd.Decimal{}, SideSell)); err != nil {
+		t.Fatal(err)
+	}
+	ask, ok := ob.BestAsk()
+	if !ok || ask.Price.Cmp(apd.New(2011, -2)) != 0 || ask.Qty != 3 {
+		t.Errorf("expected best ask 3 at 20.11, got %+v", ask)
+	}
+
+	quote := ob.Quote()
+	if !quote.HasBid || !quote.HasAsk {
+		t.Fatalf("expected a two-sided quote, got %+v", quote)
+	}
+	spread, _ := quote.Spread()
+	if spread.Cmp(apd.New(11, -2)) != 0 {
+		t.Errorf("expected spread 0.11, got %s", spread.String())
+	}
+	mid, _ := ob.Mid()
+	if mid.Cmp(apd.New(20055, -3)) != 0 {
+		t.Errorf("expected mid 20.055, got %s", mid.String())
+	}
+}
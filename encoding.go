@@ -0,0 +1,292 @@
+package tome
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/cockroachdb/apd"
+	"github.com/google/uuid"
+	"math"
+	"time"
+)
+
+var ErrInvalidEncoding = errors.New("invalid binary encoding")
+
+// Encode an order into a binary form.
+func (o Order) MarshalBinary() ([]byte, error) {
+	var e encoder
+	e.order(o)
+	return e.buf, nil
+}
+
+// Decode an order from a binary form.
+func (o *Order) UnmarshalBinary(data []byte) error {
+	d := decoder{buf: data}
+	order := d.order()
+	if err := d.finish(); err != nil {
+		return err
+	}
+	*o = order
+	return nil
+}
+
+// Encode a trade into a binary form.
+func (t Trade) MarshalBinary() ([]byte, error) {
+	var e encoder
+	e.trade(t)
+	return e.buf, nil
+}
+
+// Decode a trade from a binary form.
+func (t *Trade) UnmarshalBinary(data []byte) error {
+	d := decoder{buf: data}
+	trade := d.trade()
+	if err := d.finish(); err != nil {
+		return err
+	}
+	*t = trade
+	return nil
+}
+
+// Appends values to a byte slice in big endian order.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) uint8(v uint8) {
+	e.buf = append(e.buf, v)
+}
+
+func (e *encoder) bool(v bool) {
+	if v {
+		e.uint8(1)
+	} else {
+		e.uint8(0)
+	}
+}
+
+func (e *encoder) uint16(v uint16) {
+	e.buf = append(e.buf, byte(v>>8), byte(v))
+}
+
+func (e *encoder) uint32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *encoder) uint64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *encoder) int64(v int64) {
+	e.uint64(uint64(v))
+}
+
+func (e *encoder) bytes(v []byte) {
+	e.uint32(uint32(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *encoder) string(v string) {
+	e.uint16(uint16(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *encoder) uuid(v uuid.UUID) {
+	e.buf = append(e.buf, v[:]...)
+}
+
+// times are stored as nanoseconds since Epoch, zero time is stored as math.MinInt64
+func (e *encoder) time(v time.Time) {
+	if v.IsZero() {
+		e.int64(math.MinInt64)
+		return
+	}
+	e.int64(v.UnixNano())
+}
+
+func (e *encoder) decimal(v apd.Decimal) {
+	e.uint8(uint8(v.Form))
+	e.bool(v.Negative)
+	e.uint32(uint32(v.Exponent))
+	coeff := v.Coeff.Bytes()
+	e.uint16(uint16(len(coeff)))
+	e.buf = append(e.buf, coeff...)
+}
+
+func (e *encoder) order(o Order) {
+	e.uint64(o.ID)
+	e.string(o.Instrument)
+	e.uuid(o.CustomerID)
+	e.time(o.Timestamp)
+	e.uint8(uint8(o.Type))
+	e.uint64(uint64(o.Params))
+	e.int64(o.Qty)
+	e.int64(o.FilledQty)
+	e.decimal(o.Price)
+	e.decimal(o.StopPrice)
+	e.bool(bool(o.Side))
+	e.bool(o.Cancelled)
+	e.int64(o.DisplayQty)
+	e.time(o.ExpireTime)
+}
+
+func (e *encoder) trade(t Trade) {
+	e.uint64(t.ID)
+	e.uuid(t.Buyer)
+	e.uuid(t.Seller)
+	e.string(t.Instrument)
+	e.int64(t.Qty)
+	e.decimal(t.Price)
+	e.decimal(t.Total)
+	e.time(t.Timestamp)
+	e.uint64(t.BidOrderID)
+	e.uint64(t.AskOrderID)
+	e.bool(bool(t.AggressorSide))
+	e.decimal(t.BuyerFee)
+	e.decimal(t.SellerFee)
+}
+
+// Reads values written by an encoder. The first error is kept and all subsequent reads return zero values.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.buf) < n {
+		d.err = ErrInvalidEncoding
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+// returns an error if decoding failed or if there are unread bytes
+func (d *decoder) finish() error {
+	if d.err == nil && len(d.buf) != 0 {
+		d.err = ErrInvalidEncoding
+	}
+	return d.err
+}
+
+func (d *decoder) uint8() uint8 {
+	b := d.next(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *decoder) bool() bool {
+	return d.uint8() != 0
+}
+
+func (d *decoder) uint16() uint16 {
+	b := d.next(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func (d *decoder) uint32() uint32 {
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (d *decoder) uint64() uint64 {
+	b := d.next(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+func (d *decoder) int64() int64 {
+	return int64(d.uint64())
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uint32()
+	b := d.next(int(n))
+	if b == nil {
+		return nil
+	}
+	return append([]byte(nil), b...)
+}
+
+func (d *decoder) string() string {
+	n := d.uint16()
+	return string(d.next(int(n)))
+}
+
+func (d *decoder) uuid() uuid.UUID {
+	var v uuid.UUID
+	copy(v[:], d.next(len(v)))
+	return v
+}
+
+func (d *decoder) time() time.Time {
+	ns := d.int64()
+	if ns == math.MinInt64 || d.err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+func (d *decoder) decimal() apd.Decimal {
+	var v apd.Decimal
+	v.Form = apd.Form(d.uint8())
+	v.Negative = d.bool()
+	v.Exponent = int32(d.uint32())
+	n := d.uint16()
+	v.Coeff.SetBytes(d.next(int(n)))
+	return v
+}
+
+func (d *decoder) order() Order {
+	var o Order
+	o.ID = d.uint64()
+	o.Instrument = d.string()
+	o.CustomerID = d.uuid()
+	o.Timestamp = d.time()
+	o.Type = OrderType(d.uint8())
+	o.Params = OrderParams(d.uint64())
+	o.Qty = d.int64()
+	o.FilledQty = d.int64()
+	o.Price = d.decimal()
+	o.StopPrice = d.decimal()
+	o.Side = OrderSide(d.bool())
+	o.Cancelled = d.bool()
+	o.DisplayQty = d.int64()
+	o.ExpireTime = d.time()
+	return o
+}
+
+func (d *decoder) trade() Trade {
+	var t Trade
+	t.ID = d.uint64()
+	t.Buyer = d.uuid()
+	t.Seller = d.uuid()
+	t.Instrument = d.string()
+	t.Qty = d.int64()
+	t.Price = d.decimal()
+	t.Total = d.decimal()
+	t.Timestamp = d.time()
+	t.BidOrderID = d.uint64()
+	t.AskOrderID = d.uint64()
+	t.AggressorSide = OrderSide(d.bool())
+	t.BuyerFee = d.decimal()
+	t.SellerFee = d.decimal()
+	return t
+}
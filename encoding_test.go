@@ -0,0 +1,67 @@
+package tome
+
+import (
+	"github.com/cockroachdb/apd"
+	"github.com/google/uuid"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOrder_MarshalBinary(t *testing.T) {
+	order := Order{
+		ID:         42,
+		Instrument: instrument,
+		CustomerID: uuid.New(),
+		Timestamp:  time.Unix(0, time.Now().UnixNano()),
+		Type:       TypeLimit,
+		Params:     ParamStop | ParamAON | ParamGTD,
+		Qty:        100,
+		FilledQty:  20,
+		Price:      *apd.New(-2025, -2),
+		StopPrice:  *apd.New(2, 3),
+		Side:       SideSell,
+		Cancelled:  true,
+		ExpireTime: time.Unix(0, time.Now().Add(time.Hour).UnixNano()),
+	}
+	data, err := order.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Order
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(order, decoded) {
+		t.Errorf("expected %+v, got %+v", order, decoded)
+	}
+
+	if err := decoded.UnmarshalBinary(data[:len(data)-1]); err != ErrInvalidEncoding {
+		t.Errorf("expected %v for truncated data, got %v", ErrInvalidEncoding, err)
+	}
+}
+
+func TestTrade_MarshalBinary(t *testing.T) {
+	trade := Trade{
+		ID:            7,
+		Buyer:         uuid.New(),
+		Seller:        uuid.New(),
+		Instrument:    instrument,
+		Qty:           5,
+		Price:         *apd.New(2025, -2),
+		BidOrderID:    1,
+		AskOrderID:    2,
+		AggressorSide: SideBuy,
+	}
+	data, err := trade.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Trade
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(trade, decoded) {
+		t.Errorf("expected %+v, got %+v", trade, decoded)
+	}
+}
@@ -33,10 +33,39 @@ type settings struct {
 	instructionPrompt bool
 }
 
+const instrument = "TEST"
+
+const usage = `usage:
+	cli                   - interactive order book
+	cli record <journal>  - interactive order book, all commands are journaled
+	cli replay <journal>  - replay a journal and verify trades and the final order book
+`
+
+// Prints the usage and exits with status 2 if the arguments don't match it.
 func main() {
-	const instrument = "TEST"
+	var opts []tome.OrderBookOption
+	if len(os.Args) > 1 {
+		if len(os.Args) != 3 {
+			exitUsage()
+		}
+		switch os.Args[1] {
+		case "record":
+			journal, err := tome.OpenJournal(os.Args[2], tome.JournalConfig{Sync: tome.SyncEveryRecord})
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer journal.Close()
+			opts = append(opts, tome.WithJournal(journal))
+		case "replay":
+			replay(os.Args[2])
+			return
+		default:
+			exitUsage()
+		}
+	}
+
 	tb := tome.NewTradeBook(instrument)
-	ob := tome.NewOrderBook(instrument, *apd.New(2025, -2), tb, tome.NOPOrderRepository)
+	ob := tome.NewOrderBook(instrument, *apd.New(2025, -2), tb, tome.NOPOrderRepository, opts...)
 
 	s := settings{
 		printEvent:        printAlways,
@@ -116,6 +145,30 @@ func main() {
 	}
 }
 
+func exitUsage() {
+	fmt.Fprint(os.Stderr, usage)
+	os.Exit(2)
+}
+
+func replay(path string) {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+
+	clock := &tome.SimulatedClock{}
+	tb := tome.NewTradeBook(instrument)
+	ob := tome.NewOrderBook(instrument, *apd.New(2025, -2), tb, tome.NOPOrderRepository, tome.WithClock(clock.Now))
+	result, err := tome.Replay(file, ob, clock)
+	print(ob, tb)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("replayed %d commands up to sequence %d: %d trades and %d active orders match the journal\n",
+		result.Commands, result.LastSequence, result.Trades, result.Orders)
+}
+
 func updateSettings(s *settings, split []string) {
 	switch split[1] {
 	case "print":
@@ -175,15 +228,13 @@ func order(side tome.OrderSide, ob *tome.OrderBook, split []string) {
 	)
 	currentOrderID += 1
 
-	var price, stopPrice float64
+	var price, stopPrice apd.Decimal
 	var Type tome.OrderType
 	var err error
 	if split[orderType] == "market" {
-		price = 0
 		Type = tome.TypeMarket
 	} else if split[orderType] == "limit" {
-		price, err = strconv.ParseFloat(split[orderPrice], 64)
-		if err != nil {
+		if _, _, err = price.SetString(split[orderPrice]); err != nil {
 			panic(err)
 		}
 		Type = tome.TypeLimit
@@ -210,8 +261,7 @@ func order(side tome.OrderSide, ob *tome.OrderBook, split []string) {
 			params |= tome.ParamAON
 		case "stop":
 			params |= tome.ParamStop
-			stopPrice, err = strconv.ParseFloat(split[oParams+i+1], 64)
-			if err != nil {
+			if _, _, err = stopPrice.SetString(split[oParams+i+1]); err != nil {
 				panic(err)
 			}
 			i += 1
@@ -223,6 +273,8 @@ func order(side tome.OrderSide, ob *tome.OrderBook, split []string) {
 			params |= tome.ParamGTC
 		case "gfd":
 			params |= tome.ParamGFD
+		case "hidden":
+			params |= tome.ParamHidden
 		case "gtd":
 			params |= tome.ParamGTD
 			//time.Parse(time.RFC822Z, split[len(split)-1])
@@ -238,8 +290,8 @@ func order(side tome.OrderSide, ob *tome.OrderBook, split []string) {
 		Params:     params,
 		Qty:        int64(qty),
 		FilledQty:  0,
-		Price:      *apd.New(int64(price*10000), -4),
-		StopPrice:  *apd.New(int64(stopPrice*10000), -4),
+		Price:      price,
+		StopPrice:  stopPrice,
 		Side:       side,
 		Cancelled:  false,
 	}
@@ -267,14 +319,10 @@ func print(ob *tome.OrderBook, tb *tome.TradeBook) {
 
 func printTrades(trades []tome.Trade) {
 	writer := tablewriter.NewWriter(os.Stdout)
-	writer.SetHeader([]string{"time", "BidID", "AskID", "qty", "price", "total"})
+	writer.SetHeader([]string{"time", "BidID", "AskID", "aggressor", "qty", "price", "total"})
 	for _, trade := range trades {
-
-		price, _ := trade.Price.Float64()
-		qty := trade.Qty
-
 		writer.Append([]string{trade.Timestamp.String(), strconv.Itoa(int(trade.BidOrderID)), strconv.Itoa(int(trade.AskOrderID)),
-			strconv.Itoa(int(trade.Qty)), trade.Price.String(), strconv.FormatFloat(price*float64(qty), 'f', -1, 64)})
+			trade.AggressorSide.String(), strconv.Itoa(int(trade.Qty)), trade.Price.String(), trade.Total.String()})
 	}
 	writer.SetCaption(true, "trades")
 	writer.Render()
@@ -0,0 +1,212 @@
+package tome
+
+import (
+	"errors"
+	"github.com/cockroachdb/apd"
+	"github.com/google/uuid"
+	"sync"
+)
+
+var ErrInvalidFeeRate = errors.New("fee rate minimum and maximum can't be negative and the minimum can't exceed the maximum")
+
+// Calculates the fee of a trade participant. Negative fees are rebates.
+type FeeModel interface {
+	Fee(trade Trade, customerID uuid.UUID, liquidity Liquidity) apd.Decimal
+}
+
+// Calculate fees of both trade participants with the fee model. Fees are rounded half up to the price scale of the
+// order book.
+func WithFeeModel(model FeeModel) OrderBookOption {
+	return func(o *OrderBook) {
+		o.fees = model
+	}
+}
+
+// Fee rates relative to the trade total.
+type FeeRate struct {
+	Maker apd.Decimal // fee rate of the resting order, negative rates are rebates
+	Taker apd.Decimal // fee rate of the incoming order
+	Min   apd.Decimal // minimum charged fee, doesn't apply to rebates
+	Max   apd.Decimal // maximum fee or rebate amount, no cap if zero
+}
+
+// Returns ErrInvalidFeeRate if Min or Max is negative or Min exceeds a non-zero Max.
+func (r FeeRate) Validate() error {
+	if r.Min.Negative || r.Max.Negative || (!r.Max.IsZero() && r.Min.Cmp(&r.Max) > 0) {
+		return ErrInvalidFeeRate
+	}
+	return nil
+}
+
+// Applies the rate to a trade total.
+func (r FeeRate) Fee(total apd.Decimal, liquidity Liquidity) apd.Decimal {
+	rate := r.Taker
+	if liquidity == LiquidityMaker {
+		rate = r.Maker
+	}
+	var fee apd.Decimal
+	_, _ = BaseContext.Mul(&fee, &total, &rate)
+	if fee.Sign() > 0 && fee.Cmp(&r.Min) < 0 {
+		fee = r.Min
+	}
+	if !r.Max.IsZero() {
+		var amount apd.Decimal
+		amount.Abs(&fee)
+		if amount.Cmp(&r.Max) > 0 {
+			negative := fee.Negative
+			fee = r.Max
+			fee.Negative = negative
+		}
+	}
+	return fee
+}
+
+// round a decimal half up to scale decimal places, decimals which are already precise enough are kept as they are
+func roundHalfUp(d apd.Decimal, scale int32) apd.Decimal {
+	if d.Exponent >= -scale {
+		return d
+	}
+	ctx := BaseContext
+	ctx.Precision = uint32(d.NumDigits()) // quantizing needs a precision, rounding never adds digits
+	ctx.Rounding = apd.RoundHalfUp
+	var rounded apd.Decimal
+	_, _ = ctx.Quantize(&rounded, &d, -scale)
+	return rounded
+}
+
+type feeKey struct {
+	instrument string
+	tier       string
+}
+
+// Fee model with rates per instrument and customer tier. The most specific rate is used - instrument and tier,
+// any instrument and tier, instrument and no tier, any instrument and no tier. Fees are zero if no rate applies.
+type FeeSchedule struct {
+	mutex sync.RWMutex
+	rates map[feeKey]FeeRate
+	tiers map[uuid.UUID]string
+}
+
+// Create a new fee schedule without any rates.
+func NewFeeSchedule() *FeeSchedule {
+	return &FeeSchedule{
+		rates: make(map[feeKey]FeeRate),
+		tiers: make(map[uuid.UUID]string),
+	}
+}
+
+// Set the rate of an instrument and customer tier. An empty instrument or tier applies to all instruments or to
+// customers without a tier. Invalid rates are rejected with ErrInvalidFeeRate.
+func (f *FeeSchedule) SetRate(instrument, tier string, rate FeeRate) error {
+	if err := rate.Validate(); err != nil {
+		return err
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.rates[feeKey{instrument: instrument, tier: tier}] = rate
+	return nil
+}
+
+// Assign a customer to a tier, an empty tier removes the customer from its tier.
+func (f *FeeSchedule) SetTier(customerID uuid.UUID, tier string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if tier == "" {
+		delete(f.tiers, customerID)
+		return
+	}
+	f.tiers[customerID] = tier
+}
+
+// Returns the rate which applies to a customer trading an instrument.
+func (f *FeeSchedule) Rate(instrument string, customerID uuid.UUID) (FeeRate, bool) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	tier := f.tiers[customerID]
+	for _, key := range []feeKey{{instrument, tier}, {"", tier}, {instrument, ""}, {"", ""}} {
+		if rate, ok := f.rates[key]; ok {
+			return rate, true
+		}
+	}
+	return FeeRate{}, false
+}
+
+func (f *FeeSchedule) Fee(trade Trade, customerID uuid.UUID, liquidity Liquidity) apd.Decimal {
+	rate, ok := f.Rate(trade.Instrument, customerID)
+	if !ok {
+		return apd.Decimal{}
+	}
+	return rate.Fee(trade.Total, liquidity)
+}
+
+// Fees of a customer aggregated for billing.
+type CustomerFees struct {
+	Fees    apd.Decimal // sum of charged fees
+	Rebates apd.Decimal // sum of paid rebates as a positive amount
+	Net     apd.Decimal // Fees - Rebates
+	Trades  int         // number of trades the customer participated in
+}
+
+// sums are calculated into new decimals, copies handed out earlier share the coefficients of the previous ones
+func (c *CustomerFees) add(fee apd.Decimal) {
+	c.Trades += 1
+	var net, sum apd.Decimal
+	_, _ = BaseContext.Add(&net, &c.Net, &fee)
+	c.Net = net
+	if fee.Negative {
+		_, _ = BaseContext.Sub(&sum, &c.Rebates, &fee)
+		c.Rebates = sum
+	} else {
+		_, _ = BaseContext.Add(&sum, &c.Fees, &fee)
+		c.Fees = sum
+	}
+}
+
+// Aggregates trade fees per customer. Register it on a trade book with WithTradeCallback.
+type FeeLedger struct {
+	mutex     sync.RWMutex
+	customers map[uuid.UUID]*CustomerFees
+}
+
+// Create a new empty fee ledger.
+func NewFeeLedger() *FeeLedger {
+	return &FeeLedger{customers: make(map[uuid.UUID]*CustomerFees)}
+}
+
+// Add trade fees of both participants, implements TradeCallback.
+func (f *FeeLedger) Execute(trade Trade) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.customer(trade.Buyer).add(trade.BuyerFee)
+	f.customer(trade.Seller).add(trade.SellerFee)
+}
+
+func (f *FeeLedger) customer(customerID uuid.UUID) *CustomerFees {
+	fees, ok := f.customers[customerID]
+	if !ok {
+		fees = &CustomerFees{}
+		f.customers[customerID] = fees
+	}
+	return fees
+}
+
+// Returns aggregated fees of a customer.
+func (f *FeeLedger) Fees(customerID uuid.UUID) CustomerFees {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	if fees, ok := f.customers[customerID]; ok {
+		return *fees
+	}
+	return CustomerFees{}
+}
+
+// Returns aggregated fees of all customers.
+func (f *FeeLedger) All() map[uuid.UUID]CustomerFees {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	all := make(map[uuid.UUID]CustomerFees, len(f.customers))
+	for customerID, fees := range f.customers {
+		all[customerID] = *fees
+	}
+	return all
+}
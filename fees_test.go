@@ -0,0 +1,145 @@
+package tome
+
+import (
+	"github.com/cockroachdb/apd"
+	"github.com/google/uuid"
+	"testing"
+)
+
+func TestFeeRate_Fee(t *testing.T) {
+	rate := FeeRate{Maker: *apd.New(-1, -4), Taker: *apd.New(3, -4), Min: *apd.New(1, -2), Max: *apd.New(5, 0)}
+	for _, c := range []struct {
+		total     *apd.Decimal
+		liquidity Liquidity
+		expected  *apd.Decimal
+	}{
+		{apd.New(1000, 0), LiquidityTaker, apd.New(3, -1)},
+		{apd.New(1000, 0), LiquidityMaker, apd.New(-1, -1)},
+		{apd.New(10, 0), LiquidityTaker, apd.New(1, -2)},     // minimum
+		{apd.New(10, 0), LiquidityMaker, apd.New(-1, -3)},    // minimum doesn't apply to rebates
+		{apd.New(100000, 0), LiquidityTaker, apd.New(5, 0)},  // cap
+		{apd.New(100000, 0), LiquidityMaker, apd.New(-5, 0)}, // rebate cap
+	} {
+		fee := rate.Fee(*c.total, c.liquidity)
+		if fee.Cmp(c.expected) != 0 {
+			t.Errorf("expected %s %s fee of %s, got %s", c.liquidity, c.total.String(), c.expected.String(), fee.String())
+		}
+	}
+}
+
+func TestFeeRate_Validate(t *testing.T) {
+	for _, c := range []struct {
+		rate  FeeRate
+		valid bool
+	}{
+		{FeeRate{}, true},
+		{FeeRate{Min: *apd.New(1, -2), Max: *apd.New(5, 0)}, true},
+		{FeeRate{Min: *apd.New(1, -2)}, true}, // no cap
+		{FeeRate{Min: *apd.New(6, 0), Max: *apd.New(5, 0)}, false},
+		{FeeRate{Min: *apd.New(-1, 0)}, false},
+		{FeeRate{Max: *apd.New(-1, 0)}, false},
+	} {
+		if err := NewFeeSchedule().SetRate(instrument, "", c.rate); (err == nil) != c.valid {
+			t.Errorf("expected rate with min %s and max %s to be valid: %t, got %v", c.rate.Min.String(), c.rate.Max.String(), c.valid, err)
+		}
+	}
+}
+
+func TestFeeSchedule_Rate(t *testing.T) {
+	vip, retail := uuid.New(), uuid.New()
+	schedule := NewFeeSchedule()
+	schedule.SetTier(vip, "vip")
+	if _, ok := schedule.Rate(instrument, retail); ok {
+		t.Errorf("expected no rate")
+	}
+	schedule.SetRate("", "", FeeRate{Taker: *apd.New(5, 0)})
+	schedule.SetRate(instrument, "", FeeRate{Taker: *apd.New(4, 0)})
+	schedule.SetRate("", "vip", FeeRate{Taker: *apd.New(2, 0)})
+	schedule.SetRate(instrument, "vip", FeeRate{Taker: *apd.New(1, 0)})
+	for _, c := range []struct {
+		instrument string
+		customerID uuid.UUID
+		expected   int64
+	}{{instrument, vip, 1}, {"OTHER", vip, 2}, {instrument, retail, 4}, {"OTHER", retail, 5}} {
+		rate, _ := schedule.Rate(c.instrument, c.customerID)
+		if rate.Taker.Cmp(apd.New(c.expected, 0)) != 0 {
+			t.Errorf("expected %s rate %d, got %s", c.instrument, c.expected, rate.Taker.String())
+		}
+	}
+}
+
+func TestOrderBook_Fees(t *testing.T) {
+	maker, taker := uuid.New(), uuid.New()
+	schedule := NewFeeSchedule()
+	schedule.SetRate(instrument, "", FeeRate{Maker: *apd.New(-1, -3), Taker: *apd.New(2, -3)})
+	ledger := NewFeeLedger()
+	tb := NewTradeBook(instrument, WithTradeCallback(ledger))
+	ob := NewOrderBook(instrument, *apd.New(2025, -2), tb, NOPOrderRepository, WithFeeModel(schedule))
+
+	ask := createOrder(1, TypeLimit, 0, 20, *apd.New(25, 0), apd.Decimal{}, SideSell)
+	ask.CustomerID = maker
+	bid := createOrder(2, TypeLimit, 0, 10, *apd.New(25, 0), apd.Decimal{}, SideBuy)
+	bid.CustomerID = taker
+	for _, order := range []Order{ask, bid} {
+		if _, err := ob.Add(order); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bid.ID = 3
+	if _, err := ob.Add(bid); err != nil {
+		t.Fatal(err)
+	}
+
+	trade := tb.DailyTrades()[0]
+	if trade.BuyerFee.Cmp(apd.New(5, -1)) != 0 || trade.SellerFee.Cmp(apd.New(-25, -2)) != 0 {
+		t.Errorf("expected buyer fee 0.5 and seller rebate 0.25, got %s and %s", trade.BuyerFee.String(), trade.SellerFee.String())
+	}
+	makerFees, takerFees := ledger.Fees(maker), ledger.Fees(taker)
+	if makerFees.Trades != 2 || makerFees.Rebates.Cmp(apd.New(5, -1)) != 0 || makerFees.Net.Cmp(apd.New(-5, -1)) != 0 {
+		t.Errorf("expected maker rebates of 0.5 from 2 trades, got %+v", makerFees)
+	}
+	if takerFees.Trades != 2 || takerFees.Fees.Cmp(apd.New(1, 0)) != 0 || takerFees.Net.Cmp(apd.New(1, 0)) != 0 {
+		t.Errorf("expected taker fees of 1 from 2 trades, got %+v", takerFees)
+	}
+	if len(ledger.All()) != 2 {
+		t.Errorf("expected fees of 2 customers")
+	}
+}
+
+func TestOrderBook_FeeRounding(t *testing.T) {
+	schedule := NewFeeSchedule()
+	if err := schedule.SetRate(instrument, "", FeeRate{Maker: *apd.New(-3, -5), Taker: *apd.New(3, -5)}); err != nil {
+		t.Fatal(err)
+	}
+	tb := NewTradeBook(instrument)
+	ob := NewOrderBook(instrument, *apd.New(2025, -2), tb, NOPOrderRepository, WithFeeModel(schedule))
+	for _, order := range []Order{
+		createOrder(1, TypeLimit, 0, 2, *apd.New(125, -1), apd.Decimal{}, SideSell),
+		createOrder(2, TypeLimit, 0, 2, *apd.New(125, -1), apd.Decimal{}, SideBuy),
+	} {
+		if _, err := ob.Add(order); err != nil {
+			t.Fatal(err)
+		}
+	}
+	trade := tb.DailyTrades()[0] // fees of 0.00075 are rounded to 4 decimal places
+	if trade.BuyerFee.Cmp(apd.New(8, -4)) != 0 || trade.SellerFee.Cmp(apd.New(-8, -4)) != 0 {
+		t.Errorf("expected buyer fee 0.0008 and seller rebate 0.0008, got %s and %s", trade.BuyerFee.String(), trade.SellerFee.String())
+	}
+}
+
+func TestFeeLedger_FeesDontChange(t *testing.T) {
+	ledger := NewFeeLedger()
+	buyer, seller := uuid.New(), uuid.New()
+	trade := Trade{Buyer: buyer, Seller: seller, BuyerFee: *apd.New(20100, -2), SellerFee: *apd.New(-20100, -2)}
+	ledger.Execute(trade)
+	ledger.Execute(trade)
+	fees, all := ledger.Fees(buyer), ledger.All()
+
+	ledger.Execute(trade)
+	if fees.Fees.Cmp(apd.New(402, 0)) != 0 || fees.Net.Cmp(apd.New(402, 0)) != 0 {
+		t.Errorf("expected returned fees to stay 402, got %s and net %s", fees.Fees.String(), fees.Net.String())
+	}
+	if rebates := all[seller].Rebates; rebates.Cmp(apd.New(402, 0)) != 0 {
+		t.Errorf("expected returned rebates to stay 402, got %s", rebates.String())
+	}
+}
@@ -0,0 +1,435 @@
+package tome
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	segmentExtension   = ".seg"
+	defaultSegmentSize = 64 << 20
+)
+
+var (
+	ErrTradeNotFound    = errors.New("trade not found")
+	ErrRepositoryClosed = errors.New("repository is closed")
+	ErrSegmentCorrupted = errors.New("repository segment is corrupted")
+)
+
+// File repository configuration.
+type FileRepositoryConfig struct {
+	SegmentSize int64 // a new segment is started when the current one exceeds SegmentSize bytes, 64 MiB if not set
+	Sync        bool  // fsync after every write
+}
+
+// Location of the latest record of an ID.
+type segmentLocation struct {
+	segment int
+	offset  int64
+	size    int64
+}
+
+// Segmented append-only log of records keyed by an ID with an in-memory index of the latest record of each ID.
+// Segments are files named by their sequence number, each record is stored as
+// length (4B) | CRC-32C of the body (4B) | body, where body is ID (8B) | payload.
+//
+// Segments are never compacted - superseded records keep using disk space and are read when the log is opened, so the
+// directory grows with every write. Old segments have to be archived or rewritten offline.
+type segmentLog struct {
+	dir    string
+	config FileRepositoryConfig
+
+	mutex    sync.RWMutex
+	segments []*os.File // all segments, the last one is being appended to
+	size     int64      // size of the last segment
+	index    map[uint64]segmentLocation
+	closed   bool
+	failure  error // a write which couldn't be undone, records can't be written
+}
+
+func openSegmentLog(dir string, config FileRepositoryConfig) (*segmentLog, error) {
+	if config.SegmentSize <= 0 {
+		config.SegmentSize = defaultSegmentSize
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0)
+	for _, info := range infos {
+		if !info.IsDir() && strings.HasSuffix(info.Name(), segmentExtension) {
+			names = append(names, info.Name())
+		}
+	}
+	sort.Strings(names) // names are zero padded sequence numbers
+
+	l := &segmentLog{dir: dir, config: config, index: make(map[uint64]segmentLocation)}
+	for i, name := range names {
+		file, err := os.OpenFile(filepath.Join(dir, name), os.O_RDWR, 0644)
+		if err != nil {
+			l.closeSegments()
+			return nil, err
+		}
+		l.segments = append(l.segments, file)
+		if err := l.load(i, i == len(names)-1); err != nil {
+			l.closeSegments()
+			return nil, err
+		}
+	}
+	if len(l.segments) == 0 {
+		if err := l.rotate(); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// index all records of a segment, an incomplete or corrupted tail of the last segment is truncated
+func (l *segmentLog) load(segment int, last bool) error {
+	file := l.segments[segment]
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		id, size, err := readSegmentRecord(reader)
+		if err == io.EOF {
+			break
+		}
+		if (err == io.ErrUnexpectedEOF || err == ErrSegmentCorrupted) && last {
+			if err := truncateTornRecord(file, offset, err); err != nil {
+				return err
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("segment %s at offset %d: %w", file.Name(), offset, err)
+		}
+		l.index[id] = segmentLocation{segment: segment, offset: offset, size: size}
+		offset += size
+	}
+	l.size = offset
+	_, err := file.Seek(offset, io.SeekStart)
+	return err
+}
+
+// Truncate an invalid record at the offset of the last segment if it's the last record. Valid records after an invalid
+// one mean that the segment was damaged and truncating it would lose them.
+func truncateTornRecord(file *os.File, offset int64, cause error) error {
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	rest, err := ioutil.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	if containsValidRecord(rest, 8, func([]byte) bool { return true }) {
+		return fmt.Errorf("segment %s: %w at offset %d, valid records follow", file.Name(), ErrSegmentCorrupted, offset)
+	}
+	log.Printf("truncating segment %s at offset %d: %v\n", file.Name(), offset, cause)
+	return file.Truncate(offset)
+}
+
+// start a new segment
+func (l *segmentLog) rotate() error {
+	name := filepath.Join(l.dir, fmt.Sprintf("%020d%s", len(l.segments), segmentExtension))
+	file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	l.segments = append(l.segments, file)
+	l.size = 0
+	return nil
+}
+
+// Append the latest record of an ID.
+func (l *segmentLog) write(id uint64, payload []byte) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.closed {
+		return ErrRepositoryClosed
+	}
+	if l.failure != nil {
+		return l.failure
+	}
+	if l.size >= l.config.SegmentSize {
+		if err := l.segments[len(l.segments)-1].Sync(); err != nil {
+			return err
+		}
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	bodySize := 8 + len(payload)
+	buf := make([]byte, recordHeaderSize+bodySize)
+	body := buf[recordHeaderSize:]
+	binary.BigEndian.PutUint64(body, id)
+	copy(body[8:], payload)
+	binary.BigEndian.PutUint32(buf, uint32(bodySize))
+	binary.BigEndian.PutUint32(buf[4:], crc32.Checksum(body, crcTable))
+
+	file := l.segments[len(l.segments)-1]
+	if _, err := file.Write(buf); err != nil {
+		l.undo(file, err)
+		return err
+	}
+	if l.config.Sync {
+		if err := file.Sync(); err != nil {
+			l.undo(file, err)
+			return err
+		}
+	}
+	l.index[id] = segmentLocation{segment: len(l.segments) - 1, offset: l.size, size: int64(len(buf))}
+	l.size += int64(len(buf))
+	return nil
+}
+
+// Remove a record which failed to be written or synced from the last segment, otherwise it would be loaded on the next
+// open and following records would be appended after torn bytes. The log stops accepting writes if that fails.
+func (l *segmentLog) undo(file *os.File, cause error) {
+	if _, err := file.Seek(l.size, io.SeekStart); err != nil || file.Truncate(l.size) != nil {
+		l.failure = fmt.Errorf("repository write failed: %w", cause)
+	}
+}
+
+// Read the latest payload of an ID. Returns false if there is no record for the ID.
+func (l *segmentLog) read(id uint64) ([]byte, bool, error) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	if l.closed {
+		return nil, false, ErrRepositoryClosed
+	}
+	location, ok := l.index[id]
+	if !ok {
+		return nil, false, nil
+	}
+	buf := make([]byte, location.size)
+	if _, err := l.segments[location.segment].ReadAt(buf, location.offset); err != nil {
+		return nil, false, err
+	}
+	body := buf[recordHeaderSize:]
+	if crc32.Checksum(body, crcTable) != binary.BigEndian.Uint32(buf[4:]) {
+		return nil, false, ErrSegmentCorrupted
+	}
+	return body[8:], true, nil
+}
+
+// Returns IDs of all records.
+func (l *segmentLog) ids() []uint64 {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	ids := make([]uint64, 0, len(l.index))
+	for id := range l.index {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (l *segmentLog) close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.closed {
+		return ErrRepositoryClosed
+	}
+	l.closed = true
+	var err error
+	if len(l.segments) > 0 {
+		err = l.segments[len(l.segments)-1].Sync()
+	}
+	if closeErr := l.closeSegments(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (l *segmentLog) closeSegments() error {
+	var err error
+	for _, segment := range l.segments {
+		if closeErr := segment.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// reads a segment record and returns its ID and size in bytes
+func readSegmentRecord(r io.Reader) (uint64, int64, error) {
+	var header [recordHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, 0, err
+	}
+	bodySize := binary.BigEndian.Uint32(header[:])
+	if bodySize < 8 || bodySize > maxRecordSize {
+		return 0, 0, ErrSegmentCorrupted
+	}
+	body := make([]byte, bodySize)
+	if _, err := io.ReadFull(r, body); err != nil {
+		if err == io.EOF {
+			return 0, 0, io.ErrUnexpectedEOF
+		}
+		return 0, 0, err
+	}
+	if crc32.Checksum(body, crcTable) != binary.BigEndian.Uint32(header[4:]) {
+		return 0, 0, ErrSegmentCorrupted
+	}
+	return binary.BigEndian.Uint64(body), int64(recordHeaderSize) + int64(bodySize), nil
+}
+
+// Order repository which stores orders in segmented append-only files in a directory.
+// Every Save appends the new order state, the in-memory index points to the latest one.
+// Queried fields of all orders are kept in memory, only orders on the requested page are read from the disk.
+type FileOrderRepository struct {
+	log *segmentLog
+
+	mutex     sync.RWMutex
+	summaries map[uint64]orderSummary
+}
+
+// order fields used by queries
+type orderSummary struct {
+	id         uint64
+	customerID uuid.UUID
+	instrument string
+	status     OrderStatus
+	timestamp  time.Time
+}
+
+func newOrderSummary(order Order) orderSummary {
+	return orderSummary{
+		id:         order.ID,
+		customerID: order.CustomerID,
+		instrument: order.Instrument,
+		status:     order.Status(),
+		timestamp:  order.Timestamp,
+	}
+}
+
+// Open a file order repository in a directory, create the directory if it doesn't exist.
+func OpenFileOrderRepository(dir string, config FileRepositoryConfig) (*FileOrderRepository, error) {
+	l, err := openSegmentLog(dir, config)
+	if err != nil {
+		return nil, err
+	}
+	f := &FileOrderRepository{log: l, summaries: make(map[uint64]orderSummary)}
+	for _, id := range l.ids() {
+		order, err := f.GetByID(id)
+		if err != nil {
+			l.close()
+			return nil, err
+		}
+		f.summaries[id] = newOrderSummary(order)
+	}
+	return f, nil
+}
+
+func (f *FileOrderRepository) Save(order Order) error {
+	payload, _ := order.MarshalBinary()
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if err := f.log.write(order.ID, payload); err != nil {
+		return err
+	}
+	f.summaries[order.ID] = newOrderSummary(order)
+	return nil
+}
+
+// List orders matching the query.
+func (f *FileOrderRepository) Query(query OrderQuery) ([]Order, error) {
+	f.mutex.RLock()
+	summaries := make([]orderSummary, 0)
+	for _, s := range f.summaries {
+		if query.matches(s.customerID, s.instrument, s.status, s.timestamp) {
+			summaries = append(summaries, s)
+		}
+	}
+	f.mutex.RUnlock()
+
+	sort.Slice(summaries, func(i, j int) bool {
+		return orderBefore(summaries[i].timestamp, summaries[i].id, summaries[j].timestamp, summaries[j].id)
+	})
+	start, end := query.page(len(summaries))
+	orders := make([]Order, 0, end-start)
+	for _, s := range summaries[start:end] {
+		order, err := f.GetByID(s.id)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+	return orders, nil
+}
+
+// Get the latest state of an order. Returns ErrOrderNotFound if the order was never saved.
+func (f *FileOrderRepository) GetByID(id uint64) (Order, error) {
+	payload, ok, err := f.log.read(id)
+	if err != nil {
+		return Order{}, err
+	}
+	if !ok {
+		return Order{}, ErrOrderNotFound
+	}
+	var order Order
+	err = order.UnmarshalBinary(payload)
+	return order, err
+}
+
+// Sync and close the repository.
+func (f *FileOrderRepository) Close() error {
+	return f.log.close()
+}
+
+// Trade repository which stores trades in segmented append-only files in a directory.
+type FileTradeRepository struct {
+	log *segmentLog
+}
+
+// Open a file trade repository in a directory, create the directory if it doesn't exist.
+func OpenFileTradeRepository(dir string, config FileRepositoryConfig) (*FileTradeRepository, error) {
+	l, err := openSegmentLog(dir, config)
+	if err != nil {
+		return nil, err
+	}
+	return &FileTradeRepository{log: l}, nil
+}
+
+func (f *FileTradeRepository) Store(trade Trade) error {
+	payload, _ := trade.MarshalBinary()
+	return f.log.write(trade.ID, payload)
+}
+
+// Get a trade by its ID. Returns ErrTradeNotFound if the trade was never stored.
+func (f *FileTradeRepository) GetByID(id uint64) (Trade, error) {
+	payload, ok, err := f.log.read(id)
+	if err != nil {
+		return Trade{}, err
+	}
+	if !ok {
+		return Trade{}, ErrTradeNotFound
+	}
+	var trade Trade
+	err = trade.UnmarshalBinary(payload)
+	return trade, err
+}
+
+// Sync and close the repository.
+func (f *FileTradeRepository) Close() error {
+	return f.log.close()
+}
@@ -0,0 +1,180 @@
+package tome
+
+import (
+	"bytes"
+	"errors"
+	"github.com/cockroachdb/apd"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileOrderRepository(t *testing.T) {
+	dir := t.TempDir()
+	config := FileRepositoryConfig{SegmentSize: 256} // a few orders per segment
+	repo, err := OpenFileOrderRepository(dir, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		order := createOrder(uint64(i+1), TypeLimit, 0, 10, *apd.New(2010, -2), apd.Decimal{}, SideSell)
+		if err := repo.Save(order); err != nil {
+			t.Fatal(err)
+		}
+		order.FilledQty = 5 // save a newer state
+		if err := repo.Save(order); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.Close(); err != nil {
+		t.Fatal(err)
+	}
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExtension))
+	if len(segments) < 2 {
+		t.Errorf("expected multiple segments, got %d", len(segments))
+	}
+
+	repo, err = OpenFileOrderRepository(dir, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+	for i := 0; i < 10; i++ {
+		order, err := repo.GetByID(uint64(i + 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if order.ID != uint64(i+1) || order.FilledQty != 5 {
+			t.Errorf("expected the latest state of order %d, got %+v", i+1, order)
+		}
+	}
+	if _, err := repo.GetByID(42); err != ErrOrderNotFound {
+		t.Errorf("expected %v, got %v", ErrOrderNotFound, err)
+	}
+}
+
+func TestFileTradeRepository_TruncateTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := OpenFileTradeRepository(dir, FileRepositoryConfig{Sync: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		trade := Trade{ID: uint64(i), Instrument: instrument, Qty: 5, Price: *apd.New(2010, -2), Timestamp: time.Now()}
+		if err := repo.Store(trade); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExtension))
+	info, err := os.Stat(segments[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(segments[0], info.Size()-3); err != nil { // simulate a crash while writing the last trade
+		t.Fatal(err)
+	}
+
+	repo, err = OpenFileTradeRepository(dir, FileRepositoryConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+	if _, err := repo.GetByID(2); err != ErrTradeNotFound {
+		t.Errorf("expected %v, got %v", ErrTradeNotFound, err)
+	}
+	trade, err := repo.GetByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trade.Qty != 5 || trade.Instrument != instrument {
+		t.Errorf("invalid trade %+v", trade)
+	}
+	if err := repo.Store(Trade{ID: 2, Instrument: instrument}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetByID(2); err != nil {
+		t.Errorf("expected the trade to be stored after truncation, got %v", err)
+	}
+}
+
+func TestFileTradeRepository_CorruptedSegment(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := OpenFileTradeRepository(dir, FileRepositoryConfig{SegmentSize: 1}) // a segment per trade
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := repo.Store(Trade{ID: uint64(i), Instrument: instrument, Qty: 5}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExtension))
+	data, err := ioutil.ReadFile(segments[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := ioutil.WriteFile(segments[0], data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenFileTradeRepository(dir, FileRepositoryConfig{}); !errors.Is(err, ErrSegmentCorrupted) {
+		t.Errorf("expected %v, got %v", ErrSegmentCorrupted, err)
+	}
+}
+
+func TestFileTradeRepository_CorruptedLastSegment(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := OpenFileTradeRepository(dir, FileRepositoryConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := repo.Store(Trade{ID: uint64(i), Instrument: instrument, Qty: 5}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExtension))
+	data, err := ioutil.ReadFile(segments[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[recordHeaderSize] ^= 0xff // ID of the first trade
+	if err := ioutil.WriteFile(segments[0], data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenFileTradeRepository(dir, FileRepositoryConfig{}); !errors.Is(err, ErrSegmentCorrupted) {
+		t.Errorf("expected %v, got %v", ErrSegmentCorrupted, err)
+	}
+	if after, _ := ioutil.ReadFile(segments[0]); !bytes.Equal(after, data) {
+		t.Errorf("expected a segment with corrupted records in the middle not to be truncated")
+	}
+}
+
+func TestFileTradeRepository_WriteError(t *testing.T) {
+	repo, err := OpenFileTradeRepository(t.TempDir(), FileRepositoryConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+	repo.log.segments[0].Close() // fail writes
+	if err := repo.Store(Trade{ID: 1, Instrument: instrument}); err == nil {
+		t.Fatal("expected the write to fail")
+	}
+	if err := repo.Store(Trade{ID: 2, Instrument: instrument}); err == nil {
+		t.Error("expected writes to fail after a write which couldn't be undone")
+	}
+}
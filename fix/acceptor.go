@@ -0,0 +1,221 @@
+// Package fix is a FIX 4.4 acceptor which routes orders of FIX sessions through an order entry server.
+//
+// Supported session messages are Logon, Logout, Heartbeat, TestRequest, ResendRequest, SequenceReset and Reject.
+// Supported application messages are NewOrderSingle, OrderCancelRequest and OrderCancelReplaceRequest, answered with
+// ExecutionReport and OrderCancelReject. Sequence numbers and the last ExecID of every session are persisted in a
+// SequenceStore. Sent messages of open orders are kept in memory for resends, messages of terminal orders and rejections
+// only within the resend window - messages which can't be resent are gap filled.
+package fix
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ffhan/tome/server"
+	"github.com/google/uuid"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultSendBuffer   = 1024
+	DefaultLogonTimeout = 10 * time.Second
+	DefaultResendWindow = 10000
+)
+
+var (
+	ErrAcceptorClosed = errors.New("acceptor is closed")
+	ErrUnknownSession = errors.New("unknown session")
+)
+
+// Acceptor configuration.
+type Config struct {
+	CompID       string          // SenderCompID of the acceptor
+	Sessions     []SessionConfig // allowed sessions
+	Store        SequenceStore   // persists sequence numbers, in memory if not set
+	SendBuffer   int             // number of outgoing messages a connection can buffer, DefaultSendBuffer if not set
+	LogonTimeout time.Duration   // time to wait for a Logon after a connection is accepted, DefaultLogonTimeout if not set
+	ResendWindow int             // number of messages of terminal orders kept for resends, DefaultResendWindow if not set
+	DayEnd       time.Duration   // time of the day (UTC) at which day orders expire, midnight if not set
+}
+
+// Returns the end of the trading day of an order entered at t.
+func (c Config) dayEnd(t time.Time) time.Time {
+	t = t.UTC()
+	end := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Add(c.DayEnd)
+	if !end.After(t) {
+		end = end.Add(24 * time.Hour)
+	}
+	return end
+}
+
+// Configuration of a session with a counterparty.
+type SessionConfig struct {
+	CompID     string    // SenderCompID of the counterparty
+	CustomerID uuid.UUID // owner of all orders entered in the session
+}
+
+// FIX acceptor. Sessions are created up front from the configuration and keep their sequence numbers, orders and sent
+// messages across connections.
+type Acceptor struct {
+	config Config
+
+	mutex    sync.Mutex
+	sessions map[string]*session // by counterparty CompID
+	listener net.Listener
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// Create an acceptor of the configured sessions which enters orders through the server.
+func NewAcceptor(srv *server.Server, config Config) (*Acceptor, error) {
+	if config.Store == nil {
+		config.Store = NewMemoryStore()
+	}
+	if config.SendBuffer <= 0 {
+		config.SendBuffer = DefaultSendBuffer
+	}
+	if config.LogonTimeout <= 0 {
+		config.LogonTimeout = DefaultLogonTimeout
+	}
+	if config.ResendWindow <= 0 {
+		config.ResendWindow = DefaultResendWindow
+	}
+	a := &Acceptor{config: config, sessions: make(map[string]*session)}
+	for _, sc := range config.Sessions {
+		s, err := newSession(a, srv, sc)
+		if err != nil {
+			return nil, err
+		}
+		a.sessions[sc.CompID] = s
+	}
+	return a, nil
+}
+
+// Returns the ID of the session with a counterparty, used as the sequence store key.
+func (a *Acceptor) SessionID(compID string) string {
+	return fmt.Sprintf("%s:%s->%s", BeginString, a.config.CompID, compID)
+}
+
+// Listen on a TCP address and accept connections in the background.
+func (a *Acceptor) ListenAndServe(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	if err := a.listen(listener); err != nil {
+		return err
+	}
+	go a.accept(listener)
+	return nil
+}
+
+// Accept connections from the listener until the acceptor is closed.
+func (a *Acceptor) Serve(listener net.Listener) error {
+	if err := a.listen(listener); err != nil {
+		return err
+	}
+	return a.accept(listener)
+}
+
+func (a *Acceptor) listen(listener net.Listener) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.closed {
+		listener.Close()
+		return ErrAcceptorClosed
+	}
+	a.listener = listener
+	return nil
+}
+
+func (a *Acceptor) accept(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			a.mutex.Lock()
+			closed := a.closed
+			a.mutex.Unlock()
+			if closed {
+				return ErrAcceptorClosed
+			}
+			return err
+		}
+		a.mutex.Lock()
+		if a.closed {
+			a.mutex.Unlock()
+			conn.Close()
+			return ErrAcceptorClosed
+		}
+		a.wg.Add(1)
+		a.mutex.Unlock()
+		go func() {
+			defer a.wg.Done()
+			a.handle(conn)
+		}()
+	}
+}
+
+// Returns the address the acceptor is listening on, nil if it isn't listening.
+func (a *Acceptor) Addr() net.Addr {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.listener == nil {
+		return nil
+	}
+	return a.listener.Addr()
+}
+
+// Log out all sessions, stop accepting connections and wait for connections to finish.
+func (a *Acceptor) Close() error {
+	a.mutex.Lock()
+	if a.closed {
+		a.mutex.Unlock()
+		return ErrAcceptorClosed
+	}
+	a.closed = true
+	var err error
+	if a.listener != nil {
+		err = a.listener.Close()
+	}
+	a.mutex.Unlock()
+	for _, s := range a.sessions {
+		s.logout("acceptor is shutting down")
+	}
+	a.wg.Wait()
+	for _, s := range a.sessions { // sessions which weren't logged on could have received messages of their orders
+		s.save()
+	}
+	return err
+}
+
+// handle a connection - wait for a valid Logon and run the session until the connection is closed
+func (a *Acceptor) handle(conn net.Conn) {
+	c := newConnection(conn, a.config.SendBuffer)
+	defer c.close()
+
+	conn.SetReadDeadline(time.Now().Add(a.config.LogonTimeout))
+	logon, err := ReadMessage(c.reader)
+	if err != nil {
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	if logon.Type() != MsgTypeLogon {
+		return
+	}
+	compID, _ := logon.Get(TagSenderCompID)
+	targetCompID, _ := logon.Get(TagTargetCompID)
+	a.mutex.Lock()
+	s, ok := a.sessions[compID]
+	a.mutex.Unlock()
+	if !ok || targetCompID != a.config.CompID {
+		a.logf("rejecting logon from %s: %v\n", conn.RemoteAddr(), ErrUnknownSession)
+		return
+	}
+	s.run(c, logon)
+}
+
+func (a *Acceptor) logf(format string, args ...interface{}) {
+	log.Printf(format, args...)
+}
@@ -0,0 +1,462 @@
+package fix
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/cockroachdb/apd"
+	"github.com/ffhan/tome"
+	"github.com/ffhan/tome/server"
+	"github.com/google/uuid"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	instrument = "TEST"
+	acceptorID = "TOME"
+)
+
+type testInitiator struct {
+	t      *testing.T
+	compID string
+	conn   net.Conn
+	reader *bufio.Reader
+	seq    int64
+}
+
+func setup(t *testing.T, store SequenceStore, compIDs ...string) *Acceptor {
+	return setupConfig(t, Config{Store: store}, compIDs...)
+}
+
+func setupConfig(t *testing.T, config Config, compIDs ...string) *Acceptor {
+	srv := server.New(server.WithExpiryInterval(10 * time.Millisecond))
+	tb := tome.NewTradeBook(instrument, tome.WithTradeCallback(srv))
+	ob := tome.NewOrderBook(instrument, *apd.New(2000, -2), tb, tome.NOPOrderRepository)
+	srv.Register(ob)
+
+	config.CompID = acceptorID
+	for _, compID := range compIDs {
+		config.Sessions = append(config.Sessions, SessionConfig{CompID: compID, CustomerID: uuid.New()})
+	}
+	acceptor, err := NewAcceptor(srv, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := acceptor.ListenAndServe("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		acceptor.Close()
+	})
+	return acceptor
+}
+
+func dial(t *testing.T, acceptor *Acceptor, compID string) *testInitiator {
+	conn, err := net.Dial("tcp", acceptor.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	return &testInitiator{t: t, compID: compID, conn: conn, reader: bufio.NewReader(conn), seq: 1}
+}
+
+func (i *testInitiator) logon(fields ...Field) *Message {
+	i.t.Helper()
+	logon := NewMessage(MsgTypeLogon).SetInt(TagHeartBtInt, 30)
+	for _, f := range fields {
+		logon.Set(f.Tag, f.Value)
+	}
+	i.send(logon)
+	return i.expect(MsgTypeLogon)
+}
+
+func (i *testInitiator) send(msg *Message) {
+	i.sendSeq(msg, i.seq)
+	i.seq += 1
+}
+
+func (i *testInitiator) sendSeq(msg *Message, seq int64) {
+	msg.Set(TagSenderCompID, i.compID).
+		Set(TagTargetCompID, acceptorID).
+		SetInt(TagMsgSeqNum, seq).
+		Set(TagSendingTime, FormatTime(time.Now()))
+	if _, err := i.conn.Write(msg.Bytes()); err != nil {
+		i.t.Fatal(err)
+	}
+}
+
+func (i *testInitiator) read() (*Message, error) {
+	i.conn.SetReadDeadline(time.Now().Add(time.Second))
+	return ReadMessage(i.reader)
+}
+
+func (i *testInitiator) expect(msgType string) *Message {
+	i.t.Helper()
+	msg, err := i.read()
+	if err != nil {
+		i.t.Fatalf("expected message type %s, got %v", msgType, err)
+	}
+	if msg.Type() != msgType {
+		i.t.Fatalf("expected message type %s, got %s", msgType, msg.Text())
+	}
+	return msg
+}
+
+func (i *testInitiator) expectField(msg *Message, tag int, expected string) {
+	i.t.Helper()
+	if actual, _ := msg.Get(tag); actual != expected {
+		i.t.Errorf("expected tag %d to be %q, got %q in %s", tag, expected, actual, msg.Text())
+	}
+}
+
+func newOrderSingle(clOrdID, side string, qty int64, price string) *Message {
+	return NewMessage(MsgTypeNewOrderSingle).
+		Set(TagClOrdID, clOrdID).
+		Set(TagSymbol, instrument).
+		Set(TagSide, side).
+		SetInt(TagOrderQty, qty).
+		Set(TagOrdType, "2").
+		Set(TagPrice, price).
+		Set(TagTransactTime, FormatTime(time.Now()))
+}
+
+func TestMessage_Bytes(t *testing.T) {
+	msg := NewMessage(MsgTypeHeartbeat).
+		Set(TagTestReqID, "1").
+		Set(TagSenderCompID, "A").
+		Set(TagTargetCompID, "B").
+		SetInt(TagMsgSeqNum, 2).
+		Set(TagSendingTime, "20200101-10:00:00.000")
+	expected := "8=FIX.4.4|9=51|35=0|49=A|56=B|34=2|52=20200101-10:00:00.000|112=1|10=048|"
+	if actual := msg.Text(); actual != expected {
+		t.Errorf("expected %s, got %s", expected, actual)
+	}
+
+	read, err := ReadMessage(bufio.NewReader(bytes.NewReader(msg.Bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Text() != expected {
+		t.Errorf("expected %s, got %s", expected, read.Text())
+	}
+
+	corrupted := msg.Bytes()
+	corrupted[len(corrupted)-3] = '9'
+	if _, err := ReadMessage(bufio.NewReader(bytes.NewReader(corrupted))); err != ErrInvalidChecksum {
+		t.Errorf("expected %v, got %v", ErrInvalidChecksum, err)
+	}
+}
+
+func TestAcceptor_Orders(t *testing.T) {
+	acceptor := setup(t, nil, "MAKER", "TAKER")
+	maker, taker := dial(t, acceptor, "MAKER"), dial(t, acceptor, "TAKER")
+	maker.logon()
+	taker.logon()
+
+	maker.send(newOrderSingle("m1", "2", 10, "20.25"))
+	report := maker.expect(MsgTypeExecutionReport)
+	maker.expectField(report, TagExecType, execNew)
+	maker.expectField(report, TagClOrdID, "m1")
+	orderID, _ := report.Get(TagOrderID)
+
+	taker.send(newOrderSingle("t1", "1", 4, "20.30").Set(TagTimeInForce, "3"))
+	report = taker.expect(MsgTypeExecutionReport)
+	taker.expectField(report, TagExecType, execNew)
+	report = taker.expect(MsgTypeExecutionReport)
+	taker.expectField(report, TagExecType, execTrade)
+	taker.expectField(report, TagOrdStatus, statusFilled)
+	taker.expectField(report, TagLastPx, "20.30")
+	taker.expectField(report, TagAvgPx, "20.3")
+
+	report = maker.expect(MsgTypeExecutionReport)
+	maker.expectField(report, TagExecType, execTrade)
+	maker.expectField(report, TagOrdStatus, statusPartiallyFilled)
+	maker.expectField(report, TagLeavesQty, "6")
+	maker.expectField(report, TagCumQty, "4")
+
+	maker.send(NewMessage(MsgTypeOrderCancelReplaceRequest).
+		Set(TagClOrdID, "m2").
+		Set(TagOrigClOrdID, "m1").
+		Set(TagSymbol, instrument).
+		Set(TagSide, "2").
+		SetInt(TagOrderQty, 8).
+		Set(TagOrdType, "2").
+		Set(TagPrice, "20.20"))
+	report = maker.expect(MsgTypeExecutionReport)
+	maker.expectField(report, TagExecType, execReplaced)
+	maker.expectField(report, TagClOrdID, "m2")
+	maker.expectField(report, TagOrigClOrdID, "m1")
+	maker.expectField(report, TagLeavesQty, "4")
+
+	maker.send(NewMessage(MsgTypeOrderCancelRequest).Set(TagClOrdID, "m3").Set(TagOrigClOrdID, "m1"))
+	reject := maker.expect(MsgTypeOrderCancelReject)
+	maker.expectField(reject, TagCxlRejResponseTo, "1")
+
+	maker.send(NewMessage(MsgTypeOrderCancelRequest).Set(TagClOrdID, "m3").Set(TagOrigClOrdID, "m2"))
+	report = maker.expect(MsgTypeExecutionReport)
+	maker.expectField(report, TagExecType, execCanceled)
+	maker.expectField(report, TagOrderID, orderID)
+	maker.expectField(report, TagClOrdID, "m3")
+	maker.expectField(report, TagOrigClOrdID, "m2")
+
+	taker.send(newOrderSingle("t1", "1", 2, "20.00"))
+	report = taker.expect(MsgTypeExecutionReport)
+	taker.expectField(report, TagExecType, execRejected)
+	taker.expectField(report, TagText, ErrDuplicateClOrdID.Error())
+
+	taker.send(newOrderSingle("t2", "1", 2, "20.00").Set(TagSymbol, "UNKNOWN"))
+	report = taker.expect(MsgTypeExecutionReport)
+	taker.expectField(report, TagExecType, execRejected)
+	taker.expectField(report, TagText, server.ErrUnknownInstrument.Error())
+}
+
+func TestAcceptor_Expiry(t *testing.T) {
+	acceptor := setup(t, nil, "CLIENT")
+	client := dial(t, acceptor, "CLIENT")
+	client.logon()
+
+	client.send(newOrderSingle("g1", "1", 5, "19.00").
+		Set(TagTimeInForce, "6").
+		Set(TagExpireTime, FormatTime(time.Now().Add(50*time.Millisecond))))
+	report := client.expect(MsgTypeExecutionReport)
+	client.expectField(report, TagExecType, execNew)
+	report = client.expect(MsgTypeExecutionReport)
+	client.expectField(report, TagExecType, execExpired)
+	client.expectField(report, TagOrdStatus, statusExpired)
+	client.expectField(report, TagClOrdID, "g1")
+	client.expectField(report, TagLeavesQty, "0")
+}
+
+func TestAcceptor_DayExpiry(t *testing.T) {
+	now := time.Now().UTC()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	acceptor := setupConfig(t, Config{DayEnd: now.Sub(midnight) + 50*time.Millisecond}, "CLIENT")
+	client := dial(t, acceptor, "CLIENT")
+	client.logon()
+
+	client.send(newOrderSingle("d1", "1", 5, "19.00").Set(TagTimeInForce, "0"))
+	report := client.expect(MsgTypeExecutionReport)
+	client.expectField(report, TagExecType, execNew)
+	report = client.expect(MsgTypeExecutionReport)
+	client.expectField(report, TagExecType, execExpired)
+	client.expectField(report, TagClOrdID, "d1")
+}
+
+func TestConfig_DayEnd(t *testing.T) {
+	config := Config{DayEnd: 22 * time.Hour}
+	before := time.Date(2024, 3, 1, 21, 0, 0, 0, time.UTC)
+	if end := config.dayEnd(before); !end.Equal(time.Date(2024, 3, 1, 22, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected day orders to expire today, got %s", end)
+	}
+	after := time.Date(2024, 3, 1, 22, 0, 0, 0, time.UTC)
+	if end := config.dayEnd(after); !end.Equal(time.Date(2024, 3, 2, 22, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected day orders to expire tomorrow, got %s", end)
+	}
+}
+
+func TestAcceptor_Logon(t *testing.T) {
+	acceptor := setup(t, nil, "CLIENT")
+
+	unknown := dial(t, acceptor, "UNKNOWN")
+	unknown.send(NewMessage(MsgTypeLogon).SetInt(TagHeartBtInt, 30))
+	if msg, err := unknown.read(); err == nil {
+		t.Errorf("expected the connection to be closed, got %s", msg.Text())
+	}
+
+	first := dial(t, acceptor, "CLIENT")
+	first.logon()
+	second := dial(t, acceptor, "CLIENT")
+	second.seq = 2
+	second.send(NewMessage(MsgTypeLogon).SetInt(TagHeartBtInt, 30))
+	second.expect(MsgTypeLogout)
+
+	first.send(NewMessage(MsgTypeTestRequest).Set(TagTestReqID, "ping"))
+	heartbeat := first.expect(MsgTypeHeartbeat)
+	first.expectField(heartbeat, TagTestReqID, "ping")
+
+	first.send(NewMessage(MsgTypeLogout))
+	first.expect(MsgTypeLogout)
+}
+
+func TestAcceptor_Resend(t *testing.T) {
+	acceptor := setup(t, nil, "CLIENT")
+	client := dial(t, acceptor, "CLIENT")
+	client.logon()
+	client.send(newOrderSingle("c1", "1", 5, "19.00"))
+	client.expect(MsgTypeExecutionReport)
+	client.send(NewMessage(MsgTypeTestRequest).Set(TagTestReqID, "ping"))
+	client.expect(MsgTypeHeartbeat)
+
+	// sequence numbers 1 (Logon), 2 (ExecutionReport) and 3 (Heartbeat) were sent
+	client.send(NewMessage(MsgTypeResendRequest).SetInt(TagBeginSeqNo, 1).SetInt(TagEndSeqNo, 0))
+	gapFill := client.expect(MsgTypeSequenceReset)
+	client.expectField(gapFill, TagMsgSeqNum, "1")
+	client.expectField(gapFill, TagNewSeqNo, "2")
+	resent := client.expect(MsgTypeExecutionReport)
+	client.expectField(resent, TagMsgSeqNum, "2")
+	client.expectField(resent, TagPossDupFlag, "Y")
+	client.expectField(resent, TagClOrdID, "c1")
+	gapFill = client.expect(MsgTypeSequenceReset)
+	client.expectField(gapFill, TagMsgSeqNum, "3")
+	client.expectField(gapFill, TagNewSeqNo, "4")
+
+	// a gap in incoming sequence numbers is requested to be resent
+	client.seq += 2
+	client.send(NewMessage(MsgTypeHeartbeat))
+	request := client.expect(MsgTypeResendRequest)
+	client.expectField(request, TagBeginSeqNo, "5")
+	client.sendSeq(NewMessage(MsgTypeSequenceReset).Set(TagGapFillFlag, "Y").Set(TagPossDupFlag, "Y").SetInt(TagNewSeqNo, client.seq), 5)
+	client.send(NewMessage(MsgTypeTestRequest).Set(TagTestReqID, "after gap"))
+	heartbeat := client.expect(MsgTypeHeartbeat)
+	client.expectField(heartbeat, TagTestReqID, "after gap")
+}
+
+func TestAcceptor_ResendWindow(t *testing.T) {
+	acceptor := setupConfig(t, Config{ResendWindow: 1}, "CLIENT")
+	client := dial(t, acceptor, "CLIENT")
+	client.logon()
+	client.send(newOrderSingle("c1", "1", 5, "19.00"))
+	client.expect(MsgTypeExecutionReport)
+	client.send(newOrderSingle("c2", "3", 5, "19.00"))
+	client.expect(MsgTypeExecutionReport)
+	client.send(newOrderSingle("c3", "3", 5, "19.00"))
+	client.expect(MsgTypeExecutionReport)
+
+	// the report of the open order c1 is kept, only the last rejection fits in the resend window
+	client.send(NewMessage(MsgTypeResendRequest).SetInt(TagBeginSeqNo, 2).SetInt(TagEndSeqNo, 0))
+	resent := client.expect(MsgTypeExecutionReport)
+	client.expectField(resent, TagMsgSeqNum, "2")
+	client.expectField(resent, TagClOrdID, "c1")
+	gapFill := client.expect(MsgTypeSequenceReset)
+	client.expectField(gapFill, TagMsgSeqNum, "3")
+	client.expectField(gapFill, TagNewSeqNo, "4")
+	resent = client.expect(MsgTypeExecutionReport)
+	client.expectField(resent, TagMsgSeqNum, "4")
+	client.expectField(resent, TagClOrdID, "c3")
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	store, err := OpenFileStore(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acceptor := setup(t, store, "CLIENT")
+	client := dial(t, acceptor, "CLIENT")
+	client.logon()
+	client.send(newOrderSingle("c1", "1", 5, "19.00"))
+	client.expect(MsgTypeExecutionReport)
+	acceptor.Close()
+	store.Close()
+
+	store, err = OpenFileStore(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		store.Close()
+	})
+	nextIn, nextOut, execID, err := store.Load(acceptor.SessionID("CLIENT"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nextIn != 3 || nextOut != 4 { // Logon and NewOrderSingle in, Logon, ExecutionReport and Logout out
+		t.Errorf("expected next sequence numbers 3 and 4, got %d and %d", nextIn, nextOut)
+	}
+	if execID != 1 {
+		t.Errorf("expected the last ExecID 1, got %d", execID)
+	}
+
+	acceptor = setup(t, store, "CLIENT")
+	client = dial(t, acceptor, "CLIENT")
+	client.seq = 3
+	logon := client.logon()
+	client.expectField(logon, TagMsgSeqNum, "4")
+	client.send(newOrderSingle("c2", "1", 5, "19.00"))
+	report := client.expect(MsgTypeExecutionReport)
+	client.expectField(report, TagExecID, acceptor.SessionID("CLIENT")+"-2") // ExecIDs aren't repeated after a restart
+}
+
+// memory store whose saves wait while it's blocked
+type blockingStore struct {
+	*MemoryStore
+	mutex   sync.Mutex
+	blocked chan struct{}
+}
+
+func (b *blockingStore) block() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.blocked = make(chan struct{})
+}
+
+func (b *blockingStore) unblock() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	close(b.blocked)
+	b.blocked = nil
+}
+
+func (b *blockingStore) Save(sessionID string, nextIn, nextOut, execID uint64) error {
+	b.mutex.Lock()
+	blocked := b.blocked
+	b.mutex.Unlock()
+	if blocked != nil {
+		<-blocked
+	}
+	return b.MemoryStore.Save(sessionID, nextIn, nextOut, execID)
+}
+
+func TestAcceptor_SaveOutsideMatching(t *testing.T) {
+	srv := server.New()
+	tb := tome.NewTradeBook(instrument, tome.WithTradeCallback(srv))
+	ob := tome.NewOrderBook(instrument, *apd.New(2000, -2), tb, tome.NOPOrderRepository)
+	srv.Register(ob)
+	store := &blockingStore{MemoryStore: NewMemoryStore()}
+	acceptor, err := NewAcceptor(srv, Config{CompID: acceptorID, Store: store, Sessions: []SessionConfig{{CompID: "MAKER", CustomerID: uuid.New()}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := acceptor.ListenAndServe("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		acceptor.Close()
+		srv.Close()
+	})
+	maker := dial(t, acceptor, "MAKER")
+	maker.logon()
+	maker.send(newOrderSingle("m1", "2", 10, "20.00"))
+	maker.expect(MsgTypeExecutionReport)
+
+	// execution reports of the fill are persisted and sent after the store is unblocked, matching doesn't wait for it
+	store.block()
+	taker := srv.Connect(uuid.New(), func(msg server.Message) {})
+	matched := make(chan struct{})
+	go func() {
+		defer close(matched)
+		taker.Enter(server.Message{Type: server.MessageNew, Instrument: instrument, OrderType: "Limit", Side: "BUY", Qty: 4, Price: "20.00"})
+	}()
+	select {
+	case <-matched:
+	case <-time.After(time.Second):
+		t.Fatal("expected matching not to wait for the sequence store")
+	}
+	store.unblock()
+	report := maker.expect(MsgTypeExecutionReport)
+	maker.expectField(report, TagExecType, execTrade)
+	maker.expectField(report, TagCumQty, "4")
+}
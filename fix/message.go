@@ -0,0 +1,334 @@
+package fix
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+const (
+	BeginString = "FIX.4.4"
+	soh         = 0x01
+
+	maxBodyLength = 1 << 20
+)
+
+// Field tags.
+const (
+	TagAvgPx               = 6
+	TagBeginSeqNo          = 7
+	TagBeginString         = 8
+	TagBodyLength          = 9
+	TagCheckSum            = 10
+	TagClOrdID             = 11
+	TagCumQty              = 14
+	TagEndSeqNo            = 16
+	TagExecID              = 17
+	TagExecInst            = 18
+	TagLastPx              = 31
+	TagLastQty             = 32
+	TagMsgSeqNum           = 34
+	TagMsgType             = 35
+	TagNewSeqNo            = 36
+	TagOrderID             = 37
+	TagOrderQty            = 38
+	TagOrdStatus           = 39
+	TagOrdType             = 40
+	TagOrigClOrdID         = 41
+	TagPossDupFlag         = 43
+	TagPrice               = 44
+	TagRefSeqNum           = 45
+	TagSenderCompID        = 49
+	TagSendingTime         = 52
+	TagSide                = 54
+	TagSymbol              = 55
+	TagTargetCompID        = 56
+	TagText                = 58
+	TagTimeInForce         = 59
+	TagTransactTime        = 60
+	TagStopPx              = 99
+	TagCxlRejReason        = 102
+	TagOrdRejReason        = 103
+	TagHeartBtInt          = 108
+	TagMaxFloor            = 111
+	TagTestReqID           = 112
+	TagOrigSendingTime     = 122
+	TagGapFillFlag         = 123
+	TagExpireTime          = 126
+	TagResetSeqNumFlag     = 141
+	TagExecType            = 150
+	TagLeavesQty           = 151
+	TagRefMsgType          = 372
+	TagSessionRejectReason = 373
+	TagCxlRejResponseTo    = 434
+)
+
+// Message types.
+const (
+	MsgTypeHeartbeat                 = "0"
+	MsgTypeTestRequest               = "1"
+	MsgTypeResendRequest             = "2"
+	MsgTypeReject                    = "3"
+	MsgTypeSequenceReset             = "4"
+	MsgTypeLogout                    = "5"
+	MsgTypeExecutionReport           = "8"
+	MsgTypeOrderCancelReject         = "9"
+	MsgTypeLogon                     = "A"
+	MsgTypeNewOrderSingle            = "D"
+	MsgTypeOrderCancelRequest        = "F"
+	MsgTypeOrderCancelReplaceRequest = "G"
+)
+
+// UTC timestamp format of SendingTime, TransactTime and ExpireTime.
+const TimestampFormat = "20060102-15:04:05.000"
+
+var (
+	ErrInvalidMessage  = errors.New("invalid FIX message")
+	ErrInvalidChecksum = errors.New("invalid FIX checksum")
+	ErrFieldNotFound   = errors.New("required field not found")
+)
+
+// Tag-value pair of a message.
+type Field struct {
+	Tag   int
+	Value string
+}
+
+// FIX message - fields in their wire order without BeginString, BodyLength and CheckSum.
+type Message struct {
+	Fields []Field
+}
+
+// Create a new message of a type.
+func NewMessage(msgType string) *Message {
+	m := &Message{}
+	m.Set(TagMsgType, msgType)
+	return m
+}
+
+// Returns the message type.
+func (m *Message) Type() string {
+	v, _ := m.Get(TagMsgType)
+	return v
+}
+
+// Returns the value of the first field with the tag.
+func (m *Message) Get(tag int) (string, bool) {
+	for _, f := range m.Fields {
+		if f.Tag == tag {
+			return f.Value, true
+		}
+	}
+	return "", false
+}
+
+// Returns the value of a required field.
+func (m *Message) String(tag int) (string, error) {
+	v, ok := m.Get(tag)
+	if !ok || v == "" {
+		return "", fmt.Errorf("tag %d: %w", tag, ErrFieldNotFound)
+	}
+	return v, nil
+}
+
+// Returns the integer value of a required field.
+func (m *Message) Int(tag int) (int64, error) {
+	v, err := m.String(tag)
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("tag %d: %w", tag, ErrInvalidMessage)
+	}
+	return i, nil
+}
+
+// Returns true if a boolean field is set to Y.
+func (m *Message) Flag(tag int) bool {
+	v, _ := m.Get(tag)
+	return v == "Y"
+}
+
+// Set the value of a field, replaces the first field with the tag or appends a new one.
+func (m *Message) Set(tag int, value string) *Message {
+	for i := range m.Fields {
+		if m.Fields[i].Tag == tag {
+			m.Fields[i].Value = value
+			return m
+		}
+	}
+	m.Fields = append(m.Fields, Field{Tag: tag, Value: value})
+	return m
+}
+
+// Set an integer field.
+func (m *Message) SetInt(tag int, value int64) *Message {
+	return m.Set(tag, strconv.FormatInt(value, 10))
+}
+
+// Remove all fields with the tag.
+func (m *Message) Remove(tag int) {
+	fields := m.Fields[:0]
+	for _, f := range m.Fields {
+		if f.Tag != tag {
+			fields = append(fields, f)
+		}
+	}
+	m.Fields = fields
+}
+
+// Encode the message with the header and the trailer. The header fields (MsgType, SenderCompID, TargetCompID,
+// MsgSeqNum, SendingTime) are written first.
+func (m *Message) Bytes() []byte {
+	var body bytes.Buffer
+	for _, tag := range []int{TagMsgType, TagSenderCompID, TagTargetCompID, TagMsgSeqNum, TagPossDupFlag, TagSendingTime, TagOrigSendingTime} {
+		if v, ok := m.Get(tag); ok {
+			writeField(&body, tag, v)
+		}
+	}
+	for _, f := range m.Fields {
+		if !isHeaderTag(f.Tag) {
+			writeField(&body, f.Tag, f.Value)
+		}
+	}
+	var buf bytes.Buffer
+	writeField(&buf, TagBeginString, BeginString)
+	writeField(&buf, TagBodyLength, strconv.Itoa(body.Len()))
+	buf.Write(body.Bytes())
+	writeField(&buf, TagCheckSum, fmt.Sprintf("%03d", checksum(buf.Bytes())))
+	return buf.Bytes()
+}
+
+// Returns the message in a human readable form with | as the field delimiter.
+func (m *Message) Text() string {
+	return string(bytes.ReplaceAll(m.Bytes(), []byte{soh}, []byte{'|'}))
+}
+
+func isHeaderTag(tag int) bool {
+	switch tag {
+	case TagBeginString, TagBodyLength, TagCheckSum, TagMsgType, TagSenderCompID, TagTargetCompID, TagMsgSeqNum,
+		TagPossDupFlag, TagSendingTime, TagOrigSendingTime:
+		return true
+	}
+	return false
+}
+
+func writeField(buf *bytes.Buffer, tag int, value string) {
+	buf.WriteString(strconv.Itoa(tag))
+	buf.WriteByte('=')
+	buf.WriteString(value)
+	buf.WriteByte(soh)
+}
+
+func checksum(data []byte) int {
+	var sum int
+	for _, b := range data {
+		sum += int(b)
+	}
+	return sum % 256
+}
+
+// Read a message. Returns ErrInvalidMessage if the framing is wrong and ErrInvalidChecksum if the checksum doesn't
+// match - the stream can't be trusted after both.
+func ReadMessage(r *bufio.Reader) (*Message, error) {
+	var raw bytes.Buffer
+	beginString, err := readField(r, &raw, TagBeginString)
+	if err != nil {
+		return nil, err
+	}
+	if beginString != BeginString {
+		return nil, fmt.Errorf("begin string %q: %w", beginString, ErrInvalidMessage)
+	}
+	lengthValue, err := readField(r, &raw, TagBodyLength)
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(lengthValue)
+	if err != nil || length <= 0 || length > maxBodyLength {
+		return nil, fmt.Errorf("body length %q: %w", lengthValue, ErrInvalidMessage)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	raw.Write(body)
+	expected := checksum(raw.Bytes())
+	checksumValue, err := readField(r, nil, TagCheckSum)
+	if err != nil {
+		return nil, err
+	}
+	if actual, err := strconv.Atoi(checksumValue); err != nil || actual != expected {
+		return nil, ErrInvalidChecksum
+	}
+
+	m := &Message{}
+	for len(body) > 0 {
+		end := bytes.IndexByte(body, soh)
+		if end < 0 {
+			return nil, ErrInvalidMessage
+		}
+		tag, value, err := parseField(body[:end])
+		if err != nil {
+			return nil, err
+		}
+		m.Fields = append(m.Fields, Field{Tag: tag, Value: value})
+		body = body[end+1:]
+	}
+	if len(m.Fields) == 0 || m.Fields[0].Tag != TagMsgType {
+		return nil, fmt.Errorf("message type isn't the first field: %w", ErrInvalidMessage)
+	}
+	return m, nil
+}
+
+// read a field with the expected tag, optionally copy its raw bytes
+func readField(r *bufio.Reader, raw *bytes.Buffer, expectedTag int) (string, error) {
+	data, err := r.ReadSlice(soh)
+	if err != nil {
+		if err == bufio.ErrBufferFull {
+			return "", ErrInvalidMessage
+		}
+		return "", err
+	}
+	if raw != nil {
+		raw.Write(data)
+	}
+	tag, value, err := parseField(data[:len(data)-1])
+	if err != nil {
+		return "", err
+	}
+	if tag != expectedTag {
+		return "", fmt.Errorf("expected tag %d, got %d: %w", expectedTag, tag, ErrInvalidMessage)
+	}
+	return value, nil
+}
+
+func parseField(data []byte) (int, string, error) {
+	eq := bytes.IndexByte(data, '=')
+	if eq <= 0 {
+		return 0, "", ErrInvalidMessage
+	}
+	tag, err := strconv.Atoi(string(data[:eq]))
+	if err != nil {
+		return 0, "", ErrInvalidMessage
+	}
+	return tag, string(data[eq+1:]), nil
+}
+
+// Format a UTC timestamp.
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(TimestampFormat)
+}
+
+// Parse a UTC timestamp with or without milliseconds.
+func ParseTime(value string) (time.Time, error) {
+	t, err := time.Parse(TimestampFormat, value)
+	if err != nil {
+		t, err = time.Parse("20060102-15:04:05", value)
+	}
+	return t, err
+}
@@ -0,0 +1,404 @@
+package fix
+
+import (
+	"errors"
+	"github.com/cockroachdb/apd"
+	"github.com/ffhan/tome"
+	"github.com/ffhan/tome/server"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExecType and OrdStatus values.
+const (
+	execNew      = "0"
+	execCanceled = "4"
+	execReplaced = "5"
+	execRejected = "8"
+	execExpired  = "C"
+	execTrade    = "F"
+
+	statusNew             = "0"
+	statusPartiallyFilled = "1"
+	statusFilled          = "2"
+	statusCanceled        = "4"
+	statusRejected        = "8"
+	statusExpired         = "C"
+)
+
+var (
+	ErrUnsupportedOrdType     = errors.New("unsupported OrdType")
+	ErrUnsupportedTimeInForce = errors.New("unsupported TimeInForce")
+	ErrUnsupportedExecInst    = errors.New("unsupported ExecInst")
+	ErrInvalidSide            = errors.New("unsupported Side")
+	ErrDuplicateClOrdID       = errors.New("duplicate ClOrdID")
+	ErrUnknownOrder           = errors.New("unknown order")
+)
+
+var avgPxContext = apd.BaseContext.WithPrecision(16)
+
+// order state used in execution reports
+type order struct {
+	clOrdID  string
+	symbol   string
+	side     string
+	ordType  string
+	qty      int64
+	price    string
+	cumQty   int64
+	turnover apd.Decimal // sum of LastPx * LastQty
+	reports  []uint64    // sequence numbers of sent execution reports
+}
+
+func (o *order) status() string {
+	switch {
+	case o.cumQty >= o.qty:
+		return statusFilled
+	case o.cumQty > 0:
+		return statusPartiallyFilled
+	default:
+		return statusNew
+	}
+}
+
+func (o *order) avgPx() string {
+	if o.cumQty == 0 {
+		return "0"
+	}
+	var avgPx apd.Decimal
+	_, _ = avgPxContext.Quo(&avgPx, &o.turnover, apd.New(o.cumQty, 0))
+	avgPx.Reduce(&avgPx)
+	return avgPx.Text('f')
+}
+
+// Map a NewOrderSingle onto a server order message. Day orders are entered as GTD orders expiring at dayEnd.
+func newOrderMessage(msg *Message, dayEnd time.Time) (server.Message, error) {
+	m := server.Message{Type: server.MessageNew}
+	var err error
+	if m.ClientOrderID, err = msg.String(TagClOrdID); err != nil {
+		return m, err
+	}
+	if m.Instrument, err = msg.String(TagSymbol); err != nil {
+		return m, err
+	}
+	if m.Qty, err = msg.Int(TagOrderQty); err != nil {
+		return m, err
+	}
+	side, _ := msg.Get(TagSide)
+	switch side {
+	case "1":
+		m.Side = tome.SideBuy.String()
+	case "2":
+		m.Side = tome.SideSell.String()
+	default:
+		return m, ErrInvalidSide
+	}
+
+	ordType, _ := msg.Get(TagOrdType)
+	switch ordType {
+	case "1":
+		m.OrderType = tome.TypeMarket.String()
+	case "2":
+		m.OrderType = tome.TypeLimit.String()
+	case "3":
+		m.OrderType = tome.TypeMarket.String()
+		m.Params = append(m.Params, "STOP")
+	case "4":
+		m.OrderType = tome.TypeLimit.String()
+		m.Params = append(m.Params, "STOP")
+	default:
+		return m, ErrUnsupportedOrdType
+	}
+	m.Price, _ = msg.Get(TagPrice)
+	m.StopPrice, _ = msg.Get(TagStopPx)
+
+	timeInForce, _ := msg.Get(TagTimeInForce)
+	switch timeInForce {
+	case "", "0":
+		m.Params = append(m.Params, "GTD")
+		m.ExpireTime = &dayEnd
+	case "1":
+		m.Params = append(m.Params, "GTC")
+	case "3":
+		m.Params = append(m.Params, "IOC")
+	case "4":
+		m.Params = append(m.Params, "FOK")
+	case "6":
+		m.Params = append(m.Params, "GTD")
+		if value, ok := msg.Get(TagExpireTime); ok {
+			expireTime, err := ParseTime(value)
+			if err != nil {
+				return m, err
+			}
+			m.ExpireTime = &expireTime
+		}
+	default:
+		return m, ErrUnsupportedTimeInForce
+	}
+
+	if execInst, ok := msg.Get(TagExecInst); ok {
+		for _, instruction := range strings.Fields(execInst) {
+			if instruction != "G" { // all or none
+				return m, ErrUnsupportedExecInst
+			}
+			m.Params = append(m.Params, "AON")
+		}
+	}
+	if _, ok := msg.Get(TagMaxFloor); ok {
+		if m.DisplayQty, err = msg.Int(TagMaxFloor); err != nil {
+			return m, err
+		}
+	}
+	return m, nil
+}
+
+func (s *session) newOrder(msg *Message) {
+	m, err := newOrderMessage(msg, s.acceptor.config.dayEnd(time.Now()))
+	if err != nil {
+		s.rejectOrder(msg, err.Error())
+		return
+	}
+	s.mutex.Lock()
+	if _, ok := s.orderIDs[m.ClientOrderID]; ok {
+		s.mutex.Unlock()
+		s.rejectOrder(msg, ErrDuplicateClOrdID.Error())
+		return
+	}
+	s.request = msg
+	s.mutex.Unlock()
+
+	s.client.Enter(m)
+	s.endRequest()
+}
+
+func (s *session) cancelOrder(msg *Message) {
+	orderID, ok := s.requestedOrder(msg, "1")
+	if !ok {
+		return
+	}
+	clOrdID, _ := msg.Get(TagClOrdID)
+	s.client.Cancel(server.Message{Type: server.MessageCancel, OrderID: orderID, ClientOrderID: clOrdID})
+	s.endRequest()
+}
+
+func (s *session) replaceOrder(msg *Message) {
+	orderID, ok := s.requestedOrder(msg, "2")
+	if !ok {
+		return
+	}
+	clOrdID, _ := msg.Get(TagClOrdID)
+	qty, err := msg.Int(TagOrderQty)
+	if err != nil {
+		s.endRequest()
+		s.cancelReject(msg, "2", err.Error())
+		return
+	}
+	price, _ := msg.Get(TagPrice)
+	s.client.Amend(server.Message{Type: server.MessageAmend, OrderID: orderID, ClientOrderID: clOrdID, Qty: qty, Price: price})
+	s.endRequest()
+}
+
+// find the order of a cancel or replace request and start the request, rejects unknown orders
+func (s *session) requestedOrder(msg *Message, responseTo string) (uint64, bool) {
+	origClOrdID, _ := msg.Get(TagOrigClOrdID)
+	s.mutex.Lock()
+	orderID, ok := s.orderIDs[origClOrdID]
+	if ok {
+		s.request = msg
+	}
+	s.mutex.Unlock()
+	if !ok {
+		s.cancelReject(msg, responseTo, ErrUnknownOrder.Error())
+	}
+	return orderID, ok
+}
+
+func (s *session) endRequest() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.request = nil
+}
+
+// send an ExecutionReport rejecting a NewOrderSingle
+func (s *session) rejectOrder(msg *Message, text string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.rejectOrderLocked(msg, 0, text)
+}
+
+func (s *session) rejectOrderLocked(msg *Message, orderID uint64, text string) {
+	report := s.reportLocked(execRejected, statusRejected, orderID)
+	for _, tag := range []int{TagClOrdID, TagSymbol, TagSide, TagOrderQty, TagOrdType, TagPrice} {
+		if v, ok := msg.Get(tag); ok {
+			report.Set(tag, v)
+		}
+	}
+	report.SetInt(TagLeavesQty, 0).SetInt(TagCumQty, 0).Set(TagAvgPx, "0").Set(TagText, text)
+	s.pruneLocked(s.sendLocked(report, true))
+}
+
+// send an OrderCancelReject
+func (s *session) cancelReject(msg *Message, responseTo string, text string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.cancelRejectLocked(msg, responseTo, text)
+}
+
+func (s *session) cancelRejectLocked(msg *Message, responseTo string, text string) {
+	clOrdID, _ := msg.Get(TagClOrdID)
+	origClOrdID, _ := msg.Get(TagOrigClOrdID)
+	orderID, status := "NONE", statusRejected
+	if id, ok := s.orderIDs[origClOrdID]; ok {
+		if o, ok := s.orders[id]; ok {
+			orderID, status = strconv.FormatUint(id, 10), o.status()
+		}
+	}
+	reject := NewMessage(MsgTypeOrderCancelReject).
+		Set(TagOrderID, orderID).
+		Set(TagClOrdID, clOrdID).
+		Set(TagOrigClOrdID, origClOrdID).
+		Set(TagOrdStatus, status).
+		Set(TagCxlRejResponseTo, responseTo).
+		Set(TagText, text)
+	s.pruneLocked(s.sendLocked(reject, true))
+}
+
+// Receives messages of session orders from the server. Called synchronously while matching, so it only converts the
+// message and queues it.
+func (s *session) sink(m server.Message) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if m.Type == server.MessageRejected {
+		s.rejectedLocked(m)
+		return
+	}
+	if m.Type == server.MessageAccepted {
+		s.orders[m.OrderID] = &order{
+			clOrdID: m.ClientOrderID,
+			symbol:  m.Instrument,
+			side:    fixSide(m.Side),
+			ordType: fixOrdType(m.OrderType, m.Params),
+			qty:     m.Qty,
+			price:   m.Price,
+		}
+		s.orderIDs[m.ClientOrderID] = m.OrderID
+	}
+	o, ok := s.orders[m.OrderID]
+	if !ok {
+		return
+	}
+
+	var report *Message
+	switch m.Type {
+	case server.MessageAccepted:
+		report = s.orderReportLocked(execNew, m.OrderID, o)
+	case server.MessageFill:
+		var lastPx apd.Decimal
+		_, _, _ = lastPx.SetString(m.LastPrice)
+		var value apd.Decimal
+		_, _ = tome.BaseContext.Mul(&value, &lastPx, apd.New(m.LastQty, 0))
+		_, _ = tome.BaseContext.Add(&o.turnover, &o.turnover, &value)
+		o.cumQty = m.FilledQty
+		report = s.orderReportLocked(execTrade, m.OrderID, o).
+			SetInt(TagLastQty, m.LastQty).
+			Set(TagLastPx, m.LastPrice)
+	case server.MessageAmended:
+		previous := o.clOrdID
+		o.clOrdID, o.qty = m.ClientOrderID, m.Qty
+		if m.Price != "" {
+			o.price = m.Price
+		}
+		s.orderIDs[previous] = 0 // replaced ClOrdIDs can't be reused or referenced
+		s.orderIDs[o.clOrdID] = m.OrderID
+		report = s.orderReportLocked(execReplaced, m.OrderID, o).Set(TagOrigClOrdID, previous)
+	case server.MessageCancelled:
+		o.clOrdID = m.ClientOrderID
+		execType, status := execCanceled, statusCanceled
+		if m.Reason == server.ErrOrderExpired.Error() {
+			execType, status = execExpired, statusExpired
+		}
+		report = s.orderReportLocked(execType, m.OrderID, o).Set(TagOrdStatus, status).SetInt(TagLeavesQty, 0)
+		if m.OrigClientOrderID != "" {
+			report.Set(TagOrigClOrdID, m.OrigClientOrderID)
+		}
+	default:
+		return
+	}
+	o.reports = append(o.reports, s.sendLocked(report, true))
+	if m.Type == server.MessageCancelled || o.cumQty >= o.qty {
+		delete(s.orders, m.OrderID)
+		s.pruneLocked(o.reports...)
+	}
+}
+
+// convert a server rejection of the request which is being processed
+func (s *session) rejectedLocked(m server.Message) {
+	if s.request == nil {
+		return
+	}
+	switch m.RefType {
+	case server.MessageNew:
+		s.rejectOrderLocked(s.request, m.OrderID, m.Reason)
+	case server.MessageCancel:
+		s.cancelRejectLocked(s.request, "1", m.Reason)
+	case server.MessageAmend:
+		s.cancelRejectLocked(s.request, "2", m.Reason)
+	}
+}
+
+// create an ExecutionReport with the order state
+func (s *session) orderReportLocked(execType string, orderID uint64, o *order) *Message {
+	report := s.reportLocked(execType, o.status(), orderID).
+		Set(TagClOrdID, o.clOrdID).
+		Set(TagSymbol, o.symbol).
+		Set(TagSide, o.side).
+		SetInt(TagOrderQty, o.qty).
+		Set(TagOrdType, o.ordType)
+	if o.price != "" {
+		report.Set(TagPrice, o.price)
+	}
+	return report.
+		SetInt(TagLeavesQty, o.qty-o.cumQty).
+		SetInt(TagCumQty, o.cumQty).
+		Set(TagAvgPx, o.avgPx())
+}
+
+func (s *session) reportLocked(execType, status string, orderID uint64) *Message {
+	s.execID += 1
+	id := "NONE"
+	if orderID != 0 {
+		id = strconv.FormatUint(orderID, 10)
+	}
+	return NewMessage(MsgTypeExecutionReport).
+		Set(TagOrderID, id).
+		Set(TagExecID, s.id+"-"+strconv.FormatUint(s.execID, 10)).
+		Set(TagExecType, execType).
+		Set(TagOrdStatus, status).
+		Set(TagTransactTime, FormatTime(time.Now()))
+}
+
+func fixSide(side string) string {
+	if side == tome.SideBuy.String() {
+		return "1"
+	}
+	return "2"
+}
+
+func fixOrdType(orderType string, params []string) string {
+	stop := false
+	for _, param := range params {
+		stop = stop || param == "STOP"
+	}
+	switch {
+	case orderType == tome.TypeMarket.String() && stop:
+		return "3"
+	case stop:
+		return "4"
+	case orderType == tome.TypeMarket.String():
+		return "1"
+	default:
+		return "2"
+	}
+}
@@ -0,0 +1,535 @@
+package fix
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/ffhan/tome/server"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Session reject reasons.
+const (
+	rejectRequiredTagMissing = 1
+	rejectValueIncorrect     = 5
+	rejectInvalidMsgType     = 11
+)
+
+// TCP connection of a session. Messages are written by a separate goroutine, a connection which can't keep up is closed.
+type connection struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	out         chan []byte
+	persist     func() // called by the writer before queued messages are written, set before the first message is queued
+	done        chan struct{}
+	writerDone  chan struct{}
+	closeOnce   sync.Once
+	heartBtInt  time.Duration
+	lastSent    time.Time // guarded by the session mutex
+	lastRecv    time.Time // guarded by the session mutex
+	testRequest bool      // TestRequest was sent and not answered, guarded by the session mutex
+	resendUntil uint64    // highest sequence number covered by a sent ResendRequest, guarded by the session mutex
+}
+
+func newConnection(conn net.Conn, sendBuffer int) *connection {
+	c := &connection{
+		conn:       conn,
+		reader:     bufio.NewReader(conn),
+		out:        make(chan []byte, sendBuffer),
+		done:       make(chan struct{}),
+		writerDone: make(chan struct{}),
+	}
+	go c.write()
+	return c
+}
+
+// queue a message without waiting
+func (c *connection) send(data []byte) {
+	select {
+	case c.out <- data:
+	case <-c.done:
+	default:
+		c.conn.Close() // too slow, the counterparty can resend missed messages after reconnecting
+	}
+}
+
+func (c *connection) write() {
+	defer close(c.writerDone)
+	writer := bufio.NewWriter(c.conn)
+	for {
+		select {
+		case data := <-c.out:
+			if c.writeBatch(writer, data) != nil {
+				return
+			}
+		case <-c.done:
+			select {
+			case data := <-c.out:
+				c.writeBatch(writer, data)
+			default:
+			}
+			return
+		}
+	}
+}
+
+// Write a message and all queued messages after persisting the session state, so sequence numbers of written messages
+// are never lost.
+func (c *connection) writeBatch(writer *bufio.Writer, data []byte) error {
+	batch := [][]byte{data}
+	for queued := true; queued; {
+		select {
+		case data := <-c.out:
+			batch = append(batch, data)
+		default:
+			queued = false
+		}
+	}
+	if c.persist != nil {
+		c.persist()
+	}
+	for _, data := range batch {
+		if _, err := writer.Write(data); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// flush queued messages and close the connection
+func (c *connection) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		<-c.writerDone
+		c.conn.Close()
+	})
+}
+
+// sent application message kept for resends
+type sentMessage struct {
+	msg         *Message
+	sendingTime string
+}
+
+// Session with a counterparty. The session goroutine reads and processes messages of the current connection,
+// messages of orders are sent from the server sink. Both send under the session mutex, which assigns sequence numbers.
+type session struct {
+	acceptor *Acceptor
+	id       string
+	config   SessionConfig
+	client   *server.Client
+
+	mutex    sync.Mutex
+	conn     *connection // current connection, nil if the counterparty isn't logged on
+	nextIn   uint64
+	nextOut  uint64
+	sent     map[uint64]sentMessage
+	terminal []uint64          // sequence numbers of sent messages of terminal orders and rejections, oldest first
+	orders   map[uint64]*order // open orders by order ID
+	orderIDs map[string]uint64 // order IDs by ClOrdID
+	request  *Message          // request which is being processed, used for synchronous rejects
+	execID   uint64            // last ExecID, persisted with the sequence numbers
+
+	// Sequence numbers and the ExecID are persisted outside of the session mutex, the server sink can't wait for the
+	// store while matching.
+	saveMutex  sync.Mutex // serializes saves
+	stateMutex sync.Mutex // guards state and dirty, acquired under the session mutex
+	state      sequenceState
+	dirty      bool
+}
+
+// persisted state of a session
+type sequenceState struct {
+	nextIn, nextOut, execID uint64
+}
+
+func newSession(a *Acceptor, srv *server.Server, config SessionConfig) (*session, error) {
+	s := &session{
+		acceptor: a,
+		id:       a.SessionID(config.CompID),
+		config:   config,
+		sent:     make(map[uint64]sentMessage),
+		orders:   make(map[uint64]*order),
+		orderIDs: make(map[string]uint64),
+	}
+	var err error
+	if s.nextIn, s.nextOut, s.execID, err = a.config.Store.Load(s.id); err != nil {
+		return nil, err
+	}
+	s.client = srv.Connect(config.CustomerID, s.sink)
+	return s, nil
+}
+
+// run a logged on connection until it's closed
+func (s *session) run(c *connection, logon *Message) {
+	c.persist = s.save
+	ok := s.logon(c, logon)
+	s.save()
+	if !ok {
+		return
+	}
+	defer s.detach(c)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.heartbeat(c, stop)
+
+	for {
+		msg, err := ReadMessage(c.reader)
+		if err != nil {
+			return
+		}
+		ok := s.receive(c, msg)
+		s.save()
+		if !ok {
+			return
+		}
+	}
+}
+
+// validate the Logon and attach the connection, returns false if the logon was rejected
+func (s *session) logon(c *connection, logon *Message) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	seq, err := logon.Int(TagMsgSeqNum)
+	heartBtInt, hbErr := logon.Int(TagHeartBtInt)
+	switch {
+	case err != nil || hbErr != nil || heartBtInt <= 0:
+		s.logoutLocked(c, "invalid logon")
+		return false
+	case s.conn != nil:
+		s.logoutLocked(c, "session is already logged on")
+		return false
+	}
+	reset := logon.Flag(TagResetSeqNumFlag)
+	if reset {
+		s.nextIn, s.nextOut = 1, 1
+		s.sent = make(map[uint64]sentMessage)
+		s.terminal = nil
+		for _, o := range s.orders {
+			o.reports = nil
+		}
+	}
+	if uint64(seq) < s.nextIn {
+		s.logoutLocked(c, fmt.Sprintf("MsgSeqNum too low, expecting %d but received %d", s.nextIn, seq))
+		return false
+	}
+
+	c.heartBtInt = time.Duration(heartBtInt) * time.Second
+	c.lastRecv = time.Now()
+	s.conn = c
+	s.client.Reconnect()
+	response := NewMessage(MsgTypeLogon).SetInt(TagHeartBtInt, heartBtInt)
+	if reset {
+		response.Set(TagResetSeqNumFlag, "Y")
+	}
+	s.sendLocked(response, false)
+	if uint64(seq) > s.nextIn {
+		s.requestResendLocked(c, uint64(seq))
+	} else {
+		s.nextIn += 1
+		s.saveLocked()
+	}
+	return true
+}
+
+// process a message, returns false if the connection has to be closed
+func (s *session) receive(c *connection, msg *Message) bool {
+	s.mutex.Lock()
+	c.lastRecv = time.Now()
+	c.testRequest = false
+	process, ok := s.sequenceLocked(c, msg)
+	s.mutex.Unlock()
+	if !ok {
+		return false
+	}
+	if !process {
+		return true
+	}
+
+	switch msg.Type() {
+	case MsgTypeHeartbeat, MsgTypeReject:
+	case MsgTypeTestRequest:
+		testReqID, _ := msg.Get(TagTestReqID)
+		s.send(NewMessage(MsgTypeHeartbeat).Set(TagTestReqID, testReqID))
+	case MsgTypeResendRequest:
+		s.resend(msg)
+	case MsgTypeSequenceReset:
+		s.gapFill(msg)
+	case MsgTypeLogout:
+		s.mutex.Lock()
+		s.logoutLocked(c, "")
+		s.mutex.Unlock()
+		return false
+	case MsgTypeLogon:
+		s.reject(msg, rejectValueIncorrect, "session is already logged on")
+	case MsgTypeNewOrderSingle:
+		s.newOrder(msg)
+	case MsgTypeOrderCancelRequest:
+		s.cancelOrder(msg)
+	case MsgTypeOrderCancelReplaceRequest:
+		s.replaceOrder(msg)
+	default:
+		s.reject(msg, rejectInvalidMsgType, "unsupported message type")
+	}
+	return true
+}
+
+// check the sequence number of a message. Returns whether the message should be processed and whether the connection
+// can stay open.
+func (s *session) sequenceLocked(c *connection, msg *Message) (bool, bool) {
+	seq, err := msg.Int(TagMsgSeqNum)
+	if err != nil {
+		s.logoutLocked(c, "MsgSeqNum is missing")
+		return false, false
+	}
+	if msg.Type() == MsgTypeSequenceReset && !msg.Flag(TagGapFillFlag) { // reset mode ignores the sequence number
+		if newSeqNo, err := msg.Int(TagNewSeqNo); err == nil && uint64(newSeqNo) > s.nextIn {
+			s.nextIn = uint64(newSeqNo)
+			s.saveLocked()
+		}
+		return false, true
+	}
+	switch {
+	case uint64(seq) < s.nextIn:
+		if msg.Flag(TagPossDupFlag) {
+			return false, true // already processed
+		}
+		s.logoutLocked(c, fmt.Sprintf("MsgSeqNum too low, expecting %d but received %d", s.nextIn, seq))
+		return false, false
+	case uint64(seq) > s.nextIn:
+		s.requestResendLocked(c, uint64(seq))
+		return msg.Type() == MsgTypeResendRequest || msg.Type() == MsgTypeLogout, true
+	}
+	s.nextIn += 1
+	s.saveLocked()
+	return true, true
+}
+
+// request a resend of all missed messages, unless they were already requested
+func (s *session) requestResendLocked(c *connection, seq uint64) {
+	if seq <= c.resendUntil {
+		return
+	}
+	c.resendUntil = seq
+	s.sendLocked(NewMessage(MsgTypeResendRequest).SetInt(TagBeginSeqNo, int64(s.nextIn)).SetInt(TagEndSeqNo, 0), false)
+}
+
+// resend stored application messages, gap fill everything else
+func (s *session) resend(msg *Message) {
+	begin, err := msg.Int(TagBeginSeqNo)
+	if err != nil {
+		s.reject(msg, rejectRequiredTagMissing, err.Error())
+		return
+	}
+	end, _ := msg.Int(TagEndSeqNo)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	last := s.nextOut - 1
+	if end > 0 && uint64(end) < last {
+		last = uint64(end)
+	}
+	gapStart := uint64(0)
+	for seq := uint64(begin); seq <= last; seq++ {
+		sent, ok := s.sent[seq]
+		if !ok {
+			if gapStart == 0 {
+				gapStart = seq
+			}
+			continue
+		}
+		if gapStart != 0 {
+			s.gapFillLocked(gapStart, seq)
+			gapStart = 0
+		}
+		resent := &Message{Fields: append([]Field(nil), sent.msg.Fields...)}
+		resent.Set(TagPossDupFlag, "Y").Set(TagOrigSendingTime, sent.sendingTime)
+		s.writeLocked(resent, seq)
+	}
+	if gapStart != 0 {
+		s.gapFillLocked(gapStart, last+1)
+	}
+}
+
+func (s *session) gapFillLocked(seq, newSeqNo uint64) {
+	gapFill := NewMessage(MsgTypeSequenceReset).Set(TagPossDupFlag, "Y").Set(TagGapFillFlag, "Y").SetInt(TagNewSeqNo, int64(newSeqNo))
+	s.writeLocked(gapFill, seq)
+}
+
+// process a SequenceReset in gap fill mode
+func (s *session) gapFill(msg *Message) {
+	newSeqNo, err := msg.Int(TagNewSeqNo)
+	if err != nil {
+		s.reject(msg, rejectRequiredTagMissing, err.Error())
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if uint64(newSeqNo) > s.nextIn {
+		s.nextIn = uint64(newSeqNo)
+		s.saveLocked()
+	}
+}
+
+// send heartbeats and test requests, close the connection if the counterparty stops responding
+func (s *session) heartbeat(c *connection, stop chan struct{}) {
+	interval := c.heartBtInt / 2
+	if interval > time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			s.mutex.Lock()
+			if s.conn != c {
+				s.mutex.Unlock()
+				return
+			}
+			silence := now.Sub(c.lastRecv)
+			switch {
+			case silence >= 2*c.heartBtInt:
+				s.logoutLocked(c, "heartbeat timeout")
+			case silence >= c.heartBtInt+c.heartBtInt/5 && !c.testRequest:
+				c.testRequest = true
+				s.sendLocked(NewMessage(MsgTypeTestRequest).Set(TagTestReqID, strconv.FormatInt(now.UnixNano(), 10)), false)
+			case now.Sub(c.lastSent) >= c.heartBtInt:
+				s.sendLocked(NewMessage(MsgTypeHeartbeat), false)
+			}
+			s.mutex.Unlock()
+		}
+	}
+}
+
+// send a session level Reject of a message
+func (s *session) reject(msg *Message, reason int, text string) {
+	seq, _ := msg.Get(TagMsgSeqNum)
+	reject := NewMessage(MsgTypeReject).
+		Set(TagRefSeqNum, seq).
+		Set(TagRefMsgType, msg.Type()).
+		SetInt(TagSessionRejectReason, int64(reason)).
+		Set(TagText, text)
+	s.send(reject)
+}
+
+// log out the current connection
+func (s *session) logout(text string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.conn != nil {
+		s.logoutLocked(s.conn, text)
+	}
+}
+
+// send a Logout and close the connection
+func (s *session) logoutLocked(c *connection, text string) {
+	logout := NewMessage(MsgTypeLogout)
+	if text != "" {
+		logout.Set(TagText, text)
+	}
+	if s.conn == c {
+		s.sendLocked(logout, false)
+		s.conn = nil
+	} else { // the connection isn't logged on, don't consume a sequence number
+		s.stampLocked(logout, s.nextOut)
+		c.send(logout.Bytes())
+	}
+	c.close()
+}
+
+// detach a closed connection
+func (s *session) detach(c *connection) {
+	s.mutex.Lock()
+	attached := s.conn == c
+	if attached {
+		s.conn = nil
+	}
+	s.mutex.Unlock()
+	c.close()
+	if attached {
+		s.client.Disconnect()
+	}
+	s.save()
+}
+
+func (s *session) send(msg *Message) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sendLocked(msg, false)
+}
+
+// assign the next sequence number and send the message to the current connection, application messages are stored
+// for resends even when the counterparty isn't logged on. Returns the sequence number.
+func (s *session) sendLocked(msg *Message, application bool) uint64 {
+	seq := s.nextOut
+	s.nextOut += 1
+	sendingTime := s.writeLocked(msg, seq)
+	if application {
+		s.sent[seq] = sentMessage{msg: msg, sendingTime: sendingTime}
+	}
+	s.saveLocked()
+	return seq
+}
+
+// Keep sent messages of terminal orders and rejections for resends until they fall out of the resend window.
+// Messages of open orders are kept until the orders are terminal.
+func (s *session) pruneLocked(seqs ...uint64) {
+	s.terminal = append(s.terminal, seqs...)
+	for len(s.terminal) > s.acceptor.config.ResendWindow {
+		delete(s.sent, s.terminal[0])
+		s.terminal = s.terminal[1:]
+	}
+}
+
+// write a message with a sequence number to the current connection, returns the sending time
+func (s *session) writeLocked(msg *Message, seq uint64) string {
+	sendingTime := s.stampLocked(msg, seq)
+	if s.conn != nil {
+		s.conn.lastSent = time.Now()
+		s.conn.send(msg.Bytes())
+	}
+	return sendingTime
+}
+
+// set the header of a message, returns the sending time
+func (s *session) stampLocked(msg *Message, seq uint64) string {
+	sendingTime := FormatTime(time.Now())
+	msg.Set(TagSenderCompID, s.acceptor.config.CompID).
+		Set(TagTargetCompID, s.config.CompID).
+		SetInt(TagMsgSeqNum, int64(seq)).
+		Set(TagSendingTime, sendingTime)
+	return sendingTime
+}
+
+// mark the sequence numbers and the ExecID to be persisted by the next save
+func (s *session) saveLocked() {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	s.state = sequenceState{nextIn: s.nextIn, nextOut: s.nextOut, execID: s.execID}
+	s.dirty = true
+}
+
+// Persist the sequence numbers and the ExecID if they changed. Has to be called without holding the session mutex -
+// by the connection writer before messages are written and by the session goroutine after a message is processed.
+func (s *session) save() {
+	s.saveMutex.Lock()
+	defer s.saveMutex.Unlock()
+	s.stateMutex.Lock()
+	state, dirty := s.state, s.dirty
+	s.dirty = false
+	s.stateMutex.Unlock()
+	if !dirty {
+		return
+	}
+	if err := s.acceptor.config.Store.Save(s.id, state.nextIn, state.nextOut, state.execID); err != nil {
+		s.acceptor.logf("cannot save sequence numbers of %s: %v\n", s.id, err)
+	}
+}
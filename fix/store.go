@@ -0,0 +1,132 @@
+package fix
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Persists expected sequence numbers and the last ExecID of sessions, so sessions can continue after a restart without
+// repeating ExecIDs.
+type SequenceStore interface {
+	Load(sessionID string) (nextIn, nextOut, execID uint64, err error) // returns 1, 1, 0 for unknown sessions
+	Save(sessionID string, nextIn, nextOut, execID uint64) error
+}
+
+// Sequence store which keeps sequence numbers in memory.
+type MemoryStore struct {
+	mutex     sync.Mutex
+	sequences map[string][3]uint64
+}
+
+// Create a new in-memory sequence store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sequences: make(map[string][3]uint64)}
+}
+
+func (m *MemoryStore) Load(sessionID string) (uint64, uint64, uint64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if s, ok := m.sequences[sessionID]; ok {
+		return s[0], s[1], s[2], nil
+	}
+	return 1, 1, 0, nil
+}
+
+func (m *MemoryStore) Save(sessionID string, nextIn, nextOut, execID uint64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.sequences[sessionID] = [3]uint64{nextIn, nextOut, execID}
+	return nil
+}
+
+// fixed width record of both sequence numbers and the last ExecID, overwritten in place
+const sequenceRecordSize = 3*20 + 1
+
+// Sequence store which keeps a file per session in a directory.
+type FileStore struct {
+	dir  string
+	sync bool
+
+	mutex sync.Mutex
+	files map[string]*os.File
+}
+
+// Open a file sequence store in a directory, create the directory if it doesn't exist. With sync every save is synced
+// to the disk.
+func OpenFileStore(dir string, sync bool) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir, sync: sync, files: make(map[string]*os.File)}, nil
+}
+
+func (f *FileStore) file(sessionID string) (*os.File, error) {
+	if file, ok := f.files[sessionID]; ok {
+		return file, nil
+	}
+	name := strings.NewReplacer("/", "_", ":", "_", ">", "_").Replace(sessionID) + ".seqnums"
+	file, err := os.OpenFile(filepath.Join(f.dir, name), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	f.files[sessionID] = file
+	return file, nil
+}
+
+func (f *FileStore) Load(sessionID string) (uint64, uint64, uint64, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	file, err := f.file(sessionID)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	buf := make([]byte, sequenceRecordSize)
+	n, err := file.ReadAt(buf, 0)
+	if n == 0 {
+		return 1, 1, 0, nil
+	}
+	if n != sequenceRecordSize {
+		return 0, 0, 0, fmt.Errorf("sequence file of %s is corrupted: %v", sessionID, err)
+	}
+	nextIn, inErr := strconv.ParseUint(string(buf[:20]), 10, 64)
+	nextOut, outErr := strconv.ParseUint(string(buf[20:40]), 10, 64)
+	execID, execErr := strconv.ParseUint(string(buf[40:60]), 10, 64)
+	if inErr != nil || outErr != nil || execErr != nil {
+		return 0, 0, 0, fmt.Errorf("sequence file of %s is corrupted", sessionID)
+	}
+	return nextIn, nextOut, execID, nil
+}
+
+func (f *FileStore) Save(sessionID string, nextIn, nextOut, execID uint64) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	file, err := f.file(sessionID)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteAt([]byte(fmt.Sprintf("%020d%020d%020d\n", nextIn, nextOut, execID)), 0); err != nil {
+		return err
+	}
+	if f.sync {
+		return file.Sync()
+	}
+	return nil
+}
+
+// Close all session files.
+func (f *FileStore) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var err error
+	for sessionID, file := range f.files {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		delete(f.files, sessionID)
+	}
+	return err
+}
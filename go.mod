@@ -1,13 +1,23 @@
 module github.com/ffhan/tome
 
-go 1.15
+go 1.19
 
 require (
 	github.com/cockroachdb/apd v1.1.0
-	github.com/google/uuid v1.2.0
+	github.com/google/uuid v1.6.0
+	github.com/olekukonko/tablewriter v0.0.4
+	google.golang.org/grpc v1.64.1
+	google.golang.org/protobuf v1.33.0
+)
+
+require (
 	github.com/igrmk/treemap v0.0.0-20201120130758-a6996be7c32c // indirect
+	github.com/mattn/go-runewidth v0.0.7 // indirect
 	github.com/ncw/gotemplate v0.0.0-20210105194706-14f008362492 // indirect
-	github.com/olekukonko/tablewriter v0.0.4
 	github.com/pkg/errors v0.9.1 // indirect
-	golang.org/x/tools v0.1.0 // indirect
+	golang.org/x/net v0.26.0 // indirect
+	golang.org/x/sys v0.21.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
+	golang.org/x/tools v0.21.1-0.20240508182429-e35e4ccd0d2d // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240318140521-94a12d6c2237 // indirect
 )
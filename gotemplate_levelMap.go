@@ -32,37 +32,37 @@ package tome
 // Value is a generic value type of the map
 
 // TreeMap is the red-black tree based map
-type orderMap struct {
-	endNode   *nodeOrderMap
-	beginNode *nodeOrderMap
+type levelMap struct {
+	endNode   *nodeLevelMap
+	beginNode *nodeLevelMap
 	count     int
 	// Less returns a < b
 	Less func(a OrderTracker, b OrderTracker) bool
 }
 
-type nodeOrderMap struct {
-	right   *nodeOrderMap
-	left    *nodeOrderMap
-	parent  *nodeOrderMap
+type nodeLevelMap struct {
+	right   *nodeLevelMap
+	left    *nodeLevelMap
+	parent  *nodeLevelMap
 	isBlack bool
 	key     OrderTracker
-	value   bool
+	value   *priceLevel
 }
 
 // New creates and returns new TreeMap.
 // Parameter less is a function returning a < b.
-func newOrderMap(less func(a OrderTracker, b OrderTracker) bool) *orderMap {
-	endNode := &nodeOrderMap{isBlack: true}
-	return &orderMap{beginNode: endNode, endNode: endNode, Less: less}
+func newLevelMap(less func(a OrderTracker, b OrderTracker) bool) *levelMap {
+	endNode := &nodeLevelMap{isBlack: true}
+	return &levelMap{beginNode: endNode, endNode: endNode, Less: less}
 }
 
 // Len returns total count of elements in a map.
 // Complexity: O(1).
-func (t *orderMap) Len() int { return t.count }
+func (t *levelMap) Len() int { return t.count }
 
 // Set sets the value and silently overrides previous value if it exists.
 // Complexity: O(log N).
-func (t *orderMap) Set(key OrderTracker, value bool) {
+func (t *levelMap) Set(key OrderTracker, value *priceLevel) {
 	parent := t.endNode
 	current := parent.left
 	less := true
@@ -80,7 +80,7 @@ func (t *orderMap) Set(key OrderTracker, value bool) {
 			return
 		}
 	}
-	x := &nodeOrderMap{parent: parent, value: value, key: key}
+	x := &nodeLevelMap{parent: parent, value: value, key: key}
 	if less {
 		parent.left = x
 	} else {
@@ -95,7 +95,7 @@ func (t *orderMap) Set(key OrderTracker, value bool) {
 
 // Del deletes the value.
 // Complexity: O(log N).
-func (t *orderMap) Del(key OrderTracker) {
+func (t *levelMap) Del(key OrderTracker) {
 	z := t.findNode(key)
 	if z == nil {
 		return
@@ -108,12 +108,12 @@ func (t *orderMap) Del(key OrderTracker) {
 		}
 	}
 	t.count--
-	removeNodeOrderMap(t.endNode.left, z)
+	removeNodeLevelMap(t.endNode.left, z)
 }
 
 // Clear clears the map.
 // Complexity: O(1).
-func (t *orderMap) Clear() {
+func (t *levelMap) Clear() {
 	t.count = 0
 	t.beginNode = t.endNode
 	t.endNode.left = nil
@@ -121,7 +121,7 @@ func (t *orderMap) Clear() {
 
 // Get retrieves a value from a map for specified key and reports if it exists.
 // Complexity: O(log N).
-func (t *orderMap) Get(id OrderTracker) (bool, bool) {
+func (t *levelMap) Get(id OrderTracker) (*priceLevel, bool) {
 	node := t.findNode(id)
 	if node == nil {
 		node = t.endNode
@@ -131,36 +131,36 @@ func (t *orderMap) Get(id OrderTracker) (bool, bool) {
 
 // Contains checks if key exists in a map.
 // Complexity: O(log N)
-func (t *orderMap) Contains(id OrderTracker) bool { return t.findNode(id) != nil }
+func (t *levelMap) Contains(id OrderTracker) bool { return t.findNode(id) != nil }
 
 // Range returns a pair of iterators that you can use to go through all the keys in the range [from, to].
 // More specifically it returns iterators pointing to lower bound and upper bound.
 // Complexity: O(log N).
-func (t *orderMap) Range(from, to OrderTracker) (forwardIteratorOrderMap, forwardIteratorOrderMap) {
+func (t *levelMap) Range(from, to OrderTracker) (forwardIteratorLevelMap, forwardIteratorLevelMap) {
 	return t.LowerBound(from), t.UpperBound(to)
 }
 
 // LowerBound returns an iterator pointing to the first element that is not less than the given key.
 // Complexity: O(log N).
-func (t *orderMap) LowerBound(key OrderTracker) forwardIteratorOrderMap {
+func (t *levelMap) LowerBound(key OrderTracker) forwardIteratorLevelMap {
 	result := t.endNode
 	node := t.endNode.left
 	if node == nil {
-		return forwardIteratorOrderMap{tree: t, node: t.endNode}
+		return forwardIteratorLevelMap{tree: t, node: t.endNode}
 	}
 	for {
 		if t.Less(node.key, key) {
 			if node.right != nil {
 				node = node.right
 			} else {
-				return forwardIteratorOrderMap{tree: t, node: result}
+				return forwardIteratorLevelMap{tree: t, node: result}
 			}
 		} else {
 			result = node
 			if node.left != nil {
 				node = node.left
 			} else {
-				return forwardIteratorOrderMap{tree: t, node: result}
+				return forwardIteratorLevelMap{tree: t, node: result}
 			}
 		}
 	}
@@ -168,25 +168,25 @@ func (t *orderMap) LowerBound(key OrderTracker) forwardIteratorOrderMap {
 
 // UpperBound returns an iterator pointing to the first element that is greater than the given key.
 // Complexity: O(log N).
-func (t *orderMap) UpperBound(key OrderTracker) forwardIteratorOrderMap {
+func (t *levelMap) UpperBound(key OrderTracker) forwardIteratorLevelMap {
 	result := t.endNode
 	node := t.endNode.left
 	if node == nil {
-		return forwardIteratorOrderMap{tree: t, node: t.endNode}
+		return forwardIteratorLevelMap{tree: t, node: t.endNode}
 	}
 	for {
 		if !t.Less(key, node.key) {
 			if node.right != nil {
 				node = node.right
 			} else {
-				return forwardIteratorOrderMap{tree: t, node: result}
+				return forwardIteratorLevelMap{tree: t, node: result}
 			}
 		} else {
 			result = node
 			if node.left != nil {
 				node = node.left
 			} else {
-				return forwardIteratorOrderMap{tree: t, node: result}
+				return forwardIteratorLevelMap{tree: t, node: result}
 			}
 		}
 	}
@@ -196,23 +196,23 @@ func (t *orderMap) UpperBound(key OrderTracker) forwardIteratorOrderMap {
 // It starts at the first element and goes to the one-past-the-end position.
 // You can iterate a map at O(N) complexity.
 // Method complexity: O(1)
-func (t *orderMap) Iterator() forwardIteratorOrderMap {
-	return forwardIteratorOrderMap{tree: t, node: t.beginNode}
+func (t *levelMap) Iterator() forwardIteratorLevelMap {
+	return forwardIteratorLevelMap{tree: t, node: t.beginNode}
 }
 
 // Reverse returns a reverse iterator for tree map.
 // It starts at the last element and goes to the one-before-the-start position.
 // You can iterate a map at O(N) complexity.
 // Method complexity: O(log N)
-func (t *orderMap) Reverse() reverseIteratorOrderMap {
+func (t *levelMap) Reverse() reverseIteratorLevelMap {
 	node := t.endNode.left
 	if node != nil {
-		node = mostRightOrderMap(node)
+		node = mostRightLevelMap(node)
 	}
-	return reverseIteratorOrderMap{tree: t, node: node}
+	return reverseIteratorLevelMap{tree: t, node: node}
 }
 
-func (t *orderMap) findNode(id OrderTracker) *nodeOrderMap {
+func (t *levelMap) findNode(id OrderTracker) *nodeLevelMap {
 	current := t.endNode.left
 	for current != nil {
 		switch {
@@ -227,23 +227,23 @@ func (t *orderMap) findNode(id OrderTracker) *nodeOrderMap {
 	return nil
 }
 
-func mostLeftOrderMap(x *nodeOrderMap) *nodeOrderMap {
+func mostLeftLevelMap(x *nodeLevelMap) *nodeLevelMap {
 	for x.left != nil {
 		x = x.left
 	}
 	return x
 }
 
-func mostRightOrderMap(x *nodeOrderMap) *nodeOrderMap {
+func mostRightLevelMap(x *nodeLevelMap) *nodeLevelMap {
 	for x.right != nil {
 		x = x.right
 	}
 	return x
 }
 
-func successorOrderMap(x *nodeOrderMap) *nodeOrderMap {
+func successorLevelMap(x *nodeLevelMap) *nodeLevelMap {
 	if x.right != nil {
-		return mostLeftOrderMap(x.right)
+		return mostLeftLevelMap(x.right)
 	}
 	for x != x.parent.left {
 		x = x.parent
@@ -251,9 +251,9 @@ func successorOrderMap(x *nodeOrderMap) *nodeOrderMap {
 	return x.parent
 }
 
-func predecessorOrderMap(x *nodeOrderMap) *nodeOrderMap {
+func predecessorLevelMap(x *nodeLevelMap) *nodeLevelMap {
 	if x.left != nil {
-		return mostRightOrderMap(x.left)
+		return mostRightLevelMap(x.left)
 	}
 	for x.parent != nil && x != x.parent.right {
 		x = x.parent
@@ -261,7 +261,7 @@ func predecessorOrderMap(x *nodeOrderMap) *nodeOrderMap {
 	return x.parent
 }
 
-func rotateLeftOrderMap(x *nodeOrderMap) {
+func rotateLeftLevelMap(x *nodeLevelMap) {
 	y := x.right
 	x.right = y.left
 	if x.right != nil {
@@ -277,7 +277,7 @@ func rotateLeftOrderMap(x *nodeOrderMap) {
 	x.parent = y
 }
 
-func rotateRightOrderMap(x *nodeOrderMap) {
+func rotateRightLevelMap(x *nodeLevelMap) {
 	y := x.left
 	x.left = y.right
 	if x.left != nil {
@@ -293,7 +293,7 @@ func rotateRightOrderMap(x *nodeOrderMap) {
 	x.parent = y
 }
 
-func (t *orderMap) insertFixup(x *nodeOrderMap) {
+func (t *levelMap) insertFixup(x *nodeLevelMap) {
 	root := t.endNode.left
 	x.isBlack = x == root
 	for x != root && !x.parent.isBlack {
@@ -308,13 +308,13 @@ func (t *orderMap) insertFixup(x *nodeOrderMap) {
 			} else {
 				if x != x.parent.left {
 					x = x.parent
-					rotateLeftOrderMap(x)
+					rotateLeftLevelMap(x)
 				}
 				x = x.parent
 				x.isBlack = true
 				x = x.parent
 				x.isBlack = false
-				rotateRightOrderMap(x)
+				rotateRightLevelMap(x)
 				break
 			}
 		} else {
@@ -328,13 +328,13 @@ func (t *orderMap) insertFixup(x *nodeOrderMap) {
 			} else {
 				if x == x.parent.left {
 					x = x.parent
-					rotateRightOrderMap(x)
+					rotateRightLevelMap(x)
 				}
 				x = x.parent
 				x.isBlack = true
 				x = x.parent
 				x.isBlack = false
-				rotateLeftOrderMap(x)
+				rotateLeftLevelMap(x)
 				break
 			}
 		}
@@ -343,20 +343,20 @@ func (t *orderMap) insertFixup(x *nodeOrderMap) {
 
 // nolint: gocyclo
 //noinspection GoNilness
-func removeNodeOrderMap(root *nodeOrderMap, z *nodeOrderMap) {
-	var y *nodeOrderMap
+func removeNodeLevelMap(root *nodeLevelMap, z *nodeLevelMap) {
+	var y *nodeLevelMap
 	if z.left == nil || z.right == nil {
 		y = z
 	} else {
-		y = successorOrderMap(z)
+		y = successorLevelMap(z)
 	}
-	var x *nodeOrderMap
+	var x *nodeLevelMap
 	if y.left != nil {
 		x = y.left
 	} else {
 		x = y.right
 	}
-	var w *nodeOrderMap
+	var w *nodeLevelMap
 	if x != nil {
 		x.parent = y.parent
 	}
@@ -399,7 +399,7 @@ func removeNodeOrderMap(root *nodeOrderMap, z *nodeOrderMap) {
 					if !w.isBlack {
 						w.isBlack = true
 						w.parent.isBlack = false
-						rotateLeftOrderMap(w.parent)
+						rotateLeftLevelMap(w.parent)
 						if root == w.left {
 							root = w
 						}
@@ -421,20 +421,20 @@ func removeNodeOrderMap(root *nodeOrderMap, z *nodeOrderMap) {
 						if w.right == nil || w.right.isBlack {
 							w.left.isBlack = true
 							w.isBlack = false
-							rotateRightOrderMap(w)
+							rotateRightLevelMap(w)
 							w = w.parent
 						}
 						w.isBlack = w.parent.isBlack
 						w.parent.isBlack = true
 						w.right.isBlack = true
-						rotateLeftOrderMap(w.parent)
+						rotateLeftLevelMap(w.parent)
 						break
 					}
 				} else {
 					if !w.isBlack {
 						w.isBlack = true
 						w.parent.isBlack = false
-						rotateRightOrderMap(w.parent)
+						rotateRightLevelMap(w.parent)
 						if root == w.right {
 							root = w
 						}
@@ -456,13 +456,13 @@ func removeNodeOrderMap(root *nodeOrderMap, z *nodeOrderMap) {
 						if w.left == nil || w.left.isBlack {
 							w.right.isBlack = true
 							w.isBlack = false
-							rotateLeftOrderMap(w)
+							rotateLeftLevelMap(w)
 							w = w.parent
 						}
 						w.isBlack = w.parent.isBlack
 						w.parent.isBlack = true
 						w.left.isBlack = true
-						rotateRightOrderMap(w.parent)
+						rotateRightLevelMap(w.parent)
 						break
 					}
 				}
@@ -474,65 +474,65 @@ func removeNodeOrderMap(root *nodeOrderMap, z *nodeOrderMap) {
 // ForwardIterator represents a position in a tree map.
 // It is designed to iterate a map in a forward order.
 // It can point to any position from the first element to the one-past-the-end element.
-type forwardIteratorOrderMap struct {
-	tree *orderMap
-	node *nodeOrderMap
+type forwardIteratorLevelMap struct {
+	tree *levelMap
+	node *nodeLevelMap
 }
 
 // Valid reports if an iterator's position is valid.
 // In other words it returns true if an iterator is not at the one-past-the-end position.
-func (i forwardIteratorOrderMap) Valid() bool { return i.node != i.tree.endNode }
+func (i forwardIteratorLevelMap) Valid() bool { return i.node != i.tree.endNode }
 
 // Next moves an iterator to the next element.
 // It panics if goes out of bounds.
-func (i *forwardIteratorOrderMap) Next() {
+func (i *forwardIteratorLevelMap) Next() {
 	if i.node == i.tree.endNode {
 		panic("out of bound iteration")
 	}
-	i.node = successorOrderMap(i.node)
+	i.node = successorLevelMap(i.node)
 }
 
 // Prev moves an iterator to the previous element.
 // It panics if goes out of bounds.
-func (i *forwardIteratorOrderMap) Prev() {
-	i.node = predecessorOrderMap(i.node)
+func (i *forwardIteratorLevelMap) Prev() {
+	i.node = predecessorLevelMap(i.node)
 	if i.node == nil {
 		panic("out of bound iteration")
 	}
 }
 
 // Key returns a key at an iterator's position
-func (i forwardIteratorOrderMap) Key() OrderTracker { return i.node.key }
+func (i forwardIteratorLevelMap) Key() OrderTracker { return i.node.key }
 
 // Value returns a value at an iterator's position
-func (i forwardIteratorOrderMap) Value() bool { return i.node.value }
+func (i forwardIteratorLevelMap) Value() *priceLevel { return i.node.value }
 
 // ReverseIterator represents a position in a tree map.
 // It is designed to iterate a map in a reverse order.
 // It can point to any position from the one-before-the-start element to the last element.
-type reverseIteratorOrderMap struct {
-	tree *orderMap
-	node *nodeOrderMap
+type reverseIteratorLevelMap struct {
+	tree *levelMap
+	node *nodeLevelMap
 }
 
 // Valid reports if an iterator's position is valid.
 // In other words it returns true if an iterator is not at the one-before-the-start position.
-func (i reverseIteratorOrderMap) Valid() bool { return i.node != nil }
+func (i reverseIteratorLevelMap) Valid() bool { return i.node != nil }
 
 // Next moves an iterator to the next element in reverse order.
 // It panics if goes out of bounds.
-func (i *reverseIteratorOrderMap) Next() {
+func (i *reverseIteratorLevelMap) Next() {
 	if i.node == nil {
 		panic("out of bound iteration")
 	}
-	i.node = predecessorOrderMap(i.node)
+	i.node = predecessorLevelMap(i.node)
 }
 
 // Prev moves an iterator to the previous element in reverse order.
 // It panics if goes out of bounds.
-func (i *reverseIteratorOrderMap) Prev() {
+func (i *reverseIteratorLevelMap) Prev() {
 	if i.node != nil {
-		i.node = successorOrderMap(i.node)
+		i.node = successorLevelMap(i.node)
 	} else {
 		i.node = i.tree.beginNode
 	}
@@ -542,7 +542,7 @@ func (i *reverseIteratorOrderMap) Prev() {
 }
 
 // Key returns a key at an iterator's position
-func (i reverseIteratorOrderMap) Key() OrderTracker { return i.node.key }
+func (i reverseIteratorLevelMap) Key() OrderTracker { return i.node.key }
 
 // Value returns a value at an iterator's position
-func (i reverseIteratorOrderMap) Value() bool { return i.node.value }
+func (i reverseIteratorLevelMap) Value() *priceLevel { return i.node.value }
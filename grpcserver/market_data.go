@@ -0,0 +1,219 @@
+package grpcserver
+
+import (
+	"github.com/cockroachdb/apd"
+	"github.com/ffhan/tome"
+	"github.com/ffhan/tome/server"
+	"github.com/ffhan/tome/tomepb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sort"
+)
+
+// replica of the displayed price levels of an instrument
+type book struct {
+	instrument  string
+	sequence    uint64 // sequence of the last message
+	bids, asks  map[string]level
+	subscribers map[*subscriber]struct{}
+}
+
+type level struct {
+	price apd.Decimal
+	qty   int64
+	count int
+}
+
+func (b *book) levels(side tome.OrderSide) map[string]level {
+	if side == tome.SideBuy {
+		return b.bids
+	}
+	return b.asks
+}
+
+func (b *book) snapshot() *tomepb.MarketDataMessage {
+	return &tomepb.MarketDataMessage{Message: &tomepb.MarketDataMessage_Snapshot{Snapshot: &tomepb.DepthSnapshot{
+		Instrument: b.instrument,
+		Sequence:   b.sequence,
+		Bids:       sortedLevels(b.bids, true),
+		Asks:       sortedLevels(b.asks, false),
+	}}}
+}
+
+func sortedLevels(levels map[string]level, descending bool) []*tomepb.Level {
+	sorted := make([]level, 0, len(levels))
+	for _, l := range levels {
+		sorted = append(sorted, l)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		cmp := sorted[i].price.Cmp(&sorted[j].price)
+		return (descending && cmp > 0) || (!descending && cmp < 0)
+	})
+	result := make([]*tomepb.Level, len(sorted))
+	for i, l := range sorted {
+		result[i] = &tomepb.Level{Price: l.price.Text('f'), Qty: l.qty, Count: int32(l.count)}
+	}
+	return result
+}
+
+// market data stream of an instrument
+type subscriber struct {
+	send    chan *tomepb.MarketDataMessage
+	dropped bool
+}
+
+// Returns the market data handler of an instrument, clients can stream market data of the instrument from now on.
+func (s *Service) Handler(instrument string) tome.MarketDataHandler {
+	s.mutex.Lock()
+	b := s.bookLocked(instrument)
+	s.mutex.Unlock()
+	return func(update tome.MarketDataUpdate) {
+		s.update(b, update)
+	}
+}
+
+// Replace the replica of an order book with its current displayed price levels. The book has to be registered with the
+// handler of its instrument, so updates following the seed are applied to the replica. Streams of the instrument
+// receive a new snapshot.
+func (s *Service) Seed(ob *tome.OrderBook) {
+	depth := ob.Depth(0)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	b := s.bookLocked(ob.Instrument)
+	b.bids, b.asks = seedLevels(depth.Bids), seedLevels(depth.Asks)
+	b.sequence += 1
+	snapshot := b.snapshot()
+	for sub := range b.subscribers {
+		s.sendLocked(b, sub, snapshot)
+	}
+}
+
+func seedLevels(depth []tome.DepthLevel) map[string]level {
+	levels := make(map[string]level, len(depth))
+	for _, l := range depth {
+		levels[l.Price.Text('f')] = level{price: l.Price, qty: l.Qty, count: l.Count}
+	}
+	return levels
+}
+
+func (s *Service) bookLocked(instrument string) *book {
+	b, ok := s.books[instrument]
+	if !ok {
+		b = &book{
+			instrument:  instrument,
+			bids:        make(map[string]level),
+			asks:        make(map[string]level),
+			subscribers: make(map[*subscriber]struct{}),
+		}
+		s.books[instrument] = b
+	}
+	return b
+}
+
+// apply L2 updates to the replica and publish all updates
+func (s *Service) update(b *book, update tome.MarketDataUpdate) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch update.Type {
+	case tome.UpdateLevelAdd, tome.UpdateLevelChange:
+		b.levels(update.Side)[update.Price.Text('f')] = level{price: update.Price, qty: update.Qty, count: update.Count}
+	case tome.UpdateLevelDelete:
+		delete(b.levels(update.Side), update.Price.Text('f'))
+	}
+	b.sequence += 1
+	s.publishLocked(b, &tomepb.MarketDataMessage{Message: &tomepb.MarketDataMessage_Update{Update: &tomepb.MarketDataUpdate{
+		Sequence:    b.sequence,
+		Type:        tomepb.MarketDataUpdate_Type(update.Type),
+		Timestamp:   timestamp(update.Timestamp),
+		Side:        side(update.Side),
+		Price:       update.Price.Text('f'),
+		Qty:         update.Qty,
+		Count:       int32(update.Count),
+		OrderId:     update.OrderID,
+		TradeId:     update.TradeID,
+		ExecutedQty: update.ExecutedQty,
+	}}})
+}
+
+// Publish a trade, implements tome.TradeCallback. Trades share the sequence of market data updates of the instrument.
+func (s *Service) Execute(trade tome.Trade) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	b := s.bookLocked(trade.Instrument)
+	b.sequence += 1
+	s.publishLocked(b, &tomepb.MarketDataMessage{Message: &tomepb.MarketDataMessage_Trade{Trade: &tomepb.Trade{
+		Id:            trade.ID,
+		Instrument:    trade.Instrument,
+		Qty:           trade.Qty,
+		Price:         trade.Price.Text('f'),
+		Total:         trade.Total.Text('f'),
+		Timestamp:     timestamp(trade.Timestamp),
+		BidOrderId:    trade.BidOrderID,
+		AskOrderId:    trade.AskOrderID,
+		AggressorSide: side(trade.AggressorSide),
+	}}})
+}
+
+func (s *Service) publishLocked(b *book, msg *tomepb.MarketDataMessage) {
+	for sub := range b.subscribers {
+		s.sendLocked(b, sub, msg)
+	}
+}
+
+// send a message without waiting, a stream with a full buffer is closed
+func (s *Service) sendLocked(b *book, sub *subscriber, msg *tomepb.MarketDataMessage) {
+	if sub.dropped {
+		return
+	}
+	select {
+	case sub.send <- msg:
+	default:
+		s.dropLocked(b, sub)
+	}
+}
+
+func (s *Service) dropLocked(b *book, sub *subscriber) {
+	if sub.dropped {
+		return
+	}
+	sub.dropped = true
+	delete(b.subscribers, sub)
+	close(sub.send)
+}
+
+// Stream a snapshot of displayed price levels of an instrument followed by sequenced updates and trades until the
+// client cancels the stream.
+func (s *Service) MarketData(request *tomepb.MarketDataRequest, stream tomepb.MatchingEngine_MarketDataServer) error {
+	s.mutex.Lock()
+	b, ok := s.books[request.Instrument]
+	if !ok {
+		s.mutex.Unlock()
+		return status.Error(codes.NotFound, server.ErrUnknownInstrument.Error())
+	}
+	sub := &subscriber{send: make(chan *tomepb.MarketDataMessage, s.sendBuffer)}
+	b.subscribers[sub] = struct{}{}
+	s.sendLocked(b, sub, b.snapshot())
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.dropLocked(b, sub)
+	}()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case msg, ok := <-sub.send:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, ErrSlowConsumer.Error())
+			}
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
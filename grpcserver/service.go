@@ -0,0 +1,327 @@
+// Package grpcserver implements the matching engine gRPC service (tomepb.MatchingEngineServer).
+//
+// Orders are entered, amended and cancelled through the order entry server on behalf of the customer in the request,
+// so order IDs and ownership are shared with other gateways. Execution reports are streamed for all orders of a
+// customer. Market data is streamed from a replica of displayed price levels, kept by the service as a market data
+// handler and a trade callback of the order books.
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"github.com/ffhan/tome"
+	"github.com/ffhan/tome/server"
+	"github.com/ffhan/tome/tomepb"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Number of messages buffered for a stream, streams which fall further behind are closed.
+const DefaultSendBuffer = 1024
+
+var ErrSlowConsumer = errors.New("stream fell behind")
+
+// Matching engine gRPC service. Register it with tomepb.RegisterMatchingEngineServer, register order books with
+// WithMarketDataHandler(service.Handler(instrument)) and their trade books with WithTradeCallback(service) to stream
+// their market data, and seed the replicas of books which already have orders with Seed.
+type Service struct {
+	tomepb.UnimplementedMatchingEngineServer
+
+	server     *server.Server
+	sendBuffer int
+
+	mutex     sync.Mutex
+	customers map[uuid.UUID]*customer
+	books     map[string]*book // market data by instrument
+}
+
+// Option changes the default behaviour of a service.
+type Option func(s *Service)
+
+// Buffer up to size messages for each stream.
+func WithSendBuffer(size int) Option {
+	return func(s *Service) {
+		s.sendBuffer = size
+	}
+}
+
+// Create a service of order books registered with the server.
+func New(srv *server.Server, opts ...Option) *Service {
+	s := &Service{
+		server:     srv,
+		sendBuffer: DefaultSendBuffer,
+		customers:  make(map[uuid.UUID]*customer),
+		books:      make(map[string]*book),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Server client of a customer. Requests of a customer are processed sequentially and collect the messages they cause.
+type customer struct {
+	request sync.Mutex
+	client  *server.Client
+
+	mutex    sync.Mutex
+	capture  bool
+	messages []server.Message
+}
+
+func (c *customer) sink(msg server.Message) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.capture {
+		c.messages = append(c.messages, msg)
+	}
+}
+
+// process a request, returns messages sent while it was processed
+func (c *customer) do(request func(client *server.Client)) []server.Message {
+	c.request.Lock()
+	defer c.request.Unlock()
+
+	c.mutex.Lock()
+	c.capture = true
+	c.mutex.Unlock()
+
+	request(c.client)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	messages := c.messages
+	c.capture, c.messages = false, nil
+	return messages
+}
+
+// returns the customer of a request, fails if the customer ID is invalid
+func (s *Service) customer(customerID string) (*customer, error) {
+	id, err := uuid.Parse(customerID)
+	if err != nil || id == uuid.Nil {
+		return nil, status.Error(codes.InvalidArgument, server.ErrInvalidCustomerID.Error())
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	c, ok := s.customers[id]
+	if !ok {
+		c = &customer{}
+		c.client = s.server.Connect(id, c.sink)
+		s.customers[id] = c
+	}
+	return c, nil
+}
+
+// Enter a new order.
+func (s *Service) NewOrder(ctx context.Context, request *tomepb.NewOrderRequest) (*tomepb.OrderResponse, error) {
+	c, err := s.customer(request.CustomerId)
+	if err != nil {
+		return nil, err
+	}
+	msg := server.Message{
+		Type:          server.MessageNew,
+		ClientOrderID: request.ClientOrderId,
+		Instrument:    request.Instrument,
+		OrderType:     strings.TrimPrefix(request.Type.String(), "ORDER_TYPE_"),
+		Side:          strings.TrimPrefix(request.Side.String(), "SIDE_"),
+		Qty:           request.Qty,
+		Price:         request.Price,
+		StopPrice:     request.StopPrice,
+		DisplayQty:    request.DisplayQty,
+	}
+	for _, param := range request.Params {
+		msg.Params = append(msg.Params, strings.TrimPrefix(param.String(), "ORDER_PARAM_"))
+	}
+	if request.ExpireTime != nil {
+		expireTime := request.ExpireTime.AsTime()
+		msg.ExpireTime = &expireTime
+	}
+	var orderID uint64
+	messages := c.do(func(client *server.Client) {
+		orderID = client.Enter(msg)
+	})
+	return orderResponse(&tomepb.OrderResponse{OrderId: orderID, ClientOrderId: request.ClientOrderId, Qty: request.Qty}, server.MessageNew, messages)
+}
+
+// Cancel an order entered through the service.
+func (s *Service) CancelOrder(ctx context.Context, request *tomepb.CancelOrderRequest) (*tomepb.OrderResponse, error) {
+	c, err := s.customer(request.CustomerId)
+	if err != nil {
+		return nil, err
+	}
+	order, ok := s.activeOrder(request.OrderId, c.client.CustomerID())
+	if !ok {
+		return nil, status.Error(codes.NotFound, tome.ErrOrderNotFound.Error())
+	}
+	messages := c.do(func(client *server.Client) {
+		client.Cancel(server.Message{Type: server.MessageCancel, OrderID: request.OrderId, ClientOrderID: request.ClientOrderId})
+	})
+	return orderResponse(&tomepb.OrderResponse{OrderId: request.OrderId, Qty: order.Qty, FilledQty: order.FilledQty}, server.MessageCancel, messages)
+}
+
+// Amend the quantity and price of an order entered through the service, an empty price keeps the current one.
+func (s *Service) AmendOrder(ctx context.Context, request *tomepb.AmendOrderRequest) (*tomepb.OrderResponse, error) {
+	c, err := s.customer(request.CustomerId)
+	if err != nil {
+		return nil, err
+	}
+	order, ok := s.activeOrder(request.OrderId, c.client.CustomerID())
+	if !ok {
+		return nil, status.Error(codes.NotFound, tome.ErrOrderNotFound.Error())
+	}
+	price := request.Price
+	if price == "" && !order.Price.IsZero() {
+		price = order.Price.Text('f')
+	}
+	messages := c.do(func(client *server.Client) {
+		client.Amend(server.Message{
+			Type:          server.MessageAmend,
+			OrderID:       request.OrderId,
+			ClientOrderID: request.ClientOrderId,
+			Qty:           request.Qty,
+			Price:         price,
+		})
+	})
+	return orderResponse(&tomepb.OrderResponse{OrderId: request.OrderId, Qty: order.Qty, FilledQty: order.FilledQty}, server.MessageAmend, messages)
+}
+
+// returns an active order of a customer
+func (s *Service) activeOrder(orderID uint64, customerID uuid.UUID) (tome.Order, bool) {
+	for _, book := range s.server.Books() {
+		if order, ok := book.GetOrder(orderID); ok {
+			return order, order.CustomerID == customerID
+		}
+	}
+	return tome.Order{}, false
+}
+
+// Apply messages of an order request to the order state. Fails with the rejection if the request was rejected.
+func orderResponse(response *tomepb.OrderResponse, requestType server.MessageType, messages []server.Message) (*tomepb.OrderResponse, error) {
+	cancelled := false
+	for _, msg := range messages {
+		if msg.Type == server.MessageRejected && msg.RefType == requestType && (msg.OrderID == response.OrderId || msg.OrderID == 0) {
+			code := codes.InvalidArgument
+			if msg.Reason == tome.ErrOrderNotFound.Error() {
+				code = codes.NotFound
+			}
+			return nil, status.Error(code, msg.Reason)
+		}
+		if msg.OrderID != response.OrderId {
+			continue
+		}
+		switch msg.Type {
+		case server.MessageAccepted:
+			response.ClientOrderId = msg.ClientOrderID
+		case server.MessageFill:
+			response.Fills = append(response.Fills, &tomepb.Fill{TradeId: msg.TradeID, Qty: msg.LastQty, Price: msg.LastPrice})
+			response.FilledQty = msg.FilledQty
+		case server.MessageAmended:
+			response.ClientOrderId, response.Qty = msg.ClientOrderID, msg.Qty
+		case server.MessageCancelled:
+			response.ClientOrderId, response.FilledQty = msg.ClientOrderID, msg.FilledQty
+			cancelled = true
+		}
+	}
+	switch {
+	case cancelled:
+		response.Status = tomepb.OrderStatus_ORDER_STATUS_CANCELLED
+	case response.FilledQty >= response.Qty:
+		response.Status = tomepb.OrderStatus_ORDER_STATUS_FILLED
+	case response.FilledQty > 0:
+		response.Status = tomepb.OrderStatus_ORDER_STATUS_PARTIALLY_FILLED
+	default:
+		response.Status = tomepb.OrderStatus_ORDER_STATUS_NEW
+	}
+	if !cancelled {
+		response.LeavesQty = response.Qty - response.FilledQty
+	}
+	return response, nil
+}
+
+var reportTypes = map[server.MessageType]tomepb.ExecutionReport_Type{
+	server.MessageAccepted:  tomepb.ExecutionReport_TYPE_ACCEPTED,
+	server.MessageRejected:  tomepb.ExecutionReport_TYPE_REJECTED,
+	server.MessageFill:      tomepb.ExecutionReport_TYPE_FILL,
+	server.MessageCancelled: tomepb.ExecutionReport_TYPE_CANCELLED,
+	server.MessageAmended:   tomepb.ExecutionReport_TYPE_AMENDED,
+}
+
+// stream of execution reports of a customer
+type reports struct {
+	mutex   sync.Mutex
+	send    chan *tomepb.ExecutionReport
+	dropped bool
+}
+
+// queue a report without waiting, the stream is closed if its buffer is full
+func (r *reports) sink(msg server.Message) {
+	reportType, ok := reportTypes[msg.Type]
+	if !ok {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.dropped {
+		return
+	}
+	select {
+	case r.send <- &tomepb.ExecutionReport{
+		Type:              reportType,
+		OrderId:           msg.OrderID,
+		ClientOrderId:     msg.ClientOrderID,
+		OrigClientOrderId: msg.OrigClientOrderID,
+		Reason:            msg.Reason,
+		TradeId:           msg.TradeID,
+		LastQty:           msg.LastQty,
+		LastPrice:         msg.LastPrice,
+		FilledQty:         msg.FilledQty,
+		LeavesQty:         msg.LeavesQty,
+	}:
+	default:
+		r.dropped = true
+		close(r.send)
+	}
+}
+
+// Stream execution reports of all orders of a customer until the client cancels the stream.
+func (s *Service) ExecutionReports(request *tomepb.ExecutionReportsRequest, stream tomepb.MatchingEngine_ExecutionReportsServer) error {
+	customerID, err := uuid.Parse(request.CustomerId)
+	if err != nil || customerID == uuid.Nil {
+		return status.Error(codes.InvalidArgument, server.ErrInvalidCustomerID.Error())
+	}
+	r := &reports{send: make(chan *tomepb.ExecutionReport, s.sendBuffer)}
+	stop := s.server.Watch(customerID, r.sink)
+	defer stop()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case report, ok := <-r.send:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, ErrSlowConsumer.Error())
+			}
+			if err := stream.Send(report); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func side(side tome.OrderSide) tomepb.Side {
+	if side == tome.SideBuy {
+		return tomepb.Side_SIDE_BUY
+	}
+	return tomepb.Side_SIDE_SELL
+}
+
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
@@ -0,0 +1,274 @@
+package grpcserver
+
+import (
+	"context"
+	"github.com/cockroachdb/apd"
+	"github.com/ffhan/tome"
+	"github.com/ffhan/tome/server"
+	"github.com/ffhan/tome/tomepb"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"testing"
+	"time"
+)
+
+const instrument = "TEST"
+
+func setup(t *testing.T) (tomepb.MatchingEngineClient, *tome.OrderBook) {
+	srv := server.New()
+	service := New(srv)
+	tb := tome.NewTradeBook(instrument, tome.WithTradeCallback(srv), tome.WithTradeCallback(service))
+	ob := tome.NewOrderBook(instrument, *apd.New(2000, -2), tb, tome.NOPOrderRepository, tome.WithMarketDataHandler(service.Handler(instrument)))
+	srv.Register(ob)
+
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer()
+	tomepb.RegisterMatchingEngineServer(grpcServer, service)
+	go grpcServer.Serve(listener)
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		grpcServer.Stop()
+		srv.Close()
+	})
+	return tomepb.NewMatchingEngineClient(conn), ob
+}
+
+func context1s(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func newOrder(customerID uuid.UUID, side tomepb.Side, qty int64, price string) *tomepb.NewOrderRequest {
+	return &tomepb.NewOrderRequest{
+		CustomerId: customerID.String(),
+		Instrument: instrument,
+		Type:       tomepb.OrderType_ORDER_TYPE_LIMIT,
+		Side:       side,
+		Qty:        qty,
+		Price:      price,
+	}
+}
+
+func expectCode(t *testing.T, err error, code codes.Code) {
+	t.Helper()
+	if status.Code(err) != code {
+		t.Errorf("expected %s, got %v", code, err)
+	}
+}
+
+func TestService_Orders(t *testing.T) {
+	client, ob := setup(t)
+	ctx := context1s(t)
+	buyer, seller := uuid.New(), uuid.New()
+
+	ask, err := client.NewOrder(ctx, newOrder(seller, tomepb.Side_SIDE_SELL, 5, "20"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ask.Status != tomepb.OrderStatus_ORDER_STATUS_NEW || ask.LeavesQty != 5 {
+		t.Errorf("expected a new order, got %v", ask)
+	}
+
+	request := newOrder(buyer, tomepb.Side_SIDE_BUY, 10, "20")
+	request.ClientOrderId = "b1"
+	bid, err := client.NewOrder(ctx, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bid.Status != tomepb.OrderStatus_ORDER_STATUS_PARTIALLY_FILLED || bid.ClientOrderId != "b1" || bid.FilledQty != 5 ||
+		bid.LeavesQty != 5 || len(bid.Fills) != 1 || bid.Fills[0].Price != "20" {
+		t.Errorf("expected a partially filled order, got %v", bid)
+	}
+
+	amended, err := client.AmendOrder(ctx, &tomepb.AmendOrderRequest{CustomerId: buyer.String(), OrderId: bid.OrderId, ClientOrderId: "b2", Qty: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if amended.ClientOrderId != "b2" || amended.Qty != 8 || amended.LeavesQty != 3 {
+		t.Errorf("expected an amended order, got %v", amended)
+	}
+	if order, _ := ob.GetOrder(bid.OrderId); order.Price.Text('f') != "20" {
+		t.Errorf("expected the amendment to keep the price, got %s", order.Price.Text('f'))
+	}
+
+	_, err = client.CancelOrder(ctx, &tomepb.CancelOrderRequest{CustomerId: seller.String(), OrderId: bid.OrderId})
+	expectCode(t, err, codes.NotFound)
+
+	cancelled, err := client.CancelOrder(ctx, &tomepb.CancelOrderRequest{CustomerId: buyer.String(), OrderId: bid.OrderId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cancelled.Status != tomepb.OrderStatus_ORDER_STATUS_CANCELLED || cancelled.FilledQty != 5 || cancelled.ClientOrderId != "b2" {
+		t.Errorf("expected a cancelled order, got %v", cancelled)
+	}
+	if _, ok := ob.GetOrder(bid.OrderId); ok {
+		t.Errorf("expected the cancelled order not to be active")
+	}
+}
+
+func TestService_Reject(t *testing.T) {
+	client, _ := setup(t)
+	ctx := context1s(t)
+	customerID := uuid.New()
+
+	_, err := client.NewOrder(ctx, newOrder(uuid.Nil, tomepb.Side_SIDE_BUY, 10, "20"))
+	expectCode(t, err, codes.InvalidArgument)
+
+	request := newOrder(customerID, tomepb.Side_SIDE_BUY, 10, "20")
+	request.Instrument = "UNKNOWN"
+	_, err = client.NewOrder(ctx, request)
+	expectCode(t, err, codes.InvalidArgument)
+	if status.Convert(err).Message() != server.ErrUnknownInstrument.Error() {
+		t.Errorf("expected %v, got %v", server.ErrUnknownInstrument, err)
+	}
+
+	_, err = client.NewOrder(ctx, newOrder(customerID, tomepb.Side_SIDE_UNSPECIFIED, 10, "20"))
+	expectCode(t, err, codes.InvalidArgument)
+
+	_, err = client.AmendOrder(ctx, &tomepb.AmendOrderRequest{CustomerId: customerID.String(), OrderId: 100, Qty: 5})
+	expectCode(t, err, codes.NotFound)
+}
+
+func TestService_ExecutionReports(t *testing.T) {
+	client, _ := setup(t)
+	ctx := context1s(t)
+	customerID := uuid.New()
+
+	stream, err := client.ExecutionReports(ctx, &tomepb.ExecutionReportsRequest{CustomerId: customerID.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reports := make(chan *tomepb.ExecutionReport)
+	go func() {
+		defer close(reports)
+		for {
+			report, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			reports <- report
+		}
+	}()
+	// the stream is watched once the server receives it, probe it with rejected orders until they're reported
+	probe := newOrder(customerID, tomepb.Side_SIDE_BUY, 10, "19")
+	probe.Instrument = "UNKNOWN"
+	for watched := false; !watched; {
+		client.NewOrder(ctx, probe)
+		select {
+		case report := <-reports:
+			if report.Type != tomepb.ExecutionReport_TYPE_REJECTED || report.Reason != server.ErrUnknownInstrument.Error() {
+				t.Fatalf("expected a rejected report, got %v", report)
+			}
+			watched = true
+		case <-time.After(10 * time.Millisecond):
+		case <-ctx.Done():
+			t.Fatal("expected a rejected report")
+		}
+	}
+
+	bid, err := client.NewOrder(ctx, newOrder(customerID, tomepb.Side_SIDE_BUY, 10, "19"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.NewOrder(ctx, newOrder(uuid.New(), tomepb.Side_SIDE_SELL, 4, "19")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CancelOrder(ctx, &tomepb.CancelOrderRequest{CustomerId: customerID.String(), OrderId: bid.OrderId, ClientOrderId: "c1"}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []*tomepb.ExecutionReport{
+		{Type: tomepb.ExecutionReport_TYPE_ACCEPTED},
+		{Type: tomepb.ExecutionReport_TYPE_FILL, LastQty: 4, LastPrice: "19", FilledQty: 4, LeavesQty: 6},
+		{Type: tomepb.ExecutionReport_TYPE_CANCELLED, ClientOrderId: "c1", FilledQty: 4},
+	}
+	for _, e := range expected {
+		report := <-reports
+		for report != nil && report.Type == tomepb.ExecutionReport_TYPE_REJECTED { // late reports of probes
+			report = <-reports
+		}
+		if report == nil {
+			t.Fatalf("expected a %s report, the stream ended", e.Type)
+		}
+		if report.Type != e.Type || report.OrderId != bid.OrderId || report.LastQty != e.LastQty || report.LastPrice != e.LastPrice ||
+			report.FilledQty != e.FilledQty || report.LeavesQty != e.LeavesQty || report.ClientOrderId != e.ClientOrderId {
+			t.Errorf("expected %v, got %v", e, report)
+		}
+	}
+}
+
+func TestService_MarketData(t *testing.T) {
+	client, ob := setup(t)
+	ctx := context1s(t)
+	customerID := uuid.New()
+
+	if _, err := client.NewOrder(ctx, newOrder(customerID, tomepb.Side_SIDE_BUY, 10, "19")); err != nil {
+		t.Fatal(err)
+	}
+	stream, err := client.MarketData(ctx, &tomepb.MarketDataRequest{Instrument: instrument})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot := msg.GetSnapshot()
+	if snapshot == nil || snapshot.Instrument != instrument || len(snapshot.Bids) != 1 || snapshot.Bids[0].Price != "19.0000" ||
+		snapshot.Bids[0].Qty != 10 || snapshot.Bids[0].Count != 1 || len(snapshot.Asks) != 0 {
+		t.Fatalf("expected a snapshot with one bid level, got %v", msg)
+	}
+
+	if _, err := client.NewOrder(ctx, newOrder(uuid.New(), tomepb.Side_SIDE_SELL, 4, "19")); err != nil {
+		t.Fatal(err)
+	}
+	sequence := snapshot.Sequence
+	var trade *tomepb.Trade
+	var changed bool
+	for trade == nil || !changed {
+		msg, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sequence += 1
+		switch m := msg.Message.(type) {
+		case *tomepb.MarketDataMessage_Update:
+			if m.Update.Sequence != sequence {
+				t.Fatalf("expected sequence %d, got %d", sequence, m.Update.Sequence)
+			}
+			if m.Update.Type == tomepb.MarketDataUpdate_TYPE_LEVEL_CHANGE {
+				changed = m.Update.Side == tomepb.Side_SIDE_BUY && m.Update.Price == "19.0000" && m.Update.Qty == 6
+			}
+		case *tomepb.MarketDataMessage_Trade:
+			trade = m.Trade
+		default:
+			t.Fatalf("expected an update or a trade, got %v", msg)
+		}
+	}
+	if trade.Qty != 4 || trade.Price != "19" || trade.Total != "76" || trade.AggressorSide != tomepb.Side_SIDE_SELL {
+		t.Errorf("expected a trade of 4 at 19, got %v", trade)
+	}
+	if depth := ob.Depth(0); len(depth.Bids) != 1 || depth.Bids[0].Qty != 6 {
+		t.Errorf("expected the book to keep 6 at 19, got %+v", depth)
+	}
+
+	unknown, err := client.MarketData(ctx, &tomepb.MarketDataRequest{Instrument: "UNKNOWN"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = unknown.Recv()
+	expectCode(t, err, codes.NotFound)
+}
@@ -0,0 +1,231 @@
+package itch
+
+import (
+	"github.com/cockroachdb/apd"
+	"github.com/ffhan/tome"
+	"sync"
+	"time"
+)
+
+// Number of last messages kept for publishers and replays.
+const DefaultHistory = 1 << 20
+
+// key of a displayed order
+type orderKey struct {
+	locate  uint16
+	orderID uint64
+}
+
+// last trade of an instrument, executions of displayed orders are published after their trade
+type lastTrade struct {
+	id    uint64
+	price int64
+}
+
+// Sequenced market data feed of order books. Register order books with WithMarketDataHandler(feed.Handler(instrument))
+// and their trade books with WithTradeCallback(feed). Handlers are called synchronously while matching, so the feed
+// only encodes messages and keeps them in memory - publishers and replays read them from there.
+type Feed struct {
+	session Session
+
+	mutex     sync.Mutex
+	sequence  uint64   // sequence number of the last message
+	history   [][]byte // last messages with their length prefixes, by sequence number modulo its length
+	locates   map[string]uint16
+	displayed map[orderKey]struct{}
+	trades    map[uint16]lastTrade
+	listeners map[chan struct{}]struct{}
+}
+
+// Option changes the default behaviour of a feed.
+type Option func(f *Feed)
+
+// Keep the last size messages for publishers and replays.
+func WithHistory(size int) Option {
+	return func(f *Feed) {
+		f.history = make([][]byte, size)
+	}
+}
+
+// Create a new feed session, the first message is the start of messages system event.
+func NewFeed(session string, opts ...Option) *Feed {
+	f := &Feed{
+		session:   NewSession(session),
+		history:   make([][]byte, DefaultHistory),
+		locates:   make(map[string]uint16),
+		displayed: make(map[orderKey]struct{}),
+		trades:    make(map[uint16]lastTrade),
+		listeners: make(map[chan struct{}]struct{}),
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	f.SystemEvent(EventStartOfMessages)
+	return f
+}
+
+// Returns the feed session.
+func (f *Feed) Session() Session {
+	return f.session
+}
+
+// Returns the sequence number of the last message.
+func (f *Feed) Sequence() uint64 {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.sequence
+}
+
+// Publish a system event, e.g. the start of market hours.
+func (f *Feed) SystemEvent(eventCode byte) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	msg := SystemEvent{Header: Header{Timestamp: time.Now().UnixNano()}, EventCode: eventCode}
+	f.appendLocked(msg.Append(nil))
+}
+
+// Returns the market data handler of an instrument, registers the instrument with a Directory message.
+func (f *Feed) Handler(instrument string) tome.MarketDataHandler {
+	f.mutex.Lock()
+	locate := f.locateLocked(instrument)
+	f.mutex.Unlock()
+	return func(update tome.MarketDataUpdate) {
+		f.update(locate, update)
+	}
+}
+
+// returns the locate code of an instrument, sends a Directory message for new instruments
+func (f *Feed) locateLocked(instrument string) uint16 {
+	if locate, ok := f.locates[instrument]; ok {
+		return locate
+	}
+	locate := uint16(len(f.locates) + 1)
+	f.locates[instrument] = locate
+	msg := Directory{Header: Header{Locate: locate, Timestamp: time.Now().UnixNano()}, Symbol: NewSymbol(instrument)}
+	f.appendLocked(msg.Append(nil))
+	return locate
+}
+
+// publish an L3 update, L1 and L2 updates can be derived from them
+func (f *Feed) update(locate uint16, update tome.MarketDataUpdate) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	header := Header{Locate: locate, Timestamp: update.Timestamp.UnixNano()}
+	key := orderKey{locate: locate, orderID: update.OrderID}
+	price := fixedPrice(update.Price)
+	switch update.Type {
+	case tome.UpdateOrderAdd:
+		f.displayed[key] = struct{}{}
+		msg := AddOrder{Header: header, OrderID: update.OrderID, Side: side(update.Side), Qty: uint32(update.Qty), Price: price}
+		f.appendLocked(msg.Append(nil))
+	case tome.UpdateOrderModify:
+		msg := OrderReplace{Header: header, OrigOrderID: update.OrderID, NewOrderID: update.OrderID, Qty: uint32(update.Qty), Price: price}
+		f.appendLocked(msg.Append(nil))
+	case tome.UpdateOrderExecute:
+		if trade, ok := f.trades[locate]; ok && trade.id == update.TradeID {
+			price = trade.price
+		}
+		msg := OrderExecuted{
+			Header:       header,
+			OrderID:      update.OrderID,
+			ExecutedQty:  uint32(update.ExecutedQty),
+			MatchNumber:  update.TradeID,
+			Price:        price,
+			DisplayedQty: uint32(update.Qty),
+		}
+		f.appendLocked(msg.Append(nil))
+	case tome.UpdateOrderDelete:
+		delete(f.displayed, key)
+		msg := OrderDelete{Header: header, OrderID: update.OrderID}
+		f.appendLocked(msg.Append(nil))
+	}
+}
+
+// Publish trades of orders which aren't displayed, implements tome.TradeCallback.
+func (f *Feed) Execute(trade tome.Trade) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	locate := f.locateLocked(trade.Instrument)
+	price := fixedPrice(trade.Price)
+	f.trades[locate] = lastTrade{id: trade.ID, price: price}
+
+	restingID := trade.BidOrderID
+	if trade.AggressorSide == tome.SideBuy {
+		restingID = trade.AskOrderID
+	}
+	if _, ok := f.displayed[orderKey{locate: locate, orderID: restingID}]; ok {
+		return // published as OrderExecuted
+	}
+	msg := Trade{
+		Header:      Header{Locate: locate, Timestamp: trade.Timestamp.UnixNano()},
+		OrderID:     restingID,
+		Side:        side(!trade.AggressorSide),
+		Qty:         uint32(trade.Qty),
+		Price:       price,
+		MatchNumber: trade.ID,
+	}
+	f.appendLocked(msg.Append(nil))
+}
+
+// assign the next sequence number to a message and notify listeners
+func (f *Feed) appendLocked(msg []byte) {
+	f.sequence += 1
+	f.history[f.sequence%uint64(len(f.history))] = msg
+	for listener := range f.listeners {
+		select {
+		case listener <- struct{}{}:
+		default: // already notified
+		}
+	}
+}
+
+// Returns up to max messages (with their length prefixes) starting at the sequence number, or at the oldest kept
+// message if older messages were discarded. Returns the sequence number of the first returned message.
+func (f *Feed) Messages(sequence uint64, max int) (uint64, [][]byte) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if oldest := f.oldestLocked(); sequence < oldest {
+		sequence = oldest
+	}
+	var messages [][]byte
+	for seq := sequence; seq <= f.sequence && len(messages) < max; seq++ {
+		messages = append(messages, f.history[seq%uint64(len(f.history))])
+	}
+	return sequence, messages
+}
+
+func (f *Feed) oldestLocked() uint64 {
+	if f.sequence < uint64(len(f.history)) {
+		return 1
+	}
+	return f.sequence - uint64(len(f.history)) + 1
+}
+
+// returns a channel which receives a value after new messages were added
+func (f *Feed) listen() chan struct{} {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	listener := make(chan struct{}, 1)
+	f.listeners[listener] = struct{}{}
+	return listener
+}
+
+func (f *Feed) unlisten(listener chan struct{}) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.listeners, listener)
+}
+
+func side(s tome.OrderSide) byte {
+	if s == tome.SideBuy {
+		return SideBuy
+	}
+	return SideSell
+}
+
+func fixedPrice(price apd.Decimal) int64 {
+	fixed, _ := tome.ToFixedPrice(price, PriceScale)
+	return fixed
+}
@@ -0,0 +1,226 @@
+package itch
+
+import (
+	"fmt"
+	"github.com/cockroachdb/apd"
+	"github.com/ffhan/tome"
+	"net"
+	"testing"
+	"time"
+)
+
+const instrument = "TEST"
+
+func setup(t *testing.T, opts ...Option) (*Feed, *tome.OrderBook) {
+	feed := NewFeed("TEST01", opts...)
+	tb := tome.NewTradeBook(instrument, tome.WithTradeCallback(feed))
+	ob := tome.NewOrderBook(instrument, *apd.New(2000, -2), tb, tome.NOPOrderRepository, tome.WithMarketDataHandler(feed.Handler(instrument)))
+	return feed, ob
+}
+
+func limitOrder(id uint64, side tome.OrderSide, qty int64, price string, params tome.OrderParams) tome.Order {
+	p, _, _ := apd.NewFromString(price)
+	return tome.Order{
+		ID:         id,
+		Instrument: instrument,
+		Timestamp:  time.Now(),
+		Type:       tome.TypeLimit,
+		Params:     params,
+		Qty:        qty,
+		Price:      *p,
+		Side:       side,
+	}
+}
+
+// trade against a displayed and a hidden order and cancel the rest
+func trade(t *testing.T, ob *tome.OrderBook) {
+	orders := []tome.Order{
+		limitOrder(1, tome.SideSell, 10, "20.25", 0),
+		limitOrder(2, tome.SideSell, 5, "20.20", tome.ParamHidden),
+		limitOrder(3, tome.SideBuy, 7, "20.30", tome.ParamIOC),
+	}
+	for _, order := range orders {
+		if _, err := ob.Add(order); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ob.Cancel(1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// describe a message without its timestamp
+func describe(t *testing.T, msg []byte) string {
+	var err error
+	var description string
+	switch msg[0] {
+	case TypeSystemEvent:
+		var m SystemEvent
+		err = m.Unmarshal(msg)
+		description = fmt.Sprintf("S %c", m.EventCode)
+	case TypeDirectory:
+		var m Directory
+		err = m.Unmarshal(msg)
+		description = fmt.Sprintf("R %d %s", m.Locate, m.Symbol)
+	case TypeAddOrder:
+		var m AddOrder
+		err = m.Unmarshal(msg)
+		description = fmt.Sprintf("A %d %d %c %d %d", m.Locate, m.OrderID, m.Side, m.Qty, m.Price)
+	case TypeOrderExecuted:
+		var m OrderExecuted
+		err = m.Unmarshal(msg)
+		description = fmt.Sprintf("E %d %d %d %d %d %d", m.Locate, m.OrderID, m.ExecutedQty, m.MatchNumber, m.Price, m.DisplayedQty)
+	case TypeOrderReplace:
+		var m OrderReplace
+		err = m.Unmarshal(msg)
+		description = fmt.Sprintf("U %d %d %d %d %d", m.Locate, m.OrigOrderID, m.NewOrderID, m.Qty, m.Price)
+	case TypeOrderDelete:
+		var m OrderDelete
+		err = m.Unmarshal(msg)
+		description = fmt.Sprintf("D %d %d", m.Locate, m.OrderID)
+	case TypeTrade:
+		var m Trade
+		err = m.Unmarshal(msg)
+		description = fmt.Sprintf("P %d %d %c %d %d %d", m.Locate, m.OrderID, m.Side, m.Qty, m.Price, m.MatchNumber)
+	default:
+		t.Fatalf("unknown message type %c", msg[0])
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return description
+}
+
+var expectedMessages = []string{
+	"S O",
+	"R 1 TEST",
+	"A 1 1 S 10 202500",
+	"P 1 2 S 5 203000 0", // hidden order
+	"E 1 1 2 1 203000 8",
+	"D 1 1",
+}
+
+func TestFeed(t *testing.T) {
+	feed, ob := setup(t)
+	trade(t, ob)
+
+	first, messages := feed.Messages(1, 100)
+	if first != 1 || len(messages) != len(expectedMessages) {
+		t.Fatalf("expected %d messages from sequence 1, got %d from %d", len(expectedMessages), len(messages), first)
+	}
+	for i, msg := range messages {
+		if actual := describe(t, msg[2:]); actual != expectedMessages[i] {
+			t.Errorf("message %d: expected %q, got %q", i+1, expectedMessages[i], actual)
+		}
+	}
+	if feed.Sequence() != uint64(len(expectedMessages)) {
+		t.Errorf("expected sequence %d, got %d", len(expectedMessages), feed.Sequence())
+	}
+
+	feed, ob = setup(t, WithHistory(4))
+	trade(t, ob)
+	first, messages = feed.Messages(1, 100)
+	if first != 3 || len(messages) != 4 {
+		t.Errorf("expected 4 kept messages from sequence 3, got %d from %d", len(messages), first)
+	}
+}
+
+func TestPublisher(t *testing.T) {
+	receiver, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Close()
+
+	feed, ob := setup(t)
+	publisher, err := NewPublisher(feed, receiver.LocalAddr().String(), WithMaxPacketSize(PacketHeaderSize+2*AddOrderSize), WithHeartbeatInterval(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Close()
+	trade(t, ob)
+
+	buf := make([]byte, DefaultMaxPacketSize)
+	var received []string
+	next := uint64(1)
+	for len(received) < len(expectedMessages) {
+		receiver.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := receiver.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		packet, err := ParsePacket(buf[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if packet.Session != NewSession("TEST01") || packet.Sequence != next {
+			t.Fatalf("expected a packet of session TEST01 with sequence %d, got %+v", next, packet)
+		}
+		if n > PacketHeaderSize+2*AddOrderSize && packet.Count > 1 {
+			t.Errorf("expected packets of at most %d bytes, got %d", PacketHeaderSize+2*AddOrderSize, n)
+		}
+		for msg, ok := packet.Next(); ok; msg, ok = packet.Next() {
+			received = append(received, describe(t, msg))
+			next++
+		}
+	}
+	for i := range expectedMessages {
+		if received[i] != expectedMessages[i] {
+			t.Errorf("message %d: expected %q, got %q", i+1, expectedMessages[i], received[i])
+		}
+	}
+
+	receiver.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := receiver.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	heartbeat, err := ParsePacket(buf[:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if heartbeat.Count != 0 || heartbeat.Sequence != next {
+		t.Errorf("expected a heartbeat with sequence %d, got %+v", next, heartbeat)
+	}
+}
+
+func TestReplayServer(t *testing.T) {
+	feed, ob := setup(t)
+	trade(t, ob)
+	server := NewReplayServer(feed)
+	if err := server.ListenAndServe("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	var sequences []uint64
+	next, err := Replay(conn, feed.Session(), 3, 2, func(sequence uint64, msg []byte) {
+		sequences = append(sequences, sequence)
+		if actual := describe(t, msg); actual != expectedMessages[sequence-1] {
+			t.Errorf("message %d: expected %q, got %q", sequence, expectedMessages[sequence-1], actual)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != 5 || len(sequences) != 2 || sequences[0] != 3 || sequences[1] != 4 {
+		t.Errorf("expected messages 3 and 4, got %v with next sequence %d", sequences, next)
+	}
+
+	next, err = Replay(conn, feed.Session(), 100, 10, func(sequence uint64, msg []byte) {
+		t.Errorf("expected no messages, got %d", sequence)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != 100 {
+		t.Errorf("expected next sequence 100, got %d", next)
+	}
+}
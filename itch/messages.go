@@ -0,0 +1,360 @@
+// Package itch is an ITCH-style binary market data feed of order book events.
+//
+// A Feed converts order book (L3) updates and trades into sequenced fixed-width messages. Messages are published in
+// MoldUDP64-style packets over UDP (e.g. multicast) by a Publisher, missed sequence ranges are recovered from a
+// ReplayServer over TCP. All integers are big endian, prices are fixed-point integers with PriceScale decimal places.
+package itch
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Number of decimal places of feed prices.
+const PriceScale int32 = 4
+
+// Message types.
+const (
+	TypeSystemEvent   byte = 'S'
+	TypeDirectory     byte = 'R'
+	TypeAddOrder      byte = 'A'
+	TypeOrderExecuted byte = 'E'
+	TypeOrderReplace  byte = 'U'
+	TypeOrderDelete   byte = 'D'
+	TypeTrade         byte = 'P'
+)
+
+// Message sizes, including the type.
+const (
+	headerSize = 1 + 2 + 8
+
+	SystemEventSize   = headerSize + 1
+	DirectorySize     = headerSize + 8
+	AddOrderSize      = headerSize + 8 + 1 + 4 + 8
+	OrderExecutedSize = headerSize + 8 + 4 + 8 + 8 + 4
+	OrderReplaceSize  = headerSize + 8 + 8 + 4 + 8
+	OrderDeleteSize   = headerSize + 8
+	TradeSize         = headerSize + 8 + 1 + 4 + 8 + 8
+)
+
+// System event codes.
+const (
+	EventStartOfMessages    byte = 'O'
+	EventStartOfMarketHours byte = 'Q'
+	EventEndOfMarketHours   byte = 'M'
+	EventEndOfMessages      byte = 'C'
+)
+
+// Sides.
+const (
+	SideBuy  byte = 'B'
+	SideSell byte = 'S'
+)
+
+var (
+	ErrInvalidLength = errors.New("invalid message length")
+	ErrInvalidType   = errors.New("invalid message type")
+)
+
+// Instrument symbol, left aligned and padded with spaces.
+type Symbol [8]byte
+
+// Create a symbol from a string, longer strings are truncated.
+func NewSymbol(s string) Symbol {
+	var sym Symbol
+	n := copy(sym[:], s)
+	for i := n; i < len(sym); i++ {
+		sym[i] = ' '
+	}
+	return sym
+}
+
+func (s Symbol) String() string {
+	end := len(s)
+	for end > 0 && s[end-1] == ' ' {
+		end--
+	}
+	return string(s[:end])
+}
+
+// Fields common to all messages.
+type Header struct {
+	Locate    uint16 // instrument locate code assigned by a Directory message, zero for system events
+	Timestamp int64  // nanoseconds since Epoch
+}
+
+// Feed wide event.
+type SystemEvent struct {
+	Header
+	EventCode byte
+}
+
+// Assigns a locate code to an instrument, sent before any other message of the instrument.
+type Directory struct {
+	Header
+	Symbol Symbol
+}
+
+// Displayed order was added to the book.
+type AddOrder struct {
+	Header
+	OrderID uint64
+	Side    byte
+	Qty     uint32 // displayed quantity
+	Price   int64
+}
+
+// Displayed order was executed.
+type OrderExecuted struct {
+	Header
+	OrderID      uint64
+	ExecutedQty  uint32
+	MatchNumber  uint64 // trade ID
+	Price        int64  // trade price
+	DisplayedQty uint32 // displayed quantity of the order after the execution
+}
+
+// Displayed order was modified without losing its priority. Order IDs don't change in this feed, so both are the same.
+type OrderReplace struct {
+	Header
+	OrigOrderID uint64
+	NewOrderID  uint64
+	Qty         uint32 // displayed quantity
+	Price       int64
+}
+
+// Order was removed from the book - cancelled, filled or it isn't displayed anymore.
+type OrderDelete struct {
+	Header
+	OrderID uint64
+}
+
+// Execution of an order which isn't displayed (hidden and market orders). Executions of displayed orders are only
+// sent as OrderExecuted.
+type Trade struct {
+	Header
+	OrderID     uint64 // resting order
+	Side        byte   // side of the resting order
+	Qty         uint32
+	Price       int64
+	MatchNumber uint64
+}
+
+// Appends fixed-width fields after the message length.
+type encoder struct {
+	buf []byte
+}
+
+func newEncoder(buf []byte, msgType byte, size int, header Header) encoder {
+	e := encoder{buf: append(buf, byte(size>>8), byte(size))}
+	e.uint8(msgType)
+	e.uint16(header.Locate)
+	e.uint64(uint64(header.Timestamp))
+	return e
+}
+
+func (e *encoder) uint8(v uint8) {
+	e.buf = append(e.buf, v)
+}
+
+func (e *encoder) uint16(v uint16) {
+	e.buf = append(e.buf, byte(v>>8), byte(v))
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.buf = append(e.buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (e *encoder) uint64(v uint64) {
+	e.buf = append(e.buf, byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// Reads fixed-width fields of a message which was checked for its length.
+type decoder struct {
+	buf []byte
+	pos int
+}
+
+func newDecoder(data []byte, msgType byte, size int, header *Header) (decoder, error) {
+	if len(data) != size {
+		return decoder{}, ErrInvalidLength
+	}
+	if data[0] != msgType {
+		return decoder{}, ErrInvalidType
+	}
+	d := decoder{buf: data, pos: 1}
+	header.Locate = d.uint16()
+	header.Timestamp = int64(d.uint64())
+	return d, nil
+}
+
+func (d *decoder) uint8() uint8 {
+	v := d.buf[d.pos]
+	d.pos += 1
+	return v
+}
+
+func (d *decoder) uint16() uint16 {
+	v := binary.BigEndian.Uint16(d.buf[d.pos:])
+	d.pos += 2
+	return v
+}
+
+func (d *decoder) uint32() uint32 {
+	v := binary.BigEndian.Uint32(d.buf[d.pos:])
+	d.pos += 4
+	return v
+}
+
+func (d *decoder) uint64() uint64 {
+	v := binary.BigEndian.Uint64(d.buf[d.pos:])
+	d.pos += 8
+	return v
+}
+
+// Append the message with its length.
+func (m *SystemEvent) Append(buf []byte) []byte {
+	e := newEncoder(buf, TypeSystemEvent, SystemEventSize, m.Header)
+	e.uint8(m.EventCode)
+	return e.buf
+}
+
+// Decode the message.
+func (m *SystemEvent) Unmarshal(data []byte) error {
+	d, err := newDecoder(data, TypeSystemEvent, SystemEventSize, &m.Header)
+	if err != nil {
+		return err
+	}
+	m.EventCode = d.uint8()
+	return nil
+}
+
+// Append the message with its length.
+func (m *Directory) Append(buf []byte) []byte {
+	e := newEncoder(buf, TypeDirectory, DirectorySize, m.Header)
+	e.buf = append(e.buf, m.Symbol[:]...)
+	return e.buf
+}
+
+// Decode the message.
+func (m *Directory) Unmarshal(data []byte) error {
+	d, err := newDecoder(data, TypeDirectory, DirectorySize, &m.Header)
+	if err != nil {
+		return err
+	}
+	copy(m.Symbol[:], d.buf[d.pos:])
+	return nil
+}
+
+// Append the message with its length.
+func (m *AddOrder) Append(buf []byte) []byte {
+	e := newEncoder(buf, TypeAddOrder, AddOrderSize, m.Header)
+	e.uint64(m.OrderID)
+	e.uint8(m.Side)
+	e.uint32(m.Qty)
+	e.uint64(uint64(m.Price))
+	return e.buf
+}
+
+// Decode the message.
+func (m *AddOrder) Unmarshal(data []byte) error {
+	d, err := newDecoder(data, TypeAddOrder, AddOrderSize, &m.Header)
+	if err != nil {
+		return err
+	}
+	m.OrderID = d.uint64()
+	m.Side = d.uint8()
+	m.Qty = d.uint32()
+	m.Price = int64(d.uint64())
+	return nil
+}
+
+// Append the message with its length.
+func (m *OrderExecuted) Append(buf []byte) []byte {
+	e := newEncoder(buf, TypeOrderExecuted, OrderExecutedSize, m.Header)
+	e.uint64(m.OrderID)
+	e.uint32(m.ExecutedQty)
+	e.uint64(m.MatchNumber)
+	e.uint64(uint64(m.Price))
+	e.uint32(m.DisplayedQty)
+	return e.buf
+}
+
+// Decode the message.
+func (m *OrderExecuted) Unmarshal(data []byte) error {
+	d, err := newDecoder(data, TypeOrderExecuted, OrderExecutedSize, &m.Header)
+	if err != nil {
+		return err
+	}
+	m.OrderID = d.uint64()
+	m.ExecutedQty = d.uint32()
+	m.MatchNumber = d.uint64()
+	m.Price = int64(d.uint64())
+	m.DisplayedQty = d.uint32()
+	return nil
+}
+
+// Append the message with its length.
+func (m *OrderReplace) Append(buf []byte) []byte {
+	e := newEncoder(buf, TypeOrderReplace, OrderReplaceSize, m.Header)
+	e.uint64(m.OrigOrderID)
+	e.uint64(m.NewOrderID)
+	e.uint32(m.Qty)
+	e.uint64(uint64(m.Price))
+	return e.buf
+}
+
+// Decode the message.
+func (m *OrderReplace) Unmarshal(data []byte) error {
+	d, err := newDecoder(data, TypeOrderReplace, OrderReplaceSize, &m.Header)
+	if err != nil {
+		return err
+	}
+	m.OrigOrderID = d.uint64()
+	m.NewOrderID = d.uint64()
+	m.Qty = d.uint32()
+	m.Price = int64(d.uint64())
+	return nil
+}
+
+// Append the message with its length.
+func (m *OrderDelete) Append(buf []byte) []byte {
+	e := newEncoder(buf, TypeOrderDelete, OrderDeleteSize, m.Header)
+	e.uint64(m.OrderID)
+	return e.buf
+}
+
+// Decode the message.
+func (m *OrderDelete) Unmarshal(data []byte) error {
+	d, err := newDecoder(data, TypeOrderDelete, OrderDeleteSize, &m.Header)
+	if err != nil {
+		return err
+	}
+	m.OrderID = d.uint64()
+	return nil
+}
+
+// Append the message with its length.
+func (m *Trade) Append(buf []byte) []byte {
+	e := newEncoder(buf, TypeTrade, TradeSize, m.Header)
+	e.uint64(m.OrderID)
+	e.uint8(m.Side)
+	e.uint32(m.Qty)
+	e.uint64(uint64(m.Price))
+	e.uint64(m.MatchNumber)
+	return e.buf
+}
+
+// Decode the message.
+func (m *Trade) Unmarshal(data []byte) error {
+	d, err := newDecoder(data, TypeTrade, TradeSize, &m.Header)
+	if err != nil {
+		return err
+	}
+	m.OrderID = d.uint64()
+	m.Side = d.uint8()
+	m.Qty = d.uint32()
+	m.Price = int64(d.uint64())
+	m.MatchNumber = d.uint64()
+	return nil
+}
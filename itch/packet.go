@@ -0,0 +1,102 @@
+package itch
+
+import (
+	"encoding/binary"
+)
+
+const (
+	PacketHeaderSize     = 10 + 8 + 2
+	DefaultMaxPacketSize = 1400 // fits into a typical Ethernet MTU with IP and UDP headers
+)
+
+// Name of a feed session, left aligned and padded with spaces.
+type Session [10]byte
+
+// Create a session name from a string, longer strings are truncated.
+func NewSession(s string) Session {
+	var session Session
+	n := copy(session[:], s)
+	for i := n; i < len(session); i++ {
+		session[i] = ' '
+	}
+	return session
+}
+
+// MoldUDP64-style packet - sequence number of the first message and the message count, followed by messages prefixed
+// with their lengths. Packets without messages are heartbeats which carry the next sequence number.
+type Packet struct {
+	Session  Session
+	Sequence uint64
+	Count    uint16
+	messages []byte
+}
+
+// Returns the next message of the packet, false after the last message. Messages are valid as long as the packet
+// data is.
+func (p *Packet) Next() ([]byte, bool) {
+	if len(p.messages) < 2 {
+		return nil, false
+	}
+	size := int(binary.BigEndian.Uint16(p.messages))
+	msg := p.messages[2 : 2+size]
+	p.messages = p.messages[2+size:]
+	return msg, true
+}
+
+// Parse a packet without copying its messages.
+func ParsePacket(data []byte) (Packet, error) {
+	if len(data) < PacketHeaderSize {
+		return Packet{}, ErrInvalidLength
+	}
+	var p Packet
+	copy(p.Session[:], data)
+	p.Sequence = binary.BigEndian.Uint64(data[10:])
+	p.Count = binary.BigEndian.Uint16(data[18:])
+	p.messages = data[PacketHeaderSize:]
+
+	count := 0
+	for rest := p.messages; len(rest) > 0; count++ {
+		if len(rest) < 2 {
+			return Packet{}, ErrInvalidLength
+		}
+		size := int(binary.BigEndian.Uint16(rest))
+		if size == 0 || len(rest) < 2+size {
+			return Packet{}, ErrInvalidLength
+		}
+		rest = rest[2+size:]
+	}
+	if count != int(p.Count) {
+		return Packet{}, ErrInvalidLength
+	}
+	return p, nil
+}
+
+// append a packet of messages which already have their length prefixes
+func appendPacket(buf []byte, session Session, sequence uint64, messages [][]byte) []byte {
+	buf = append(buf, session[:]...)
+	var b [10]byte
+	binary.BigEndian.PutUint64(b[:], sequence)
+	binary.BigEndian.PutUint16(b[8:], uint16(len(messages)))
+	buf = append(buf, b[:]...)
+	for _, msg := range messages {
+		buf = append(buf, msg...)
+	}
+	return buf
+}
+
+// split messages starting at the sequence number into packets of at most maxSize bytes, each packet has at least
+// one message
+func splitPackets(session Session, sequence uint64, messages [][]byte, maxSize int) [][]byte {
+	var packets [][]byte
+	for len(messages) > 0 {
+		size, count := PacketHeaderSize, 0
+		for count < len(messages) && (count == 0 || size+len(messages[count]) <= maxSize) {
+			size += len(messages[count])
+			count++
+		}
+		packets = append(packets, appendPacket(make([]byte, 0, size), session, sequence, messages[:count]))
+		sequence += uint64(count)
+		messages = messages[count:]
+	}
+	return packets
+}
@@ -0,0 +1,122 @@
+package itch
+
+import (
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Interval of heartbeat packets while there are no messages.
+const DefaultHeartbeatInterval = time.Second
+
+// Publishes feed messages in UDP packets, e.g. to a multicast group. Packets are sent in the background, so the feed
+// never waits for the network. Receivers detect lost packets by sequence gaps and recover them from a ReplayServer.
+type Publisher struct {
+	feed          *Feed
+	conn          net.Conn
+	maxPacketSize int
+	heartbeat     time.Duration
+	next          uint64 // sequence number of the next message to send
+
+	listener  chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// PublisherOption changes the default behaviour of a publisher.
+type PublisherOption func(p *Publisher)
+
+// Send packets of at most size bytes.
+func WithMaxPacketSize(size int) PublisherOption {
+	return func(p *Publisher) {
+		p.maxPacketSize = size
+	}
+}
+
+// Send heartbeats after the interval without messages.
+func WithHeartbeatInterval(interval time.Duration) PublisherOption {
+	return func(p *Publisher) {
+		p.heartbeat = interval
+	}
+}
+
+// Publish the feed to a UDP address (e.g. "239.1.1.1:5000" or a loopback address), starting with the oldest message
+// the feed keeps.
+func NewPublisher(feed *Feed, address string, opts ...PublisherOption) (*Publisher, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	p := &Publisher{
+		feed:          feed,
+		conn:          conn,
+		maxPacketSize: DefaultMaxPacketSize,
+		heartbeat:     DefaultHeartbeatInterval,
+		next:          1,
+		listener:      feed.listen(),
+		done:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	p.wg.Add(1)
+	go p.run()
+	return p, nil
+}
+
+func (p *Publisher) run() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.heartbeat)
+	defer ticker.Stop()
+	p.flush()
+	sent := true
+	for {
+		select {
+		case <-p.listener:
+			p.flush()
+			sent = true
+		case <-ticker.C:
+			if !sent {
+				p.send(appendPacket(nil, p.feed.session, p.next, nil))
+			}
+			sent = false
+		case <-p.done:
+			p.flush()
+			return
+		}
+	}
+}
+
+// send all new messages
+func (p *Publisher) flush() {
+	for {
+		first, messages := p.feed.Messages(p.next, 256)
+		if len(messages) == 0 {
+			return
+		}
+		for _, packet := range splitPackets(p.feed.session, first, messages, p.maxPacketSize) {
+			p.send(packet)
+		}
+		p.next = first + uint64(len(messages))
+	}
+}
+
+func (p *Publisher) send(packet []byte) {
+	if _, err := p.conn.Write(packet); err != nil {
+		log.Printf("cannot publish packet: %v\n", err)
+	}
+}
+
+// Send the remaining messages and stop publishing.
+func (p *Publisher) Close() error {
+	var err error
+	p.closeOnce.Do(func() {
+		close(p.done)
+		p.wg.Wait()
+		p.feed.unlisten(p.listener)
+		err = p.conn.Close()
+	})
+	return err
+}
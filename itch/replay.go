@@ -0,0 +1,207 @@
+package itch
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// Maximum number of messages returned for a replay request.
+const MaxReplayCount = 10000
+
+var (
+	ErrReplayServerClosed = errors.New("replay server is closed")
+)
+
+// Replays feed messages over TCP. A request is a packet header without messages - session, sequence number of the
+// first message and the message count. The response are packets of the requested messages, each prefixed with its 2
+// byte length, and a closing heartbeat packet with the sequence number following the last replayed message. Replays
+// start at the oldest kept message if the requested ones were discarded.
+type ReplayServer struct {
+	feed *Feed
+
+	mutex    sync.Mutex
+	conns    map[net.Conn]struct{}
+	listener net.Listener
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// Create a new replay server of the feed.
+func NewReplayServer(feed *Feed) *ReplayServer {
+	return &ReplayServer{feed: feed, conns: make(map[net.Conn]struct{})}
+}
+
+// Listen on a TCP address and serve requests in the background.
+func (r *ReplayServer) ListenAndServe(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	if err := r.listen(listener); err != nil {
+		return err
+	}
+	go r.accept(listener)
+	return nil
+}
+
+// Serve requests from the listener until the server is closed.
+func (r *ReplayServer) Serve(listener net.Listener) error {
+	if err := r.listen(listener); err != nil {
+		return err
+	}
+	return r.accept(listener)
+}
+
+func (r *ReplayServer) listen(listener net.Listener) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		listener.Close()
+		return ErrReplayServerClosed
+	}
+	r.listener = listener
+	return nil
+}
+
+func (r *ReplayServer) accept(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			r.mutex.Lock()
+			closed := r.closed
+			r.mutex.Unlock()
+			if closed {
+				return ErrReplayServerClosed
+			}
+			return err
+		}
+		r.mutex.Lock()
+		if r.closed {
+			r.mutex.Unlock()
+			conn.Close()
+			return ErrReplayServerClosed
+		}
+		r.conns[conn] = struct{}{}
+		r.wg.Add(1)
+		r.mutex.Unlock()
+		go func() {
+			defer r.wg.Done()
+			r.handle(conn)
+			r.mutex.Lock()
+			delete(r.conns, conn)
+			r.mutex.Unlock()
+		}()
+	}
+}
+
+// Returns the address the server is listening on, nil if it isn't listening.
+func (r *ReplayServer) Addr() net.Addr {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.listener == nil {
+		return nil
+	}
+	return r.listener.Addr()
+}
+
+// Stop accepting connections, close all connections and wait for them to finish.
+func (r *ReplayServer) Close() error {
+	r.mutex.Lock()
+	if r.closed {
+		r.mutex.Unlock()
+		return ErrReplayServerClosed
+	}
+	r.closed = true
+	var err error
+	if r.listener != nil {
+		err = r.listener.Close()
+	}
+	for conn := range r.conns {
+		conn.Close()
+	}
+	r.mutex.Unlock()
+	r.wg.Wait()
+	return err
+}
+
+// serve requests of a connection until it's closed
+func (r *ReplayServer) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	request := make([]byte, PacketHeaderSize)
+	for {
+		if _, err := io.ReadFull(reader, request); err != nil {
+			return
+		}
+		var session Session
+		copy(session[:], request)
+		if session != r.feed.session {
+			return
+		}
+		sequence := binary.BigEndian.Uint64(request[10:])
+		count := int(binary.BigEndian.Uint16(request[18:]))
+		if count > MaxReplayCount {
+			count = MaxReplayCount
+		}
+
+		first, messages := r.feed.Messages(sequence, count)
+		for _, packet := range splitPackets(r.feed.session, first, messages, DefaultMaxPacketSize) {
+			if writeFrame(writer, packet) != nil {
+				return
+			}
+		}
+		next := first + uint64(len(messages))
+		if writeFrame(writer, appendPacket(nil, r.feed.session, next, nil)) != nil || writer.Flush() != nil {
+			return
+		}
+	}
+}
+
+func writeFrame(w io.Writer, packet []byte) error {
+	var size [2]byte
+	binary.BigEndian.PutUint16(size[:], uint16(len(packet)))
+	if _, err := w.Write(size[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(packet)
+	return err
+}
+
+// Request a replay of count messages starting at the sequence number from a replay server connection. Messages are
+// passed to the handler with their sequence numbers. Returns the sequence number following the last replayed message.
+func Replay(conn io.ReadWriter, session Session, sequence uint64, count uint16, handler func(sequence uint64, msg []byte)) (uint64, error) {
+	request := make([]byte, PacketHeaderSize)
+	copy(request, session[:])
+	binary.BigEndian.PutUint64(request[10:], sequence)
+	binary.BigEndian.PutUint16(request[18:], count)
+	if _, err := conn.Write(request); err != nil {
+		return 0, err
+	}
+	var size [2]byte
+	for {
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return 0, err
+		}
+		data := make([]byte, binary.BigEndian.Uint16(size[:]))
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return 0, err
+		}
+		packet, err := ParsePacket(data)
+		if err != nil {
+			return 0, err
+		}
+		if packet.Count == 0 {
+			return packet.Sequence, nil
+		}
+		seq := packet.Sequence
+		for msg, ok := packet.Next(); ok; msg, ok = packet.Next() {
+			handler(seq, msg)
+			seq++
+		}
+	}
+}
@@ -0,0 +1,529 @@
+package tome
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/cockroachdb/apd"
+	"github.com/google/uuid"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	journalMagic = "TOMEJRNL"
+	// Journals of older versions aren't decoded and have to be replayed by the version which wrote them. Versions:
+	// 1 - initial format
+	// 2 - orders with a display quantity
+	// 3 - trades with an aggressor side
+	// 4 - trades with buyer and seller fees
+	// 5 - orders with an expire time, RecordExpireOrders
+	// 6 - RecordCancelOrders
+	journalVersion = 6
+
+	journalHeaderSize = len(journalMagic) + 1
+	recordHeaderSize  = 8  // length + checksum
+	recordBodyPrefix  = 17 // sequence + timestamp + type
+	maxRecordSize     = 1 << 24
+)
+
+var (
+	ErrJournalCorrupted     = errors.New("journal is corrupted")
+	ErrInvalidJournalHeader = errors.New("invalid journal header")
+	ErrUnsupportedJournal   = errors.New("unsupported journal version")
+	ErrJournalClosed        = errors.New("journal is closed")
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// Type of a journal record. Commands are inputs of an order book, events are their results.
+type RecordType byte
+
+const (
+	RecordAddOrder             RecordType = iota + 1 // command - order added, payload is an Order
+	RecordCancelOrder                                // command - order cancelled, payload is an order ID
+	RecordAmendOrder                                 // command - order amended, payload is an order ID, quantity and price
+	RecordSetMarketPrice                             // command - market price set, payload is a price
+	RecordTrade                                      // event - trade executed, payload is a Trade
+	RecordOrderUpdate                                // event - order state changed, payload is an Order
+	RecordCancelCustomerOrders                       // command - all orders of a customer cancelled, payload is a customer ID
+	RecordExpireOrders                               // command - expired GTD orders cancelled, no payload
+	RecordCancelOrders                               // command - orders cancelled at once, payload is a list of order IDs
+)
+
+func (r RecordType) String() string {
+	switch r {
+	case RecordAddOrder:
+		return "AddOrder"
+	case RecordCancelOrder:
+		return "CancelOrder"
+	case RecordAmendOrder:
+		return "AmendOrder"
+	case RecordSetMarketPrice:
+		return "SetMarketPrice"
+	case RecordTrade:
+		return "Trade"
+	case RecordOrderUpdate:
+		return "OrderUpdate"
+	case RecordCancelCustomerOrders:
+		return "CancelCustomerOrders"
+	case RecordExpireOrders:
+		return "ExpireOrders"
+	case RecordCancelOrders:
+		return "CancelOrders"
+	default:
+		return "invalid"
+	}
+}
+
+// Returns true if a record is an order book input command.
+func (r RecordType) IsCommand() bool {
+	return (r >= RecordAddOrder && r <= RecordSetMarketPrice) || r >= RecordCancelCustomerOrders
+}
+
+// Determines when journal records are flushed to the disk.
+type SyncPolicy byte
+
+const (
+	SyncEveryRecord SyncPolicy = iota // fsync after every record
+	SyncBatch                         // fsync after BatchSize records or BatchInterval, whichever comes first
+	SyncNone                          // never fsync, leave it to the OS
+)
+
+// Journal configuration.
+type JournalConfig struct {
+	Sync          SyncPolicy
+	BatchSize     int           // used by SyncBatch, records are synced after BatchSize records (if > 0)
+	BatchInterval time.Duration // used by SyncBatch, records are synced at least once per BatchInterval (if > 0)
+}
+
+// A single journal record.
+type JournalRecord struct {
+	Sequence  uint64
+	Type      RecordType
+	Timestamp time.Time
+	Payload   []byte
+}
+
+// Decode an order from RecordAddOrder and RecordOrderUpdate records.
+func (r JournalRecord) Order() (Order, error) {
+	var order Order
+	err := order.UnmarshalBinary(r.Payload)
+	return order, err
+}
+
+// Decode a trade from RecordTrade records.
+func (r JournalRecord) Trade() (Trade, error) {
+	var trade Trade
+	err := trade.UnmarshalBinary(r.Payload)
+	return trade, err
+}
+
+// Decode an order ID from RecordCancelOrder records.
+func (r JournalRecord) OrderID() (uint64, error) {
+	d := decoder{buf: r.Payload}
+	id := d.uint64()
+	return id, d.finish()
+}
+
+// Decode order IDs from RecordCancelOrders records.
+func (r JournalRecord) OrderIDs() ([]uint64, error) {
+	d := decoder{buf: r.Payload}
+	n := d.uint32()
+	var ids []uint64
+	for i := uint32(0); i < n && d.err == nil; i++ {
+		ids = append(ids, d.uint64())
+	}
+	return ids, d.finish()
+}
+
+// Decode an amendment from RecordAmendOrder records.
+func (r JournalRecord) Amendment() (id uint64, qty int64, price apd.Decimal, err error) {
+	d := decoder{buf: r.Payload}
+	id = d.uint64()
+	qty = d.int64()
+	price = d.decimal()
+	return id, qty, price, d.finish()
+}
+
+// Decode a customer ID from RecordCancelCustomerOrders records.
+func (r JournalRecord) CustomerID() (uuid.UUID, error) {
+	d := decoder{buf: r.Payload}
+	id := d.uuid()
+	return id, d.finish()
+}
+
+// Decode a price from RecordSetMarketPrice records.
+func (r JournalRecord) Price() (apd.Decimal, error) {
+	d := decoder{buf: r.Payload}
+	price := d.decimal()
+	return price, d.finish()
+}
+
+// Journal is a sequenced, checksummed, append-only log of order book commands and events stored on a local disk.
+// Order book state can always be rebuilt by replaying its commands.
+//
+// File format: magic "TOMEJRNL", version byte, followed by records. Each record is stored as
+// length (4B) | CRC-32C of the body (4B) | body, where body is sequence (8B) | timestamp (8B) | type (1B) | payload.
+type Journal struct {
+	file   *os.File
+	config JournalConfig
+
+	mutex    sync.Mutex
+	sequence uint64 // sequence of the last appended record
+	offset   int64  // end of the last appended record
+	unsynced int    // number of records written since the last fsync
+	closed   bool
+	failure  error // error of the last background sync or a write which couldn't be undone, records can't be appended
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Open a journal file, create it if it doesn't exist.
+// An incomplete or corrupted last record (e.g. after a crash) is truncated. Journals with an invalid header or corrupted
+// records followed by valid ones aren't opened, they have to be repaired manually.
+func OpenJournal(path string, config JournalConfig) (*Journal, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	j := &Journal{file: file, config: config}
+	if err := j.recover(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if config.Sync == SyncBatch && config.BatchInterval > 0 {
+		j.stop = make(chan struct{})
+		j.done = make(chan struct{})
+		go j.syncPeriodically()
+	}
+	return j, nil
+}
+
+// find the last valid record, truncate a torn record after it and position the file for appending
+func (j *Journal) recover() error {
+	info, err := j.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		header := append([]byte(journalMagic), journalVersion)
+		if _, err := j.file.Write(header); err != nil {
+			return err
+		}
+		j.offset = int64(journalHeaderSize)
+		return j.file.Sync()
+	}
+
+	reader := NewJournalReader(j.file)
+	if err := reader.readHeader(); err != nil {
+		return fmt.Errorf("journal %s: %w", j.file.Name(), err)
+	}
+	offset := int64(journalHeaderSize)
+	for {
+		record, size, err := reader.next()
+		if err == io.EOF {
+			break
+		}
+		if err == io.ErrUnexpectedEOF || err == ErrJournalCorrupted {
+			if err := j.truncateTornRecord(offset, err); err != nil {
+				return err
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+		j.sequence = record.Sequence
+		offset += size
+	}
+	j.offset = offset
+	_, err = j.file.Seek(offset, io.SeekStart)
+	return err
+}
+
+// Truncate an invalid record at the offset if it's the last one. A crash can only tear the last record, valid records
+// after an invalid one mean that the journal was damaged and truncating it would lose them.
+func (j *Journal) truncateTornRecord(offset int64, cause error) error {
+	if _, err := j.file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	rest, err := ioutil.ReadAll(j.file)
+	if err != nil {
+		return err
+	}
+	if validRecordAfter(rest, j.sequence) {
+		return fmt.Errorf("journal %s: %w at offset %d, valid records follow", j.file.Name(), ErrJournalCorrupted, offset)
+	}
+	log.Printf("truncating journal %s at offset %d: %v\n", j.file.Name(), offset, cause)
+	return j.file.Truncate(offset)
+}
+
+// reports whether data following an invalid record contains a valid record with a sequence after the given one
+func validRecordAfter(data []byte, sequence uint64) bool {
+	return containsValidRecord(data, recordBodyPrefix, func(body []byte) bool {
+		return binary.BigEndian.Uint64(body) > sequence
+	})
+}
+
+// reports whether data following an invalid record contains a record with a valid checksum and a body of at least
+// minBodySize bytes which is accepted
+func containsValidRecord(data []byte, minBodySize int, accept func(body []byte) bool) bool {
+	for i := 1; i+recordHeaderSize+minBodySize <= len(data); i++ {
+		bodySize := int(binary.BigEndian.Uint32(data[i:]))
+		if bodySize < minBodySize || bodySize > len(data)-i-recordHeaderSize {
+			continue
+		}
+		body := data[i+recordHeaderSize : i+recordHeaderSize+bodySize]
+		if crc32.Checksum(body, crcTable) == binary.BigEndian.Uint32(data[i+4:]) && accept(body) {
+			return true
+		}
+	}
+	return false
+}
+
+// Append a record, returns its sequence number.
+func (j *Journal) Append(recordType RecordType, timestamp time.Time, payload []byte) (uint64, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if j.closed {
+		return 0, ErrJournalClosed
+	}
+	if j.failure != nil {
+		return 0, j.failure
+	}
+
+	sequence := j.sequence + 1
+	bodySize := recordBodyPrefix + len(payload)
+	buf := make([]byte, recordHeaderSize+bodySize)
+	body := buf[recordHeaderSize:]
+	binary.BigEndian.PutUint64(body, sequence)
+	binary.BigEndian.PutUint64(body[8:], uint64(timestamp.UnixNano()))
+	body[16] = byte(recordType)
+	copy(body[recordBodyPrefix:], payload)
+	binary.BigEndian.PutUint32(buf, uint32(bodySize))
+	binary.BigEndian.PutUint32(buf[4:], crc32.Checksum(body, crcTable))
+
+	if _, err := j.file.Write(buf); err != nil {
+		// remove torn bytes of the record, otherwise following records would be appended after them
+		if _, serr := j.file.Seek(j.offset, io.SeekStart); serr != nil || j.file.Truncate(j.offset) != nil {
+			j.failure = fmt.Errorf("journal write failed: %w", err)
+		}
+		return 0, err
+	}
+	offset := j.offset
+	j.sequence = sequence
+	j.offset += int64(len(buf))
+	j.unsynced += 1
+
+	var err error
+	switch j.config.Sync {
+	case SyncEveryRecord:
+		err = j.sync()
+	case SyncBatch:
+		if j.config.BatchSize > 0 && j.unsynced >= j.config.BatchSize {
+			err = j.sync()
+		}
+	}
+	if err != nil {
+		// the command fails, remove its record so it isn't replayed. Written records can't be trusted to reach the disk
+		// after a failed fsync, so the journal stops accepting records.
+		if _, serr := j.file.Seek(offset, io.SeekStart); serr == nil {
+			j.file.Truncate(offset)
+		}
+		j.sequence, j.offset, j.unsynced = sequence-1, offset, j.unsynced-1
+		j.failure = fmt.Errorf("journal sync failed: %w", err)
+		return 0, err
+	}
+	return sequence, nil
+}
+
+// Returns the sequence number of the last record.
+func (j *Journal) Sequence() uint64 {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.sequence
+}
+
+// Flush all records to the disk.
+func (j *Journal) Sync() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if j.closed {
+		return ErrJournalClosed
+	}
+	return j.sync()
+}
+
+func (j *Journal) sync() error {
+	if j.unsynced == 0 {
+		return nil
+	}
+	if err := j.file.Sync(); err != nil {
+		return err
+	}
+	j.unsynced = 0
+	return nil
+}
+
+func (j *Journal) syncPeriodically() {
+	defer close(j.done)
+	ticker := time.NewTicker(j.config.BatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-j.stop:
+			return
+		case <-ticker.C:
+			j.mutex.Lock()
+			if err := j.sync(); err != nil {
+				j.failure = fmt.Errorf("journal sync failed: %w", err)
+			}
+			j.mutex.Unlock()
+		}
+	}
+}
+
+// Sync and close the journal.
+func (j *Journal) Close() error {
+	j.mutex.Lock()
+	if j.closed {
+		j.mutex.Unlock()
+		return ErrJournalClosed
+	}
+	j.closed = true
+	j.mutex.Unlock()
+
+	if j.stop != nil {
+		close(j.stop)
+		<-j.done
+	}
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if err := j.sync(); err != nil {
+		j.file.Close()
+		return err
+	}
+	return j.file.Close()
+}
+
+// Reads journal records in sequence.
+type JournalReader struct {
+	reader       *bufio.Reader
+	headerRead   bool
+	lastSequence uint64
+}
+
+// Create a new journal reader. The reader has to be positioned at the beginning of a journal.
+func NewJournalReader(r io.Reader) *JournalReader {
+	return &JournalReader{reader: bufio.NewReader(r)}
+}
+
+// Read the next record. Returns io.EOF at the end of the journal, io.ErrUnexpectedEOF if the last record is incomplete,
+// ErrInvalidJournalHeader if the reader isn't positioned at a journal and ErrJournalCorrupted if a record checksum or
+// sequence is invalid.
+func (r *JournalReader) Next() (JournalRecord, error) {
+	record, _, err := r.next()
+	return record, err
+}
+
+// returns a record and its size in bytes
+func (r *JournalReader) next() (JournalRecord, int64, error) {
+	if !r.headerRead {
+		if err := r.readHeader(); err != nil {
+			return JournalRecord{}, 0, err
+		}
+	}
+
+	var header [recordHeaderSize]byte
+	if _, err := io.ReadFull(r.reader, header[:]); err != nil {
+		return JournalRecord{}, 0, err // io.EOF if there are no more records
+	}
+	bodySize := binary.BigEndian.Uint32(header[:])
+	if bodySize < recordBodyPrefix || bodySize > maxRecordSize {
+		return JournalRecord{}, 0, ErrJournalCorrupted
+	}
+	body := make([]byte, bodySize)
+	if _, err := io.ReadFull(r.reader, body); err != nil {
+		if err == io.EOF {
+			return JournalRecord{}, 0, io.ErrUnexpectedEOF
+		}
+		return JournalRecord{}, 0, err
+	}
+	if crc32.Checksum(body, crcTable) != binary.BigEndian.Uint32(header[4:]) {
+		return JournalRecord{}, 0, ErrJournalCorrupted
+	}
+
+	record := JournalRecord{
+		Sequence:  binary.BigEndian.Uint64(body),
+		Timestamp: time.Unix(0, int64(binary.BigEndian.Uint64(body[8:]))),
+		Type:      RecordType(body[16]),
+		Payload:   body[recordBodyPrefix:],
+	}
+	if record.Sequence != r.lastSequence+1 {
+		return JournalRecord{}, 0, ErrJournalCorrupted
+	}
+	r.lastSequence = record.Sequence
+	return record, int64(recordHeaderSize) + int64(bodySize), nil
+}
+
+// read the journal magic and version
+func (r *JournalReader) readHeader() error {
+	header := make([]byte, journalHeaderSize)
+	if _, err := io.ReadFull(r.reader, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrInvalidJournalHeader
+		}
+		return err
+	}
+	if string(header[:len(journalMagic)]) != journalMagic {
+		return ErrInvalidJournalHeader
+	}
+	if header[len(journalMagic)] != journalVersion {
+		return fmt.Errorf("%w %d", ErrUnsupportedJournal, header[len(journalMagic)])
+	}
+	r.headerRead = true
+	return nil
+}
+
+func encodeOrderID(id uint64) []byte {
+	var e encoder
+	e.uint64(id)
+	return e.buf
+}
+
+func encodeOrderIDs(ids []uint64) []byte {
+	var e encoder
+	e.uint32(uint32(len(ids)))
+	for _, id := range ids {
+		e.uint64(id)
+	}
+	return e.buf
+}
+
+func encodeAmendment(id uint64, qty int64, price apd.Decimal) []byte {
+	var e encoder
+	e.uint64(id)
+	e.int64(qty)
+	e.decimal(price)
+	return e.buf
+}
+
+func encodeCustomerID(id uuid.UUID) []byte {
+	var e encoder
+	e.uuid(id)
+	return e.buf
+}
+
+func encodePrice(price apd.Decimal) []byte {
+	var e encoder
+	e.decimal(price)
+	return e.buf
+}
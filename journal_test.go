@@ -0,0 +1,340 @@
+package tome
+
+import (
+	"bytes"
+	"errors"
+	"github.com/cockroachdb/apd"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestJournal(t *testing.T, config JournalConfig) (*Journal, string) {
+	path := filepath.Join(t.TempDir(), "test.journal")
+	j, err := OpenJournal(path, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return j, path
+}
+
+func readJournal(t *testing.T, path string) []JournalRecord {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	records := make([]JournalRecord, 0)
+	reader := NewJournalReader(file)
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return records
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+}
+
+func TestJournal_AppendReopen(t *testing.T) {
+	for _, config := range []JournalConfig{
+		{Sync: SyncEveryRecord},
+		{Sync: SyncBatch, BatchSize: 2, BatchInterval: time.Millisecond},
+		{Sync: SyncNone},
+	} {
+		j, path := openTestJournal(t, config)
+		for i := 0; i < 3; i++ {
+			seq, err := j.Append(RecordCancelOrder, time.Now(), encodeOrderID(uint64(i)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if seq != uint64(i+1) {
+				t.Errorf("expected sequence %d, got %d", i+1, seq)
+			}
+		}
+		if err := j.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		j, err := OpenJournal(path, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j.Sequence() != 3 {
+			t.Errorf("expected sequence 3 after reopening, got %d", j.Sequence())
+		}
+		if _, err := j.Append(RecordSetMarketPrice, time.Now(), encodePrice(*apd.New(2025, -2))); err != nil {
+			t.Fatal(err)
+		}
+		if err := j.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		records := readJournal(t, path)
+		if len(records) != 4 {
+			t.Fatalf("expected 4 records, got %d", len(records))
+		}
+		for i, record := range records[:3] {
+			id, err := record.OrderID()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if record.Type != RecordCancelOrder || id != uint64(i) {
+				t.Errorf("expected cancel of order %d, got %s of %d", i, record.Type, id)
+			}
+		}
+		price, err := records[3].Price()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if price.Cmp(apd.New(2025, -2)) != 0 {
+			t.Errorf("expected price 20.25, got %s", price.String())
+		}
+	}
+}
+
+func TestJournal_TruncateTornRecord(t *testing.T) {
+	j, path := openTestJournal(t, JournalConfig{Sync: SyncNone})
+	for i := 0; i < 2; i++ {
+		if _, err := j.Append(RecordCancelOrder, time.Now(), encodeOrderID(uint64(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-3); err != nil { // simulate a torn write
+		t.Fatal(err)
+	}
+
+	j, err = OpenJournal(path, JournalConfig{Sync: SyncNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.Sequence() != 1 {
+		t.Errorf("expected sequence 1 after recovery, got %d", j.Sequence())
+	}
+	if seq, err := j.Append(RecordCancelOrder, time.Now(), encodeOrderID(5)); err != nil || seq != 2 {
+		t.Errorf("expected sequence 2, got %d (%v)", seq, err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if records := readJournal(t, path); len(records) != 2 {
+		t.Errorf("expected 2 records, got %d", len(records))
+	}
+}
+
+func TestJournal_Checksum(t *testing.T) {
+	j, path := openTestJournal(t, JournalConfig{Sync: SyncNone})
+	if _, err := j.Append(RecordCancelOrder, time.Now(), encodeOrderID(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	file, err := ioutil.TempFile(t.TempDir(), "corrupted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewJournalReader(file).Next(); err != ErrJournalCorrupted {
+		t.Errorf("expected %v, got %v", ErrJournalCorrupted, err)
+	}
+}
+
+func TestJournal_InvalidHeader(t *testing.T) {
+	for name, content := range map[string]string{
+		"not a journal":  "not a journal file",
+		"short header":   journalMagic[:4],
+		"magic mismatch": "TOMEJRNX\x01",
+	} {
+		path := filepath.Join(t.TempDir(), "test.journal")
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := OpenJournal(path, JournalConfig{Sync: SyncNone}); !errors.Is(err, ErrInvalidJournalHeader) {
+			t.Errorf("%s: expected %v, got %v", name, ErrInvalidJournalHeader, err)
+		}
+		if data, err := ioutil.ReadFile(path); err != nil || string(data) != content {
+			t.Errorf("%s: expected the file to be left intact, got %q (%v)", name, data, err)
+		}
+	}
+}
+
+func TestJournal_UnsupportedVersion(t *testing.T) {
+	for version := byte(1); version < journalVersion; version++ {
+		path := filepath.Join(t.TempDir(), "test.journal")
+		if err := ioutil.WriteFile(path, append([]byte(journalMagic), version), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := OpenJournal(path, JournalConfig{Sync: SyncNone}); !errors.Is(err, ErrUnsupportedJournal) {
+			t.Errorf("version %d: expected %v, got %v", version, ErrUnsupportedJournal, err)
+		}
+	}
+}
+
+func TestJournal_CorruptedRecord(t *testing.T) {
+	j, path := openTestJournal(t, JournalConfig{Sync: SyncNone})
+	for i := 0; i < 3; i++ {
+		if _, err := j.Append(RecordCancelOrder, time.Now(), encodeOrderID(uint64(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordSize := (len(data) - journalHeaderSize) / 3
+
+	corrupted := append([]byte(nil), data...)
+	corrupted[journalHeaderSize+recordSize+recordHeaderSize] ^= 0xff // sequence of the second record
+	if err := ioutil.WriteFile(path, corrupted, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenJournal(path, JournalConfig{Sync: SyncNone}); !errors.Is(err, ErrJournalCorrupted) {
+		t.Errorf("expected %v, got %v", ErrJournalCorrupted, err)
+	}
+	if after, _ := ioutil.ReadFile(path); !bytes.Equal(after, corrupted) {
+		t.Errorf("expected a journal with corrupted records in the middle not to be truncated")
+	}
+
+	corrupted = append([]byte(nil), data...)
+	corrupted[len(corrupted)-1] ^= 0xff
+	if err := ioutil.WriteFile(path, corrupted, 0644); err != nil {
+		t.Fatal(err)
+	}
+	j, err = OpenJournal(path, JournalConfig{Sync: SyncNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.Sequence() != 2 {
+		t.Errorf("expected the corrupted last record to be truncated, got sequence %d", j.Sequence())
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJournal_WriteError(t *testing.T) {
+	j, _ := openTestJournal(t, JournalConfig{Sync: SyncNone})
+	j.file.Close() // fail writes
+	if _, err := j.Append(RecordCancelOrder, time.Now(), encodeOrderID(1)); err == nil {
+		t.Fatal("expected the write to fail")
+	}
+	if _, err := j.Append(RecordCancelOrder, time.Now(), encodeOrderID(2)); err == nil {
+		t.Error("expected appends to fail after a write which couldn't be undone")
+	}
+	if j.Sequence() != 0 {
+		t.Errorf("expected no records to be appended, got sequence %d", j.Sequence())
+	}
+}
+
+func TestJournal_SyncError(t *testing.T) {
+	j, _ := openTestJournal(t, JournalConfig{Sync: SyncEveryRecord})
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	j.file.Close()
+	j.file = writer // writes succeed, syncs fail
+	if _, err := j.Append(RecordCancelOrder, time.Now(), encodeOrderID(1)); err == nil {
+		t.Fatal("expected the sync to fail")
+	}
+	if j.Sequence() != 0 {
+		t.Errorf("expected the record of a failed sync to be removed, got sequence %d", j.Sequence())
+	}
+	if _, err := j.Append(RecordCancelOrder, time.Now(), encodeOrderID(2)); err == nil {
+		t.Error("expected appends to fail after a failed sync")
+	}
+}
+
+func TestJournal_Close(t *testing.T) {
+	j, _ := openTestJournal(t, JournalConfig{Sync: SyncBatch, BatchInterval: time.Millisecond})
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Close(); err != ErrJournalClosed {
+		t.Errorf("expected %v, got %v", ErrJournalClosed, err)
+	}
+	if _, err := j.Append(RecordCancelOrder, time.Now(), encodeOrderID(1)); err != ErrJournalClosed {
+		t.Errorf("expected %v, got %v", ErrJournalClosed, err)
+	}
+}
+
+func TestOrderBook_Journal(t *testing.T) {
+	j, path := openTestJournal(t, JournalConfig{Sync: SyncEveryRecord})
+	tb := NewTradeBook(instrument)
+	ob := NewOrderBook(instrument, *apd.New(2025, -2), tb, NOPOrderRepository, WithJournal(j))
+
+	if _, err := ob.Add(createOrder(1, TypeLimit, 0, 5, *apd.New(2010, -2), apd.Decimal{}, SideSell)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ob.Add(createOrder(2, TypeLimit, 0, 3, *apd.New(2012, -2), apd.Decimal{}, SideBuy)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ob.Amend(1, 4, *apd.New(2010, -2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ob.Cancel(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []RecordType{
+		RecordAddOrder, RecordOrderUpdate, // order 1 rests in the books
+		RecordAddOrder, RecordOrderUpdate, RecordTrade, // order 2 matches order 1
+		RecordAmendOrder, RecordOrderUpdate,
+		RecordCancelOrder, RecordOrderUpdate,
+	}
+	records := readJournal(t, path)
+	if len(records) != len(expected) {
+		t.Fatalf("expected %d records, got %d", len(expected), len(records))
+	}
+	for i, record := range records {
+		if record.Type != expected[i] {
+			t.Errorf("expected record %d to be %s, got %s", i, expected[i], record.Type)
+		}
+	}
+	trade, err := records[4].Trade()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trade.Qty != 3 || trade.BidOrderID != 2 || trade.AskOrderID != 1 {
+		t.Errorf("unexpected trade %+v", trade)
+	}
+	order, err := records[8].Order()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !order.IsCancelled() || order.Qty != 4 || order.FilledQty != 3 {
+		t.Errorf("unexpected cancelled order %+v", order)
+	}
+}
@@ -0,0 +1,183 @@
+package tome
+
+import (
+	"github.com/cockroachdb/apd"
+	"time"
+)
+
+// Type of an incremental market data update.
+type MarketDataType byte
+
+const (
+	UpdateBest         MarketDataType = iota + 1 // L1 - best displayed price level of a side changed, zero Qty if the side is empty
+	UpdateLevelAdd                               // L2 - new displayed price level
+	UpdateLevelChange                            // L2 - displayed quantity or order count of a price level changed
+	UpdateLevelDelete                            // L2 - price level isn't displayed anymore
+	UpdateOrderAdd                               // L3 - order was added to the book
+	UpdateOrderModify                            // L3 - order was amended without losing its priority
+	UpdateOrderDelete                            // L3 - order was removed from the book
+	UpdateOrderExecute                           // L3 - resting order was executed
+)
+
+func (m MarketDataType) String() string {
+	switch m {
+	case UpdateBest:
+		return "Best"
+	case UpdateLevelAdd:
+		return "LevelAdd"
+	case UpdateLevelChange:
+		return "LevelChange"
+	case UpdateLevelDelete:
+		return "LevelDelete"
+	case UpdateOrderAdd:
+		return "OrderAdd"
+	case UpdateOrderModify:
+		return "OrderModify"
+	case UpdateOrderDelete:
+		return "OrderDelete"
+	case UpdateOrderExecute:
+		return "OrderExecute"
+	default:
+		return "invalid"
+	}
+}
+
+// Incremental market data update. Updates contain only displayed quantities - hidden orders are never published and
+// iceberg orders are published with their displayed quantity.
+type MarketDataUpdate struct {
+	Sequence  uint64 // increases by one with every update, a gap means that updates were lost
+	Type      MarketDataType
+	Timestamp time.Time
+	Side      OrderSide
+	Price     apd.Decimal
+	Qty       int64 // displayed quantity of the level or the order after the update
+	Count     int   // number of displayed orders at the level (L1 and L2)
+
+	OrderID     uint64 // L3 updates
+	TradeID     uint64 // UpdateOrderExecute
+	ExecutedQty int64  // UpdateOrderExecute
+}
+
+// Receives market data updates. Handlers are called synchronously while a command is processed, so they can read the
+// order book (e.g. Depth), but can't call commands (Add, Cancel, Amend, SetMarketPrice).
+type MarketDataHandler func(update MarketDataUpdate)
+
+// Publish incremental market data updates to the handler.
+func WithMarketDataHandler(handler MarketDataHandler) OrderBookOption {
+	return func(o *OrderBook) {
+		o.marketData = handler
+	}
+}
+
+// last published state of a price level
+type publishedLevel struct {
+	price int64
+	qty   int64
+	count int
+}
+
+// publish an L3 update of an order in the book and resulting L2 and L1 updates
+func (o *OrderBook) publishOrder(updateType MarketDataType, order Order, price int64, tradeID uint64, executedQty int64) {
+	if o.marketData == nil || order.Type != TypeLimit {
+		return
+	}
+	if displayPeak(order) >= 0 {
+		qty := order.DisplayedQty()
+		if updateType == UpdateOrderDelete {
+			qty = 0
+		}
+		o.publish(MarketDataUpdate{
+			Type:        updateType,
+			Side:        order.Side,
+			Price:       FromFixedPrice(price, o.priceScale),
+			Qty:         qty,
+			OrderID:     order.ID,
+			TradeID:     tradeID,
+			ExecutedQty: executedQty,
+		})
+	}
+	o.publishLevel(order.Side, price)
+	o.publishBest(order.Side)
+}
+
+// publish a price level update if its displayed state changed
+func (o *OrderBook) publishLevel(side OrderSide, price int64) {
+	o.orderMutex.RLock()
+	current := publishedLevel{price: price}
+	if level, ok := o.orders.side(side).levels.Get(OrderTracker{Type: TypeLimit, Price: price}); ok {
+		current.qty, current.count = level.DisplayQty, level.DisplayCount
+	}
+	o.orderMutex.RUnlock()
+
+	levels := o.publishedLevels[side]
+	previous, ok := levels[price]
+	var updateType MarketDataType
+	switch {
+	case !ok && current.qty == 0:
+		return
+	case !ok:
+		updateType = UpdateLevelAdd
+		levels[price] = current
+	case current.qty == 0:
+		updateType = UpdateLevelDelete
+		delete(levels, price)
+	case current != previous:
+		updateType = UpdateLevelChange
+		levels[price] = current
+	default:
+		return
+	}
+	o.publish(MarketDataUpdate{
+		Type:  updateType,
+		Side:  side,
+		Price: FromFixedPrice(price, o.priceScale),
+		Qty:   current.qty,
+		Count: current.count,
+	})
+}
+
+// publish the best displayed price level of a side if it changed
+func (o *OrderBook) publishBest(side OrderSide) {
+	o.orderMutex.RLock()
+	var best publishedLevel
+	if level := o.bestLevel(side); level != nil {
+		best = publishedLevel{price: level.Price, qty: level.DisplayQty, count: level.DisplayCount}
+	}
+	o.orderMutex.RUnlock()
+
+	if best == o.publishedBest[side] {
+		return
+	}
+	o.publishedBest[side] = best
+	update := MarketDataUpdate{Type: UpdateBest, Side: side, Qty: best.qty, Count: best.count}
+	if best.qty > 0 {
+		update.Price = FromFixedPrice(best.price, o.priceScale)
+	}
+	o.publish(update)
+}
+
+// set the published state to the displayed levels of the books without publishing updates, e.g. after a restore
+func (o *OrderBook) resetPublished() {
+	o.orderMutex.RLock()
+	defer o.orderMutex.RUnlock()
+	for _, side := range []OrderSide{SideBuy, SideSell} {
+		levels := make(map[int64]publishedLevel)
+		for iter := o.orders.Levels(side); iter.Valid(); iter.Next() {
+			if level := iter.Value(); level.Type == TypeLimit && level.DisplayQty > 0 {
+				levels[level.Price] = publishedLevel{price: level.Price, qty: level.DisplayQty, count: level.DisplayCount}
+			}
+		}
+		o.publishedLevels[side] = levels
+		o.publishedBest[side] = publishedLevel{}
+		if level := o.bestLevel(side); level != nil {
+			o.publishedBest[side] = publishedLevel{price: level.Price, qty: level.DisplayQty, count: level.DisplayCount}
+		}
+	}
+}
+
+func (o *OrderBook) publish(update MarketDataUpdate) {
+	o.marketDataSequence += 1
+	update.Sequence = o.marketDataSequence
+	update.Timestamp = o.now
+	o.marketData(update)
+}
@@ -0,0 +1,122 @@
+package tome
+
+import (
+	"github.com/cockroachdb/apd"
+	"testing"
+)
+
+// local book maintained from incremental market data updates
+type bookReplica struct {
+	t        *testing.T
+	sequence uint64
+	levels   map[OrderSide]map[string]DepthLevel
+	orders   map[uint64]int64 // displayed quantities
+	best     map[OrderSide]DepthLevel
+}
+
+func newBookReplica(t *testing.T) *bookReplica {
+	return &bookReplica{
+		t:      t,
+		levels: map[OrderSide]map[string]DepthLevel{SideBuy: {}, SideSell: {}},
+		orders: make(map[uint64]int64),
+		best:   make(map[OrderSide]DepthLevel),
+	}
+}
+
+func (r *bookReplica) handle(update MarketDataUpdate) {
+	if update.Sequence != r.sequence+1 {
+		r.t.Errorf("expected sequence %d, got %d", r.sequence+1, update.Sequence)
+	}
+	r.sequence = update.Sequence
+	level := DepthLevel{Price: update.Price, Qty: update.Qty, Count: update.Count}
+	switch update.Type {
+	case UpdateBest:
+		r.best[update.Side] = level
+	case UpdateLevelAdd, UpdateLevelChange:
+		r.levels[update.Side][update.Price.String()] = level
+	case UpdateLevelDelete:
+		delete(r.levels[update.Side], update.Price.String())
+	case UpdateOrderAdd, UpdateOrderModify, UpdateOrderExecute:
+		r.orders[update.OrderID] = update.Qty
+	case UpdateOrderDelete:
+		delete(r.orders, update.OrderID)
+	}
+}
+
+func (r *bookReplica) assertMatches(ob *OrderBook) {
+	depth := ob.Depth(0)
+	for side, levels := range map[OrderSide][]DepthLevel{SideBuy: depth.Bids, SideSell: depth.Asks} {
+		if len(levels) != len(r.levels[side]) {
+			r.t.Fatalf("%s: expected %d levels, replica has %d", side, len(levels), len(r.levels[side]))
+		}
+		for _, level := range levels {
+			replicated := r.levels[side][level.Price.String()]
+			if replicated.Qty != level.Qty || replicated.Count != level.Count {
+				r.t.Errorf("%s: expected level %+v, replica has %+v", side, level, replicated)
+			}
+		}
+		best := r.best[side]
+		if len(levels) == 0 {
+			if best.Qty != 0 {
+				r.t.Errorf("%s: expected an empty side, replica has best %+v", side, best)
+			}
+		} else if best.Price.Cmp(&levels[0].Price) != 0 || best.Qty != levels[0].Qty {
+			r.t.Errorf("%s: expected best %+v, replica has %+v", side, levels[0], best)
+		}
+	}
+
+	displayed := make(map[uint64]int64)
+	for _, order := range append(ob.GetBids(), ob.GetAsks()...) {
+		if !order.Params.Is(ParamHidden) {
+			displayed[order.ID] = order.DisplayedQty()
+		}
+	}
+	for id, qty := range r.orders {
+		if qty == 0 {
+			continue
+		}
+		if displayed[id] != qty {
+			r.t.Errorf("order %d: expected displayed qty %d, replica has %d", id, displayed[id], qty)
+		}
+	}
+	if len(displayed) != len(r.orders) {
+		r.t.Errorf("expected %d displayed orders, replica has %d", len(displayed), len(r.orders))
+	}
+}
+
+func TestOrderBook_MarketData(t *testing.T) {
+	replica := newBookReplica(t)
+	ob := NewOrderBook(instrument, *apd.New(2025, -2), NewTradeBook(instrument), NOPOrderRepository,
+		WithMarketDataHandler(replica.handle))
+
+	add := func(order Order) {
+		if _, err := ob.Add(order); err != nil {
+			t.Fatal(err)
+		}
+		replica.assertMatches(ob)
+	}
+	add(createOrder(1, TypeLimit, 0, 5, *apd.New(2010, -2), apd.Decimal{}, SideSell))
+	add(createOrder(2, TypeLimit, 0, 5, *apd.New(2011, -2), apd.Decimal{}, SideSell))
+	iceberg := createOrder(3, TypeLimit, 0, 10, *apd.New(2010, -2), apd.Decimal{}, SideSell)
+	iceberg.DisplayQty = 3
+	add(iceberg)
+	add(createOrder(4, TypeLimit, ParamHidden, 5, *apd.New(2009, -2), apd.Decimal{}, SideSell))
+	add(createOrder(5, TypeLimit, 0, 4, *apd.New(2000, -2), apd.Decimal{}, SideBuy))
+	add(createOrder(6, TypeLimit, 0, 12, *apd.New(2010, -2), apd.Decimal{}, SideBuy)) // executes hidden, 1 and iceberg
+
+	if _, err := ob.Amend(5, 3, *apd.New(2000, -2)); err != nil {
+		t.Fatal(err)
+	}
+	replica.assertMatches(ob)
+	if _, err := ob.Amend(5, 3, *apd.New(2001, -2)); err != nil {
+		t.Fatal(err)
+	}
+	replica.assertMatches(ob)
+	if err := ob.Cancel(2); err != nil {
+		t.Fatal(err)
+	}
+	replica.assertMatches(ob)
+	if replica.sequence == 0 {
+		t.Fatal("expected market data updates")
+	}
+}
@@ -41,6 +41,31 @@ const (
 	TypeLimit
 )
 
+// order status derived from its filled quantity and cancellation
+type OrderStatus byte
+
+const (
+	StatusNew OrderStatus = iota + 1
+	StatusPartiallyFilled
+	StatusFilled
+	StatusCancelled
+)
+
+func (o OrderStatus) String() string {
+	switch o {
+	case StatusNew:
+		return "New"
+	case StatusPartiallyFilled:
+		return "PartiallyFilled"
+	case StatusFilled:
+		return "Filled"
+	case StatusCancelled:
+		return "Cancelled"
+	default:
+		return "invalid"
+	}
+}
+
 // determines order parameters. Each bit turns on a different parameter which changes the way an order is stored and matched
 type OrderParams uint64
 
@@ -67,6 +92,7 @@ func (o OrderParams) String() string {
 	added = o.appendStr(added, &sb, ParamGTC, "GTC")
 	added = o.appendStr(added, &sb, ParamGFD, "GFD")
 	added = o.appendStr(added, &sb, ParamGTD, "GTD")
+	added = o.appendStr(added, &sb, ParamHidden, "HIDDEN")
 	return sb.String()
 }
 
@@ -77,20 +103,21 @@ func (o OrderParams) Is(param OrderParams) bool {
 }
 
 const (
-	ParamStop OrderParams = 0x1                 // stop order (has to have stop price set)
-	ParamAON  OrderParams = 0x2                 // all-or-nothing - complete fill or cancel https://www.investopedia.com/terms/a/aon.asp
-	ParamIOC  OrderParams = 0x4                 // immediate-or-cancel - immediately fill what you can, cancel the rest
-	ParamFOK  OrderParams = ParamIOC | ParamAON // IOC + AON - immediately try to fill the whole order
-	ParamGTC  OrderParams = 0x10                // good-till-cancelled -  keep order active until manually cancelled
-	ParamGFD  OrderParams = 0x20                // good-for-day keep order active until the end of the trading day
-	ParamGTD  OrderParams = 0x40                // good-till-date - keep order active until the provided date (including the date)
+	ParamStop   OrderParams = 0x1                 // stop order (has to have stop price set)
+	ParamAON    OrderParams = 0x2                 // all-or-nothing - complete fill or cancel https://www.investopedia.com/terms/a/aon.asp
+	ParamIOC    OrderParams = 0x4                 // immediate-or-cancel - immediately fill what you can, cancel the rest
+	ParamFOK    OrderParams = ParamIOC | ParamAON // IOC + AON - immediately try to fill the whole order
+	ParamGTC    OrderParams = 0x10                // good-till-cancelled -  keep order active until manually cancelled
+	ParamGFD    OrderParams = 0x20                // good-for-day keep order active until the end of the trading day
+	ParamGTD    OrderParams = 0x40                // good-till-date - keep order active until the provided date (including the date)
+	ParamHidden OrderParams = 0x80                // hidden order - matched normally, but never displayed in market data
 )
 
 // Used as a transport object in matching and quick retrieval, represents an order stored somewhere else.
 type OrderTracker struct {
 	OrderID   uint64
 	Type      OrderType
-	Price     float64
+	Price     int64 // fixed-point price, see ToFixedPrice
 	Side      OrderSide
 	Timestamp int64 // nanoseconds since Epoch
 }
@@ -103,14 +130,16 @@ type Order struct {
 	CustomerID uuid.UUID
 	Timestamp  time.Time // local timestamp - when did the order arrive
 
-	Type      OrderType   // order type - market or limit
-	Params    OrderParams // order parameters which change the way an order is stored and matched
-	Qty       int64       // quantity - no fractional prices available, no unsigned to prevent accidental huge orders
-	FilledQty int64       // currently filled quantity
-	Price     apd.Decimal // used in limit orders
-	StopPrice apd.Decimal // used in stop orders
-	Side      OrderSide   // determines whether an order is a bid (buy) or an ask (sell)
-	Cancelled bool        // determines if an order is cancelled. A partially filled order can be cancelled.
+	Type       OrderType   // order type - market or limit
+	Params     OrderParams // order parameters which change the way an order is stored and matched
+	Qty        int64       // quantity - no fractional prices available, no unsigned to prevent accidental huge orders
+	DisplayQty int64       // displayed quantity of iceberg orders, 0 displays the whole unfilled quantity
+	FilledQty  int64       // currently filled quantity
+	Price      apd.Decimal // used in limit orders
+	StopPrice  apd.Decimal // used in stop orders
+	Side       OrderSide   // determines whether an order is a bid (buy) or an ask (sell)
+	Cancelled  bool        // determines if an order is cancelled. A partially filled order can be cancelled.
+	ExpireTime time.Time   // expiration of GTD orders
 }
 
 func (o *Order) IsCancelled() bool {
@@ -137,4 +166,41 @@ func (o Order) UnfilledQty() int64 {
 	return o.Qty - o.FilledQty
 }
 
-//go:generate gotemplate "github.com/igrmk/treemap" "orderMap(OrderTracker, bool)"
+// Returns the quantity displayed in market data - at most DisplayQty of the unfilled quantity, none for hidden orders.
+func (o Order) DisplayedQty() int64 {
+	return displayedQty(o.UnfilledQty(), displayPeak(o))
+}
+
+// Returns the current order status. Cancelled orders are cancelled even if they were partially filled.
+func (o Order) Status() OrderStatus {
+	switch {
+	case o.Cancelled:
+		return StatusCancelled
+	case o.IsFilled():
+		return StatusFilled
+	case o.FilledQty > 0:
+		return StatusPartiallyFilled
+	default:
+		return StatusNew
+	}
+}
+
+// returns the displayed quantity peak of an order - 0 displays the whole quantity, negative peaks are hidden
+func displayPeak(order Order) int64 {
+	if order.Params.Is(ParamHidden) {
+		return -1
+	}
+	return order.DisplayQty
+}
+
+func displayedQty(qty, peak int64) int64 {
+	if peak < 0 {
+		return 0
+	}
+	if peak > 0 && peak < qty {
+		return peak
+	}
+	return qty
+}
+
+//go:generate gotemplate "github.com/igrmk/treemap" "levelMap(OrderTracker, *priceLevel)"
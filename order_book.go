@@ -6,6 +6,7 @@ import (
 	"github.com/cockroachdb/apd"
 	"github.com/google/uuid"
 	"log"
+	"sort"
 	"sync"
 	"time"
 )
@@ -19,6 +20,8 @@ var (
 	ErrInvalidMarketPrice = errors.New("price has to be zero for market orders")
 	ErrInvalidLimitPrice  = errors.New("price has to be set for limit orders")
 	ErrInvalidStopPrice   = errors.New("stop price has to be set for a stop order")
+	ErrInvalidExpireTime  = errors.New("expire time of a GTD order has to be in the future")
+	ErrOrderNotFound      = errors.New("order not found")
 
 	BaseContext = apd.Context{
 		Precision:   0,               // no rounding
@@ -33,6 +36,7 @@ type OrderBook struct {
 	Instrument string // instrument name
 
 	marketPrice      apd.Decimal // current market price
+	fixedMarketPrice int64       // current market price in fixed-point, compared with stop prices
 	marketPriceMutex sync.RWMutex
 
 	tradeBook *TradeBook // trade book ptr
@@ -43,8 +47,69 @@ type OrderBook struct {
 	orders     *orderContainer // contains all orders sorted by our preferences
 	stopOrders *orderContainer // contains all stop orders sorted by our preferences
 
+	allocator  Allocator     // price level allocation, orders are matched in FIFO order if nil
+	priceScale int32         // number of decimal places of fixed-point prices
+	journal    journalWriter // journal of all commands and events, can be nil
+	history    OrderHistory  // audit trail of order state transitions, can be nil
+	fees       FeeModel      // calculates trade fees, can be nil
+
+	marketData         MarketDataHandler // incremental market data updates, can be nil
+	marketDataSequence uint64            // sequence of the last market data update
+	publishedLevels    map[OrderSide]map[int64]publishedLevel
+	publishedBest      map[OrderSide]publishedLevel
+	clock              func() time.Time // read once per command
+	now                time.Time        // time of the command which is being processed
+	nextExpiry         time.Time        // earliest expire time of active GTD orders, zero if there are none
+
 	orderMutex sync.RWMutex
-	matchMutex sync.Mutex // mutex that ensures that matching is always sequential
+	matchMutex sync.Mutex // mutex that ensures that commands (and matching) are always sequential
+}
+
+// writes journal records, implemented by Journal
+type journalWriter interface {
+	Append(recordType RecordType, timestamp time.Time, payload []byte) (uint64, error)
+	Sequence() uint64
+}
+
+// OrderBookOption changes the default behaviour of an order book.
+type OrderBookOption func(o *OrderBook)
+
+// Allocate incoming orders between resting limit orders at a price level using the allocator.
+func WithAllocator(allocator Allocator) OrderBookOption {
+	return func(o *OrderBook) {
+		o.allocator = allocator
+	}
+}
+
+// Write all commands and their resulting events to the journal.
+func WithJournal(journal *Journal) OrderBookOption {
+	return func(o *OrderBook) {
+		if journal != nil {
+			o.journal = journal
+		}
+	}
+}
+
+// Append an event of every order state transition to the order history.
+func WithOrderHistory(history OrderHistory) OrderBookOption {
+	return func(o *OrderBook) {
+		o.history = history
+	}
+}
+
+// Use a custom clock (e.g. SimulatedClock.Now). The clock is read once per command, all trades and journal records
+// resulting from a command share its time.
+func WithClock(clock func() time.Time) OrderBookOption {
+	return func(o *OrderBook) {
+		o.clock = clock
+	}
+}
+
+// Use fixed-point prices with scale decimal places (tick size of 10^-scale). Orders with more precise prices are rejected.
+func WithPriceScale(scale int32) OrderBookOption {
+	return func(o *OrderBook) {
+		o.priceScale = scale
+	}
 }
 
 // function that compares two OrderTrackers and returns true if a is less or equal than b
@@ -68,11 +133,10 @@ func makeComparator(priceDescending bool) LessFunc {
 		} else if a.Type == TypeMarket && b.Type == TypeMarket {
 			return a.Timestamp < b.Timestamp // if both market order by time
 		}
-		priceCmp := a.Price - b.Price // compare prices
-		if priceCmp == 0 {            // if prices are equal, compare timestamps
+		if a.Price == b.Price { // if prices are equal, compare timestamps
 			return a.Timestamp < b.Timestamp
 		}
-		if priceCmp < 0 { // if a price is less than b return true if ascending, false if descending
+		if a.Price < b.Price { // if a price is less than b return true if ascending, false if descending
 			return sort
 		}
 		return !sort // if a price is bigger than b return false if ascending, true if descending
@@ -89,11 +153,10 @@ func makeStopComparator(priceDescending bool) LessFunc {
 		sort = descending
 	}
 	return func(a, b OrderTracker) bool { // ignores order types because we're always comparing stop prices
-		priceCmp := a.Price - b.Price // compare prices
-		if priceCmp == 0 {            // if prices are equal, compare timestamps
+		if a.Price == b.Price { // if prices are equal, compare timestamps
 			return a.Timestamp < b.Timestamp
 		}
-		if priceCmp < 0 { // if a price is less than b return true if ascending, false if descending
+		if a.Price < b.Price { // if a price is less than b return true if ascending, false if descending
 			return sort
 		}
 		return !sort // if a price is bigger than b return false if ascending, true if descending
@@ -101,7 +164,7 @@ func makeStopComparator(priceDescending bool) LessFunc {
 }
 
 // Create a new order book.
-func NewOrderBook(instrument string, marketPrice apd.Decimal, tradeBook *TradeBook, orderRepo OrderRepository) *OrderBook {
+func NewOrderBook(instrument string, marketPrice apd.Decimal, tradeBook *TradeBook, orderRepo OrderRepository, opts ...OrderBookOption) *OrderBook {
 	bidLess := makeComparator(true)
 	askLess := makeComparator(false)
 	/*
@@ -116,7 +179,7 @@ func NewOrderBook(instrument string, marketPrice apd.Decimal, tradeBook *TradeBo
 	*/
 	stopBidLess := makeStopComparator(false)
 	stopAskLess := makeStopComparator(true)
-	return &OrderBook{
+	book := &OrderBook{
 		Instrument:   instrument,
 		marketPrice:  marketPrice,
 		tradeBook:    tradeBook,
@@ -124,7 +187,19 @@ func NewOrderBook(instrument string, marketPrice apd.Decimal, tradeBook *TradeBo
 		activeOrders: make(map[uint64]Order),
 		orders:       NewOrderContainer(bidLess, askLess),
 		stopOrders:   NewOrderContainer(stopBidLess, stopAskLess),
+		priceScale:   DefaultPriceScale,
+		clock:        time.Now,
+		publishedLevels: map[OrderSide]map[int64]publishedLevel{
+			SideBuy:  make(map[int64]publishedLevel),
+			SideSell: make(map[int64]publishedLevel),
+		},
+		publishedBest: make(map[OrderSide]publishedLevel),
 	}
+	for _, opt := range opts {
+		opt(book)
+	}
+	book.fixedMarketPrice, _ = ToFixedPrice(roundHalfUp(marketPrice, book.priceScale), book.priceScale)
+	return book
 }
 
 // Get all bids ordered the same way they are matched.
@@ -171,6 +246,11 @@ func (o *OrderBook) GetStopAsks() []Order {
 	return orders
 }
 
+// Get an active (resting or stop) order by ID. Returns false if the order is filled, cancelled or unknown.
+func (o *OrderBook) GetOrder(id uint64) (Order, bool) {
+	return o.getActiveOrder(id)
+}
+
 // Get a market price.
 func (o *OrderBook) MarketPrice() apd.Decimal {
 	o.marketPriceMutex.RLock()
@@ -178,31 +258,85 @@ func (o *OrderBook) MarketPrice() apd.Decimal {
 	return o.marketPrice
 }
 
-// Set a market price.
-func (o *OrderBook) SetMarketPrice(price apd.Decimal, fPrice float64) {
+// Returns the trade book of the order book.
+func (o *OrderBook) TradeBook() *TradeBook {
+	return o.tradeBook
+}
+
+// Returns the number of decimal places of fixed-point prices.
+func (o *OrderBook) PriceScale() int32 {
+	return o.priceScale
+}
+
+// Set a market price. Activates stop orders which were passed by the market price.
+func (o *OrderBook) SetMarketPrice(price apd.Decimal) error {
+	o.beginCommand()
+	defer o.endCommand()
+
+	if err := o.journalRecord(RecordSetMarketPrice, encodePrice(price)); err != nil {
+		return err
+	}
+	fixedPrice, err := ToFixedPrice(price, o.priceScale)
+	if err != nil {
+		return err
+	}
+	o.setMarketPrice(price, fixedPrice)
+	return nil
+}
+
+func (o *OrderBook) setMarketPrice(price apd.Decimal, fixedPrice int64) {
 	o.marketPriceMutex.Lock()
-	o.marketPrice = price
+	o.marketPrice, o.fixedMarketPrice = price, fixedPrice
 	o.marketPriceMutex.Unlock()
 
-	bids := o.stopOrders.GetBidsBelow(fPrice)
-	o.addOrders(bids)
-	asks := o.stopOrders.GetAsksAbove(fPrice)
-	o.addOrders(asks)
+	bids := o.stopOrders.GetBidsBelow(fixedPrice)
+	o.activateStopOrders(bids)
+	asks := o.stopOrders.GetAsksAbove(fixedPrice)
+	o.activateStopOrders(asks)
 }
 
-func (o *OrderBook) addOrders(trackers []OrderTracker) {
-	for _, bid := range trackers {
-		order, ok := o.getActiveOrder(bid.OrderID)
-		o.stopOrders.Remove(bid.OrderID)
+// Move stop orders to the books and match them.
+func (o *OrderBook) activateStopOrders(trackers []OrderTracker) {
+	for _, stopTracker := range trackers {
+		o.orderMutex.Lock()
+		order, ok := o.activeOrders[stopTracker.OrderID]
 		if !ok {
-			panic(fmt.Errorf("order with ID %d not found", bid.OrderID))
+			o.orderMutex.Unlock()
+			panic(fmt.Errorf("order with ID %d not found", stopTracker.OrderID))
 		}
-		if _, err := o.submit(order, bid); err != nil {
+		o.stopOrders.Remove(stopTracker.OrderID)
+		delete(o.activeOrders, stopTracker.OrderID) // it's going to be stored again if it isn't filled
+		o.orderMutex.Unlock()
+
+		tracker, err := o.orderTracker(order)
+		if err != nil {
+			log.Println(err) // todo: better handling of these events
+			continue
+		}
+		if err := o.recordEvent(order, EventTriggered, ActorEngine); err != nil {
+			log.Println(err)
+		}
+		if _, err := o.submit(order, tracker); err != nil {
 			log.Println(err) // todo: better handling of these events
 		}
 	}
 }
 
+// Create an order tracker with a fixed-point order price.
+func (o *OrderBook) orderTracker(order Order) (OrderTracker, error) {
+	price, err := ToFixedPrice(order.Price, o.priceScale)
+	if err != nil {
+		return OrderTracker{}, err
+	}
+	return OrderTracker{
+		OrderID:   order.ID,
+		Type:      order.Type,
+		Price:     price,
+		Side:      order.Side,
+		Timestamp: order.Timestamp.UnixNano(),
+	}, nil
+}
+
 // Get an order from activeOrders map.
 func (o *OrderBook) getActiveOrder(id uint64) (Order, bool) {
 	o.orderMutex.RLock()
@@ -219,22 +353,84 @@ func (o *OrderBook) setActiveOrder(order Order) error {
 		return fmt.Errorf("order with ID %d already exists", order.ID)
 	}
 	o.activeOrders[order.ID] = order
+	o.trackExpiry(order)
 	return nil
 }
 
+// Start processing a command - wait for other commands to finish and read the clock.
+func (o *OrderBook) beginCommand() {
+	o.matchMutex.Lock()
+	o.now = o.clock()
+}
+
+// Finish processing a command.
+func (o *OrderBook) endCommand() {
+	o.matchMutex.Unlock()
+}
+
+// Save an order to the order repository and journal its new state.
+func (o *OrderBook) saveOrder(order Order) error {
+	if o.journal != nil {
+		payload, _ := order.MarshalBinary()
+		if err := o.journalRecord(RecordOrderUpdate, payload); err != nil {
+			return err
+		}
+	}
+	return o.orderRepo.Save(order)
+}
+
+// Append an order state transition to the order history, if the order book has one.
+func (o *OrderBook) recordEvent(order Order, eventType OrderEventType, actor OrderActor) error {
+	return o.recordFill(order, eventType, actor, 0, 0)
+}
+
+func (o *OrderBook) recordFill(order Order, eventType OrderEventType, actor OrderActor, tradeID uint64, tradeQty int64) error {
+	if o.history == nil {
+		return nil
+	}
+	_, err := o.history.Append(OrderEvent{
+		OrderID:   order.ID,
+		Type:      eventType,
+		Actor:     actor,
+		Timestamp: o.now,
+		Qty:       order.Qty,
+		FilledQty: order.FilledQty,
+		Price:     order.Price,
+		TradeID:   tradeID,
+		TradeQty:  tradeQty,
+	})
+	return err
+}
+
+// Append a record to the journal, if the order book has one.
+func (o *OrderBook) journalRecord(recordType RecordType, payload []byte) error {
+	if o.journal == nil {
+		return nil
+	}
+	_, err := o.journal.Append(recordType, o.now, payload)
+	return err
+}
+
 // Add an order to books - make it matchable against other orders.
-func (o *OrderBook) addToBooks(tracker OrderTracker) {
+func (o *OrderBook) addToBooks(tracker OrderTracker, order Order) {
 	o.orderMutex.Lock()
-	o.orders.Add(tracker) // enter pointer to the tree
+	o.orders.Add(tracker, order.UnfilledQty()) // enter the tracker to its price level
+	o.orders.SetPeak(order.ID, displayPeak(order))
 	o.orderMutex.Unlock()
+	o.publishOrder(UpdateOrderAdd, order, tracker.Price, 0, 0)
 }
 
 func (o *OrderBook) storeOrder(order Order) error {
 	if err := o.setActiveOrder(order); err != nil {
-		o.orders.Remove(order.ID)
+		o.orderMutex.Lock()
+		tracker, inBooks := o.removeTracker(order.ID)
+		o.orderMutex.Unlock()
+		if inBooks {
+			o.publishOrder(UpdateOrderDelete, order, tracker.Price, 0, 0)
+		}
 		return err
 	}
-	return o.orderRepo.Save(order)
+	return o.saveOrder(order)
 }
 
 // Update an active order.
@@ -245,7 +441,9 @@ func (o *OrderBook) updateActiveOrder(order Order) error {
 		return fmt.Errorf("order with ID %d hasn't yet been saved", order.ID)
 	}
 	o.activeOrders[order.ID] = order
-	return o.orderRepo.Save(order)
+	o.orders.Update(order.ID, order.UnfilledQty()) // keep price level quantities up to date
+	o.stopOrders.Update(order.ID, order.UnfilledQty())
+	return o.saveOrder(order)
 }
 
 // Removes an order from books - removes it from possible matches.
@@ -254,27 +452,250 @@ func (o *OrderBook) removeFromBooks(orderID uint64) {
 	if !ok {
 		return
 	}
-	if err := o.orderRepo.Save(order); err != nil { // ensure we store the latest order data
+	if err := o.saveOrder(order); err != nil { // ensure we store the latest order data
 		log.Printf("cannot save the order %+v to the repo - repository data might be inconsistent\n", order.ID)
 	}
 
 	o.orderMutex.Lock()
-	o.orders.Remove(orderID)
+	tracker, inBooks := o.removeTracker(orderID)
 	delete(o.activeOrders, orderID) // remove an active order
 	o.orderMutex.Unlock()
+	if inBooks {
+		o.publishOrder(UpdateOrderDelete, order, tracker.Price, 0, 0)
+	}
+}
+
+// Remove an order tracker from the container which holds it. Has to be called under the order lock.
+// Returns the removed tracker and true if it was removed from the books (not from stop orders).
+func (o *OrderBook) removeTracker(orderID uint64) (OrderTracker, bool) {
+	if tracker, ok := o.stopOrders.Get(orderID); ok {
+		o.stopOrders.Remove(orderID)
+		return tracker, false
+	}
+	tracker, ok := o.orders.Get(orderID)
+	if ok {
+		o.orders.Remove(orderID)
+	}
+	return tracker, ok
 }
 
-// Cancel an order.
+// Cancel an order. Cancelled orders are immediately removed from the books.
 func (o *OrderBook) Cancel(id uint64) error {
-	o.orderMutex.RLock()
-	order, ok := o.activeOrders[id]
-	o.orderMutex.RUnlock()
+	o.beginCommand()
+	defer o.endCommand()
 
+	if err := o.journalRecord(RecordCancelOrder, encodeOrderID(id)); err != nil {
+		return err
+	}
+	order, ok := o.getActiveOrder(id)
 	if !ok {
 		return nil
 	}
 	order.Cancel()
-	return o.updateActiveOrder(order) // todo: remove from active orders
+	if err := o.saveOrder(order); err != nil {
+		return err
+	}
+	if err := o.recordEvent(order, EventCancelled, ActorCustomer); err != nil {
+		return err
+	}
+
+	o.orderMutex.Lock()
+	tracker, inBooks := o.removeTracker(id)
+	delete(o.activeOrders, id)
+	o.orderMutex.Unlock()
+	if inBooks {
+		o.publishOrder(UpdateOrderDelete, order, tracker.Price, 0, 0)
+	}
+	return nil
+}
+
+// Cancel all active orders of a customer (e.g. when its session disconnects), including stop orders. All orders are
+// removed from the books at once, so none of them can be matched while others are already cancelled.
+// Returns the cancelled orders sorted by ID.
+func (o *OrderBook) CancelCustomerOrders(customerID uuid.UUID) ([]Order, error) {
+	o.beginCommand()
+	defer o.endCommand()
+
+	if err := o.journalRecord(RecordCancelCustomerOrders, encodeCustomerID(customerID)); err != nil {
+		return nil, err
+	}
+	return o.cancelOrders(func(order Order) bool {
+		return order.CustomerID == customerID
+	}, EventCancelled, ActorCustomer)
+}
+
+// Cancel active orders with the IDs (e.g. orders of a disconnected session), including stop orders. All orders are
+// removed from the books at once, so none of them can be matched while others are already cancelled. IDs of orders
+// which aren't active are ignored.
+// Returns the cancelled orders sorted by ID.
+func (o *OrderBook) CancelOrders(ids []uint64) ([]Order, error) {
+	o.beginCommand()
+	defer o.endCommand()
+
+	if err := o.journalRecord(RecordCancelOrders, encodeOrderIDs(ids)); err != nil {
+		return nil, err
+	}
+	cancel := make(map[uint64]struct{}, len(ids))
+	for _, id := range ids {
+		cancel[id] = struct{}{}
+	}
+	return o.cancelOrders(func(order Order) bool {
+		_, ok := cancel[order.ID]
+		return ok
+	}, EventCancelled, ActorCustomer)
+}
+
+// Cancel GTD orders (including stop orders) whose expire time isn't after the order book clock. Expired orders aren't
+// removed by other commands, so they have to be expired before they can be matched - call ExpireOrders periodically
+// and before entering or amending orders. The command is journaled only if some orders expire.
+// Returns the expired orders sorted by ID.
+func (o *OrderBook) ExpireOrders() ([]Order, error) {
+	o.beginCommand()
+	defer o.endCommand()
+
+	o.orderMutex.Lock()
+	due := o.expiryDueLocked()
+	o.orderMutex.Unlock()
+	if !due {
+		return nil, nil
+	}
+	if err := o.journalRecord(RecordExpireOrders, nil); err != nil {
+		return nil, err
+	}
+	return o.cancelOrders(o.isExpired, EventExpired, ActorEngine)
+}
+
+func (o *OrderBook) isExpired(order Order) bool {
+	return order.Params.Is(ParamGTD) && !order.ExpireTime.After(o.now)
+}
+
+// Keep the earliest expire time of active GTD orders. Has to be called under the order lock.
+func (o *OrderBook) trackExpiry(order Order) {
+	if order.Params.Is(ParamGTD) && (o.nextExpiry.IsZero() || order.ExpireTime.Before(o.nextExpiry)) {
+		o.nextExpiry = order.ExpireTime
+	}
+}
+
+// Returns true if some active orders have expired and recalculates the earliest expire time of the others. Has to be
+// called under the order lock.
+func (o *OrderBook) expiryDueLocked() bool {
+	if o.nextExpiry.IsZero() || o.nextExpiry.After(o.now) { // nextExpiry is never later than the earliest expire time
+		return false
+	}
+	due := false
+	o.nextExpiry = time.Time{}
+	for _, order := range o.activeOrders {
+		if o.isExpired(order) {
+			due = true
+		} else {
+			o.trackExpiry(order)
+		}
+	}
+	return due
+}
+
+// Cancel all active orders which match at once and record the event. Returns the cancelled orders sorted by ID.
+func (o *OrderBook) cancelOrders(match func(order Order) bool, eventType OrderEventType, actor OrderActor) ([]Order, error) {
+	type removedOrder struct {
+		order   Order
+		tracker OrderTracker
+		inBooks bool
+	}
+	var removed []removedOrder
+	o.orderMutex.Lock()
+	for id, order := range o.activeOrders {
+		if !match(order) {
+			continue
+		}
+		tracker, inBooks := o.removeTracker(id)
+		delete(o.activeOrders, id)
+		order.Cancel()
+		removed = append(removed, removedOrder{order: order, tracker: tracker, inBooks: inBooks})
+	}
+	o.orderMutex.Unlock()
+	sort.Slice(removed, func(i, j int) bool {
+		return removed[i].order.ID < removed[j].order.ID
+	})
+
+	var err error
+	cancelled := make([]Order, 0, len(removed))
+	for _, r := range removed {
+		if saveErr := o.saveOrder(r.order); saveErr != nil && err == nil {
+			err = saveErr
+		}
+		if recordErr := o.recordEvent(r.order, eventType, actor); recordErr != nil && err == nil {
+			err = recordErr
+		}
+		if r.inBooks {
+			o.publishOrder(UpdateOrderDelete, r.order, r.tracker.Price, 0, 0)
+		}
+		cancelled = append(cancelled, r.order)
+	}
+	return cancelled, err
+}
+
+// Amend an active order - change its quantity and limit price.
+// Decreasing the quantity keeps the time priority of an order, any other change re-enters the order with a new timestamp
+// and can result in a match. Stop orders keep their priority because they are sorted by stop prices.
+// Returns true if the amended order was matched (partially or fully).
+func (o *OrderBook) Amend(id uint64, qty int64, price apd.Decimal) (bool, error) {
+	o.beginCommand()
+	defer o.endCommand()
+
+	if err := o.journalRecord(RecordAmendOrder, encodeAmendment(id, qty, price)); err != nil {
+		return false, err
+	}
+	order, ok := o.getActiveOrder(id)
+	if !ok {
+		return false, ErrOrderNotFound
+	}
+	if qty <= MinQty || qty <= order.FilledQty {
+		return false, ErrInvalidQty
+	}
+	if order.Type == TypeMarket && !price.IsZero() {
+		return false, ErrInvalidMarketPrice
+	}
+	if order.Type == TypeLimit && price.IsZero() {
+		return false, ErrInvalidLimitPrice
+	}
+
+	keepsPriority := order.Price.Cmp(&price) == 0 && qty <= order.Qty
+	order.Qty = qty
+	order.Price = price
+	tracker, err := o.orderTracker(order)
+	if err != nil {
+		return false, err
+	}
+
+	o.orderMutex.RLock()
+	_, isStop := o.stopOrders.Get(id)
+	o.orderMutex.RUnlock()
+
+	if err := o.recordEvent(order, EventAmended, ActorCustomer); err != nil {
+		return false, err
+	}
+
+	if isStop || keepsPriority {
+		if err := o.updateActiveOrder(order); err != nil {
+			return false, err
+		}
+		if !isStop {
+			o.publishOrder(UpdateOrderModify, order, tracker.Price, 0, 0)
+		}
+		return false, nil
+	}
+
+	// the order loses its time priority - remove it from the books and submit it again
+	o.orderMutex.Lock()
+	previous, _ := o.orders.Get(id)
+	o.orders.Remove(id)
+	delete(o.activeOrders, id)
+	o.orderMutex.Unlock()
+	o.publishOrder(UpdateOrderDelete, order, previous.Price, 0, 0)
+
+	order.Timestamp = o.now
+	tracker.Timestamp = order.Timestamp.UnixNano()
+	return o.submit(order, tracker)
 }
 
 // get an OrderTracker from order ID. Returns false if OrderTracker under that ID doesn't exist.
@@ -287,7 +708,16 @@ func (o *OrderBook) getOrderTracker(orderID uint64) (OrderTracker, bool) {
 // Add a new order. Order can be matched immediately or later (or never), depending on order parameters and order type.
 // Returns true if order was matched (partially or fully), false otherwise.
 func (o *OrderBook) Add(order Order) (bool, error) {
-	if order.Qty <= MinQty { // check the qty
+	o.beginCommand()
+	defer o.endCommand()
+
+	if o.journal != nil {
+		payload, _ := order.MarshalBinary()
+		if err := o.journalRecord(RecordAddOrder, payload); err != nil {
+			return false, err
+		}
+	}
+	if order.Qty <= MinQty || order.DisplayQty < 0 { // check the qty
 		return false, ErrInvalidQty
 	}
 	if order.Type == TypeMarket && !order.Price.IsZero() {
@@ -299,27 +729,28 @@ func (o *OrderBook) Add(order Order) (bool, error) {
 	if order.Params.Is(ParamStop) && order.StopPrice.IsZero() {
 		return false, ErrInvalidStopPrice
 	}
+	if order.Params.Is(ParamGTD) && !order.ExpireTime.After(o.now) {
+		return false, ErrInvalidExpireTime
+	}
 
-	orderPrice, err := order.Price.Float64()
+	tracker, err := o.orderTracker(order)
 	if err != nil {
 		return false, err
 	}
-
-	tracker := OrderTracker{
-		OrderID:   order.ID,
-		Type:      order.Type,
-		Price:     orderPrice,
-		Side:      order.Side,
-		Timestamp: order.Timestamp.UnixNano(),
-	}
-
+	var orderStopPrice int64
 	if order.Params.Is(ParamStop) {
-		marketPrice := o.MarketPrice()
-
-		orderStopPrice, err := order.StopPrice.Float64()
-		if err != nil {
+		if orderStopPrice, err = ToFixedPrice(order.StopPrice, o.priceScale); err != nil {
 			return false, err
 		}
+	}
+	if err := o.recordEvent(order, EventAccepted, ActorCustomer); err != nil {
+		return false, err
+	}
+
+	if order.Params.Is(ParamStop) {
+		o.marketPriceMutex.RLock()
+		marketPrice := o.fixedMarketPrice
+		o.marketPriceMutex.RUnlock()
 
 		tracker := OrderTracker{
 			OrderID:   order.ID,
@@ -333,8 +764,8 @@ func (o *OrderBook) Add(order Order) (bool, error) {
 		case SideBuy:
 			// if market price is lower than the bid stop price add as a stop order
 			// otherwise process immediately
-			if marketPrice.Cmp(&order.StopPrice) < 0 {
-				o.stopOrders.Add(tracker)
+			if marketPrice < orderStopPrice {
+				o.stopOrders.Add(tracker, order.UnfilledQty())
 				if err := o.storeOrder(order); err != nil {
 					return false, err
 				}
@@ -343,8 +774,8 @@ func (o *OrderBook) Add(order Order) (bool, error) {
 		case SideSell:
 			// if market price is higher than the ask stop price add as a stop order
 			// otherwise proces immediately
-			if marketPrice.Cmp(&order.StopPrice) > 0 {
-				o.stopOrders.Add(tracker)
+			if marketPrice > orderStopPrice {
+				o.stopOrders.Add(tracker, order.UnfilledQty())
 				if err := o.storeOrder(order); err != nil {
 					return false, err
 				}
@@ -371,15 +802,18 @@ func (o *OrderBook) submit(order Order, tracker OrderTracker) (bool, error) {
 	addToBooks := true
 
 	if order.Params.Is(ParamIOC) && !order.IsFilled() {
-		order.Cancel()                                  // cancel the rest of the order
-		if err := o.orderRepo.Save(order); err != nil { // store the order (not in the books)
+		order.Cancel()                             // cancel the rest of the order
+		if err := o.saveOrder(order); err != nil { // store the order (not in the books)
+			return matched, err
+		}
+		if err := o.recordEvent(order, EventCancelled, ActorEngine); err != nil {
 			return matched, err
 		}
 		addToBooks = false // don't add the order to the books (keep it stored but not active)
 	}
 
 	if !order.IsFilled() && addToBooks {
-		o.addToBooks(tracker)
+		o.addToBooks(tracker, order)
 		if err := o.storeOrder(order); err != nil {
 			return matched, err
 		}
@@ -396,24 +830,11 @@ func min(q1, q2 int64) int64 {
 }
 
 // match an order against other offers, return if an order was matched (partially or not) and error if it occurs
-func (o *OrderBook) matchOrder(orderPrice float64, order *Order, offers *orderMap) (bool, error) {
-	//o.matchMutex.Lock()
-	//defer o.matchMutex.Unlock()
+func (o *OrderBook) matchOrder(orderPrice int64, order *Order, offers *orderSide) (bool, error) {
 	// this method shouldn't handle stop orders
 	// we only have to take care of AON param (FOK will be handled in submit because of IOC) & market/limit types
 	var matched bool
 
-	var buyer, seller uuid.UUID
-	var bidOrderID, askOrderID uint64
-	buying := order.IsBid()
-	if buying {
-		buyer = order.CustomerID
-		bidOrderID = order.ID
-	} else {
-		seller = order.CustomerID
-		askOrderID = order.ID
-	}
-
 	removeOrders := make([]uint64, 0)
 
 	defer func() {
@@ -429,111 +850,242 @@ func (o *OrderBook) matchOrder(orderPrice float64, order *Order, offers *orderMa
 		if !ok {
 			panic("should NEVER happen - tracker exists but active order does not")
 		}
-		oppositeAON := oppositeOrder.Params.Is(ParamAON)
 
 		if oppositeOrder.IsCancelled() {
 			removeOrders = append(removeOrders, oppositeOrder.ID) // mark order for removal
 			continue                                              // don't match with this order
 		}
+		if oppositeOrder.IsFilled() { // already filled, waiting to be removed from the books
+			continue
+		}
+
+		price, fixedPrice, ok, done := tradePrice(orderPrice, order, oppositeOrder, oppositeTracker)
+		if done {
+			return matched, nil
+		}
+		if !ok {
+			continue
+		}
+
+		// AON orders can't be split between resting orders, allocate only whole price levels of limit orders
+		if o.allocator != nil && !currentAON && oppositeOrder.Type == TypeLimit {
+			levelMatched, err := o.matchLevel(order, &iter, price, fixedPrice, &removeOrders)
+			matched = matched || levelMatched
+			if err != nil {
+				return matched, err
+			}
+			if order.IsFilled() {
+				return true, nil
+			}
+			continue
+		}
 
 		qty := min(order.UnfilledQty(), oppositeOrder.UnfilledQty())
 		// ensure AONs are filled completely
 		if currentAON && qty != order.UnfilledQty() {
 			continue // couldn't find a match - we require AON but couldn't fill the order in one trade
 		}
-		if oppositeAON && qty != oppositeOrder.UnfilledQty() {
+		if oppositeOrder.Params.Is(ParamAON) && qty != oppositeOrder.UnfilledQty() {
 			continue // couldn't find a match - other offer requires AON but our order can't fill it completely
 		}
 
-		var price apd.Decimal
-		var fPrice float64
-		switch order.Type { // look only after the best available price
+		matched = true
+		if err := o.executeTrade(order, oppositeOrder, qty, price, fixedPrice, &removeOrders); err != nil {
+			return matched, err
+		}
+		if order.IsFilled() {
+			return true, nil
+		}
+	}
+	return matched, nil
+}
+
+// determine the price of a trade between an order and an opposite order.
+// Returns false if orders can't be matched, done is true if no other opposite orders can be matched with the order.
+func tradePrice(orderPrice int64, order *Order, oppositeOrder Order, oppositeTracker OrderTracker) (price apd.Decimal, fixedPrice int64, ok, done bool) {
+	switch order.Type { // look only after the best available price
+	case TypeMarket:
+		switch oppositeOrder.Type {
 		case TypeMarket:
+			return price, fixedPrice, false, false // two opposing market orders are usually forbidden (rejected) - continue matching
+		case TypeLimit:
+			return oppositeOrder.Price, oppositeTracker.Price, true, false // crossing the spread
+		default:
+			panicOnOrderType(oppositeOrder)
+		}
+	case TypeLimit: // if buying buy for less or equal than our price, if selling sell for more or equal to our price
+		myPrice := order.Price
+		if order.IsBid() {
 			switch oppositeOrder.Type {
-			case TypeMarket:
-				continue // two opposing market orders are usually forbidden (rejected) - continue matching
+			case TypeMarket: // we have a limit, they are selling at our price
+				return myPrice, orderPrice, true, false
 			case TypeLimit:
-				price = oppositeOrder.Price // crossing the spread
-				fPrice = oppositeTracker.Price
+				// check if we can cross the spread
+				if orderPrice < oppositeTracker.Price {
+					return price, fixedPrice, false, true // other prices are going to be even higher than our limit
+				}
+				// our bid is higher or equal to their ask - set price to myPrice
+				return myPrice, orderPrice, true, false // e.g. our bid is $20.10, their ask is $20 - trade executes at $20.10
 			default:
 				panicOnOrderType(oppositeOrder)
 			}
-		case TypeLimit: // if buying buy for less or equal than our price, if selling sell for more or equal to our price
-			myPrice := order.Price
-			if buying {
-				switch oppositeOrder.Type {
-				case TypeMarket: // we have a limit, they are selling at our price
-					price = myPrice
-					fPrice = orderPrice
-				case TypeLimit:
-					// check if we can cross the spread
-					if myPrice.Cmp(&oppositeOrder.Price) < 0 {
-						return matched, nil // other prices are going to be even higher than our limit
-					} else {
-						// our bid is higher or equal to their ask - set price to myPrice
-						price = myPrice // e.g. our bid is $20.10, their ask is $20 - trade executes at $20.10
-						fPrice = orderPrice
-					}
-				default:
-					panicOnOrderType(oppositeOrder)
-				}
-			} else { // we're selling
-				switch oppositeOrder.Type {
-				case TypeMarket: // we have a limit, they are buying at our specified price
-					price = myPrice
-					fPrice = orderPrice
-				case TypeLimit:
-					// check if we can cross the spread
-					if myPrice.Cmp(&oppositeOrder.Price) > 0 {
-						// we can't match since our ask is higher than the best bid
-						return matched, nil
-					} else {
-						// our ask is lower or equal to their bid - match!
-						price = oppositeOrder.Price // set price to their bid
-						fPrice = oppositeTracker.Price
-					}
-				default:
-					panicOnOrderType(oppositeOrder)
+		} else { // we're selling
+			switch oppositeOrder.Type {
+			case TypeMarket: // we have a limit, they are buying at our specified price
+				return myPrice, orderPrice, true, false
+			case TypeLimit:
+				// check if we can cross the spread
+				if orderPrice > oppositeTracker.Price {
+					// we can't match since our ask is higher than the best bid
+					return price, fixedPrice, false, true
 				}
+				// our ask is lower or equal to their bid - match!
+				return oppositeOrder.Price, oppositeTracker.Price, true, false // set price to their bid
+			default:
+				panicOnOrderType(oppositeOrder)
 			}
-		default:
-			panicOnOrderType(*order)
 		}
-		if buying {
-			seller = oppositeOrder.CustomerID
-			askOrderID = oppositeOrder.ID
-		} else {
-			buyer = oppositeOrder.CustomerID
-			bidOrderID = oppositeOrder.ID
+	default:
+		panicOnOrderType(*order)
+	}
+	return price, fixedPrice, false, true
+}
+
+// match an order against a price level of resting limit orders using the order book allocator.
+// The iterator has to point to the first order of the level, it's moved to the last order of the level.
+func (o *OrderBook) matchLevel(order *Order, iter *orderIterator, price apd.Decimal, fixedPrice int64, removeOrders *[]uint64) (bool, error) {
+	priceLevel := iter.Level()
+	defer func() {
+		// move after matching, same as matchOrder - orders which were added to the level in the meantime are skipped
+		for next := *iter; ; *iter = next {
+			next.Next()
+			if !next.Valid() || next.Level() != priceLevel {
+				return
+			}
 		}
+	}()
 
-		order.FilledQty += qty
-		oppositeOrder.FilledQty += qty
+	level := make([]Order, 0, priceLevel.Count)
+	for levelIter := *iter; levelIter.Valid() && levelIter.Level() == priceLevel; levelIter.Next() {
+		oppositeOrder, ok := o.getActiveOrder(levelIter.Key().OrderID)
+		if !ok {
+			panic("should NEVER happen - tracker exists but active order does not")
+		}
+		if oppositeOrder.IsCancelled() {
+			*removeOrders = append(*removeOrders, oppositeOrder.ID)
+			continue
+		}
+		if oppositeOrder.IsFilled() {
+			continue
+		}
+		level = append(level, oppositeOrder)
+	}
+
+	allocations := o.allocate(order.UnfilledQty(), level)
 
+	var matched bool
+	for i, allocation := range allocations {
+		if allocation == 0 {
+			continue
+		}
+		// reload the opposite order because previous trades could have activated stop orders which matched it
+		oppositeOrder, ok := o.getActiveOrder(level[i].ID)
+		if !ok || oppositeOrder.IsCancelled() || oppositeOrder.IsFilled() {
+			continue
+		}
+		qty := min(allocation, min(order.UnfilledQty(), oppositeOrder.UnfilledQty()))
+		if qty == 0 {
+			continue
+		}
 		matched = true
-		if oppositeOrder.UnfilledQty() == 0 { // if the other order is filled completely - remove it from the order book
-			removeOrders = append(removeOrders, oppositeOrder.ID)
-		} else {
-			if err := o.updateActiveOrder(oppositeOrder); err != nil { // otherwise update it
-				return matched, err
+		if err := o.executeTrade(order, oppositeOrder, qty, price, fixedPrice, removeOrders); err != nil {
+			return matched, err
+		}
+	}
+	return matched, nil
+}
+
+// allocate a quantity between resting orders at a price level.
+// Resting AON orders are excluded from the allocation and the quantity is reallocated until they can be filled completely.
+func (o *OrderBook) allocate(qty int64, level []Order) []int64 {
+	excluded := make([]bool, len(level))
+	for {
+		resting := make([]int64, len(level))
+		for i, oppositeOrder := range level {
+			if !excluded[i] {
+				resting[i] = oppositeOrder.UnfilledQty()
 			}
 		}
-		o.tradeBook.Enter(Trade{
-			Buyer:      buyer,
-			Seller:     seller,
-			Instrument: o.Instrument,
-			Qty:        qty,
-			Price:      price,
-			Timestamp:  time.Now(),
-			BidOrderID: bidOrderID,
-			AskOrderID: askOrderID,
-		})
-		o.SetMarketPrice(price, fPrice)
-		if order.IsFilled() {
-			return true, nil
+		allocations := o.allocator.Allocate(qty, resting)
+
+		reallocate := false
+		for i, oppositeOrder := range level {
+			if allocations[i] != 0 && allocations[i] != oppositeOrder.UnfilledQty() && oppositeOrder.Params.Is(ParamAON) {
+				excluded[i] = true
+				reallocate = true
+			}
+		}
+		if !reallocate {
+			return allocations
 		}
 	}
-	return matched, nil
+}
+
+// execute a trade between an order and a resting opposite order
+func (o *OrderBook) executeTrade(order *Order, oppositeOrder Order, qty int64, price apd.Decimal, fixedPrice int64, removeOrders *[]uint64) error {
+	var buyer, seller uuid.UUID
+	var bidOrderID, askOrderID uint64
+	if order.IsBid() {
+		buyer, bidOrderID = order.CustomerID, order.ID
+		seller, askOrderID = oppositeOrder.CustomerID, oppositeOrder.ID
+	} else {
+		seller, askOrderID = order.CustomerID, order.ID
+		buyer, bidOrderID = oppositeOrder.CustomerID, oppositeOrder.ID
+	}
+
+	order.FilledQty += qty
+	oppositeOrder.FilledQty += qty
+
+	if oppositeOrder.UnfilledQty() == 0 { // if the other order is filled completely - remove it from the order book
+		*removeOrders = append(*removeOrders, oppositeOrder.ID)
+	}
+	if err := o.updateActiveOrder(oppositeOrder); err != nil { // update it in any case so it can't be matched again
+		return err
+	}
+	trade := Trade{
+		Buyer:         buyer,
+		Seller:        seller,
+		Instrument:    o.Instrument,
+		Qty:           qty,
+		Price:         price,
+		Timestamp:     o.now,
+		BidOrderID:    bidOrderID,
+		AskOrderID:    askOrderID,
+		AggressorSide: order.Side,
+	}
+	tradeTotal(&trade.Total, trade)
+	if o.fees != nil {
+		trade.BuyerFee = roundHalfUp(o.fees.Fee(trade, buyer, trade.BuyerLiquidity()), o.priceScale)
+		trade.SellerFee = roundHalfUp(o.fees.Fee(trade, seller, trade.SellerLiquidity()), o.priceScale)
+	}
+	trade = o.tradeBook.Enter(trade)
+	if o.journal != nil {
+		payload, _ := trade.MarshalBinary()
+		if err := o.journalRecord(RecordTrade, payload); err != nil {
+			return err
+		}
+	}
+	if err := o.recordFill(*order, EventFilled, ActorEngine, trade.ID, qty); err != nil {
+		return err
+	}
+	if err := o.recordFill(oppositeOrder, EventFilled, ActorEngine, trade.ID, qty); err != nil {
+		return err
+	}
+	if oppositeTracker, ok := o.getOrderTracker(oppositeOrder.ID); ok {
+		o.publishOrder(UpdateOrderExecute, oppositeOrder, oppositeTracker.Price, trade.ID, qty)
+	}
+	o.setMarketPrice(price, fixedPrice)
+	return nil
 }
 
 func panicOnOrderType(order Order) {
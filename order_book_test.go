@@ -105,6 +105,44 @@ func TestOrderBook_LimitToMarket(t *testing.T) {
 	}
 }
 
+func TestOrderBook_TradeTotalAndAggressor(t *testing.T) {
+	tb, ob := setup(2025, -2)
+
+	if _, err := ob.Add(createOrder(1, TypeLimit, 0, 3, *apd.New(2013, -2), apd.Decimal{}, SideBuy)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ob.Add(createOrder(2, TypeLimit, 0, 7, *apd.New(2013, -2), apd.Decimal{}, SideSell)); err != nil {
+		t.Fatal(err)
+	}
+	trade := tb.DailyTrades()[0]
+	if trade.Total.Cmp(apd.New(6039, -2)) != 0 {
+		t.Errorf("expected total 60.39, got %s", trade.Total.String())
+	}
+	if trade.AggressorSide != SideSell || trade.SellerLiquidity() != LiquidityTaker || trade.BuyerLiquidity() != LiquidityMaker {
+		t.Errorf("expected the seller to be the aggressor, got %+v", trade)
+	}
+}
+
+func TestOrderBook_GTDExpireTime(t *testing.T) {
+	_, ob := setup(2025, -2)
+
+	order := createOrder(1, TypeLimit, ParamGTD, 5, *apd.New(2012, -2), apd.Decimal{}, SideBuy)
+	if _, err := ob.Add(order); err != ErrInvalidExpireTime {
+		t.Errorf("expected %v, got %v", ErrInvalidExpireTime, err)
+	}
+	order.ExpireTime = time.Now().Add(-time.Second)
+	if _, err := ob.Add(order); err != ErrInvalidExpireTime {
+		t.Errorf("expected an expired order to be rejected with %v, got %v", ErrInvalidExpireTime, err)
+	}
+	order.ExpireTime = time.Now().Add(time.Hour)
+	if _, err := ob.Add(order); err != nil {
+		t.Fatal(err)
+	}
+	if active, ok := ob.GetOrder(1); !ok || !active.ExpireTime.Equal(order.ExpireTime) {
+		t.Errorf("expected an active GTD order, got %+v", active)
+	}
+}
+
 func TestOrderBook_Limit_To_Limit_No_Match(t *testing.T) {
 	tb, ob := setup(2025, -2)
 
@@ -679,3 +717,292 @@ func createRandomOrder(i int) Order {
 	}
 	return order
 }
+
+func TestOrderBook_ProRata(t *testing.T) {
+	tb := NewTradeBook(instrument)
+	ob := NewOrderBook(instrument, *apd.New(2025, -2), tb, NOPOrderRepository, WithAllocator(ProRataAllocator{}))
+
+	resting := []Order{
+		createOrder(1, TypeLimit, 0, 10, *apd.New(2010, -2), apd.Decimal{}, SideSell),
+		createOrder(2, TypeLimit, 0, 30, *apd.New(2010, -2), apd.Decimal{}, SideSell),
+		createOrder(3, TypeLimit, ParamAON, 20, *apd.New(2010, -2), apd.Decimal{}, SideSell),
+		createOrder(4, TypeLimit, 0, 10, *apd.New(2011, -2), apd.Decimal{}, SideSell),
+	}
+	for _, order := range resting {
+		if _, err := ob.Add(order); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	matched, err := ob.Add(createOrder(5, TypeLimit, 0, 20, *apd.New(2010, -2), apd.Decimal{}, SideBuy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !matched {
+		t.Fatal("expected a match, got none")
+	}
+
+	// AON order 3 can't be filled completely - 20 lots are allocated between orders 1 and 2 (1:3)
+	expected := map[uint64]int64{1: 5, 2: 15}
+	trades := tb.DailyTrades()
+	if len(trades) != len(expected) {
+		t.Fatalf("expected %d trades, got %d", len(expected), len(trades))
+	}
+	for _, trade := range trades {
+		if trade.Qty != expected[trade.AskOrderID] {
+			t.Errorf("expected qty %d for order %d, got %d", expected[trade.AskOrderID], trade.AskOrderID, trade.Qty)
+		}
+	}
+	if ob.orders.Asks.Len() != 4 {
+		t.Errorf("expected 4 asks, got %d", ob.orders.Asks.Len())
+	}
+	if ob.orders.Bids.Len() != 0 {
+		t.Errorf("expected 0 bids, got %d", ob.orders.Bids.Len())
+	}
+}
+
+func TestOrderBook_Add_InvalidPricePrecision(t *testing.T) {
+	_, ob := setup(2025, -2)
+
+	_, err := ob.Add(createOrder(1, TypeLimit, 0, 5, *apd.New(201234, -5), apd.Decimal{}, SideBuy))
+	if err != ErrInvalidPricePrecision {
+		t.Errorf("expected %v, got %v", ErrInvalidPricePrecision, err)
+	}
+	if len(ob.activeOrders) != 0 {
+		t.Errorf("expected no active orders, got %d", len(ob.activeOrders))
+	}
+}
+
+func TestOrderBook_StopOrder_Activation(t *testing.T) {
+	tb, ob := setup(2025, -2)
+
+	matched, err := ob.Add(createOrder(1, TypeLimit, ParamStop, 5, *apd.New(2100, -2), *apd.New(2050, -2), SideBuy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matched {
+		t.Errorf("expected no match for a stop order, got a match")
+	}
+	if ob.stopOrders.Len(SideBuy) != 1 {
+		t.Fatalf("expected 1 stop bid, got %d", ob.stopOrders.Len(SideBuy))
+	}
+
+	if err := ob.SetMarketPrice(*apd.New(2060, -2)); err != nil {
+		t.Fatal(err)
+	}
+	if ob.stopOrders.Len(SideBuy) != 0 {
+		t.Errorf("expected no stop bids, got %d", ob.stopOrders.Len(SideBuy))
+	}
+	if ob.orders.Len(SideBuy) != 1 {
+		t.Fatalf("expected the activated stop order in the books, got %d bids", ob.orders.Len(SideBuy))
+	}
+	tracker, _ := ob.orders.Get(1)
+	if tracker.Price != 210000 {
+		t.Errorf("expected the activated order to be sorted by its limit price, got %d", tracker.Price)
+	}
+
+	matched, err = ob.Add(createOrder(2, TypeLimit, 0, 5, *apd.New(2090, -2), apd.Decimal{}, SideSell))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !matched {
+		t.Errorf("expected the activated stop order to be matched")
+	}
+	if len(tb.trades) != 1 {
+		t.Errorf("expected a trade, got %d", len(tb.trades))
+	}
+	if len(ob.activeOrders) != 0 {
+		t.Errorf("expected no active orders, got %d", len(ob.activeOrders))
+	}
+}
+
+func TestOrderBook_Amend(t *testing.T) {
+	tb, ob := setup(2025, -2)
+
+	for i, price := range []int64{2010, 2010, 2011} {
+		if _, err := ob.Add(createOrder(uint64(i+1), TypeLimit, 0, 5, *apd.New(price, -2), apd.Decimal{}, SideSell)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// decreasing quantity keeps time priority
+	if _, err := ob.Amend(1, 3, *apd.New(2010, -2)); err != nil {
+		t.Fatal(err)
+	}
+	// increasing quantity loses time priority
+	if _, err := ob.Amend(2, 6, *apd.New(2010, -2)); err != nil {
+		t.Fatal(err)
+	}
+	// changing price moves the order to a new level
+	if _, err := ob.Amend(3, 5, *apd.New(2009, -2)); err != nil {
+		t.Fatal(err)
+	}
+
+	sorted := []uint64{3, 1, 2}
+	asks := ob.GetAsks()
+	if len(asks) != len(sorted) {
+		t.Fatalf("expected %d asks, got %d", len(sorted), len(asks))
+	}
+	for i, ask := range asks {
+		if ask.ID != sorted[i] {
+			t.Errorf("expected order %d in place %d, got %d", sorted[i], i, ask.ID)
+		}
+	}
+	level, _ := ob.orders.Asks.Best()
+	if level.Qty != 5 {
+		t.Errorf("expected best level quantity 5, got %d", level.Qty)
+	}
+
+	if _, err := ob.Amend(1, 1, *apd.New(2010, -2)); err != ErrInvalidQty {
+		t.Errorf("expected %v, got %v", ErrInvalidQty, err)
+	}
+	if _, err := ob.Amend(42, 5, *apd.New(2010, -2)); err != ErrOrderNotFound {
+		t.Errorf("expected %v, got %v", ErrOrderNotFound, err)
+	}
+
+	// amending a bid to cross the spread results in a match
+	if _, err := ob.Add(createOrder(4, TypeLimit, 0, 2, *apd.New(2000, -2), apd.Decimal{}, SideBuy)); err != nil {
+		t.Fatal(err)
+	}
+	matched, err := ob.Amend(4, 2, *apd.New(2009, -2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !matched || len(tb.trades) != 1 {
+		t.Errorf("expected the amended order to be matched")
+	}
+}
+
+func TestOrderBook_CancelCustomerOrders(t *testing.T) {
+	_, ob := setup(2025, -2)
+	customer, other := uuid.New(), uuid.New()
+
+	orders := []Order{
+		createOrder(1, TypeLimit, 0, 5, *apd.New(2010, -2), apd.Decimal{}, SideSell),
+		createOrder(2, TypeLimit, 0, 5, *apd.New(2010, -2), apd.Decimal{}, SideSell),
+		createOrder(3, TypeLimit, ParamStop, 5, *apd.New(2100, -2), *apd.New(2050, -2), SideBuy),
+		createOrder(4, TypeLimit, 0, 5, *apd.New(2000, -2), apd.Decimal{}, SideBuy),
+	}
+	for i, order := range orders {
+		order.CustomerID = customer
+		if i == 1 {
+			order.CustomerID = other
+		}
+		if _, err := ob.Add(order); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cancelled, err := ob.CancelCustomerOrders(customer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []uint64{1, 3, 4}
+	if len(cancelled) != len(expected) {
+		t.Fatalf("expected %d cancelled orders, got %d", len(expected), len(cancelled))
+	}
+	for i, order := range cancelled {
+		if order.ID != expected[i] || order.Status() != StatusCancelled {
+			t.Errorf("expected cancelled order %d in place %d, got %d with status %s", expected[i], i, order.ID, order.Status())
+		}
+		if _, ok := ob.GetOrder(order.ID); ok {
+			t.Errorf("expected order %d not to be active", order.ID)
+		}
+	}
+	if ob.stopOrders.Len(SideBuy) != 0 || ob.orders.Len(SideBuy) != 0 || ob.orders.Len(SideSell) != 1 {
+		t.Errorf("expected only the ask of the other customer in the books")
+	}
+	if _, ok := ob.GetOrder(2); !ok {
+		t.Errorf("expected the order of the other customer to stay active")
+	}
+
+	if cancelled, err := ob.CancelCustomerOrders(customer); err != nil || len(cancelled) != 0 {
+		t.Errorf("expected no orders to cancel, got %d (%v)", len(cancelled), err)
+	}
+}
+
+func TestOrderBook_CancelOrders(t *testing.T) {
+	_, ob := setup(2025, -2)
+	orders := []Order{
+		createOrder(1, TypeLimit, 0, 5, *apd.New(2010, -2), apd.Decimal{}, SideSell),
+		createOrder(2, TypeLimit, 0, 5, *apd.New(2010, -2), apd.Decimal{}, SideSell),
+		createOrder(3, TypeLimit, ParamStop, 5, *apd.New(2100, -2), *apd.New(2050, -2), SideBuy),
+		createOrder(4, TypeLimit, 0, 5, *apd.New(2000, -2), apd.Decimal{}, SideBuy),
+	}
+	for _, order := range orders {
+		if _, err := ob.Add(order); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cancelled, err := ob.CancelOrders([]uint64{4, 3, 1, 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []uint64{1, 3, 4}
+	if len(cancelled) != len(expected) {
+		t.Fatalf("expected %d cancelled orders, got %d", len(expected), len(cancelled))
+	}
+	for i, order := range cancelled {
+		if order.ID != expected[i] || order.Status() != StatusCancelled {
+			t.Errorf("expected cancelled order %d in place %d, got %d with status %s", expected[i], i, order.ID, order.Status())
+		}
+	}
+	if ob.stopOrders.Len(SideBuy) != 0 || ob.orders.Len(SideBuy) != 0 || ob.orders.Len(SideSell) != 1 {
+		t.Errorf("expected only order 2 in the books")
+	}
+	if _, ok := ob.GetOrder(2); !ok {
+		t.Errorf("expected order 2 to stay active")
+	}
+}
+
+func TestOrderBook_ExpireOrders(t *testing.T) {
+	clock := &SimulatedClock{}
+	start := time.Unix(1600000000, 0)
+	clock.Set(start)
+	history := NewInMemoryOrderHistory()
+	ob := NewOrderBook(instrument, *apd.New(2025, -2), NewTradeBook(instrument), NOPOrderRepository,
+		WithClock(clock.Now), WithOrderHistory(history))
+
+	orders := []Order{
+		createOrder(1, TypeLimit, ParamGTD, 5, *apd.New(2010, -2), apd.Decimal{}, SideSell),
+		createOrder(2, TypeLimit, ParamGTD, 5, *apd.New(2011, -2), apd.Decimal{}, SideSell),
+		createOrder(3, TypeLimit, ParamGTD|ParamStop, 5, *apd.New(2100, -2), *apd.New(2050, -2), SideBuy),
+		createOrder(4, TypeLimit, ParamGTC, 5, *apd.New(2000, -2), apd.Decimal{}, SideBuy),
+	}
+	orders[0].ExpireTime = start.Add(time.Minute)
+	orders[1].ExpireTime = start.Add(time.Hour)
+	orders[2].ExpireTime = start.Add(time.Minute)
+	for _, order := range orders {
+		if _, err := ob.Add(order); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	clock.Set(start.Add(time.Minute - 1))
+	if expired, err := ob.ExpireOrders(); err != nil || len(expired) != 0 {
+		t.Fatalf("expected no expired orders before the expire time, got %d (%v)", len(expired), err)
+	}
+	clock.Set(start.Add(time.Minute))
+	expired, err := ob.ExpireOrders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 2 || expired[0].ID != 1 || expired[1].ID != 3 || !expired[0].IsCancelled() {
+		t.Fatalf("expected cancelled orders 1 and 3 to expire, got %+v", expired)
+	}
+	if ob.stopOrders.Len(SideBuy) != 0 || ob.orders.Len(SideSell) != 1 || ob.orders.Len(SideBuy) != 1 {
+		t.Errorf("expected only orders 2 and 4 in the books")
+	}
+	if events, _ := history.History(1); len(events) != 2 || events[1].Type != EventExpired || events[1].Actor != ActorEngine {
+		t.Errorf("expected an expiry by the engine, got %+v", events)
+	}
+
+	clock.Set(start.Add(2 * time.Hour))
+	if expired, err := ob.ExpireOrders(); err != nil || len(expired) != 1 || expired[0].ID != 2 {
+		t.Errorf("expected order 2 to expire, got %+v (%v)", expired, err)
+	}
+	if _, ok := ob.GetOrder(4); !ok {
+		t.Errorf("expected the GTC order to stay active")
+	}
+}
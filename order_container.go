@@ -8,75 +8,140 @@ import (
 // Container object that stores bids an asks for a specified instrument.
 // It doesn't store Orders, but OrderTrackers (for faster operation)
 // Handles fast and efficient insertion, removal, retrieval, sorting and filtering of orders.
+//
+// Orders are grouped into price levels sorted with LessFuncs. Each price level is a FIFO queue of orders with cached
+// total quantity and order count, so best price and depth queries visit price levels instead of all orders.
 type orderContainer struct {
-	Bids, Asks *orderMap
-	trackers   map[uint64]OrderTracker
+	Bids, Asks *orderSide
+	entries    map[uint64]*levelEntry
+}
+
+// One side of the order container, contains price levels sorted from the best to the worst price.
+type orderSide struct {
+	levels *levelMap
+	count  int // number of orders on the side
+}
+
+// Price level contains all orders with the same price in time priority.
+// Market orders don't have a price, they are stored in a separate level which is always matched first.
+type priceLevel struct {
+	Type  OrderType
+	Price int64 // fixed-point price
+	Qty   int64 // total unfilled quantity of orders at the level
+	Count int   // number of orders at the level
+
+	DisplayQty   int64 // total displayed quantity of orders at the level
+	DisplayCount int   // number of orders with a displayed quantity at the level
+
+	key        OrderTracker // key of the level in the levelMap
+	head, tail *levelEntry
+}
+
+// Order entry in a price level queue.
+type levelEntry struct {
+	tracker    OrderTracker
+	qty        int64 // unfilled quantity
+	peak       int64 // displayed quantity peak, 0 displays the whole quantity, negative peaks are hidden
+	level      *priceLevel
+	prev, next *levelEntry
+	removed    bool // removed entries keep their next pointer so iterators can continue past them
 }
 
 // Create a new order container with specified LessFuncs.
+// LessFuncs are used to sort price levels, orders inside a price level are always sorted by time.
 func NewOrderContainer(bidLess, askLess LessFunc) *orderContainer {
 	return &orderContainer{
-		Bids:     newOrderMap(bidLess),
-		Asks:     newOrderMap(askLess),
-		trackers: make(map[uint64]OrderTracker),
+		Bids:    newOrderSide(bidLess),
+		Asks:    newOrderSide(askLess),
+		entries: make(map[uint64]*levelEntry),
 	}
 }
 
-// Add an order tracker.
-func (o *orderContainer) Add(tracker OrderTracker) {
-	if tracker.Side == SideBuy {
-		o.Bids.Set(tracker, true)
-	} else {
-		o.Asks.Set(tracker, true)
-	}
-	o.trackers[tracker.OrderID] = tracker
+func newOrderSide(less LessFunc) *orderSide {
+	return &orderSide{levels: newLevelMap(less)}
+}
+
+// Add an order tracker with its unfilled quantity.
+func (o *orderContainer) Add(tracker OrderTracker, qty int64) {
+	entry := &levelEntry{tracker: tracker, qty: qty}
+	o.side(tracker.Side).add(entry)
+	o.entries[tracker.OrderID] = entry
 }
 
 // Remove an order tracker.
 func (o *orderContainer) Remove(id uint64) {
-	tracker, ok := o.trackers[id]
+	entry, ok := o.entries[id]
 	if !ok {
 		log.Printf("cannot remove order: no tracker for id %d", id)
 		return
 	}
-	delete(o.trackers, id)
-	if tracker.Side == SideBuy {
-		o.Bids.Del(tracker)
-	} else {
-		o.Asks.Del(tracker)
+	delete(o.entries, id)
+	o.side(entry.tracker.Side).remove(entry)
+}
+
+// Update the unfilled quantity of an order. Does nothing if an order isn't in the container.
+func (o *orderContainer) Update(id uint64, qty int64) {
+	entry, ok := o.entries[id]
+	if !ok {
+		return
+	}
+	entry.level.undisplay(entry)
+	entry.level.Qty += qty - entry.qty
+	entry.qty = qty
+	entry.level.display(entry)
+}
+
+// Set the displayed quantity peak of an order (see displayPeak). Does nothing if an order isn't in the container.
+func (o *orderContainer) SetPeak(id uint64, peak int64) {
+	entry, ok := o.entries[id]
+	if !ok {
+		return
 	}
+	entry.level.undisplay(entry)
+	entry.peak = peak
+	entry.level.display(entry)
 }
 
 // Get a tracker by its order ID.
 func (o *orderContainer) Get(id uint64) (OrderTracker, bool) {
-	t, ok := o.trackers[id]
-	return t, ok
+	entry, ok := o.entries[id]
+	if !ok {
+		return OrderTracker{}, false
+	}
+	return entry.tracker, true
 }
 
 // Get an OrderTracker iterator which iterates through sorted bids or asks.
-func (o *orderContainer) Iterator(side OrderSide) forwardIteratorOrderMap {
-	if side == SideBuy {
-		return o.Bids.Iterator()
-	}
-	return o.Asks.Iterator()
+func (o *orderContainer) Iterator(side OrderSide) orderIterator {
+	return o.side(side).Iterator()
 }
 
 // Returns the number of bids or asks in the container.
 func (o *orderContainer) Len(side OrderSide) int {
+	return o.side(side).Len()
+}
+
+// Get a price level iterator which iterates through bid or ask price levels from the best to the worst price.
+func (o *orderContainer) Levels(side OrderSide) forwardIteratorLevelMap {
+	return o.side(side).levels.Iterator()
+}
+
+func (o *orderContainer) side(side OrderSide) *orderSide {
 	if side == SideBuy {
-		return o.Bids.Len()
+		return o.Bids
 	}
-	return o.Asks.Len()
+	return o.Asks
 }
 
 // Get ask trackers below or equal the price. Sorted by time ascending.
-func (o *orderContainer) GetAsksAbove(price float64) []OrderTracker {
+func (o *orderContainer) GetAsksAbove(price int64) []OrderTracker {
 	trackers := make([]OrderTracker, 0)
-	for iter := o.Asks.Iterator(); iter.Valid(); iter.Next() {
-		if iter.Key().Price >= price {
-			trackers = append(trackers, iter.Key())
+	for iter := o.Asks.levels.Iterator(); iter.Valid(); iter.Next() {
+		level := iter.Value()
+		if level.Price >= price {
+			trackers = level.appendTrackers(trackers)
 		} else {
-			break // iterator returns a sorted array, if price is bigger we don't have to look any further
+			break // iterator returns sorted levels, if price is bigger we don't have to look any further
 		}
 	}
 	sort.Slice(trackers, func(i, j int) bool {
@@ -86,13 +151,14 @@ func (o *orderContainer) GetAsksAbove(price float64) []OrderTracker {
 }
 
 // Get bid trackers above or equal the price. Sorted by time ascending.
-func (o *orderContainer) GetBidsBelow(price float64) []OrderTracker {
+func (o *orderContainer) GetBidsBelow(price int64) []OrderTracker {
 	trackers := make([]OrderTracker, 0)
-	for iter := o.Bids.Iterator(); iter.Valid(); iter.Next() {
-		if iter.Key().Price <= price {
-			trackers = append(trackers, iter.Key())
+	for iter := o.Bids.levels.Iterator(); iter.Valid(); iter.Next() {
+		level := iter.Value()
+		if level.Price <= price {
+			trackers = level.appendTrackers(trackers)
 		} else {
-			break // iterator returns a sorted array, if price is bigger we don't have to look any further
+			break // iterator returns sorted levels, if price is bigger we don't have to look any further
 		}
 	}
 	sort.Slice(trackers, func(i, j int) bool {
@@ -100,3 +166,164 @@ func (o *orderContainer) GetBidsBelow(price float64) []OrderTracker {
 	})
 	return trackers
 }
+
+// Returns the number of orders on the side.
+func (s *orderSide) Len() int {
+	return s.count
+}
+
+// Get an OrderTracker iterator which iterates through orders from the best to the worst price level.
+func (s *orderSide) Iterator() orderIterator {
+	iter := orderIterator{side: s}
+	if levels := s.levels.Iterator(); levels.Valid() {
+		iter.entry = levels.Value().head
+	}
+	return iter
+}
+
+// Returns the best price level. Returns false if the side is empty.
+func (s *orderSide) Best() (*priceLevel, bool) {
+	levels := s.levels.Iterator()
+	if !levels.Valid() {
+		return nil, false
+	}
+	return levels.Value(), true
+}
+
+func (s *orderSide) add(entry *levelEntry) {
+	key := levelKey(entry.tracker)
+	level, ok := s.levels.Get(key)
+	if !ok {
+		level = &priceLevel{Type: key.Type, Price: key.Price, key: key}
+		s.levels.Set(key, level)
+	}
+	level.push(entry)
+	s.count += 1
+}
+
+func (s *orderSide) remove(entry *levelEntry) {
+	level := entry.level
+	level.unlink(entry)
+	if level.Count == 0 {
+		s.levels.Del(level.key)
+	}
+	s.count -= 1
+}
+
+// orders with the same type and price share a price level, level ordering is determined by the LessFunc
+func levelKey(tracker OrderTracker) OrderTracker {
+	return OrderTracker{Type: tracker.Type, Price: tracker.Price}
+}
+
+// Insert an entry to the level. Entries are usually appended, but older orders (e.g. activated stop orders) keep their
+// time priority.
+func (l *priceLevel) push(entry *levelEntry) {
+	entry.level = l
+	after := l.tail
+	for after != nil && after.tracker.Timestamp > entry.tracker.Timestamp {
+		after = after.prev
+	}
+	if after == nil {
+		entry.next = l.head
+		if l.head != nil {
+			l.head.prev = entry
+		}
+		l.head = entry
+	} else {
+		entry.prev = after
+		entry.next = after.next
+		if after.next != nil {
+			after.next.prev = entry
+		}
+		after.next = entry
+	}
+	if entry.next == nil {
+		l.tail = entry
+	}
+	l.Qty += entry.qty
+	l.Count += 1
+	l.display(entry)
+}
+
+// Remove an entry from the level. The entry keeps its next pointer.
+func (l *priceLevel) unlink(entry *levelEntry) {
+	if entry.prev != nil {
+		entry.prev.next = entry.next
+	} else {
+		l.head = entry.next
+	}
+	if entry.next != nil {
+		entry.next.prev = entry.prev
+	} else {
+		l.tail = entry.prev
+	}
+	entry.prev = nil
+	entry.removed = true
+	l.Qty -= entry.qty
+	l.Count -= 1
+	l.undisplay(entry)
+}
+
+// add the displayed quantity of an entry to the level
+func (l *priceLevel) display(entry *levelEntry) {
+	if qty := displayedQty(entry.qty, entry.peak); qty > 0 {
+		l.DisplayQty += qty
+		l.DisplayCount += 1
+	}
+}
+
+// remove the displayed quantity of an entry from the level
+func (l *priceLevel) undisplay(entry *levelEntry) {
+	if qty := displayedQty(entry.qty, entry.peak); qty > 0 {
+		l.DisplayQty -= qty
+		l.DisplayCount -= 1
+	}
+}
+
+// Append all level trackers in time priority.
+func (l *priceLevel) appendTrackers(trackers []OrderTracker) []OrderTracker {
+	for entry := l.head; entry != nil; entry = entry.next {
+		trackers = append(trackers, entry.tracker)
+	}
+	return trackers
+}
+
+// Iterates through orders of a container side. Orders can be added and removed while iterating.
+type orderIterator struct {
+	side  *orderSide
+	entry *levelEntry
+}
+
+// Returns true if the iterator points to an order.
+func (i orderIterator) Valid() bool {
+	return i.entry != nil
+}
+
+// Returns the current OrderTracker.
+func (i orderIterator) Key() OrderTracker {
+	return i.entry.tracker
+}
+
+// Returns the price level of the current order.
+func (i orderIterator) Level() *priceLevel {
+	return i.entry.level
+}
+
+// Move to the next order, continue to the next price level after the last order of the level.
+func (i *orderIterator) Next() {
+	level := i.entry.level
+	next := i.entry.next
+	for next != nil && next.removed {
+		next = next.next
+	}
+	if next != nil {
+		i.entry = next
+		return
+	}
+	// levels in the tree are never empty
+	if levels := i.side.levels.UpperBound(level.key); levels.Valid() {
+		i.entry = levels.Value().head
+		return
+	}
+	i.entry = nil
+}
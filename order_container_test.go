@@ -9,21 +9,21 @@ func TestOrderContainer_Add(t *testing.T) {
 	c := NewOrderContainer(makeComparator(true), makeComparator(false))
 
 	orders := [...]OrderTracker{
-		{OrderID: 1, Price: 20.25, Timestamp: time.Now().UnixNano(), Side: SideBuy},
-		{OrderID: 2, Price: 20.25, Timestamp: time.Now().UnixNano(), Side: SideSell},
-		{OrderID: 3, Price: 20.50, Timestamp: time.Now().UnixNano(), Side: SideBuy},
-		{OrderID: 4, Price: 20.45, Timestamp: time.Now().UnixNano(), Side: SideSell},
-		{OrderID: 5, Price: 20.10, Timestamp: time.Now().UnixNano(), Side: SideBuy},
-		{OrderID: 6, Price: 20.18, Timestamp: time.Now().UnixNano(), Side: SideSell},
-		{OrderID: 7, Price: 20.25, Timestamp: time.Now().UnixNano(), Side: SideBuy},
-		{OrderID: 8, Price: 20.45, Timestamp: time.Now().UnixNano(), Side: SideSell},
+		{OrderID: 1, Price: 202500, Timestamp: time.Now().UnixNano(), Side: SideBuy},
+		{OrderID: 2, Price: 202500, Timestamp: time.Now().UnixNano(), Side: SideSell},
+		{OrderID: 3, Price: 205000, Timestamp: time.Now().UnixNano(), Side: SideBuy},
+		{OrderID: 4, Price: 204500, Timestamp: time.Now().UnixNano(), Side: SideSell},
+		{OrderID: 5, Price: 201000, Timestamp: time.Now().UnixNano(), Side: SideBuy},
+		{OrderID: 6, Price: 201800, Timestamp: time.Now().UnixNano(), Side: SideSell},
+		{OrderID: 7, Price: 202500, Timestamp: time.Now().UnixNano(), Side: SideBuy},
+		{OrderID: 8, Price: 204500, Timestamp: time.Now().UnixNano(), Side: SideSell},
 	}
 
 	sortedBids := [...]int{2, 0, 6, 4}
 	sortedAsks := [...]int{5, 1, 3, 7}
 
 	for _, o := range orders {
-		c.Add(o)
+		c.Add(o, 1)
 	}
 
 	i := 0
@@ -51,33 +51,32 @@ func TestOrderContainer_Add(t *testing.T) {
 	}
 }
 
-// todo: fix GetBidsBelow and GetAsksAbove tests, they were inverted
-func TestOrderContainer_GetBidsAbove(t *testing.T) {
-	c := NewOrderContainer(makeComparator(true), makeComparator(false)) // simulate stop order container
+func TestOrderContainer_GetBidsBelow(t *testing.T) {
+	c := NewOrderContainer(makeStopComparator(false), makeStopComparator(true)) // simulate stop order container
 
 	orders := [...]OrderTracker{
-		{OrderID: 1, Price: 20.25, Timestamp: time.Now().UnixNano(), Side: SideBuy},
-		{OrderID: 2, Price: 20.25, Timestamp: time.Now().UnixNano(), Side: SideSell},
-		{OrderID: 3, Price: 20.50, Timestamp: time.Now().UnixNano(), Side: SideBuy},
-		{OrderID: 4, Price: 20.45, Timestamp: time.Now().UnixNano(), Side: SideSell},
-		{OrderID: 5, Price: 20.10, Timestamp: time.Now().UnixNano(), Side: SideBuy},
-		{OrderID: 6, Price: 20.18, Timestamp: time.Now().UnixNano(), Side: SideSell},
-		{OrderID: 7, Price: 20.25, Timestamp: time.Now().UnixNano(), Side: SideBuy},
-		{OrderID: 8, Price: 20.45, Timestamp: time.Now().UnixNano(), Side: SideSell},
+		{OrderID: 1, Price: 202500, Timestamp: time.Now().UnixNano(), Side: SideBuy},
+		{OrderID: 2, Price: 202500, Timestamp: time.Now().UnixNano(), Side: SideSell},
+		{OrderID: 3, Price: 205000, Timestamp: time.Now().UnixNano(), Side: SideBuy},
+		{OrderID: 4, Price: 204500, Timestamp: time.Now().UnixNano(), Side: SideSell},
+		{OrderID: 5, Price: 201000, Timestamp: time.Now().UnixNano(), Side: SideBuy},
+		{OrderID: 6, Price: 201800, Timestamp: time.Now().UnixNano(), Side: SideSell},
+		{OrderID: 7, Price: 202500, Timestamp: time.Now().UnixNano(), Side: SideBuy},
+		{OrderID: 8, Price: 204500, Timestamp: time.Now().UnixNano(), Side: SideSell},
 	}
-	results := [...]int{0, 2, 6}
+	results := [...]int{0, 4, 6}
 
 	for _, o := range orders {
-		c.Add(o)
+		c.Add(o, 1)
 	}
 
-	above := c.GetBidsBelow(20.25)
+	below := c.GetBidsBelow(202500)
 
-	if len(above) != len(results) {
-		t.Fatalf("expected %d results, got %d", len(results), len(above))
+	if len(below) != len(results) {
+		t.Fatalf("expected %d results, got %d", len(results), len(below))
 	}
 
-	for i, tracker := range above {
+	for i, tracker := range below {
 		expected := orders[results[i]]
 
 		if tracker.OrderID != expected.OrderID {
@@ -86,27 +85,27 @@ func TestOrderContainer_GetBidsAbove(t *testing.T) {
 	}
 }
 
-func TestOrderContainer_GetAsksBelow(t *testing.T) {
-	c := NewOrderContainer(makeComparator(true), makeComparator(false))
+func TestOrderContainer_GetAsksAbove(t *testing.T) {
+	c := NewOrderContainer(makeStopComparator(false), makeStopComparator(true)) // simulate stop order container
 
 	orders := [...]OrderTracker{
-		{OrderID: 1, Price: 20.25, Timestamp: time.Now().UnixNano(), Side: SideBuy},
-		{OrderID: 2, Price: 20.25, Timestamp: time.Now().UnixNano(), Side: SideSell},
-		{OrderID: 3, Price: 20.50, Timestamp: time.Now().UnixNano(), Side: SideBuy},
-		{OrderID: 4, Price: 20.45, Timestamp: time.Now().UnixNano(), Side: SideSell},
-		{OrderID: 5, Price: 20.10, Timestamp: time.Now().UnixNano(), Side: SideBuy},
-		{OrderID: 6, Price: 20.18, Timestamp: time.Now().UnixNano(), Side: SideSell},
-		{OrderID: 7, Price: 20.25, Timestamp: time.Now().UnixNano(), Side: SideBuy},
-		{OrderID: 8, Price: 20.45, Timestamp: time.Now().UnixNano(), Side: SideSell},
+		{OrderID: 1, Price: 202500, Timestamp: time.Now().UnixNano(), Side: SideBuy},
+		{OrderID: 2, Price: 202500, Timestamp: time.Now().UnixNano(), Side: SideSell},
+		{OrderID: 3, Price: 205000, Timestamp: time.Now().UnixNano(), Side: SideBuy},
+		{OrderID: 4, Price: 204500, Timestamp: time.Now().UnixNano(), Side: SideSell},
+		{OrderID: 5, Price: 201000, Timestamp: time.Now().UnixNano(), Side: SideBuy},
+		{OrderID: 6, Price: 201800, Timestamp: time.Now().UnixNano(), Side: SideSell},
+		{OrderID: 7, Price: 202500, Timestamp: time.Now().UnixNano(), Side: SideBuy},
+		{OrderID: 8, Price: 204500, Timestamp: time.Now().UnixNano(), Side: SideSell},
 	}
 
-	results := [...]int{1, 5}
+	results := [...]int{1, 3, 7}
 
 	for _, o := range orders {
-		c.Add(o)
+		c.Add(o, 1)
 	}
 
-	above := c.GetAsksAbove(20.25)
+	above := c.GetAsksAbove(202500)
 
 	if len(above) != len(results) {
 		t.Fatalf("expected %d results, got %d", len(results), len(above))
@@ -121,13 +120,109 @@ func TestOrderContainer_GetAsksBelow(t *testing.T) {
 	}
 }
 
+func TestOrderContainer_Levels(t *testing.T) {
+	c := NewOrderContainer(makeComparator(true), makeComparator(false))
+
+	now := time.Now().UnixNano()
+	orders := [...]OrderTracker{
+		{OrderID: 1, Type: TypeLimit, Price: 202500, Timestamp: now + 1, Side: SideBuy},
+		{OrderID: 2, Type: TypeLimit, Price: 205000, Timestamp: now + 2, Side: SideBuy},
+		{OrderID: 3, Type: TypeMarket, Timestamp: now + 3, Side: SideBuy},
+		{OrderID: 4, Type: TypeLimit, Price: 202500, Timestamp: now + 4, Side: SideBuy},
+		{OrderID: 5, Type: TypeLimit, Price: 202500, Timestamp: now, Side: SideBuy}, // older order keeps its priority
+	}
+	for i, o := range orders {
+		c.Add(o, int64(i+1)*10)
+	}
+
+	type level struct {
+		Type  OrderType
+		Price int64
+		Qty   int64
+		Count int
+	}
+	expected := []level{{TypeMarket, 0, 30, 1}, {TypeLimit, 205000, 20, 1}, {TypeLimit, 202500, 100, 3}}
+
+	i := 0
+	for iter := c.Levels(SideBuy); iter.Valid(); iter.Next() {
+		l := iter.Value()
+		if got := (level{l.Type, l.Price, l.Qty, l.Count}); got != expected[i] {
+			t.Errorf("expected level %+v, got %+v", expected[i], got)
+		}
+		i += 1
+	}
+	if i != len(expected) {
+		t.Fatalf("expected %d levels, got %d", len(expected), i)
+	}
+
+	sortedIDs := []uint64{3, 2, 5, 1, 4}
+	i = 0
+	for iter := c.Iterator(SideBuy); iter.Valid(); iter.Next() {
+		if iter.Key().OrderID != sortedIDs[i] {
+			t.Errorf("expected order ID %d, got %d", sortedIDs[i], iter.Key().OrderID)
+		}
+		i += 1
+	}
+
+	c.Update(1, 5)
+	c.Remove(4)
+	c.Remove(2)
+	best, ok := c.Bids.Best()
+	if !ok || best.Type != TypeMarket {
+		t.Fatalf("expected the market level to be the best level")
+	}
+	c.Remove(3)
+	best, ok = c.Bids.Best()
+	if !ok {
+		t.Fatal("expected a best bid level")
+	}
+	if best.Price != 202500 || best.Qty != 55 || best.Count != 2 {
+		t.Errorf("expected level 20.25 with qty 55 and 2 orders, got %+v", best)
+	}
+	if c.Len(SideBuy) != 2 {
+		t.Errorf("expected 2 bids, got %d", c.Len(SideBuy))
+	}
+}
+
+func TestOrderContainer_IterateWhileRemoving(t *testing.T) {
+	c := NewOrderContainer(makeComparator(true), makeComparator(false))
+
+	now := time.Now().UnixNano()
+	for i := 0; i < 6; i++ {
+		c.Add(OrderTracker{OrderID: uint64(i + 1), Type: TypeLimit, Price: int64(20 + i%2), Timestamp: now + int64(i), Side: SideSell}, 1)
+	}
+
+	visited := make([]uint64, 0)
+	for iter := c.Iterator(SideSell); iter.Valid(); iter.Next() {
+		id := iter.Key().OrderID
+		visited = append(visited, id)
+		c.Remove(id)
+		if id == 1 {
+			c.Remove(3) // remove the next order at the same level
+		}
+	}
+
+	expected := []uint64{1, 5, 2, 4, 6}
+	if len(visited) != len(expected) {
+		t.Fatalf("expected to visit %v, visited %v", expected, visited)
+	}
+	for i := range expected {
+		if visited[i] != expected[i] {
+			t.Fatalf("expected to visit %v, visited %v", expected, visited)
+		}
+	}
+	if c.Len(SideSell) != 0 {
+		t.Errorf("expected no asks, got %d", c.Len(SideSell))
+	}
+}
+
 func BenchmarkOrderContainer_Add(b *testing.B) {
 	c := NewOrderContainer(makeComparator(true), makeComparator(false))
 
 	orders := make([]OrderTracker, b.N)
 	for i := 0; i < b.N; i++ {
 		order := createRandomOrder(i + 1)
-		price, err := order.Price.Float64()
+		price, err := ToFixedPrice(order.Price, DefaultPriceScale)
 		if err != nil {
 			b.Fatal(err)
 		}
@@ -144,7 +239,7 @@ func BenchmarkOrderContainer_Add(b *testing.B) {
 	b.ReportAllocs()
 
 	for i := 0; i < b.N; i++ {
-		c.Add(orders[i])
+		c.Add(orders[i], 1)
 	}
 }
 
@@ -154,7 +249,7 @@ func BenchmarkOrderContainer_GetBidsAbove(b *testing.B) {
 	orders := make([]OrderTracker, b.N)
 	for i := 0; i < b.N; i++ {
 		order := createRandomOrder(i + 1)
-		price, err := order.Price.Float64()
+		price, err := ToFixedPrice(order.Price, DefaultPriceScale)
 		if err != nil {
 			b.Fatal(err)
 		}
@@ -168,7 +263,7 @@ func BenchmarkOrderContainer_GetBidsAbove(b *testing.B) {
 	}
 
 	for i := 0; i < b.N; i++ {
-		c.Add(orders[i])
+		c.Add(orders[i], 1)
 	}
 
 	b.ResetTimer()
@@ -0,0 +1,218 @@
+package tome
+
+import (
+	"github.com/cockroachdb/apd"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Type of an order state transition.
+type OrderEventType byte
+
+const (
+	EventAccepted  OrderEventType = iota + 1 // order was accepted by the order book
+	EventTriggered                           // stop order was activated by the market price
+	EventFilled                              // order was partially or fully filled by a trade
+	EventAmended                             // order quantity or price was changed
+	EventCancelled                           // order was cancelled
+	EventExpired                             // GTD order was cancelled when it expired
+)
+
+func (e OrderEventType) String() string {
+	switch e {
+	case EventAccepted:
+		return "Accepted"
+	case EventTriggered:
+		return "Triggered"
+	case EventFilled:
+		return "Filled"
+	case EventAmended:
+		return "Amended"
+	case EventCancelled:
+		return "Cancelled"
+	case EventExpired:
+		return "Expired"
+	default:
+		return "invalid"
+	}
+}
+
+// Who caused an order state transition.
+type OrderActor byte
+
+const (
+	ActorCustomer OrderActor = iota + 1 // the customer who owns the order
+	ActorEngine                         // the matching engine (fills, stop activation, IOC cancellation, GTD expiry)
+)
+
+func (a OrderActor) String() string {
+	switch a {
+	case ActorCustomer:
+		return "Customer"
+	case ActorEngine:
+		return "Engine"
+	default:
+		return "invalid"
+	}
+}
+
+// Immutable record of an order state transition.
+type OrderEvent struct {
+	Sequence  uint64 // assigned by the order history, increases with every event
+	OrderID   uint64
+	Type      OrderEventType
+	Actor     OrderActor
+	Timestamp time.Time
+
+	Qty       int64       // order quantity after the event
+	FilledQty int64       // filled quantity after the event
+	Price     apd.Decimal // order limit price after the event
+	TradeID   uint64      // trade which filled the order, only set for EventFilled
+	TradeQty  int64       // quantity of the trade, only set for EventFilled
+}
+
+// Stores order events. Order books append events of every order state transition.
+type OrderHistory interface {
+	Append(event OrderEvent) (OrderEvent, error)  // assigns the event sequence
+	History(orderID uint64) ([]OrderEvent, error) // all order events sorted by sequence
+}
+
+// Order history which stores all events in memory.
+type InMemoryOrderHistory struct {
+	mutex    sync.RWMutex
+	sequence uint64
+	events   map[uint64][]OrderEvent
+}
+
+// Create a new in-memory order history.
+func NewInMemoryOrderHistory() *InMemoryOrderHistory {
+	return &InMemoryOrderHistory{events: make(map[uint64][]OrderEvent)}
+}
+
+func (m *InMemoryOrderHistory) Append(event OrderEvent) (OrderEvent, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.sequence += 1
+	event.Sequence = m.sequence
+	m.events[event.OrderID] = append(m.events[event.OrderID], event)
+	return event, nil
+}
+
+func (m *InMemoryOrderHistory) History(orderID uint64) ([]OrderEvent, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return append([]OrderEvent(nil), m.events[orderID]...), nil
+}
+
+// Order history which stores events in segmented append-only files in a directory.
+// Events are keyed by their sequence, sequences of each order are kept in memory.
+type FileOrderHistory struct {
+	log *segmentLog
+
+	mutex     sync.RWMutex
+	sequence  uint64
+	sequences map[uint64][]uint64 // order ID -> event sequences
+}
+
+// Open a file order history in a directory, create the directory if it doesn't exist.
+func OpenFileOrderHistory(dir string, config FileRepositoryConfig) (*FileOrderHistory, error) {
+	l, err := openSegmentLog(dir, config)
+	if err != nil {
+		return nil, err
+	}
+	f := &FileOrderHistory{log: l, sequences: make(map[uint64][]uint64)}
+	for _, sequence := range l.ids() {
+		event, err := f.read(sequence)
+		if err != nil {
+			l.close()
+			return nil, err
+		}
+		f.sequences[event.OrderID] = append(f.sequences[event.OrderID], sequence)
+		if sequence > f.sequence {
+			f.sequence = sequence
+		}
+	}
+	for _, sequences := range f.sequences {
+		sort.Slice(sequences, func(i, j int) bool {
+			return sequences[i] < sequences[j]
+		})
+	}
+	return f, nil
+}
+
+func (f *FileOrderHistory) Append(event OrderEvent) (OrderEvent, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	event.Sequence = f.sequence + 1
+	var e encoder
+	e.orderEvent(event)
+	if err := f.log.write(event.Sequence, e.buf); err != nil {
+		return event, err
+	}
+	f.sequence = event.Sequence
+	f.sequences[event.OrderID] = append(f.sequences[event.OrderID], event.Sequence)
+	return event, nil
+}
+
+func (f *FileOrderHistory) History(orderID uint64) ([]OrderEvent, error) {
+	f.mutex.RLock()
+	sequences := append([]uint64(nil), f.sequences[orderID]...)
+	f.mutex.RUnlock()
+
+	events := make([]OrderEvent, 0, len(sequences))
+	for _, sequence := range sequences {
+		event, err := f.read(sequence)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func (f *FileOrderHistory) read(sequence uint64) (OrderEvent, error) {
+	payload, ok, err := f.log.read(sequence)
+	if err != nil {
+		return OrderEvent{}, err
+	}
+	if !ok {
+		return OrderEvent{}, ErrInvalidEncoding
+	}
+	d := decoder{buf: payload}
+	event := d.orderEvent()
+	return event, d.finish()
+}
+
+// Sync and close the order history.
+func (f *FileOrderHistory) Close() error {
+	return f.log.close()
+}
+
+func (e *encoder) orderEvent(v OrderEvent) {
+	e.uint64(v.Sequence)
+	e.uint64(v.OrderID)
+	e.uint8(uint8(v.Type))
+	e.uint8(uint8(v.Actor))
+	e.time(v.Timestamp)
+	e.int64(v.Qty)
+	e.int64(v.FilledQty)
+	e.decimal(v.Price)
+	e.uint64(v.TradeID)
+	e.int64(v.TradeQty)
+}
+
+func (d *decoder) orderEvent() OrderEvent {
+	var v OrderEvent
+	v.Sequence = d.uint64()
+	v.OrderID = d.uint64()
+	v.Type = OrderEventType(d.uint8())
+	v.Actor = OrderActor(d.uint8())
+	v.Timestamp = d.time()
+	v.Qty = d.int64()
+	v.FilledQty = d.int64()
+	v.Price = d.decimal()
+	v.TradeID = d.uint64()
+	v.TradeQty = d.int64()
+	return v
+}
@@ -0,0 +1,102 @@
+package tome
+
+import (
+	"github.com/cockroachdb/apd"
+	"testing"
+)
+
+type expectedEvent struct {
+	Type      OrderEventType
+	Actor     OrderActor
+	FilledQty int64
+}
+
+func assertHistory(t *testing.T, history OrderHistory, orderID uint64, expected []expectedEvent) {
+	events, err := history.History(orderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("order %d: expected %d events, got %+v", orderID, len(expected), events)
+	}
+	for i, event := range events {
+		if got := (expectedEvent{event.Type, event.Actor, event.FilledQty}); got != expected[i] {
+			t.Errorf("order %d: expected event %+v, got %+v", orderID, expected[i], got)
+		}
+		if i > 0 && event.Sequence <= events[i-1].Sequence {
+			t.Errorf("order %d: expected increasing sequences, got %d after %d", orderID, event.Sequence, events[i-1].Sequence)
+		}
+	}
+}
+
+func testOrderHistory(t *testing.T, history OrderHistory) {
+	tb := NewTradeBook(instrument)
+	ob := NewOrderBook(instrument, *apd.New(2025, -2), tb, NOPOrderRepository, WithOrderHistory(history))
+
+	if _, err := ob.Add(createOrder(1, TypeLimit, 0, 10, *apd.New(2010, -2), apd.Decimal{}, SideSell)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ob.Add(createOrder(2, TypeLimit, ParamIOC, 4, *apd.New(2010, -2), apd.Decimal{}, SideBuy)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ob.Amend(1, 8, *apd.New(2010, -2)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ob.Add(createOrder(3, TypeLimit, ParamIOC, 6, *apd.New(2010, -2), apd.Decimal{}, SideBuy)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ob.Cancel(1); err != nil {
+		t.Fatal(err)
+	}
+
+	assertHistory(t, history, 1, []expectedEvent{
+		{EventAccepted, ActorCustomer, 0},
+		{EventFilled, ActorEngine, 4},
+		{EventAmended, ActorCustomer, 4},
+		{EventFilled, ActorEngine, 8},
+	})
+	assertHistory(t, history, 3, []expectedEvent{
+		{EventAccepted, ActorCustomer, 0},
+		{EventFilled, ActorEngine, 4},
+		{EventCancelled, ActorEngine, 4},
+	})
+
+	events, _ := history.History(2)
+	if len(events) != 2 || events[1].TradeID != 0 || events[1].TradeQty != 4 {
+		t.Errorf("expected order 2 to be filled by trade 0, got %+v", events)
+	}
+}
+
+func TestInMemoryOrderHistory(t *testing.T) {
+	testOrderHistory(t, NewInMemoryOrderHistory())
+}
+
+func TestFileOrderHistory(t *testing.T) {
+	dir := t.TempDir()
+	history, err := OpenFileOrderHistory(dir, FileRepositoryConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testOrderHistory(t, history)
+	if err := history.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err = OpenFileOrderHistory(dir, FileRepositoryConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer history.Close()
+	assertHistory(t, history, 3, []expectedEvent{
+		{EventAccepted, ActorCustomer, 0},
+		{EventFilled, ActorEngine, 4},
+		{EventCancelled, ActorEngine, 4},
+	})
+	event, err := history.Append(OrderEvent{OrderID: 3, Type: EventCancelled})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Sequence != 10 {
+		t.Errorf("expected the sequence to continue after reopening, got %d", event.Sequence)
+	}
+}
@@ -1,10 +1,80 @@
 package tome
 
+import (
+	"github.com/google/uuid"
+	"sort"
+	"sync"
+	"time"
+)
+
 type OrderRepository interface {
 	Save(order Order) error
 	GetByID(id uint64) (Order, error)
 }
 
+// Order repository which can list orders matching a query.
+type QueryableOrderRepository interface {
+	OrderRepository
+	Query(query OrderQuery) ([]Order, error)
+}
+
+// Order query filters, zero values match all orders. Results are sorted by timestamp and ID.
+type OrderQuery struct {
+	CustomerID uuid.UUID     // uuid.Nil matches all customers
+	Instrument string        // empty matches all instruments
+	Statuses   []OrderStatus // empty matches all statuses
+	From, To   time.Time     // Timestamp range [From, To), zero times are unbounded
+
+	Offset int // number of matching orders to skip
+	Limit  int // maximum number of returned orders, 0 returns all
+}
+
+// Returns true if an order with the provided fields matches the query.
+func (q OrderQuery) matches(customerID uuid.UUID, instrument string, status OrderStatus, timestamp time.Time) bool {
+	if q.CustomerID != uuid.Nil && q.CustomerID != customerID {
+		return false
+	}
+	if q.Instrument != "" && q.Instrument != instrument {
+		return false
+	}
+	if !q.From.IsZero() && timestamp.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !timestamp.Before(q.To) {
+		return false
+	}
+	if len(q.Statuses) == 0 {
+		return true
+	}
+	for _, s := range q.Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the order matches the query.
+func (q OrderQuery) Matches(order Order) bool {
+	return q.matches(order.CustomerID, order.Instrument, order.Status(), order.Timestamp)
+}
+
+// returns the [start, end) range of n sorted results which belongs to the requested page
+func (q OrderQuery) page(n int) (int, int) {
+	start := q.Offset
+	if start < 0 {
+		start = 0
+	}
+	if start > n {
+		start = n
+	}
+	end := n
+	if q.Limit > 0 && start+q.Limit < end {
+		end = start + q.Limit
+	}
+	return start, end
+}
+
 var NOPOrderRepository = &nopOrderRepository{}
 
 type nopOrderRepository struct {
@@ -17,3 +87,58 @@ func (n *nopOrderRepository) Save(order Order) error {
 func (n *nopOrderRepository) GetByID(id uint64) (Order, error) {
 	return Order{}, nil
 }
+
+// Order repository which stores the latest state of all orders in memory.
+type InMemoryOrderRepository struct {
+	mutex  sync.RWMutex
+	orders map[uint64]Order
+}
+
+// Create a new in-memory order repository.
+func NewInMemoryOrderRepository() *InMemoryOrderRepository {
+	return &InMemoryOrderRepository{orders: make(map[uint64]Order)}
+}
+
+func (m *InMemoryOrderRepository) Save(order Order) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.orders[order.ID] = order
+	return nil
+}
+
+// Get the latest state of an order. Returns ErrOrderNotFound if the order was never saved.
+func (m *InMemoryOrderRepository) GetByID(id uint64) (Order, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	order, ok := m.orders[id]
+	if !ok {
+		return Order{}, ErrOrderNotFound
+	}
+	return order, nil
+}
+
+// List orders matching the query.
+func (m *InMemoryOrderRepository) Query(query OrderQuery) ([]Order, error) {
+	m.mutex.RLock()
+	orders := make([]Order, 0)
+	for _, order := range m.orders {
+		if query.Matches(order) {
+			orders = append(orders, order)
+		}
+	}
+	m.mutex.RUnlock()
+
+	sort.Slice(orders, func(i, j int) bool {
+		return orderBefore(orders[i].Timestamp, orders[i].ID, orders[j].Timestamp, orders[j].ID)
+	})
+	start, end := query.page(len(orders))
+	return orders[start:end], nil
+}
+
+// query result ordering - by timestamp and ID
+func orderBefore(aTimestamp time.Time, aID uint64, bTimestamp time.Time, bID uint64) bool {
+	if aTimestamp.Equal(bTimestamp) {
+		return aID < bID
+	}
+	return aTimestamp.Before(bTimestamp)
+}
@@ -0,0 +1,101 @@
+package tome
+
+import (
+	"github.com/cockroachdb/apd"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func testOrderQuery(t *testing.T, repo QueryableOrderRepository) {
+	alice, bob := uuid.New(), uuid.New()
+	start := time.Unix(1600000000, 0)
+	for i := 0; i < 10; i++ {
+		order := createOrder(uint64(i+1), TypeLimit, 0, 10, *apd.New(2010, -2), apd.Decimal{}, SideSell)
+		order.Timestamp = start.Add(time.Duration(10-i) * time.Minute) // reverse ID order
+		order.CustomerID = alice
+		if i%2 == 1 {
+			order.CustomerID = bob
+		}
+		if i >= 8 {
+			order.Instrument = "OTHER"
+		}
+		switch i % 3 {
+		case 1:
+			order.FilledQty = 5
+		case 2:
+			order.Cancel()
+		}
+		if err := repo.Save(order); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		name     string
+		query    OrderQuery
+		expected []uint64
+	}{
+		{"all", OrderQuery{}, []uint64{10, 9, 8, 7, 6, 5, 4, 3, 2, 1}},
+		{"customer", OrderQuery{CustomerID: bob}, []uint64{10, 8, 6, 4, 2}},
+		{"instrument", OrderQuery{Instrument: "OTHER"}, []uint64{10, 9}},
+		{"status", OrderQuery{Statuses: []OrderStatus{StatusCancelled, StatusPartiallyFilled}}, []uint64{9, 8, 6, 5, 3, 2}},
+		{"time range", OrderQuery{From: start.Add(3 * time.Minute), To: start.Add(6 * time.Minute)}, []uint64{8, 7, 6}},
+		{"page", OrderQuery{CustomerID: alice, Offset: 1, Limit: 2}, []uint64{7, 5}},
+		{"last page", OrderQuery{Instrument: instrument, Offset: 6, Limit: 5}, []uint64{2, 1}},
+		{"offset past the end", OrderQuery{Offset: 20}, []uint64{}},
+	} {
+		orders, err := repo.Query(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := orderIDs(orders)
+		if len(ids) != len(test.expected) {
+			t.Errorf("%s: expected orders %v, got %v", test.name, test.expected, ids)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != test.expected[i] {
+				t.Errorf("%s: expected orders %v, got %v", test.name, test.expected, ids)
+				break
+			}
+		}
+	}
+}
+
+func TestInMemoryOrderRepository_Query(t *testing.T) {
+	testOrderQuery(t, NewInMemoryOrderRepository())
+}
+
+func TestFileOrderRepository_Query(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := OpenFileOrderRepository(dir, FileRepositoryConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testOrderQuery(t, repo)
+	if err := repo.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// summaries are rebuilt after reopening
+	repo, err = OpenFileOrderRepository(dir, FileRepositoryConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+	orders, err := repo.Query(OrderQuery{Statuses: []OrderStatus{StatusFilled}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 0 {
+		t.Errorf("expected no filled orders, got %v", orderIDs(orders))
+	}
+	orders, err = repo.Query(OrderQuery{Statuses: []OrderStatus{StatusNew}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 4 {
+		t.Errorf("expected 4 new orders, got %v", orderIDs(orders))
+	}
+}
@@ -0,0 +1,152 @@
+package ouch
+
+import (
+	"errors"
+	"github.com/ffhan/tome/server"
+	"log"
+	"net"
+	"sync"
+)
+
+// Number of outgoing messages a session can buffer. A session which doesn't read its messages fast enough is closed.
+const DefaultSendBuffer = 1024
+
+var ErrGatewayClosed = errors.New("gateway is closed")
+
+// Binary order entry gateway. Every connection is a session of the customer which logged in, orders are routed through
+// the order entry server.
+type Gateway struct {
+	server     *server.Server
+	sendBuffer int
+
+	mutex    sync.Mutex
+	sessions map[*session]struct{}
+	listener net.Listener
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// Option changes the default behaviour of a gateway.
+type Option func(g *Gateway)
+
+// Buffer up to size outgoing messages per session.
+func WithSendBuffer(size int) Option {
+	return func(g *Gateway) {
+		g.sendBuffer = size
+	}
+}
+
+// Create a new gateway which enters orders through the server.
+func NewGateway(srv *server.Server, opts ...Option) *Gateway {
+	g := &Gateway{
+		server:     srv,
+		sendBuffer: DefaultSendBuffer,
+		sessions:   make(map[*session]struct{}),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
+// Listen on a TCP address and serve sessions in the background.
+func (g *Gateway) ListenAndServe(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	if err := g.listen(listener); err != nil {
+		return err
+	}
+	go g.accept(listener)
+	return nil
+}
+
+// Accept sessions from the listener until the gateway is closed.
+func (g *Gateway) Serve(listener net.Listener) error {
+	if err := g.listen(listener); err != nil {
+		return err
+	}
+	return g.accept(listener)
+}
+
+func (g *Gateway) listen(listener net.Listener) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.closed {
+		listener.Close()
+		return ErrGatewayClosed
+	}
+	g.listener = listener
+	return nil
+}
+
+func (g *Gateway) accept(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			g.mutex.Lock()
+			closed := g.closed
+			g.mutex.Unlock()
+			if closed {
+				return ErrGatewayClosed
+			}
+			return err
+		}
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			tcp.SetNoDelay(true)
+		}
+		sess := newSession(g, conn)
+		g.mutex.Lock()
+		if g.closed {
+			g.mutex.Unlock()
+			conn.Close()
+			return ErrGatewayClosed
+		}
+		g.sessions[sess] = struct{}{}
+		g.wg.Add(1)
+		g.mutex.Unlock()
+		go sess.run()
+	}
+}
+
+// Returns the address the gateway is listening on, nil if it isn't listening.
+func (g *Gateway) Addr() net.Addr {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.listener == nil {
+		return nil
+	}
+	return g.listener.Addr()
+}
+
+// Stop accepting sessions, close all sessions and wait for them to finish.
+func (g *Gateway) Close() error {
+	g.mutex.Lock()
+	if g.closed {
+		g.mutex.Unlock()
+		return ErrGatewayClosed
+	}
+	g.closed = true
+	var err error
+	if g.listener != nil {
+		err = g.listener.Close()
+	}
+	for sess := range g.sessions {
+		sess.conn.Close()
+	}
+	g.mutex.Unlock()
+	g.wg.Wait()
+	return err
+}
+
+func (g *Gateway) removeSession(sess *session) {
+	g.mutex.Lock()
+	delete(g.sessions, sess)
+	g.mutex.Unlock()
+	g.wg.Done()
+}
+
+func (g *Gateway) logf(format string, args ...interface{}) {
+	log.Printf(format, args...)
+}
@@ -0,0 +1,519 @@
+// Package ouch is a compact fixed-width binary order entry protocol, modelled after NASDAQ OUCH.
+//
+// Every message is framed by a 2 byte big endian length of the payload, the first payload byte is the message type.
+// All integers are big endian, prices are fixed-point integers with PriceScale decimal places, tokens and symbols are
+// left aligned and padded with spaces. Messages are decoded into fixed-size structs without allocating.
+package ouch
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Number of decimal places of protocol prices.
+const PriceScale int32 = 4
+
+// Client message types.
+const (
+	TypeLogin        byte = 'L'
+	TypeEnterOrder   byte = 'O'
+	TypeReplaceOrder byte = 'U'
+	TypeCancelOrder  byte = 'X'
+)
+
+// Server message types.
+const (
+	TypeLoginAccepted byte = 'L'
+	TypeAccepted      byte = 'A'
+	TypeReplaced      byte = 'U'
+	TypeCanceled      byte = 'C'
+	TypeExecuted      byte = 'E'
+	TypeRejected      byte = 'J'
+)
+
+// Payload sizes of messages, including the type.
+const (
+	LoginSize         = 1 + 16
+	EnterOrderSize    = 1 + 14 + 8 + 1 + 1 + 1 + 4 + 8 + 8 + 4 + 8
+	ReplaceOrderSize  = 1 + 14 + 14 + 4 + 8
+	CancelOrderSize   = 1 + 14
+	LoginAcceptedSize = 1 + 8
+	AcceptedSize      = 1 + 8 + EnterOrderSize - 1 + 8
+	ReplacedSize      = 1 + 8 + 14 + 14 + 8 + 4 + 8
+	CanceledSize      = 1 + 8 + 14 + 8 + 4
+	ExecutedSize      = 1 + 8 + 14 + 8 + 8 + 4 + 8 + 4
+	RejectedSize      = 1 + 8 + 14 + 1
+
+	MaxMessageSize = AcceptedSize
+)
+
+// Sides.
+const (
+	SideBuy  byte = 'B'
+	SideSell byte = 'S'
+)
+
+// Order types.
+const (
+	OrderTypeMarket byte = 'M'
+	OrderTypeLimit  byte = 'L'
+)
+
+var (
+	ErrInvalidLength = errors.New("invalid message length")
+	ErrInvalidType   = errors.New("invalid message type")
+)
+
+// Client assigned order reference, unique within a session.
+type Token [14]byte
+
+// Create a token from a string, longer strings are truncated.
+func NewToken(s string) Token {
+	var t Token
+	pad(t[:], s)
+	return t
+}
+
+func (t Token) String() string {
+	return trim(t[:])
+}
+
+// Instrument symbol.
+type Symbol [8]byte
+
+// Create a symbol from a string, longer strings are truncated.
+func NewSymbol(s string) Symbol {
+	var sym Symbol
+	pad(sym[:], s)
+	return sym
+}
+
+func (s Symbol) String() string {
+	return trim(s[:])
+}
+
+func pad(dst []byte, s string) {
+	n := copy(dst, s)
+	for i := n; i < len(dst); i++ {
+		dst[i] = ' '
+	}
+}
+
+func trim(b []byte) string {
+	end := len(b)
+	for end > 0 && b[end-1] == ' ' {
+		end--
+	}
+	return string(b[:end])
+}
+
+// Login of a customer, has to be the first message of a session.
+type Login struct {
+	CustomerID [16]byte
+}
+
+// Enter a new order.
+type EnterOrder struct {
+	Token      Token
+	Symbol     Symbol
+	Side       byte   // SideBuy or SideSell
+	OrderType  byte   // OrderTypeMarket or OrderTypeLimit
+	Params     uint8  // tome.OrderParams bits
+	Qty        uint32 // shares
+	Price      int64  // zero for market orders
+	StopPrice  int64  // stop orders
+	DisplayQty uint32 // iceberg orders, zero displays the whole quantity
+	ExpireTime int64  // GTD orders, nanoseconds since Epoch
+}
+
+// Replace the quantity and price of an order, the replacement token identifies the order from then on.
+type ReplaceOrder struct {
+	ExistingToken    Token
+	ReplacementToken Token
+	Qty              uint32
+	Price            int64
+}
+
+// Cancel an order.
+type CancelOrder struct {
+	Token Token
+}
+
+// Login was accepted.
+type LoginAccepted struct {
+	Timestamp int64 // nanoseconds since Epoch
+}
+
+// Order was accepted.
+type Accepted struct {
+	Timestamp int64
+	OrderID   uint64
+	Order     EnterOrder
+}
+
+// Order was replaced.
+type Replaced struct {
+	Timestamp     int64
+	Token         Token // replacement token
+	PreviousToken Token
+	OrderID       uint64
+	Qty           uint32
+	Price         int64
+}
+
+// Order was cancelled, either by the client or by the engine (IOC, FOK).
+type Canceled struct {
+	Timestamp int64
+	Token     Token
+	OrderID   uint64
+	FilledQty uint32
+}
+
+// Order was (partially) executed.
+type Executed struct {
+	Timestamp   int64
+	Token       Token
+	OrderID     uint64
+	MatchNumber uint64 // trade ID
+	Qty         uint32
+	Price       int64
+	LeavesQty   uint32
+}
+
+// Message was rejected.
+type Rejected struct {
+	Timestamp int64
+	Token     Token
+	Reason    RejectReason
+}
+
+// Reads framed messages from a stream. Returned payloads are valid until the next read.
+type Reader struct {
+	r   *bufio.Reader
+	buf [MaxMessageSize]byte
+}
+
+// Create a new message reader.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Read the next message payload, the first byte is the message type.
+func (r *Reader) Next() ([]byte, error) {
+	if _, err := io.ReadFull(r.r, r.buf[:2]); err != nil {
+		return nil, err
+	}
+	size := int(binary.BigEndian.Uint16(r.buf[:2]))
+	if size == 0 || size > len(r.buf) {
+		return nil, ErrInvalidLength
+	}
+	if _, err := io.ReadFull(r.r, r.buf[:size]); err != nil {
+		return nil, err
+	}
+	return r.buf[:size], nil
+}
+
+// Appends fixed-width fields after the frame length.
+type encoder struct {
+	buf []byte
+}
+
+func newEncoder(buf []byte, msgType byte, size int) encoder {
+	e := encoder{buf: append(buf, byte(size>>8), byte(size))}
+	e.uint8(msgType)
+	return e
+}
+
+func (e *encoder) uint8(v uint8) {
+	e.buf = append(e.buf, v)
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.buf = append(e.buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (e *encoder) uint64(v uint64) {
+	e.buf = append(e.buf, byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.buf = append(e.buf, b...)
+}
+
+// Reads fixed-width fields of a payload which was checked for its length.
+type decoder struct {
+	buf []byte
+	pos int
+}
+
+func newDecoder(data []byte, msgType byte, size int) (decoder, error) {
+	if len(data) != size {
+		return decoder{}, ErrInvalidLength
+	}
+	if data[0] != msgType {
+		return decoder{}, ErrInvalidType
+	}
+	return decoder{buf: data, pos: 1}, nil
+}
+
+func (d *decoder) uint8() uint8 {
+	v := d.buf[d.pos]
+	d.pos += 1
+	return v
+}
+
+func (d *decoder) uint32() uint32 {
+	v := binary.BigEndian.Uint32(d.buf[d.pos:])
+	d.pos += 4
+	return v
+}
+
+func (d *decoder) uint64() uint64 {
+	v := binary.BigEndian.Uint64(d.buf[d.pos:])
+	d.pos += 8
+	return v
+}
+
+func (d *decoder) bytes(dst []byte) {
+	d.pos += copy(dst, d.buf[d.pos:])
+}
+
+// Append the framed message.
+func (m *Login) Append(buf []byte) []byte {
+	e := newEncoder(buf, TypeLogin, LoginSize)
+	e.bytes(m.CustomerID[:])
+	return e.buf
+}
+
+// Decode the message payload.
+func (m *Login) Unmarshal(data []byte) error {
+	d, err := newDecoder(data, TypeLogin, LoginSize)
+	if err != nil {
+		return err
+	}
+	d.bytes(m.CustomerID[:])
+	return nil
+}
+
+// Append the framed message.
+func (m *EnterOrder) Append(buf []byte) []byte {
+	e := newEncoder(buf, TypeEnterOrder, EnterOrderSize)
+	m.encode(&e)
+	return e.buf
+}
+
+func (m *EnterOrder) encode(e *encoder) {
+	e.bytes(m.Token[:])
+	e.bytes(m.Symbol[:])
+	e.uint8(m.Side)
+	e.uint8(m.OrderType)
+	e.uint8(m.Params)
+	e.uint32(m.Qty)
+	e.uint64(uint64(m.Price))
+	e.uint64(uint64(m.StopPrice))
+	e.uint32(m.DisplayQty)
+	e.uint64(uint64(m.ExpireTime))
+}
+
+// Decode the message payload.
+func (m *EnterOrder) Unmarshal(data []byte) error {
+	d, err := newDecoder(data, TypeEnterOrder, EnterOrderSize)
+	if err != nil {
+		return err
+	}
+	m.decode(&d)
+	return nil
+}
+
+func (m *EnterOrder) decode(d *decoder) {
+	d.bytes(m.Token[:])
+	d.bytes(m.Symbol[:])
+	m.Side = d.uint8()
+	m.OrderType = d.uint8()
+	m.Params = d.uint8()
+	m.Qty = d.uint32()
+	m.Price = int64(d.uint64())
+	m.StopPrice = int64(d.uint64())
+	m.DisplayQty = d.uint32()
+	m.ExpireTime = int64(d.uint64())
+}
+
+// Append the framed message.
+func (m *ReplaceOrder) Append(buf []byte) []byte {
+	e := newEncoder(buf, TypeReplaceOrder, ReplaceOrderSize)
+	e.bytes(m.ExistingToken[:])
+	e.bytes(m.ReplacementToken[:])
+	e.uint32(m.Qty)
+	e.uint64(uint64(m.Price))
+	return e.buf
+}
+
+// Decode the message payload.
+func (m *ReplaceOrder) Unmarshal(data []byte) error {
+	d, err := newDecoder(data, TypeReplaceOrder, ReplaceOrderSize)
+	if err != nil {
+		return err
+	}
+	d.bytes(m.ExistingToken[:])
+	d.bytes(m.ReplacementToken[:])
+	m.Qty = d.uint32()
+	m.Price = int64(d.uint64())
+	return nil
+}
+
+// Append the framed message.
+func (m *CancelOrder) Append(buf []byte) []byte {
+	e := newEncoder(buf, TypeCancelOrder, CancelOrderSize)
+	e.bytes(m.Token[:])
+	return e.buf
+}
+
+// Decode the message payload.
+func (m *CancelOrder) Unmarshal(data []byte) error {
+	d, err := newDecoder(data, TypeCancelOrder, CancelOrderSize)
+	if err != nil {
+		return err
+	}
+	d.bytes(m.Token[:])
+	return nil
+}
+
+// Append the framed message.
+func (m *LoginAccepted) Append(buf []byte) []byte {
+	e := newEncoder(buf, TypeLoginAccepted, LoginAcceptedSize)
+	e.uint64(uint64(m.Timestamp))
+	return e.buf
+}
+
+// Decode the message payload.
+func (m *LoginAccepted) Unmarshal(data []byte) error {
+	d, err := newDecoder(data, TypeLoginAccepted, LoginAcceptedSize)
+	if err != nil {
+		return err
+	}
+	m.Timestamp = int64(d.uint64())
+	return nil
+}
+
+// Append the framed message.
+func (m *Accepted) Append(buf []byte) []byte {
+	e := newEncoder(buf, TypeAccepted, AcceptedSize)
+	e.uint64(uint64(m.Timestamp))
+	e.uint64(m.OrderID)
+	m.Order.encode(&e)
+	return e.buf
+}
+
+// Decode the message payload.
+func (m *Accepted) Unmarshal(data []byte) error {
+	d, err := newDecoder(data, TypeAccepted, AcceptedSize)
+	if err != nil {
+		return err
+	}
+	m.Timestamp = int64(d.uint64())
+	m.OrderID = d.uint64()
+	m.Order.decode(&d)
+	return nil
+}
+
+// Append the framed message.
+func (m *Replaced) Append(buf []byte) []byte {
+	e := newEncoder(buf, TypeReplaced, ReplacedSize)
+	e.uint64(uint64(m.Timestamp))
+	e.bytes(m.Token[:])
+	e.bytes(m.PreviousToken[:])
+	e.uint64(m.OrderID)
+	e.uint32(m.Qty)
+	e.uint64(uint64(m.Price))
+	return e.buf
+}
+
+// Decode the message payload.
+func (m *Replaced) Unmarshal(data []byte) error {
+	d, err := newDecoder(data, TypeReplaced, ReplacedSize)
+	if err != nil {
+		return err
+	}
+	m.Timestamp = int64(d.uint64())
+	d.bytes(m.Token[:])
+	d.bytes(m.PreviousToken[:])
+	m.OrderID = d.uint64()
+	m.Qty = d.uint32()
+	m.Price = int64(d.uint64())
+	return nil
+}
+
+// Append the framed message.
+func (m *Canceled) Append(buf []byte) []byte {
+	e := newEncoder(buf, TypeCanceled, CanceledSize)
+	e.uint64(uint64(m.Timestamp))
+	e.bytes(m.Token[:])
+	e.uint64(m.OrderID)
+	e.uint32(m.FilledQty)
+	return e.buf
+}
+
+// Decode the message payload.
+func (m *Canceled) Unmarshal(data []byte) error {
+	d, err := newDecoder(data, TypeCanceled, CanceledSize)
+	if err != nil {
+		return err
+	}
+	m.Timestamp = int64(d.uint64())
+	d.bytes(m.Token[:])
+	m.OrderID = d.uint64()
+	m.FilledQty = d.uint32()
+	return nil
+}
+
+// Append the framed message.
+func (m *Executed) Append(buf []byte) []byte {
+	e := newEncoder(buf, TypeExecuted, ExecutedSize)
+	e.uint64(uint64(m.Timestamp))
+	e.bytes(m.Token[:])
+	e.uint64(m.OrderID)
+	e.uint64(m.MatchNumber)
+	e.uint32(m.Qty)
+	e.uint64(uint64(m.Price))
+	e.uint32(m.LeavesQty)
+	return e.buf
+}
+
+// Decode the message payload.
+func (m *Executed) Unmarshal(data []byte) error {
+	d, err := newDecoder(data, TypeExecuted, ExecutedSize)
+	if err != nil {
+		return err
+	}
+	m.Timestamp = int64(d.uint64())
+	d.bytes(m.Token[:])
+	m.OrderID = d.uint64()
+	m.MatchNumber = d.uint64()
+	m.Qty = d.uint32()
+	m.Price = int64(d.uint64())
+	m.LeavesQty = d.uint32()
+	return nil
+}
+
+// Append the framed message.
+func (m *Rejected) Append(buf []byte) []byte {
+	e := newEncoder(buf, TypeRejected, RejectedSize)
+	e.uint64(uint64(m.Timestamp))
+	e.bytes(m.Token[:])
+	e.uint8(byte(m.Reason))
+	return e.buf
+}
+
+// Decode the message payload.
+func (m *Rejected) Unmarshal(data []byte) error {
+	d, err := newDecoder(data, TypeRejected, RejectedSize)
+	if err != nil {
+		return err
+	}
+	m.Timestamp = int64(d.uint64())
+	d.bytes(m.Token[:])
+	m.Reason = RejectReason(d.uint8())
+	return nil
+}
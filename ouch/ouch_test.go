@@ -0,0 +1,221 @@
+package ouch
+
+import (
+	"bytes"
+	"github.com/cockroachdb/apd"
+	"github.com/ffhan/tome"
+	"github.com/ffhan/tome/server"
+	"github.com/google/uuid"
+	"net"
+	"testing"
+	"time"
+)
+
+const instrument = "TEST"
+
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *Reader
+}
+
+func setup(t *testing.T) *Gateway {
+	srv := server.New()
+	tb := tome.NewTradeBook(instrument, tome.WithTradeCallback(srv))
+	ob := tome.NewOrderBook(instrument, *apd.New(2000, -2), tb, tome.NOPOrderRepository)
+	srv.Register(ob)
+	gateway := NewGateway(srv)
+	if err := gateway.ListenAndServe("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		gateway.Close()
+	})
+	return gateway
+}
+
+func connect(t *testing.T, gateway *Gateway) *testClient {
+	conn, err := net.Dial("tcp", gateway.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	c := &testClient{t: t, conn: conn, reader: NewReader(conn)}
+	c.send(&Login{CustomerID: uuid.New()})
+	var accepted LoginAccepted
+	c.expect(&accepted)
+	return c
+}
+
+type message interface {
+	Append(buf []byte) []byte
+	Unmarshal(data []byte) error
+}
+
+func (c *testClient) send(msg message) {
+	if _, err := c.conn.Write(msg.Append(nil)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) expect(msg message) {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	data, err := c.reader.Next()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if err := msg.Unmarshal(data); err != nil {
+		c.t.Fatalf("expected %T, got message type %c: %v", msg, data[0], err)
+	}
+}
+
+func limitOrder(token string, side byte, qty uint32, price int64) *EnterOrder {
+	return &EnterOrder{
+		Token:     NewToken(token),
+		Symbol:    NewSymbol(instrument),
+		Side:      side,
+		OrderType: OrderTypeLimit,
+		Qty:       qty,
+		Price:     price,
+	}
+}
+
+func TestMessages(t *testing.T) {
+	order := limitOrder("order-1", SideBuy, 100, 202500)
+	order.Params = uint8(tome.ParamGTD | tome.ParamAON)
+	order.DisplayQty = 10
+	order.ExpireTime = time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC).UnixNano()
+
+	messages := []struct {
+		encoded message
+		decoded message
+	}{
+		{&Login{CustomerID: uuid.New()}, &Login{}},
+		{order, &EnterOrder{}},
+		{&ReplaceOrder{ExistingToken: NewToken("a"), ReplacementToken: NewToken("b"), Qty: 5, Price: 1}, &ReplaceOrder{}},
+		{&CancelOrder{Token: NewToken("a")}, &CancelOrder{}},
+		{&LoginAccepted{Timestamp: 1}, &LoginAccepted{}},
+		{&Accepted{Timestamp: 1, OrderID: 2, Order: *order}, &Accepted{}},
+		{&Replaced{Timestamp: 1, Token: NewToken("b"), PreviousToken: NewToken("a"), OrderID: 2, Qty: 3, Price: 4}, &Replaced{}},
+		{&Canceled{Timestamp: 1, Token: NewToken("a"), OrderID: 2, FilledQty: 3}, &Canceled{}},
+		{&Executed{Timestamp: 1, Token: NewToken("a"), OrderID: 2, MatchNumber: 3, Qty: 4, Price: -5, LeavesQty: 6}, &Executed{}},
+		{&Rejected{Timestamp: 1, Token: NewToken("a"), Reason: ReasonDuplicateToken}, &Rejected{}},
+	}
+	var stream []byte
+	for _, m := range messages {
+		stream = m.encoded.Append(stream)
+	}
+	reader := NewReader(bytes.NewReader(stream))
+	for _, m := range messages {
+		data, err := reader.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.decoded.Unmarshal(data); err != nil {
+			t.Fatalf("%T: %v", m.decoded, err)
+		}
+		if !bytes.Equal(m.decoded.Append(nil), m.encoded.Append(nil)) {
+			t.Errorf("expected %+v, got %+v", m.encoded, m.decoded)
+		}
+	}
+
+	if err := (&CancelOrder{}).Unmarshal(order.Append(nil)[2:]); err != ErrInvalidLength {
+		t.Errorf("expected %v, got %v", ErrInvalidLength, err)
+	}
+	if NewToken("order-1").String() != "order-1" || NewSymbol(instrument).String() != instrument {
+		t.Errorf("expected padding to be trimmed")
+	}
+}
+
+func TestEnterOrder_UnmarshalAllocations(t *testing.T) {
+	stream := bytes.Repeat(limitOrder("order-1", SideBuy, 100, 202500).Append(nil), 1000)
+	reader := NewReader(bytes.NewReader(stream))
+	var order EnterOrder
+	allocs := testing.AllocsPerRun(500, func() {
+		data, err := reader.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := order.Unmarshal(data); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("expected no allocations, got %f", allocs)
+	}
+}
+
+func BenchmarkEnterOrder_Unmarshal(b *testing.B) {
+	data := limitOrder("order-1", SideBuy, 100, 202500).Append(nil)[2:]
+	var order EnterOrder
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := order.Unmarshal(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestGateway(t *testing.T) {
+	gateway := setup(t)
+	maker, taker := connect(t, gateway), connect(t, gateway)
+
+	maker.send(limitOrder("m1", SideSell, 10, 202500))
+	var accepted Accepted
+	maker.expect(&accepted)
+	if accepted.OrderID == 0 || accepted.Order.Token != NewToken("m1") || accepted.Order.Price != 202500 {
+		t.Errorf("expected an acceptance of m1 with an order ID, got %+v", accepted)
+	}
+
+	ioc := limitOrder("t1", SideBuy, 4, 203000)
+	ioc.Params = uint8(tome.ParamIOC)
+	taker.send(ioc)
+	var takerAccepted Accepted
+	taker.expect(&takerAccepted)
+	var executed Executed
+	taker.expect(&executed)
+	if executed.Token != NewToken("t1") || executed.Qty != 4 || executed.Price != 203000 || executed.LeavesQty != 0 {
+		t.Errorf("expected a full execution of t1, got %+v", executed)
+	}
+	maker.expect(&executed)
+	if executed.OrderID != accepted.OrderID || executed.LeavesQty != 6 {
+		t.Errorf("expected a partial execution of m1, got %+v", executed)
+	}
+
+	taker.send(limitOrder("t1", SideBuy, 4, 203000))
+	var rejected Rejected
+	taker.expect(&rejected)
+	if rejected.Reason != ReasonDuplicateToken {
+		t.Errorf("expected a duplicate token rejection, got %+v", rejected)
+	}
+	taker.send(&CancelOrder{Token: NewToken("t1")})
+	taker.expect(&rejected)
+	if rejected.Reason != ReasonOrderNotFound {
+		t.Errorf("expected an order not found rejection, got %+v", rejected)
+	}
+	unknown := limitOrder("t2", SideBuy, 4, 203000)
+	unknown.Symbol = NewSymbol("UNKNOWN")
+	taker.send(unknown)
+	taker.expect(&rejected)
+	if rejected.Token != NewToken("t2") || rejected.Reason != ReasonUnknownInstrument {
+		t.Errorf("expected an unknown instrument rejection, got %+v", rejected)
+	}
+
+	maker.send(&ReplaceOrder{ExistingToken: NewToken("m1"), ReplacementToken: NewToken("m2"), Qty: 8, Price: 202000})
+	var replaced Replaced
+	maker.expect(&replaced)
+	if replaced.Token != NewToken("m2") || replaced.PreviousToken != NewToken("m1") || replaced.Qty != 8 || replaced.Price != 202000 {
+		t.Errorf("expected m1 to be replaced by m2, got %+v", replaced)
+	}
+
+	maker.send(&CancelOrder{Token: NewToken("m2")})
+	var canceled Canceled
+	maker.expect(&canceled)
+	if canceled.Token != NewToken("m2") || canceled.OrderID != accepted.OrderID || canceled.FilledQty != 4 {
+		t.Errorf("expected m2 to be cancelled, got %+v", canceled)
+	}
+}
@@ -0,0 +1,48 @@
+package ouch
+
+import (
+	"github.com/ffhan/tome"
+	"github.com/ffhan/tome/server"
+)
+
+// Reason of a rejected message.
+type RejectReason byte
+
+const (
+	ReasonOther             RejectReason = 'O'
+	ReasonInvalidMessage    RejectReason = 'M' // unknown or malformed message
+	ReasonNotLoggedIn       RejectReason = 'L'
+	ReasonUnknownInstrument RejectReason = 'S'
+	ReasonInvalidOrder      RejectReason = 'T' // invalid side, order type or parameters
+	ReasonInvalidQty        RejectReason = 'Z'
+	ReasonInvalidPrice      RejectReason = 'X'
+	ReasonInvalidExpireTime RejectReason = 'G'
+	ReasonOrderNotFound     RejectReason = 'N' // unknown token or the order isn't active anymore
+	ReasonDuplicateToken    RejectReason = 'D'
+)
+
+// reject reasons of server and order book errors, by error text
+var rejectReasons = map[string]RejectReason{
+	server.ErrNotLoggedIn.Error():         ReasonNotLoggedIn,
+	server.ErrUnknownMessage.Error():      ReasonInvalidMessage,
+	server.ErrUnknownInstrument.Error():   ReasonUnknownInstrument,
+	server.ErrInvalidSide.Error():         ReasonInvalidOrder,
+	server.ErrInvalidOrderType.Error():    ReasonInvalidOrder,
+	server.ErrInvalidParam.Error():        ReasonInvalidOrder,
+	server.ErrInvalidPrice.Error():        ReasonInvalidPrice,
+	tome.ErrInvalidQty.Error():            ReasonInvalidQty,
+	tome.ErrInvalidMarketPrice.Error():    ReasonInvalidPrice,
+	tome.ErrInvalidLimitPrice.Error():     ReasonInvalidPrice,
+	tome.ErrInvalidStopPrice.Error():      ReasonInvalidPrice,
+	tome.ErrInvalidPricePrecision.Error(): ReasonInvalidPrice,
+	tome.ErrPriceOutOfRange.Error():       ReasonInvalidPrice,
+	tome.ErrInvalidExpireTime.Error():     ReasonInvalidExpireTime,
+	tome.ErrOrderNotFound.Error():         ReasonOrderNotFound,
+}
+
+func rejectReason(reason string) RejectReason {
+	if r, ok := rejectReasons[reason]; ok {
+		return r
+	}
+	return ReasonOther
+}
@@ -0,0 +1,350 @@
+package ouch
+
+import (
+	"bufio"
+	"github.com/cockroachdb/apd"
+	"github.com/ffhan/tome"
+	"github.com/ffhan/tome/server"
+	"github.com/google/uuid"
+	"net"
+	"sync"
+	"time"
+)
+
+// protocol bits of order parameters and their server names
+var params = []struct {
+	param tome.OrderParams
+	name  string
+}{
+	{tome.ParamStop, "STOP"},
+	{tome.ParamAON, "AON"},
+	{tome.ParamIOC, "IOC"},
+	{tome.ParamGTC, "GTC"},
+	{tome.ParamGFD, "GFD"},
+	{tome.ParamGTD, "GTD"},
+	{tome.ParamHidden, "HIDDEN"},
+}
+
+// Session of a connection. Messages are read and processed sequentially by the session goroutine and written by a
+// separate writer goroutine, so slow sessions don't block matching.
+type session struct {
+	gateway *Gateway
+	conn    net.Conn
+	client  *server.Client // set by the login
+
+	mutex   sync.Mutex
+	out     chan []byte
+	done    chan struct{}
+	closed  bool
+	tokens  map[Token]uint64 // order IDs by token, zero for tokens of orders which aren't open anymore
+	request EnterOrder       // order which is being entered, echoed in the acceptance
+}
+
+func newSession(gateway *Gateway, conn net.Conn) *session {
+	return &session{
+		gateway: gateway,
+		conn:    conn,
+		out:     make(chan []byte, gateway.sendBuffer),
+		done:    make(chan struct{}),
+		tokens:  make(map[Token]uint64),
+	}
+}
+
+func (s *session) run() {
+	defer s.gateway.removeSession(s)
+	writerDone := make(chan struct{})
+	go s.write(writerDone)
+
+	reader := NewReader(s.conn)
+	for {
+		data, err := reader.Next()
+		if err != nil {
+			break
+		}
+		s.handle(data)
+	}
+	s.mutex.Lock()
+	if !s.closed {
+		s.closeLocked()
+	}
+	s.mutex.Unlock()
+	<-writerDone
+	if s.client != nil {
+		s.client.Disconnect()
+	}
+}
+
+// write queued messages until the session is closed, then flush the remaining ones and close the connection
+func (s *session) write(writerDone chan struct{}) {
+	defer close(writerDone)
+	defer s.conn.Close()
+	writer := bufio.NewWriter(s.conn)
+	for {
+		select {
+		case data := <-s.out:
+			if _, err := writer.Write(data); err != nil {
+				return
+			}
+			if len(s.out) == 0 && writer.Flush() != nil { // batch writes while messages are queued
+				return
+			}
+		case <-s.done:
+			for {
+				select {
+				case data := <-s.out:
+					if _, err := writer.Write(data); err != nil {
+						return
+					}
+				default:
+					writer.Flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// queue a message without waiting, a session which can't keep up is closed
+func (s *session) sendLocked(data []byte) {
+	if s.closed {
+		return
+	}
+	select {
+	case s.out <- data:
+	default:
+		s.gateway.logf("closing session %s: send buffer is full\n", s.conn.RemoteAddr())
+		s.closeLocked()
+		s.conn.Close()
+	}
+}
+
+func (s *session) closeLocked() {
+	s.closed = true
+	close(s.done)
+}
+
+func (s *session) reject(token Token, reason RejectReason) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.rejectLocked(token, reason)
+}
+
+func (s *session) rejectLocked(token Token, reason RejectReason) {
+	msg := Rejected{Timestamp: time.Now().UnixNano(), Token: token, Reason: reason}
+	s.sendLocked(msg.Append(make([]byte, 0, 2+RejectedSize)))
+}
+
+func (s *session) handle(data []byte) {
+	if data[0] == TypeLogin {
+		s.login(data)
+		return
+	}
+	if s.client == nil {
+		s.reject(Token{}, ReasonNotLoggedIn)
+		return
+	}
+	switch data[0] {
+	case TypeEnterOrder:
+		s.enterOrder(data)
+	case TypeReplaceOrder:
+		s.replaceOrder(data)
+	case TypeCancelOrder:
+		s.cancelOrder(data)
+	default:
+		s.reject(Token{}, ReasonInvalidMessage)
+	}
+}
+
+func (s *session) login(data []byte) {
+	var login Login
+	if err := login.Unmarshal(data); err != nil || s.client != nil {
+		s.reject(Token{}, ReasonInvalidMessage)
+		return
+	}
+	s.client = s.gateway.server.Connect(uuid.UUID(login.CustomerID), s.sink)
+	s.client.Reconnect()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	accepted := LoginAccepted{Timestamp: time.Now().UnixNano()}
+	s.sendLocked(accepted.Append(make([]byte, 0, 2+LoginAcceptedSize)))
+}
+
+func (s *session) enterOrder(data []byte) {
+	var order EnterOrder
+	if err := order.Unmarshal(data); err != nil {
+		s.reject(Token{}, ReasonInvalidMessage)
+		return
+	}
+	msg, ok := orderMessage(&order)
+	if !ok {
+		s.reject(order.Token, ReasonInvalidOrder)
+		return
+	}
+	s.mutex.Lock()
+	if _, ok := s.tokens[order.Token]; ok {
+		s.rejectLocked(order.Token, ReasonDuplicateToken)
+		s.mutex.Unlock()
+		return
+	}
+	s.request = order
+	s.mutex.Unlock()
+
+	s.client.Enter(msg)
+}
+
+func (s *session) replaceOrder(data []byte) {
+	var replace ReplaceOrder
+	if err := replace.Unmarshal(data); err != nil {
+		s.reject(Token{}, ReasonInvalidMessage)
+		return
+	}
+	s.mutex.Lock()
+	orderID := s.tokens[replace.ExistingToken]
+	_, duplicate := s.tokens[replace.ReplacementToken]
+	duplicate = duplicate && replace.ReplacementToken != replace.ExistingToken
+	s.mutex.Unlock()
+	switch {
+	case orderID == 0:
+		s.reject(replace.ReplacementToken, ReasonOrderNotFound)
+		return
+	case duplicate:
+		s.reject(replace.ReplacementToken, ReasonDuplicateToken)
+		return
+	}
+	s.client.Amend(server.Message{
+		Type:          server.MessageAmend,
+		OrderID:       orderID,
+		ClientOrderID: replace.ReplacementToken.String(),
+		Qty:           int64(replace.Qty),
+		Price:         priceString(replace.Price),
+	})
+}
+
+func (s *session) cancelOrder(data []byte) {
+	var cancel CancelOrder
+	if err := cancel.Unmarshal(data); err != nil {
+		s.reject(Token{}, ReasonInvalidMessage)
+		return
+	}
+	s.mutex.Lock()
+	orderID := s.tokens[cancel.Token]
+	s.mutex.Unlock()
+	if orderID == 0 {
+		s.reject(cancel.Token, ReasonOrderNotFound)
+		return
+	}
+	s.client.Cancel(server.Message{Type: server.MessageCancel, OrderID: orderID, ClientOrderID: cancel.Token.String()})
+}
+
+// Receives messages of session orders from the server. Called synchronously while matching, so it only encodes the
+// message and queues it.
+func (s *session) sink(m server.Message) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now().UnixNano()
+	token := NewToken(m.ClientOrderID)
+	switch m.Type {
+	case server.MessageAccepted:
+		s.tokens[token] = m.OrderID
+		accepted := Accepted{Timestamp: now, OrderID: m.OrderID, Order: s.request}
+		s.sendLocked(accepted.Append(make([]byte, 0, 2+AcceptedSize)))
+	case server.MessageFill:
+		if m.LeavesQty == 0 {
+			s.tokens[token] = 0
+		}
+		executed := Executed{
+			Timestamp:   now,
+			Token:       token,
+			OrderID:     m.OrderID,
+			MatchNumber: m.TradeID,
+			Qty:         uint32(m.LastQty),
+			Price:       fixedPrice(m.LastPrice),
+			LeavesQty:   uint32(m.LeavesQty),
+		}
+		s.sendLocked(executed.Append(make([]byte, 0, 2+ExecutedSize)))
+	case server.MessageCancelled:
+		s.tokens[token] = 0
+		canceled := Canceled{Timestamp: now, Token: token, OrderID: m.OrderID, FilledQty: uint32(m.FilledQty)}
+		s.sendLocked(canceled.Append(make([]byte, 0, 2+CanceledSize)))
+	case server.MessageAmended:
+		previous := NewToken(m.OrigClientOrderID)
+		s.tokens[previous] = 0
+		s.tokens[token] = m.OrderID
+		replaced := Replaced{
+			Timestamp:     now,
+			Token:         token,
+			PreviousToken: previous,
+			OrderID:       m.OrderID,
+			Qty:           uint32(m.Qty),
+			Price:         fixedPrice(m.Price),
+		}
+		s.sendLocked(replaced.Append(make([]byte, 0, 2+ReplacedSize)))
+	case server.MessageRejected:
+		s.rejectLocked(token, rejectReason(m.Reason))
+	}
+}
+
+// convert an entered order to a server message, returns false if the order has unknown values
+func orderMessage(order *EnterOrder) (server.Message, bool) {
+	msg := server.Message{
+		Type:          server.MessageNew,
+		ClientOrderID: order.Token.String(),
+		Instrument:    order.Symbol.String(),
+		Qty:           int64(order.Qty),
+		Price:         priceString(order.Price),
+		StopPrice:     priceString(order.StopPrice),
+		DisplayQty:    int64(order.DisplayQty),
+	}
+	switch order.Side {
+	case SideBuy:
+		msg.Side = tome.SideBuy.String()
+	case SideSell:
+		msg.Side = tome.SideSell.String()
+	default:
+		return msg, false
+	}
+	switch order.OrderType {
+	case OrderTypeMarket:
+		msg.OrderType = tome.TypeMarket.String()
+	case OrderTypeLimit:
+		msg.OrderType = tome.TypeLimit.String()
+	default:
+		return msg, false
+	}
+	remaining := tome.OrderParams(order.Params)
+	for _, p := range params {
+		if remaining.Is(p.param) {
+			msg.Params = append(msg.Params, p.name)
+			remaining &^= p.param
+		}
+	}
+	if remaining != 0 {
+		return msg, false
+	}
+	if order.ExpireTime != 0 {
+		expireTime := time.Unix(0, order.ExpireTime)
+		msg.ExpireTime = &expireTime
+	}
+	return msg, true
+}
+
+// format a protocol price as a decimal, zero is an empty price
+func priceString(price int64) string {
+	if price == 0 {
+		return ""
+	}
+	d := tome.FromFixedPrice(price, PriceScale)
+	return d.String()
+}
+
+// parse a decimal price as a protocol price
+func fixedPrice(price string) int64 {
+	d, _, err := apd.NewFromString(price)
+	if err != nil {
+		return 0
+	}
+	fixed, _ := tome.ToFixedPrice(*d, PriceScale)
+	return fixed
+}
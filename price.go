@@ -0,0 +1,52 @@
+package tome
+
+import (
+	"errors"
+	"github.com/cockroachdb/apd"
+	"math/big"
+)
+
+// Default number of decimal places of fixed-point prices.
+const DefaultPriceScale int32 = 4
+
+var (
+	ErrInvalidPricePrecision = errors.New("price has more decimal places than the instrument supports")
+	ErrPriceOutOfRange       = errors.New("price is out of the supported range")
+)
+
+// Convert a decimal price to a fixed-point price - an integer number of 10^-scale units (e.g. 20.25 with scale 4 is
+// 202500). Conversion is exact, prices with more than scale decimal places return ErrInvalidPricePrecision.
+func ToFixedPrice(price apd.Decimal, scale int32) (int64, error) {
+	if price.Form != apd.Finite {
+		return 0, ErrPriceOutOfRange
+	}
+	var coeff big.Int
+	coeff.Set(&price.Coeff)
+
+	shift := int64(price.Exponent) + int64(scale)
+	if shift >= 0 {
+		coeff.Mul(&coeff, pow10(shift))
+	} else {
+		var remainder big.Int
+		coeff.QuoRem(&coeff, pow10(-shift), &remainder)
+		if remainder.Sign() != 0 {
+			return 0, ErrInvalidPricePrecision
+		}
+	}
+	if price.Negative {
+		coeff.Neg(&coeff)
+	}
+	if !coeff.IsInt64() {
+		return 0, ErrPriceOutOfRange
+	}
+	return coeff.Int64(), nil
+}
+
+// Convert a fixed-point price with scale decimal places to a decimal price.
+func FromFixedPrice(price int64, scale int32) apd.Decimal {
+	return *apd.New(price, -scale)
+}
+
+func pow10(exp int64) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(exp), nil)
+}
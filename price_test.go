@@ -0,0 +1,70 @@
+package tome
+
+import (
+	"github.com/cockroachdb/apd"
+	"testing"
+)
+
+func TestToFixedPrice(t *testing.T) {
+	tests := []struct {
+		price    string
+		scale    int32
+		expected int64
+		err      error
+	}{
+		{"20.25", 4, 202500, nil},
+		{"20.2500", 4, 202500, nil},
+		{"2E+3", 2, 200000, nil},
+		{"-0.5", 1, -5, nil},
+		{"0", 4, 0, nil},
+		{"20.12345", 4, 0, ErrInvalidPricePrecision},
+		{"20.12340", 4, 201234, nil},
+		{"1234567.0000000001", 10, 12345670000000001, nil},
+		{"1E+20", 4, 0, ErrPriceOutOfRange},
+	}
+	for _, test := range tests {
+		price, _, err := apd.NewFromString(test.price)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fixed, err := ToFixedPrice(*price, test.scale)
+		if err != test.err {
+			t.Errorf("%s: expected error %v, got %v", test.price, test.err, err)
+			continue
+		}
+		if fixed != test.expected {
+			t.Errorf("%s: expected %d, got %d", test.price, test.expected, fixed)
+		}
+		if err != nil {
+			continue
+		}
+		decimal := FromFixedPrice(fixed, test.scale)
+		if decimal.Cmp(price) != 0 {
+			t.Errorf("%s: expected round trip to return the same price, got %s", test.price, decimal.String())
+		}
+	}
+}
+
+func TestFixedPrice_Ordering(t *testing.T) {
+	// prices differ beyond float64 precision
+	a, _, _ := apd.NewFromString("1234567.0000000001")
+	b, _, _ := apd.NewFromString("1234567.0000000002")
+	fa, _ := a.Float64()
+	fb, _ := b.Float64()
+	if fa != fb {
+		t.Skip("float64 prices can be distinguished, test is meaningless")
+	}
+
+	fixedA, err := ToFixedPrice(*a, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixedB, err := ToFixedPrice(*b, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	less := makeComparator(false)
+	if !less(OrderTracker{Type: TypeLimit, Price: fixedA, Timestamp: 2}, OrderTracker{Type: TypeLimit, Price: fixedB, Timestamp: 1}) {
+		t.Error("expected the lower ask to be sorted first")
+	}
+}
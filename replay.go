@@ -0,0 +1,284 @@
+package tome
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Simulated clock which returns a manually set time. Used to replay journals deterministically.
+type SimulatedClock struct {
+	mutex sync.RWMutex
+	now   time.Time
+}
+
+// Returns the current simulated time.
+func (c *SimulatedClock) Now() time.Time {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.now
+}
+
+// Set the current simulated time.
+func (c *SimulatedClock) Set(now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = now
+}
+
+// Statistics of a replayed journal.
+type ReplayResult struct {
+	Commands     int    // number of replayed commands
+	Trades       int    // number of verified trades
+	Orders       int    // number of active orders in the final book
+	LastSequence uint64 // sequence of the last replayed record
+}
+
+// Returned when a replayed order book doesn't produce the same records as the recorded one.
+type ReplayMismatchError struct {
+	Sequence uint64 // sequence of the recorded record, 0 if the mismatch is in the final order book state
+	Reason   string
+}
+
+func (e *ReplayMismatchError) Error() string {
+	if e.Sequence == 0 {
+		return fmt.Sprintf("replay mismatch in the final order book: %s", e.Reason)
+	}
+	return fmt.Sprintf("replay mismatch at sequence %d: %s", e.Sequence, e.Reason)
+}
+
+// Replay all commands from a journal into a fresh order book.
+//
+// The order book has to be created with the clock (WithClock(clock.Now)) and the same instrument, market price and
+// options as the recorded order book. Every record produced by the order book (commands, trades and order updates) is
+// compared byte-for-byte with the recorded one and the final state of all active orders is compared with their last
+// recorded state. Returns a *ReplayMismatchError on the first difference.
+func Replay(r io.Reader, book *OrderBook, clock *SimulatedClock) (ReplayResult, error) {
+	return ReplayFrom(r, 0, book, clock)
+}
+
+// Replay commands with sequence numbers larger than after, e.g. a journal tail after restoring a snapshot taken at
+// sequence after (see RestoreOrderBook). Records up to and including after are skipped and orders which are already in
+// the book are expected to be active at the end unless the journal tail says otherwise.
+func ReplayFrom(r io.Reader, after uint64, book *OrderBook, clock *SimulatedClock) (ReplayResult, error) {
+	v := &replayVerifier{reader: NewJournalReader(r), lastStates: make(map[uint64][]byte), sequence: after}
+	book.orderMutex.RLock()
+	for id, order := range book.activeOrders { // orders restored from a snapshot
+		v.lastStates[id] = mustMarshal(order)
+	}
+	book.orderMutex.RUnlock()
+	for {
+		record, err := v.read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return v.result, err
+		}
+		if record.Sequence > after {
+			v.pending = &record
+			break
+		}
+	}
+
+	journal := book.journal
+	book.journal = v
+	defer func() {
+		book.journal = journal
+	}()
+
+	for v.pending != nil {
+		command := *v.pending
+		if !command.Type.IsCommand() {
+			return v.result, v.mismatch(command.Sequence, "expected a command, got %s", command.Type)
+		}
+		clock.Set(command.Timestamp)
+		if err := v.apply(book, command); err != nil {
+			return v.result, err
+		}
+		if v.err != nil {
+			return v.result, v.err
+		}
+		if v.pending != nil && v.pending.Sequence == command.Sequence {
+			return v.result, v.mismatch(command.Sequence, "command %s wasn't journaled", command.Type)
+		}
+		v.result.Commands += 1
+		v.result.LastSequence = command.Sequence
+
+		// events recorded after the command which the replayed order book didn't produce
+		if v.pending == nil {
+			next, err := v.read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return v.result, err
+			}
+			v.pending = &next
+		}
+	}
+	if v.err != nil {
+		return v.result, v.err
+	}
+	return v.result, v.verifyBook(book)
+}
+
+// Replays commands and compares journal records produced by an order book with the recorded ones.
+type replayVerifier struct {
+	reader     *JournalReader
+	pending    *JournalRecord    // next recorded record which hasn't been matched
+	lastStates map[uint64][]byte // last recorded state of each order
+	result     ReplayResult
+	sequence   uint64 // sequence of the last matched record
+	err        error  // first mismatch, order book errors can hide it
+}
+
+func (v *replayVerifier) read() (JournalRecord, error) {
+	record, err := v.reader.Next()
+	if err != nil {
+		return record, err
+	}
+	if record.Type == RecordOrderUpdate {
+		order, err := record.Order()
+		if err != nil {
+			return record, err
+		}
+		v.lastStates[order.ID] = record.Payload
+	}
+	return record, nil
+}
+
+// apply a recorded command to the order book, order book errors are expected - they happened when recording too
+func (v *replayVerifier) apply(book *OrderBook, command JournalRecord) error {
+	switch command.Type {
+	case RecordAddOrder:
+		order, err := command.Order()
+		if err != nil {
+			return err
+		}
+		_, _ = book.Add(order)
+	case RecordCancelOrder:
+		id, err := command.OrderID()
+		if err != nil {
+			return err
+		}
+		_ = book.Cancel(id)
+	case RecordAmendOrder:
+		id, qty, price, err := command.Amendment()
+		if err != nil {
+			return err
+		}
+		_, _ = book.Amend(id, qty, price)
+	case RecordSetMarketPrice:
+		price, err := command.Price()
+		if err != nil {
+			return err
+		}
+		_ = book.SetMarketPrice(price)
+	case RecordCancelCustomerOrders:
+		customerID, err := command.CustomerID()
+		if err != nil {
+			return err
+		}
+		_, _ = book.CancelCustomerOrders(customerID)
+	case RecordExpireOrders:
+		_, _ = book.ExpireOrders()
+	case RecordCancelOrders:
+		ids, err := command.OrderIDs()
+		if err != nil {
+			return err
+		}
+		_, _ = book.CancelOrders(ids)
+	default:
+		return v.mismatch(command.Sequence, "unknown command %s", command.Type)
+	}
+	return nil
+}
+
+// Append compares a record produced by the replayed order book with the next recorded record.
+func (v *replayVerifier) Append(recordType RecordType, timestamp time.Time, payload []byte) (uint64, error) {
+	if v.err != nil {
+		return 0, v.err
+	}
+	if v.pending == nil {
+		next, err := v.read()
+		if err == io.EOF {
+			v.err = v.mismatch(v.result.LastSequence+1, "unexpected %s, the journal has ended", recordType)
+			return 0, v.err
+		}
+		if err != nil {
+			v.err = err
+			return 0, err
+		}
+		v.pending = &next
+	}
+	expected := *v.pending
+	v.pending = nil
+	switch {
+	case expected.Type != recordType:
+		v.err = v.mismatch(expected.Sequence, "expected %s, got %s", expected.Type, recordType)
+	case !expected.Timestamp.Equal(timestamp):
+		v.err = v.mismatch(expected.Sequence, "expected timestamp %s, got %s", expected.Timestamp, timestamp)
+	case !bytes.Equal(expected.Payload, payload):
+		v.err = v.mismatch(expected.Sequence, "%s payloads are different", recordType)
+	}
+	if v.err != nil {
+		return 0, v.err
+	}
+	if recordType == RecordTrade {
+		v.result.Trades += 1
+	}
+	v.sequence = expected.Sequence
+	return expected.Sequence, nil
+}
+
+// Sequence returns the sequence of the last matched record.
+func (v *replayVerifier) Sequence() uint64 {
+	return v.sequence
+}
+
+// compare active orders of the order book with the last recorded order states
+func (v *replayVerifier) verifyBook(book *OrderBook) error {
+	expected := make([]Order, 0)
+	for _, payload := range v.lastStates {
+		var order Order
+		if err := order.UnmarshalBinary(payload); err != nil {
+			return err
+		}
+		if !order.IsCancelled() && !order.IsFilled() {
+			expected = append(expected, order)
+		}
+	}
+
+	book.orderMutex.RLock()
+	defer book.orderMutex.RUnlock()
+	v.result.Orders = len(book.activeOrders)
+	if len(book.activeOrders) != len(expected) {
+		return v.mismatch(0, "expected %d active orders, got %d", len(expected), len(book.activeOrders))
+	}
+	sort.Slice(expected, func(i, j int) bool {
+		return expected[i].ID < expected[j].ID
+	})
+	for _, order := range expected {
+		active, ok := book.activeOrders[order.ID]
+		if !ok {
+			return v.mismatch(0, "order %d isn't active", order.ID)
+		}
+		if !bytes.Equal(v.lastStates[order.ID], mustMarshal(active)) {
+			return v.mismatch(0, "order %d state is different", order.ID)
+		}
+	}
+	return nil
+}
+
+func (v *replayVerifier) mismatch(sequence uint64, format string, args ...interface{}) error {
+	return &ReplayMismatchError{Sequence: sequence, Reason: fmt.Sprintf(format, args...)}
+}
+
+func mustMarshal(order Order) []byte {
+	payload, _ := order.MarshalBinary()
+	return payload
+}
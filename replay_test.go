@@ -0,0 +1,119 @@
+package tome
+
+import (
+	"github.com/cockroachdb/apd"
+	"github.com/google/uuid"
+	"os"
+	"testing"
+	"time"
+)
+
+// records a session with partial fills, cancellations, an amendment, a stop order activation and a GTD expiry
+func recordSession(t *testing.T) string {
+	j, path := openTestJournal(t, JournalConfig{Sync: SyncNone})
+	clock := &SimulatedClock{}
+	clock.Set(time.Unix(1600000000, 0))
+	ob := NewOrderBook(instrument, *apd.New(2025, -2), NewTradeBook(instrument), NOPOrderRepository,
+		WithJournal(j), WithClock(clock.Now))
+
+	orders := []Order{
+		createOrder(1, TypeLimit, 0, 5, *apd.New(2010, -2), apd.Decimal{}, SideSell),
+		createOrder(2, TypeLimit, 0, 5, *apd.New(2010, -2), apd.Decimal{}, SideSell),
+		createOrder(3, TypeLimit, 0, 5, *apd.New(2011, -2), apd.Decimal{}, SideSell),
+		createOrder(4, TypeLimit, ParamStop, 2, *apd.New(2012, -2), *apd.New(2010, -2), SideBuy),
+		createOrder(5, TypeLimit, 0, 6, *apd.New(2010, -2), apd.Decimal{}, SideBuy),
+	}
+	for _, order := range orders {
+		clock.Set(clock.Now().Add(time.Second))
+		if _, err := ob.Add(order); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clock.Set(clock.Now().Add(time.Second))
+	if _, err := ob.Amend(2, 4, *apd.New(2010, -2)); err != nil {
+		t.Fatal(err)
+	}
+	clock.Set(clock.Now().Add(time.Second))
+	if err := ob.Cancel(3); err != nil {
+		t.Fatal(err)
+	}
+	clock.Set(clock.Now().Add(time.Second))
+	if err := ob.Cancel(42); err != nil { // unknown orders are journaled too
+		t.Fatal(err)
+	}
+	clock.Set(clock.Now().Add(time.Second))
+	if _, err := ob.CancelCustomerOrders(uuid.New()); err != nil {
+		t.Fatal(err)
+	}
+	clock.Set(clock.Now().Add(time.Second))
+	gtd := createOrder(6, TypeLimit, ParamGTD, 3, *apd.New(2030, -2), apd.Decimal{}, SideSell)
+	gtd.ExpireTime = clock.Now().Add(2 * time.Second)
+	if _, err := ob.Add(gtd); err != nil {
+		t.Fatal(err)
+	}
+	clock.Set(clock.Now().Add(time.Second))
+	if _, err := ob.CancelOrders([]uint64{42, 43}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ { // only the second expiry is journaled
+		clock.Set(clock.Now().Add(time.Second))
+		if _, err := ob.ExpireOrders(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReplay(t *testing.T) {
+	path := recordSession(t)
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	clock := &SimulatedClock{}
+	tb := NewTradeBook(instrument)
+	ob := NewOrderBook(instrument, *apd.New(2025, -2), tb, NOPOrderRepository, WithClock(clock.Now))
+	result, err := Replay(file, ob, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Commands != 12 {
+		t.Errorf("expected 12 commands, got %d", result.Commands)
+	}
+	if result.Trades != len(tb.trades) || result.Trades != 3 {
+		t.Errorf("expected 3 trades, got %d verified and %d in the trade book", result.Trades, len(tb.trades))
+	}
+	if result.Orders != 1 {
+		t.Errorf("expected 1 active order, got %d", result.Orders)
+	}
+	if ob.journal != nil {
+		t.Errorf("expected the original journal to be restored")
+	}
+}
+
+func TestReplay_Mismatch(t *testing.T) {
+	path := recordSession(t)
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	// a different allocation algorithm produces different trades
+	clock := &SimulatedClock{}
+	ob := NewOrderBook(instrument, *apd.New(2025, -2), NewTradeBook(instrument), NOPOrderRepository,
+		WithClock(clock.Now), WithAllocator(ProRataAllocator{}))
+	_, err = Replay(file, ob, clock)
+	mismatch, ok := err.(*ReplayMismatchError)
+	if !ok {
+		t.Fatalf("expected a replay mismatch, got %v", err)
+	}
+	if mismatch.Sequence == 0 {
+		t.Errorf("expected the mismatch to be found before the final order book check")
+	}
+}
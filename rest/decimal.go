@@ -0,0 +1,37 @@
+package rest
+
+import (
+	"bytes"
+	"errors"
+	"github.com/cockroachdb/apd"
+	"strconv"
+)
+
+var ErrInvalidDecimal = errors.New("invalid decimal")
+
+// Decimal encoded in JSON as a string (e.g. "20.25"), so prices never pass through floating point numbers. Decoding
+// also accepts JSON numbers, which are parsed from their literal text.
+type Decimal struct {
+	apd.Decimal
+}
+
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(d.Text('f'))), nil
+}
+
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	text := string(data)
+	if len(data) > 0 && data[0] == '"' {
+		var err error
+		if text, err = strconv.Unquote(text); err != nil {
+			return ErrInvalidDecimal
+		}
+	}
+	if _, _, err := d.SetString(text); err != nil || d.Form != apd.Finite {
+		return ErrInvalidDecimal
+	}
+	return nil
+}
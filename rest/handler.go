@@ -0,0 +1,379 @@
+// Package rest is an HTTP/JSON API of order books registered with an order entry server.
+//
+// Orders are entered, amended and cancelled through the server on behalf of the customer in the X-Customer-ID header,
+// so order IDs and ownership are shared with other gateways. Market data (depth, trades, market price, statistics) is
+// read from the order books. Decimals are encoded as JSON strings, see openapi.yaml for the full API description.
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/ffhan/tome"
+	"github.com/ffhan/tome/server"
+	"github.com/google/uuid"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Header with the customer ID of order requests.
+const CustomerIDHeader = "X-Customer-ID"
+
+var (
+	ErrNotFound         = errors.New("not found")
+	ErrMethodNotAllowed = errors.New("method not allowed")
+	ErrInvalidOrderID   = errors.New("invalid order ID")
+	ErrInvalidLevels    = errors.New("levels has to be a non-negative integer")
+	ErrInvalidTime      = errors.New("time has to be in the RFC 3339 format")
+)
+
+// HTTP handler of the API.
+type Handler struct {
+	server *server.Server
+	orders tome.OrderRepository // optional, used to find orders which aren't active
+
+	mutex     sync.Mutex
+	customers map[uuid.UUID]*customer
+}
+
+// Option changes the default behaviour of a handler.
+type Option func(h *Handler)
+
+// Look up orders which aren't active anymore in the repository.
+func WithOrderRepository(repo tome.OrderRepository) Option {
+	return func(h *Handler) {
+		h.orders = repo
+	}
+}
+
+// Create a handler of order books registered with the server.
+func New(srv *server.Server, opts ...Option) *Handler {
+	h := &Handler{server: srv, customers: make(map[uuid.UUID]*customer)}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// Server client of a customer. Requests of a customer are processed sequentially and collect the messages they cause.
+type customer struct {
+	request sync.Mutex
+	client  *server.Client
+
+	mutex    sync.Mutex
+	capture  bool
+	messages []server.Message
+}
+
+func (c *customer) sink(msg server.Message) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.capture {
+		c.messages = append(c.messages, msg)
+	}
+}
+
+// process a request, returns messages sent while it was processed
+func (c *customer) do(request func(client *server.Client)) []server.Message {
+	c.request.Lock()
+	defer c.request.Unlock()
+
+	c.mutex.Lock()
+	c.capture = true
+	c.mutex.Unlock()
+
+	request(c.client)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	messages := c.messages
+	c.capture, c.messages = false, nil
+	return messages
+}
+
+func (h *Handler) customer(customerID uuid.UUID) *customer {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	c, ok := h.customers[customerID]
+	if !ok {
+		c = &customer{}
+		c.client = h.server.Connect(customerID, c.sink)
+		h.customers[customerID] = c
+	}
+	return c
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "orders":
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed)
+			return
+		}
+		h.enter(w, r)
+	case len(parts) == 2 && parts[0] == "orders":
+		orderID, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrInvalidOrderID)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			h.order(w, r, orderID)
+		case http.MethodDelete:
+			h.cancel(w, r, orderID)
+		case http.MethodPatch:
+			h.amend(w, r, orderID)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed)
+		}
+	case len(parts) == 3 && parts[0] == "instruments":
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed)
+			return
+		}
+		book, ok := h.server.Book(parts[1])
+		if !ok {
+			writeError(w, http.StatusNotFound, server.ErrUnknownInstrument)
+			return
+		}
+		switch parts[2] {
+		case "depth":
+			h.depth(w, r, book)
+		case "trades":
+			h.trades(w, r, book)
+		case "price":
+			writeJSON(w, http.StatusOK, MarketPrice{Instrument: book.Instrument, Price: Decimal{book.MarketPrice()}})
+		case "statistics":
+			writeJSON(w, http.StatusOK, newStatistics(book.Instrument, book.Statistics()))
+		default:
+			writeError(w, http.StatusNotFound, ErrNotFound)
+		}
+	default:
+		writeError(w, http.StatusNotFound, ErrNotFound)
+	}
+}
+
+// returns the customer of an order request, writes an error if the customer ID is invalid
+func (h *Handler) requestCustomer(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	customerID, err := uuid.Parse(r.Header.Get(CustomerIDHeader))
+	if err != nil || customerID == uuid.Nil {
+		writeError(w, http.StatusBadRequest, server.ErrInvalidCustomerID)
+		return uuid.Nil, false
+	}
+	return customerID, true
+}
+
+func (h *Handler) enter(w http.ResponseWriter, r *http.Request) {
+	customerID, ok := h.requestCustomer(w, r)
+	if !ok {
+		return
+	}
+	var order NewOrder
+	if !readJSON(w, r, &order) {
+		return
+	}
+	var orderID uint64
+	messages := h.customer(customerID).do(func(client *server.Client) {
+		orderID = client.Enter(order.message())
+	})
+	response, rejected := orderResponse(OrderResponse{OrderID: orderID, ClientOrderID: order.ClientOrderID, Qty: order.Qty}, server.MessageNew, messages)
+	if rejected != nil {
+		writeRejection(w, rejected)
+		return
+	}
+	w.Header().Set("Location", "/orders/"+strconv.FormatUint(orderID, 10))
+	writeJSON(w, http.StatusCreated, response)
+}
+
+func (h *Handler) cancel(w http.ResponseWriter, r *http.Request, orderID uint64) {
+	customerID, ok := h.requestCustomer(w, r)
+	if !ok {
+		return
+	}
+	order, ok := h.activeOrder(orderID, customerID)
+	if !ok {
+		writeError(w, http.StatusNotFound, tome.ErrOrderNotFound)
+		return
+	}
+	messages := h.customer(customerID).do(func(client *server.Client) {
+		client.Cancel(server.Message{Type: server.MessageCancel, OrderID: orderID})
+	})
+	response, rejected := orderResponse(OrderResponse{OrderID: orderID, Qty: order.Qty, FilledQty: order.FilledQty}, server.MessageCancel, messages)
+	if rejected != nil {
+		writeRejection(w, rejected)
+		return
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (h *Handler) amend(w http.ResponseWriter, r *http.Request, orderID uint64) {
+	customerID, ok := h.requestCustomer(w, r)
+	if !ok {
+		return
+	}
+	var amendment Amendment
+	if !readJSON(w, r, &amendment) {
+		return
+	}
+	order, ok := h.activeOrder(orderID, customerID)
+	if !ok {
+		writeError(w, http.StatusNotFound, tome.ErrOrderNotFound)
+		return
+	}
+	price := decimalString(amendment.Price)
+	if amendment.Price == nil && !order.Price.IsZero() {
+		price = order.Price.Text('f')
+	}
+	messages := h.customer(customerID).do(func(client *server.Client) {
+		client.Amend(server.Message{
+			Type:          server.MessageAmend,
+			OrderID:       orderID,
+			ClientOrderID: amendment.ClientOrderID,
+			Qty:           amendment.Qty,
+			Price:         price,
+		})
+	})
+	response, rejected := orderResponse(OrderResponse{OrderID: orderID, Qty: order.Qty, FilledQty: order.FilledQty}, server.MessageAmend, messages)
+	if rejected != nil {
+		writeRejection(w, rejected)
+		return
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (h *Handler) order(w http.ResponseWriter, r *http.Request, orderID uint64) {
+	customerID, ok := h.requestCustomer(w, r)
+	if !ok {
+		return
+	}
+	if order, ok := h.activeOrder(orderID, customerID); ok {
+		writeJSON(w, http.StatusOK, newOrder(order))
+		return
+	}
+	if h.orders != nil {
+		if order, err := h.orders.GetByID(orderID); err == nil && order.CustomerID == customerID {
+			writeJSON(w, http.StatusOK, newOrder(order))
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, tome.ErrOrderNotFound)
+}
+
+// returns an active order of a customer
+func (h *Handler) activeOrder(orderID uint64, customerID uuid.UUID) (tome.Order, bool) {
+	for _, book := range h.server.Books() {
+		if order, ok := book.GetOrder(orderID); ok {
+			return order, order.CustomerID == customerID
+		}
+	}
+	return tome.Order{}, false
+}
+
+func (h *Handler) depth(w http.ResponseWriter, r *http.Request, book *tome.OrderBook) {
+	levels := 0
+	if value := r.URL.Query().Get("levels"); value != "" {
+		var err error
+		if levels, err = strconv.Atoi(value); err != nil || levels < 0 {
+			writeError(w, http.StatusBadRequest, ErrInvalidLevels)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, newDepth(book.Instrument, book.Depth(levels)))
+}
+
+// trades of the day in the [from, to) time range
+func (h *Handler) trades(w http.ResponseWriter, r *http.Request, book *tome.OrderBook) {
+	var from, to time.Time
+	for name, t := range map[string]*time.Time{"from": &from, "to": &to} {
+		if value := r.URL.Query().Get(name); value != "" {
+			var err error
+			if *t, err = time.Parse(time.RFC3339Nano, value); err != nil {
+				writeError(w, http.StatusBadRequest, ErrInvalidTime)
+				return
+			}
+		}
+	}
+	trades := []Trade{}
+	for _, trade := range book.TradeBook().DailyTrades() {
+		if (!from.IsZero() && trade.Timestamp.Before(from)) || (!to.IsZero() && !trade.Timestamp.Before(to)) {
+			continue
+		}
+		trades = append(trades, newTrade(trade))
+	}
+	writeJSON(w, http.StatusOK, trades)
+}
+
+// Apply messages of an order request to the order state. Returns the rejection of the request if it was rejected.
+func orderResponse(response OrderResponse, requestType server.MessageType, messages []server.Message) (OrderResponse, *server.Message) {
+	cancelled := false
+	for _, msg := range messages {
+		if msg.Type == server.MessageRejected && msg.RefType == requestType && (msg.OrderID == response.OrderID || msg.OrderID == 0) {
+			return response, &msg
+		}
+		if msg.OrderID != response.OrderID {
+			continue
+		}
+		switch msg.Type {
+		case server.MessageAccepted:
+			response.ClientOrderID = msg.ClientOrderID
+		case server.MessageFill:
+			var price Decimal
+			if _, _, err := price.SetString(msg.LastPrice); err != nil {
+				continue
+			}
+			response.Fills = append(response.Fills, Fill{TradeID: msg.TradeID, Qty: msg.LastQty, Price: price})
+			response.FilledQty = msg.FilledQty
+		case server.MessageAmended:
+			response.ClientOrderID, response.Qty = msg.ClientOrderID, msg.Qty
+		case server.MessageCancelled:
+			response.ClientOrderID, response.FilledQty = msg.ClientOrderID, msg.FilledQty
+			cancelled = true
+		}
+	}
+	switch {
+	case cancelled:
+		response.Status = tome.StatusCancelled.String()
+	case response.FilledQty >= response.Qty:
+		response.Status = tome.StatusFilled.String()
+	case response.FilledQty > 0:
+		response.Status = tome.StatusPartiallyFilled.String()
+	default:
+		response.Status = tome.StatusNew.String()
+	}
+	if !cancelled {
+		response.LeavesQty = response.Qty - response.FilledQty
+	}
+	return response, nil
+}
+
+func writeRejection(w http.ResponseWriter, rejected *server.Message) {
+	status := http.StatusUnprocessableEntity
+	if rejected.Reason == tome.ErrOrderNotFound.Error() {
+		status = http.StatusNotFound
+	}
+	writeJSON(w, status, Error{Error: rejected.Reason})
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, Error{Error: err.Error()})
+}
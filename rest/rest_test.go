@@ -0,0 +1,206 @@
+package rest
+
+import (
+	"encoding/json"
+	"github.com/cockroachdb/apd"
+	"github.com/ffhan/tome"
+	"github.com/ffhan/tome/server"
+	"github.com/google/uuid"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const instrument = "TEST"
+
+type testClient struct {
+	t          *testing.T
+	url        string
+	customerID uuid.UUID
+}
+
+func setup(t *testing.T) (*httptest.Server, *tome.OrderBook) {
+	srv := server.New()
+	tb := tome.NewTradeBook(instrument, tome.WithTradeCallback(srv))
+	ob := tome.NewOrderBook(instrument, *apd.New(2000, -2), tb, tome.NOPOrderRepository)
+	srv.Register(ob)
+	ts := httptest.NewServer(New(srv))
+	t.Cleanup(ts.Close)
+	return ts, ob
+}
+
+func connect(t *testing.T, ts *httptest.Server) *testClient {
+	return &testClient{t: t, url: ts.URL, customerID: uuid.New()}
+}
+
+// send a request and decode the response into v, returns the status code
+func (c *testClient) do(method, path, body string, v interface{}) int {
+	c.t.Helper()
+	return c.request(method, path, body, v).StatusCode
+}
+
+// send a request and decode the response into v
+func (c *testClient) request(method, path, body string, v interface{}) *http.Response {
+	c.t.Helper()
+	req, err := http.NewRequest(method, c.url+path, strings.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header.Set(CustomerIDHeader, c.customerID.String())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+	return resp
+}
+
+func TestHandler_Orders(t *testing.T) {
+	ts, _ := setup(t)
+	seller, buyer := connect(t, ts), connect(t, ts)
+
+	var sell OrderResponse
+	resp := seller.request(http.MethodPost, "/orders", `{"client_order_id":"s1","instrument":"TEST","type":"Limit","side":"SELL","qty":10,"price":"20.25"}`, &sell)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d", resp.StatusCode)
+	}
+	path := "/orders/" + strconv.FormatUint(sell.OrderID, 10)
+	if location := resp.Header.Get("Location"); location != path {
+		t.Errorf("expected location %s, got %s", path, location)
+	}
+	if sell.ClientOrderID != "s1" || sell.Status != "New" || sell.LeavesQty != 10 {
+		t.Errorf("expected a new order s1 with 10 leaves, got %+v", sell)
+	}
+
+	var buy OrderResponse
+	if status := buyer.do(http.MethodPost, "/orders", `{"instrument":"TEST","type":"Limit","side":"BUY","qty":4,"price":20.30}`, &buy); status != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d", status)
+	}
+	if buy.Status != "Filled" || buy.FilledQty != 4 || len(buy.Fills) != 1 || buy.Fills[0].Price.Text('f') != "20.30" {
+		t.Errorf("expected a fill of 4 at 20.30, got %+v", buy)
+	}
+
+	var order Order
+	if status := seller.do(http.MethodGet, path, "", &order); status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", status)
+	}
+	if order.Status != "PartiallyFilled" || order.FilledQty != 4 || order.Price.Text('f') != "20.25" {
+		t.Errorf("expected a partially filled order, got %+v", order)
+	}
+	var e Error
+	if status := buyer.do(http.MethodGet, path, "", &e); status != http.StatusNotFound {
+		t.Errorf("expected orders of other customers to be hidden, got %d", status)
+	}
+	if status := buyer.do(http.MethodDelete, path, "", &e); status != http.StatusNotFound {
+		t.Errorf("expected orders of other customers not to be cancelled, got %d", status)
+	}
+
+	var amended OrderResponse
+	if status := seller.do(http.MethodPatch, path, `{"client_order_id":"s2","qty":8}`, &amended); status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", status)
+	}
+	if amended.ClientOrderID != "s2" || amended.Qty != 8 || amended.LeavesQty != 4 {
+		t.Errorf("expected an amended order s2 with 4 leaves, got %+v", amended)
+	}
+
+	var cancelled OrderResponse
+	if status := seller.do(http.MethodDelete, path, "", &cancelled); status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", status)
+	}
+	if cancelled.Status != "Cancelled" || cancelled.FilledQty != 4 || cancelled.LeavesQty != 0 {
+		t.Errorf("expected a cancelled order, got %+v", cancelled)
+	}
+	if status := seller.do(http.MethodGet, path, "", &e); status != http.StatusNotFound {
+		t.Errorf("expected inactive orders to be missing without a repository, got %d", status)
+	}
+}
+
+func TestHandler_Errors(t *testing.T) {
+	ts, _ := setup(t)
+	c := connect(t, ts)
+
+	tests := []struct {
+		name         string
+		method, path string
+		body         string
+		status       int
+	}{
+		{"rejected", http.MethodPost, "/orders", `{"instrument":"TEST","type":"Limit","side":"BUY","qty":10}`, http.StatusUnprocessableEntity},
+		{"unknown instrument", http.MethodPost, "/orders", `{"instrument":"NONE","type":"Limit","side":"BUY","qty":10,"price":"1"}`, http.StatusUnprocessableEntity},
+		{"invalid decimal", http.MethodPost, "/orders", `{"instrument":"TEST","type":"Limit","side":"BUY","qty":10,"price":"1.2.3"}`, http.StatusBadRequest},
+		{"unknown field", http.MethodPost, "/orders", `{"instrument":"TEST","quantity":10}`, http.StatusBadRequest},
+		{"invalid order ID", http.MethodGet, "/orders/abc", "", http.StatusBadRequest},
+		{"unknown order", http.MethodDelete, "/orders/123", "", http.StatusNotFound},
+		{"method", http.MethodPut, "/orders/123", "", http.StatusMethodNotAllowed},
+		{"unknown instrument data", http.MethodGet, "/instruments/NONE/depth", "", http.StatusNotFound},
+		{"invalid levels", http.MethodGet, "/instruments/TEST/depth?levels=-1", "", http.StatusBadRequest},
+		{"invalid time", http.MethodGet, "/instruments/TEST/trades?from=yesterday", "", http.StatusBadRequest},
+		{"unknown path", http.MethodGet, "/instruments/TEST", "", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var e Error
+			if status := c.do(test.method, test.path, test.body, &e); status != test.status || e.Error == "" {
+				t.Errorf("expected status %d with an error, got %d %+v", test.status, status, e)
+			}
+		})
+	}
+
+	c.customerID = uuid.Nil
+	var e Error
+	if status := c.do(http.MethodPost, "/orders", `{}`, &e); status != http.StatusBadRequest || e.Error != server.ErrInvalidCustomerID.Error() {
+		t.Errorf("expected an invalid customer ID, got %d %+v", status, e)
+	}
+}
+
+func TestHandler_MarketData(t *testing.T) {
+	ts, _ := setup(t)
+	c := connect(t, ts)
+	orders := []string{
+		`{"instrument":"TEST","type":"Limit","side":"SELL","qty":10,"price":"20.25"}`,
+		`{"instrument":"TEST","type":"Limit","side":"SELL","qty":5,"price":"20.30"}`,
+		`{"instrument":"TEST","type":"Limit","side":"BUY","qty":3,"price":"20.10"}`,
+		`{"instrument":"TEST","type":"Limit","side":"BUY","qty":2,"price":"20.25"}`,
+	}
+	for _, order := range orders {
+		if status := c.do(http.MethodPost, "/orders", order, nil); status != http.StatusCreated {
+			t.Fatalf("expected status 201, got %d", status)
+		}
+	}
+
+	var depth Depth
+	if status := c.do(http.MethodGet, "/instruments/TEST/depth?levels=1", "", &depth); status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", status)
+	}
+	if len(depth.Bids) != 1 || len(depth.Asks) != 1 || depth.Bids[0].Qty != 3 || depth.Asks[0].Qty != 8 || depth.Asks[0].Price.Cmp(apd.New(2025, -2)) != 0 {
+		t.Errorf("expected a bid of 3 and an ask of 8 at 20.25, got %+v", depth)
+	}
+
+	var trades []Trade
+	if status := c.do(http.MethodGet, "/instruments/TEST/trades", "", &trades); status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", status)
+	}
+	if len(trades) != 1 || trades[0].Qty != 2 || trades[0].AggressorSide != "BUY" {
+		t.Fatalf("expected a trade of 2, got %+v", trades)
+	}
+	from := trades[0].Timestamp.Add(1).Format("2006-01-02T15:04:05.999999999Z07:00")
+	if status := c.do(http.MethodGet, "/instruments/TEST/trades?from="+from, "", &trades); status != http.StatusOK || len(trades) != 0 {
+		t.Errorf("expected no trades after the first one, got %d %+v", status, trades)
+	}
+
+	var price MarketPrice
+	if status := c.do(http.MethodGet, "/instruments/TEST/price", "", &price); status != http.StatusOK || price.Price.Text('f') != "20.25" {
+		t.Errorf("expected market price 20.25, got %d %+v", status, price)
+	}
+	var statistics Statistics
+	if status := c.do(http.MethodGet, "/instruments/TEST/statistics", "", &statistics); status != http.StatusOK || statistics.Volume != 2 || statistics.Trades != 1 {
+		t.Errorf("expected a volume of 2 in 1 trade, got %d %+v", status, statistics)
+	}
+}
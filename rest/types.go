@@ -0,0 +1,210 @@
+package rest
+
+import (
+	"github.com/ffhan/tome"
+	"github.com/ffhan/tome/server"
+	"strings"
+	"time"
+)
+
+// Request body of a new order.
+type NewOrder struct {
+	ClientOrderID string     `json:"client_order_id,omitempty"`
+	Instrument    string     `json:"instrument"`
+	Type          string     `json:"type"`             // Market or Limit
+	Side          string     `json:"side"`             // BUY or SELL
+	Params        []string   `json:"params,omitempty"` // STOP, AON, IOC, FOK, GTC, GFD, GTD, HIDDEN
+	Qty           int64      `json:"qty"`
+	Price         *Decimal   `json:"price,omitempty"`
+	StopPrice     *Decimal   `json:"stop_price,omitempty"`
+	DisplayQty    int64      `json:"display_qty,omitempty"`
+	ExpireTime    *time.Time `json:"expire_time,omitempty"`
+}
+
+func (n NewOrder) message() server.Message {
+	return server.Message{
+		Type:          server.MessageNew,
+		ClientOrderID: n.ClientOrderID,
+		Instrument:    n.Instrument,
+		OrderType:     n.Type,
+		Side:          n.Side,
+		Params:        n.Params,
+		Qty:           n.Qty,
+		Price:         decimalString(n.Price),
+		StopPrice:     decimalString(n.StopPrice),
+		DisplayQty:    n.DisplayQty,
+		ExpireTime:    n.ExpireTime,
+	}
+}
+
+// Request body of an order amendment.
+type Amendment struct {
+	ClientOrderID string   `json:"client_order_id,omitempty"` // replaces the previous client order ID
+	Qty           int64    `json:"qty"`
+	Price         *Decimal `json:"price,omitempty"` // keeps the current limit price if omitted
+}
+
+// Result of an order request - order state after the request was processed, including fills it caused.
+type OrderResponse struct {
+	OrderID       uint64 `json:"order_id"`
+	ClientOrderID string `json:"client_order_id,omitempty"`
+	Status        string `json:"status"` // New, PartiallyFilled, Filled or Cancelled
+	Qty           int64  `json:"qty"`
+	FilledQty     int64  `json:"filled_qty"`
+	LeavesQty     int64  `json:"leaves_qty"`
+	Fills         []Fill `json:"fills,omitempty"`
+}
+
+// Fill of an order.
+type Fill struct {
+	TradeID uint64  `json:"trade_id"`
+	Qty     int64   `json:"qty"`
+	Price   Decimal `json:"price"`
+}
+
+// Order state.
+type Order struct {
+	ID         uint64     `json:"id"`
+	Instrument string     `json:"instrument"`
+	CustomerID string     `json:"customer_id"`
+	Timestamp  time.Time  `json:"timestamp"`
+	Type       string     `json:"type"`
+	Side       string     `json:"side"`
+	Params     []string   `json:"params,omitempty"`
+	Status     string     `json:"status"`
+	Qty        int64      `json:"qty"`
+	FilledQty  int64      `json:"filled_qty"`
+	DisplayQty int64      `json:"display_qty,omitempty"`
+	Price      *Decimal   `json:"price,omitempty"`
+	StopPrice  *Decimal   `json:"stop_price,omitempty"`
+	ExpireTime *time.Time `json:"expire_time,omitempty"`
+}
+
+func newOrder(o tome.Order) Order {
+	order := Order{
+		ID:         o.ID,
+		Instrument: o.Instrument,
+		CustomerID: o.CustomerID.String(),
+		Timestamp:  o.Timestamp,
+		Type:       o.Type.String(),
+		Side:       o.Side.String(),
+		Params:     strings.Fields(o.Params.String()),
+		Status:     o.Status().String(),
+		Qty:        o.Qty,
+		FilledQty:  o.FilledQty,
+		DisplayQty: o.DisplayQty,
+	}
+	if !o.Price.IsZero() {
+		order.Price = &Decimal{o.Price}
+	}
+	if !o.StopPrice.IsZero() {
+		order.StopPrice = &Decimal{o.StopPrice}
+	}
+	if !o.ExpireTime.IsZero() {
+		order.ExpireTime = &o.ExpireTime
+	}
+	return order
+}
+
+// Trade without customer data.
+type Trade struct {
+	ID            uint64    `json:"id"`
+	Instrument    string    `json:"instrument"`
+	Qty           int64     `json:"qty"`
+	Price         Decimal   `json:"price"`
+	Total         Decimal   `json:"total"`
+	Timestamp     time.Time `json:"timestamp"`
+	BidOrderID    uint64    `json:"bid_order_id"`
+	AskOrderID    uint64    `json:"ask_order_id"`
+	AggressorSide string    `json:"aggressor_side"`
+}
+
+func newTrade(t tome.Trade) Trade {
+	return Trade{
+		ID:            t.ID,
+		Instrument:    t.Instrument,
+		Qty:           t.Qty,
+		Price:         Decimal{t.Price},
+		Total:         Decimal{t.Total},
+		Timestamp:     t.Timestamp,
+		BidOrderID:    t.BidOrderID,
+		AskOrderID:    t.AskOrderID,
+		AggressorSide: t.AggressorSide.String(),
+	}
+}
+
+// Displayed price levels.
+type Depth struct {
+	Instrument string  `json:"instrument"`
+	Bids       []Level `json:"bids"`
+	Asks       []Level `json:"asks"`
+}
+
+// Displayed price level.
+type Level struct {
+	Price Decimal `json:"price"`
+	Qty   int64   `json:"qty"`
+	Count int     `json:"count"`
+}
+
+func newDepth(instrument string, d tome.Depth) Depth {
+	depth := Depth{Instrument: instrument, Bids: []Level{}, Asks: []Level{}}
+	for _, level := range d.Bids {
+		depth.Bids = append(depth.Bids, Level{Price: Decimal{level.Price}, Qty: level.Qty, Count: level.Count})
+	}
+	for _, level := range d.Asks {
+		depth.Asks = append(depth.Asks, Level{Price: Decimal{level.Price}, Qty: level.Qty, Count: level.Count})
+	}
+	return depth
+}
+
+// Market price of an instrument.
+type MarketPrice struct {
+	Instrument string  `json:"instrument"`
+	Price      Decimal `json:"price"`
+}
+
+// Daily trading statistics of an instrument.
+type Statistics struct {
+	Instrument    string  `json:"instrument"`
+	Open          Decimal `json:"open"`
+	High          Decimal `json:"high"`
+	Low           Decimal `json:"low"`
+	Last          Decimal `json:"last"`
+	PreviousClose Decimal `json:"previous_close"`
+	Change        Decimal `json:"change"`
+	ChangePercent Decimal `json:"change_percent"`
+	Volume        int64   `json:"volume"`
+	Turnover      Decimal `json:"turnover"`
+	VWAP          Decimal `json:"vwap"`
+	Trades        int     `json:"trades"`
+}
+
+func newStatistics(instrument string, s tome.Statistics) Statistics {
+	return Statistics{
+		Instrument:    instrument,
+		Open:          Decimal{s.Open},
+		High:          Decimal{s.High},
+		Low:           Decimal{s.Low},
+		Last:          Decimal{s.Last},
+		PreviousClose: Decimal{s.PreviousClose},
+		Change:        Decimal{s.Change},
+		ChangePercent: Decimal{s.ChangePercent},
+		Volume:        s.Volume,
+		Turnover:      Decimal{s.Turnover},
+		VWAP:          Decimal{s.VWAP},
+		Trades:        s.Trades,
+	}
+}
+
+// Error response.
+type Error struct {
+	Error string `json:"error"`
+}
+
+func decimalString(d *Decimal) string {
+	if d == nil {
+		return ""
+	}
+	return d.Text('f')
+}
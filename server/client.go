@@ -0,0 +1,205 @@
+package server
+
+import (
+	"github.com/ffhan/tome"
+	"github.com/google/uuid"
+	"time"
+)
+
+// Receives messages of a client. Sinks are called synchronously while an order book is matching, so they must never
+// block or call the server.
+type Sink func(msg Message)
+
+// Logged in client of a protocol session. All orders entered by a client are owned by it and its customer.
+// Requests of a client have to be sent sequentially (e.g. from the session goroutine).
+type Client struct {
+	server       *Server
+	customerID   uuid.UUID
+	sink         Sink
+	disconnected bool // guarded by the server mutex
+}
+
+// Connect a client of a customer, all messages of its orders are sent to the sink.
+func (s *Server) Connect(customerID uuid.UUID, sink Sink) *Client {
+	return &Client{server: s, customerID: customerID, sink: sink}
+}
+
+// Returns the customer ID of the client.
+func (c *Client) CustomerID() uuid.UUID {
+	return c.customerID
+}
+
+// Notify the server that the session of the client disconnected. With WithCancelOnDisconnect, orders of the client
+// are cancelled after the grace period unless a session of the customer logs on before that.
+func (c *Client) Disconnect() {
+	s := c.server
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	c.disconnected = true
+	if !s.cancelOnDisconnect {
+		return
+	}
+	if timer, ok := s.disconnects[c]; ok {
+		timer.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(s.disconnectGrace, func() {
+		s.mutex.Lock()
+		pending := s.disconnects[c] == timer
+		if pending {
+			delete(s.disconnects, c)
+		}
+		s.mutex.Unlock()
+		if pending {
+			s.cancelDisconnectedOrders(c)
+		}
+	})
+	s.disconnects[c] = timer
+}
+
+// Notify the server that a session of the client logged on, either a reconnected session of the client or a new
+// session with a new client. Stops pending cancel-on-disconnects of clients of the customer and transfers orders of
+// disconnected clients of the customer to the client, so the session receives their messages and can cancel and amend
+// them.
+func (c *Client) Reconnect() {
+	s := c.server
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	c.disconnected = false
+	for client, timer := range s.disconnects {
+		if client.customerID == c.customerID {
+			timer.Stop()
+			delete(s.disconnects, client)
+		}
+	}
+	for _, state := range s.orders {
+		if state.client.customerID == c.customerID && state.client.disconnected {
+			state.client = c
+		}
+	}
+}
+
+// Handle a new, cancel or amend message.
+func (c *Client) Handle(msg Message) {
+	switch msg.Type {
+	case MessageNew:
+		c.Enter(msg)
+	case MessageCancel:
+		c.Cancel(msg)
+	case MessageAmend:
+		c.Amend(msg)
+	default:
+		c.reject(msg, ErrUnknownMessage)
+	}
+}
+
+func (c *Client) reject(msg Message, err error) {
+	c.send(Message{
+		Type:          MessageRejected,
+		RefType:       msg.Type,
+		ClientOrderID: msg.ClientOrderID,
+		OrderID:       msg.OrderID,
+		Reason:        err.Error(),
+	})
+}
+
+// Enter a new order. Returns the assigned order ID, zero if the message was rejected before reaching the order book.
+func (c *Client) Enter(msg Message) uint64 {
+	order, err := msg.order()
+	if err != nil {
+		c.reject(msg, err)
+		return 0
+	}
+	book, ok := c.server.Book(order.Instrument)
+	if !ok {
+		c.reject(msg, ErrUnknownInstrument)
+		return 0
+	}
+	order.ID = c.server.nextOrderID()
+	order.CustomerID = c.customerID
+	order.Timestamp = time.Now()
+
+	c.server.expireOrders(book)
+	state := &orderState{client: c, book: book, clientOrderID: msg.ClientOrderID, qty: order.Qty}
+	c.server.hold(order.ID, state)
+	if _, err := book.Add(order); err != nil {
+		c.server.abort(order.ID)
+		msg.OrderID = order.ID
+		c.reject(msg, err)
+		return order.ID
+	}
+	c.server.release(order.ID, Message{
+		Type:       MessageAccepted,
+		Instrument: msg.Instrument,
+		OrderType:  order.Type.String(),
+		Side:       order.Side.String(),
+		Params:     msg.Params,
+		Qty:        msg.Qty,
+		Price:      msg.Price,
+		StopPrice:  msg.StopPrice,
+		DisplayQty: msg.DisplayQty,
+		ExpireTime: msg.ExpireTime,
+	})
+	return order.ID
+}
+
+// Cancel an order of the client.
+func (c *Client) Cancel(msg Message) {
+	state, ok := c.server.owned(c, msg.OrderID)
+	if !ok {
+		c.reject(msg, tome.ErrOrderNotFound)
+		return
+	}
+	c.server.hold(msg.OrderID, state)
+	if err := state.book.Cancel(msg.OrderID); err != nil {
+		c.server.release(msg.OrderID, c.response(msg, state, Message{Type: MessageRejected, RefType: msg.Type, Reason: err.Error()}))
+		return
+	}
+	if !c.server.release(msg.OrderID, c.response(msg, state, Message{})) { // the order was filled before it could be cancelled
+		c.reject(msg, tome.ErrOrderNotFound)
+	}
+}
+
+// Amend the quantity and price of an order of the client. A new client order ID replaces the previous one.
+func (c *Client) Amend(msg Message) {
+	state, ok := c.server.owned(c, msg.OrderID)
+	if ok { // the order itself can expire
+		c.server.expireOrders(state.book)
+		state, ok = c.server.owned(c, msg.OrderID)
+	}
+	if !ok {
+		c.reject(msg, tome.ErrOrderNotFound)
+		return
+	}
+	price, err := parsePrice(msg.Price)
+	if err != nil {
+		c.reject(msg, err)
+		return
+	}
+	c.server.hold(msg.OrderID, state)
+	previousQty := c.server.setQty(state, msg.Qty)
+	if _, err := state.book.Amend(msg.OrderID, msg.Qty, price); err != nil {
+		c.server.setQty(state, previousQty)
+		c.server.release(msg.OrderID, c.response(msg, state, Message{Type: MessageRejected, RefType: msg.Type, Reason: err.Error()}))
+		return
+	}
+	response := c.response(msg, state, Message{Type: MessageAmended, Qty: msg.Qty, Price: msg.Price})
+	c.server.replaceClientOrderID(state, response.ClientOrderID)
+	c.server.release(msg.OrderID, response)
+}
+
+// send a message to the client sink and watchers of its customer
+func (c *Client) send(msg Message) {
+	c.sink(msg)
+	c.server.watched(c.customerID, msg)
+}
+
+// sets client order IDs of a response to a cancel or amend request
+func (c *Client) response(msg Message, state *orderState, response Message) Message {
+	response.ClientOrderID = msg.ClientOrderID
+	response.OrigClientOrderID = c.server.clientOrderID(state)
+	if response.ClientOrderID == "" {
+		response.ClientOrderID = response.OrigClientOrderID
+	}
+	return response
+}
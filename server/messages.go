@@ -0,0 +1,134 @@
+package server
+
+import (
+	"errors"
+	"github.com/cockroachdb/apd"
+	"github.com/ffhan/tome"
+	"strings"
+	"time"
+)
+
+// Type of a protocol message.
+type MessageType string
+
+const (
+	// client messages
+	MessageLogon  MessageType = "logon"  // must be the first message of a session
+	MessageNew    MessageType = "new"    // enter a new order
+	MessageCancel MessageType = "cancel" // cancel an order
+	MessageAmend  MessageType = "amend"  // change the order quantity and price
+
+	// server messages
+	MessageLoggedIn  MessageType = "logged_in" // session was logged in
+	MessageAccepted  MessageType = "accepted"  // order was accepted
+	MessageRejected  MessageType = "rejected"  // message was rejected, Reason contains the error
+	MessageFill      MessageType = "fill"      // order was (partially) filled by a trade
+	MessageCancelled MessageType = "cancelled" // order was cancelled by the client or by the engine (IOC, FOK, GTD expiry)
+	MessageAmended   MessageType = "amended"   // order was amended
+)
+
+var (
+	ErrNotLoggedIn       = errors.New("session is not logged in")
+	ErrUnknownMessage    = errors.New("unknown message type")
+	ErrUnknownInstrument = errors.New("unknown instrument")
+	ErrInvalidSide       = errors.New("side has to be BUY or SELL")
+	ErrInvalidOrderType  = errors.New("order type has to be Market or Limit")
+	ErrInvalidParam      = errors.New("unknown order parameter")
+	ErrInvalidPrice      = errors.New("invalid price")
+	ErrInvalidCustomerID = errors.New("invalid customer ID")
+	ErrOrderExpired      = errors.New("order expired") // reason of orders cancelled when their GTD expire time passed
+)
+
+// Protocol message, every message is a JSON object on its own line. Fields which don't apply to a message type are
+// omitted. Prices are decimal strings.
+type Message struct {
+	Type MessageType `json:"type"`
+
+	CustomerID        string     `json:"customer_id,omitempty"`          // logon
+	ClientOrderID     string     `json:"client_order_id,omitempty"`      // client reference of a request, echoed in responses
+	OrigClientOrderID string     `json:"orig_client_order_id,omitempty"` // responses to cancel and amend - previous client order ID
+	OrderID           uint64     `json:"order_id,omitempty"`             // assigned by the server
+	Instrument        string     `json:"instrument,omitempty"`
+	OrderType         string     `json:"order_type,omitempty"` // Market or Limit
+	Side              string     `json:"side,omitempty"`       // BUY or SELL
+	Params            []string   `json:"params,omitempty"`     // STOP, AON, IOC, FOK, GTC, GFD, GTD, HIDDEN
+	Qty               int64      `json:"qty,omitempty"`
+	Price             string     `json:"price,omitempty"`
+	StopPrice         string     `json:"stop_price,omitempty"`
+	DisplayQty        int64      `json:"display_qty,omitempty"`
+	ExpireTime        *time.Time `json:"expire_time,omitempty"` // GTD orders
+
+	RefType   MessageType `json:"ref_type,omitempty"`   // rejected - type of the rejected message
+	Reason    string      `json:"reason,omitempty"`     // rejected
+	TradeID   uint64      `json:"trade_id,omitempty"`   // fill
+	LastQty   int64       `json:"last_qty,omitempty"`   // fill - quantity of the trade
+	LastPrice string      `json:"last_price,omitempty"` // fill - price of the trade
+	FilledQty int64       `json:"filled_qty,omitempty"` // fill, cancelled, amended
+	LeavesQty int64       `json:"leaves_qty,omitempty"` // fill, amended - remaining open quantity
+}
+
+var params = map[string]tome.OrderParams{
+	"STOP":   tome.ParamStop,
+	"AON":    tome.ParamAON,
+	"IOC":    tome.ParamIOC,
+	"FOK":    tome.ParamFOK,
+	"GTC":    tome.ParamGTC,
+	"GFD":    tome.ParamGFD,
+	"GTD":    tome.ParamGTD,
+	"HIDDEN": tome.ParamHidden,
+}
+
+// Convert a new order message to an order. Order ID, customer ID and timestamp are set by the session.
+func (m Message) order() (tome.Order, error) {
+	order := tome.Order{
+		Instrument: m.Instrument,
+		Qty:        m.Qty,
+		DisplayQty: m.DisplayQty,
+	}
+	if m.ExpireTime != nil {
+		order.ExpireTime = *m.ExpireTime
+	}
+	switch strings.ToUpper(m.Side) {
+	case "BUY":
+		order.Side = tome.SideBuy
+	case "SELL":
+		order.Side = tome.SideSell
+	default:
+		return order, ErrInvalidSide
+	}
+	switch strings.ToUpper(m.OrderType) {
+	case "MARKET":
+		order.Type = tome.TypeMarket
+	case "LIMIT":
+		order.Type = tome.TypeLimit
+	default:
+		return order, ErrInvalidOrderType
+	}
+	for _, name := range m.Params {
+		param, ok := params[strings.ToUpper(name)]
+		if !ok {
+			return order, ErrInvalidParam
+		}
+		order.Params |= param
+	}
+	var err error
+	if order.Price, err = parsePrice(m.Price); err != nil {
+		return order, err
+	}
+	if order.StopPrice, err = parsePrice(m.StopPrice); err != nil {
+		return order, err
+	}
+	return order, nil
+}
+
+// parse a decimal price, an empty price is zero
+func parsePrice(price string) (apd.Decimal, error) {
+	if price == "" {
+		return apd.Decimal{}, nil
+	}
+	d, _, err := apd.NewFromString(price)
+	if err != nil || d.Form != apd.Finite || d.Negative {
+		return apd.Decimal{}, ErrInvalidPrice
+	}
+	return *d, nil
+}
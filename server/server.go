@@ -0,0 +1,486 @@
+// Package server accepts order entry sessions over TCP and routes their orders to order books.
+//
+// Every message is a JSON object on its own line (see Message). A session starts with a logon which sets the customer
+// ID of all of its orders. Order IDs are assigned by the server and returned in acknowledgements. Fills are sent only
+// to the session which owns the order.
+package server
+
+import (
+	"errors"
+	"github.com/ffhan/tome"
+	"github.com/google/uuid"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Number of outgoing messages a session can buffer. A session which doesn't read its messages fast enough is closed,
+// the matching engine never waits for a session.
+const DefaultSendBuffer = 1024
+
+// Interval of expiring GTD orders in all order books.
+const DefaultExpiryInterval = time.Second
+
+var ErrServerClosed = errors.New("server is closed")
+
+// Order entry server. The server has to receive trades of all registered order books - pass it to the trade books with
+// tome.WithTradeCallback.
+type Server struct {
+	lastOrderID        uint64 // accessed atomically
+	sendBuffer         int
+	cancelOnDisconnect bool
+	disconnectGrace    time.Duration
+	expiryInterval     time.Duration
+
+	mutex       sync.RWMutex
+	books       map[string]*tome.OrderBook
+	orders      map[uint64]*orderState  // open orders entered through the server
+	disconnects map[*Client]*time.Timer // pending cancel-on-disconnect by disconnected client
+	sessions    map[*session]struct{}
+	listener    net.Listener
+	closed      bool
+	stop        chan struct{} // stops expiring orders
+	wg          sync.WaitGroup
+
+	watchMutex sync.Mutex // watchers are called under the server mutex
+	watchers   map[uuid.UUID]map[*watcher]struct{}
+}
+
+// receives copies of messages sent to clients of a customer
+type watcher struct {
+	sink Sink
+}
+
+// state of an order entered through the server
+type orderState struct {
+	client        *Client
+	book          *tome.OrderBook
+	clientOrderID string
+	qty           int64
+	filledQty     int64
+	pending       bool      // order is being processed by its session, messages are deferred until the response
+	deferred      []Message // messages produced while the order was pending
+}
+
+// Option changes the default behaviour of a server.
+type Option func(s *Server)
+
+// Assign order IDs starting after lastOrderID, e.g. after restoring order books from a snapshot.
+func WithLastOrderID(lastOrderID uint64) Option {
+	return func(s *Server) {
+		s.lastOrderID = lastOrderID
+	}
+}
+
+// Buffer up to size outgoing messages per session.
+func WithSendBuffer(size int) Option {
+	return func(s *Server) {
+		s.sendBuffer = size
+	}
+}
+
+// Cancel orders of a disconnected client when no session of its customer logs on within the grace period after the
+// disconnect, every disconnected client has its own grace period. Orders of connected clients of the customer are kept, a session which logs on in time takes over
+// the orders of disconnected clients.
+func WithCancelOnDisconnect(grace time.Duration) Option {
+	return func(s *Server) {
+		s.cancelOnDisconnect = true
+		s.disconnectGrace = grace
+	}
+}
+
+// Expire GTD orders in all order books every interval, expiry is disabled if the interval isn't positive. Orders are
+// expired before new orders are entered or amended regardless of the interval, so expired orders are never matched
+// through the server.
+func WithExpiryInterval(interval time.Duration) Option {
+	return func(s *Server) {
+		s.expiryInterval = interval
+	}
+}
+
+// Create a new server without any order books. GTD orders are expired in the background until the server is closed.
+func New(opts ...Option) *Server {
+	s := &Server{
+		sendBuffer:     DefaultSendBuffer,
+		expiryInterval: DefaultExpiryInterval,
+		books:          make(map[string]*tome.OrderBook),
+		orders:         make(map[uint64]*orderState),
+		disconnects:    make(map[*Client]*time.Timer),
+		sessions:       make(map[*session]struct{}),
+		stop:           make(chan struct{}),
+		watchers:       make(map[uuid.UUID]map[*watcher]struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.expiryInterval > 0 {
+		s.wg.Add(1)
+		go s.expire()
+	}
+	return s
+}
+
+// Accept orders for the order book instrument.
+func (s *Server) Register(book *tome.OrderBook) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.books[book.Instrument] = book
+}
+
+// Returns the registered order book of an instrument.
+func (s *Server) Book(instrument string) (*tome.OrderBook, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	book, ok := s.books[instrument]
+	return book, ok
+}
+
+// Returns all registered order books sorted by instrument.
+func (s *Server) Books() []*tome.OrderBook {
+	s.mutex.RLock()
+	books := make([]*tome.OrderBook, 0, len(s.books))
+	for _, book := range s.books {
+		books = append(books, book)
+	}
+	s.mutex.RUnlock()
+	sort.Slice(books, func(i, j int) bool {
+		return books[i].Instrument < books[j].Instrument
+	})
+	return books
+}
+
+// Listen on a TCP address and serve sessions in the background.
+func (s *Server) ListenAndServe(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	if err := s.listen(listener); err != nil {
+		return err
+	}
+	go s.accept(listener)
+	return nil
+}
+
+// Accept sessions from the listener until the server is closed.
+func (s *Server) Serve(listener net.Listener) error {
+	if err := s.listen(listener); err != nil {
+		return err
+	}
+	return s.accept(listener)
+}
+
+func (s *Server) listen(listener net.Listener) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		listener.Close()
+		return ErrServerClosed
+	}
+	s.listener = listener
+	return nil
+}
+
+func (s *Server) accept(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.mutex.RLock()
+			closed := s.closed
+			s.mutex.RUnlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		sess := newSession(s, conn)
+		s.mutex.Lock()
+		if s.closed {
+			s.mutex.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.sessions[sess] = struct{}{}
+		s.wg.Add(1)
+		s.mutex.Unlock()
+		go sess.run()
+	}
+}
+
+// Returns the address the server is listening on, nil if it isn't listening.
+func (s *Server) Addr() net.Addr {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Stop accepting sessions, close all sessions and wait for them to finish.
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return ErrServerClosed
+	}
+	s.closed = true
+	close(s.stop)
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for sess := range s.sessions {
+		sess.conn.Close()
+	}
+	s.mutex.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) removeSession(sess *session) {
+	s.mutex.Lock()
+	delete(s.sessions, sess)
+	s.mutex.Unlock()
+	s.wg.Done()
+}
+
+func (s *Server) nextOrderID() uint64 {
+	return atomic.AddUint64(&s.lastOrderID, 1)
+}
+
+// Route trade fills to sessions which own the orders, implements tome.TradeCallback.
+// Called synchronously while an order book is matching, so it never waits for a session.
+func (s *Server) Execute(trade tome.Trade) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.fill(trade.BidOrderID, trade)
+	s.fill(trade.AskOrderID, trade)
+}
+
+// has to be called under the lock
+func (s *Server) fill(orderID uint64, trade tome.Trade) {
+	state, ok := s.orders[orderID]
+	if !ok {
+		return
+	}
+	state.filledQty += trade.Qty
+	s.notify(orderID, state, Message{
+		Type:      MessageFill,
+		TradeID:   trade.ID,
+		LastQty:   trade.Qty,
+		LastPrice: trade.Price.String(),
+		FilledQty: state.filledQty,
+		LeavesQty: state.qty - state.filledQty,
+	})
+	if state.filledQty >= state.qty && !state.pending {
+		delete(s.orders, orderID)
+	}
+}
+
+// send a message to the order owner, has to be called under the lock
+func (s *Server) notify(orderID uint64, state *orderState, msg Message) {
+	msg.OrderID = orderID
+	if msg.ClientOrderID == "" {
+		msg.ClientOrderID = state.clientOrderID
+	}
+	if state.pending {
+		state.deferred = append(state.deferred, msg)
+		return
+	}
+	state.client.send(msg)
+}
+
+// Mark an order as being processed by its session, messages of the order are deferred until it's released.
+func (s *Server) hold(orderID uint64, state *orderState) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	state.pending = true
+	s.orders[orderID] = state
+}
+
+// Forget an order which was rejected by the order book.
+func (s *Server) abort(orderID uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.orders, orderID)
+}
+
+// Change the quantity of an order, returns the previous quantity.
+func (s *Server) setQty(state *orderState, qty int64) int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	previous := state.qty
+	state.qty = qty
+	return previous
+}
+
+// Send the response (if it has a type) followed by all deferred messages of a held order. If the order isn't active in
+// the book anymore and it isn't filled, its remaining quantity was cancelled - client order IDs of a response without
+// a type are used for the cancellation. Returns true if the order was cancelled.
+func (s *Server) release(orderID uint64, response Message) bool {
+	s.mutex.RLock()
+	state, ok := s.orders[orderID]
+	s.mutex.RUnlock()
+	if !ok {
+		return false
+	}
+	_, active := state.book.GetOrder(orderID)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	state.pending = false
+	if response.Type != "" {
+		s.notify(orderID, state, response)
+	}
+	for _, msg := range state.deferred {
+		state.client.send(msg)
+	}
+	state.deferred = nil
+
+	if active {
+		return false
+	}
+	delete(s.orders, orderID)
+	if state.filledQty >= state.qty {
+		return false
+	}
+	cancelled := Message{Type: MessageCancelled, FilledQty: state.filledQty}
+	if response.Type == "" {
+		cancelled.ClientOrderID, cancelled.OrigClientOrderID = response.ClientOrderID, response.OrigClientOrderID
+	}
+	s.notify(orderID, state, cancelled)
+	return true
+}
+
+// Cancel orders of a disconnected client and notify it. Orders of each book are cancelled at once, so none of them can
+// be matched while others are already cancelled.
+func (s *Server) cancelDisconnectedOrders(client *Client) {
+	s.mutex.Lock()
+	orders := make(map[*tome.OrderBook][]uint64)
+	for orderID, state := range s.orders {
+		if state.client == client && client.disconnected {
+			state.pending = true
+			orders[state.book] = append(orders[state.book], orderID)
+		}
+	}
+	s.mutex.Unlock()
+
+	for book, orderIDs := range orders {
+		sort.Slice(orderIDs, func(i, j int) bool {
+			return orderIDs[i] < orderIDs[j]
+		})
+		if _, err := book.CancelOrders(orderIDs); err != nil {
+			s.logf("cannot cancel orders of customer %s in %s: %v\n", client.customerID, book.Instrument, err)
+		}
+		for _, orderID := range orderIDs {
+			s.release(orderID, Message{})
+		}
+	}
+}
+
+// expire GTD orders of all order books every expiry interval until the server is closed
+func (s *Server) expire() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.expiryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			for _, book := range s.Books() {
+				s.expireOrders(book)
+			}
+		}
+	}
+}
+
+// Expire GTD orders of an order book and notify the clients which own them.
+func (s *Server) expireOrders(book *tome.OrderBook) {
+	if s.expiryInterval <= 0 {
+		return
+	}
+	expired, err := book.ExpireOrders()
+	if err != nil {
+		s.logf("cannot expire orders in %s: %v\n", book.Instrument, err)
+	}
+	s.cancelled(expired, ErrOrderExpired.Error())
+}
+
+// notify the clients of orders cancelled by the engine
+func (s *Server) cancelled(orders []tome.Order, reason string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, order := range orders {
+		state, ok := s.orders[order.ID]
+		if !ok || state.pending { // pending orders are reported as cancelled when they're released
+			continue
+		}
+		delete(s.orders, order.ID)
+		s.notify(order.ID, state, Message{Type: MessageCancelled, FilledQty: state.filledQty, Reason: reason})
+	}
+}
+
+// Send copies of all messages sent to clients of a customer to the sink until the returned function is called, e.g. to
+// stream execution reports of orders entered through all gateways. The sink is called synchronously like client sinks.
+func (s *Server) Watch(customerID uuid.UUID, sink Sink) (stop func()) {
+	w := &watcher{sink: sink}
+	s.watchMutex.Lock()
+	defer s.watchMutex.Unlock()
+	if s.watchers[customerID] == nil {
+		s.watchers[customerID] = make(map[*watcher]struct{})
+	}
+	s.watchers[customerID][w] = struct{}{}
+	return func() {
+		s.watchMutex.Lock()
+		defer s.watchMutex.Unlock()
+		delete(s.watchers[customerID], w)
+		if len(s.watchers[customerID]) == 0 {
+			delete(s.watchers, customerID)
+		}
+	}
+}
+
+// send a message to watchers of a customer
+func (s *Server) watched(customerID uuid.UUID, msg Message) {
+	s.watchMutex.Lock()
+	defer s.watchMutex.Unlock()
+	for w := range s.watchers[customerID] {
+		w.sink(msg)
+	}
+}
+
+// Returns the state of an order owned by the client.
+func (s *Server) owned(client *Client, orderID uint64) (*orderState, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	state, ok := s.orders[orderID]
+	if !ok || state.client != client {
+		return nil, false
+	}
+	return state, true
+}
+
+func (s *Server) clientOrderID(state *orderState) string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return state.clientOrderID
+}
+
+// Replace the client order ID of an amended order, empty IDs are ignored.
+func (s *Server) replaceClientOrderID(state *orderState, clientOrderID string) {
+	if clientOrderID == "" {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	state.clientOrderID = clientOrderID
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	log.Printf(format, args...)
+}
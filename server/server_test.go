@@ -0,0 +1,335 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/cockroachdb/apd"
+	"github.com/ffhan/tome"
+	"github.com/google/uuid"
+	"net"
+	"testing"
+	"time"
+)
+
+const instrument = "TEST"
+
+type testClient struct {
+	t       *testing.T
+	conn    net.Conn
+	encoder *json.Encoder
+	decoder *json.Decoder
+}
+
+func setup(t *testing.T, opts ...Option) (*Server, *tome.OrderBook) {
+	srv := New(opts...)
+	tb := tome.NewTradeBook(instrument, tome.WithTradeCallback(srv))
+	ob := tome.NewOrderBook(instrument, *apd.New(2000, -2), tb, tome.NOPOrderRepository)
+	srv.Register(ob)
+	if err := srv.ListenAndServe("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		srv.Close()
+	})
+	return srv, ob
+}
+
+func connect(t *testing.T, srv *Server) *testClient {
+	return connectAs(t, srv, uuid.New())
+}
+
+func connectAs(t *testing.T, srv *Server, customerID uuid.UUID) *testClient {
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	c := &testClient{t: t, conn: conn, encoder: json.NewEncoder(conn), decoder: json.NewDecoder(bufio.NewReader(conn))}
+	c.send(Message{Type: MessageLogon, CustomerID: customerID.String()})
+	c.expect(MessageLoggedIn)
+	return c
+}
+
+func (c *testClient) send(msg Message) {
+	if err := c.encoder.Encode(msg); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) expect(messageType MessageType) Message {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	var msg Message
+	if err := c.decoder.Decode(&msg); err != nil {
+		c.t.Fatalf("expected %s, got %v", messageType, err)
+	}
+	if msg.Type != messageType {
+		c.t.Fatalf("expected %s, got %+v", messageType, msg)
+	}
+	return msg
+}
+
+func TestServer_Match(t *testing.T) {
+	srv, ob := setup(t)
+	maker, taker := connect(t, srv), connect(t, srv)
+
+	maker.send(Message{Type: MessageNew, ClientOrderID: "a1", Instrument: instrument, OrderType: "Limit", Side: "SELL", Qty: 10, Price: "20.25"})
+	accepted := maker.expect(MessageAccepted)
+	if accepted.ClientOrderID != "a1" || accepted.OrderID == 0 {
+		t.Errorf("expected an acknowledgement with an order ID, got %+v", accepted)
+	}
+
+	taker.send(Message{Type: MessageNew, ClientOrderID: "b1", Instrument: instrument, OrderType: "Limit", Side: "BUY", Qty: 4, Price: "20.30", Params: []string{"IOC"}})
+	takerAccepted := taker.expect(MessageAccepted)
+	fill := taker.expect(MessageFill)
+	if fill.OrderID != takerAccepted.OrderID || fill.LastQty != 4 || fill.LastPrice != "20.30" || fill.LeavesQty != 0 {
+		t.Errorf("expected a full fill of the taker order, got %+v", fill)
+	}
+
+	makerFill := maker.expect(MessageFill)
+	if makerFill.OrderID != accepted.OrderID || makerFill.ClientOrderID != "a1" || makerFill.FilledQty != 4 || makerFill.LeavesQty != 6 {
+		t.Errorf("expected a partial fill of the maker order, got %+v", makerFill)
+	}
+
+	maker.send(Message{Type: MessageAmend, OrderID: accepted.OrderID, Qty: 8, Price: "20.25"})
+	maker.expect(MessageAmended)
+	maker.send(Message{Type: MessageCancel, OrderID: accepted.OrderID})
+	cancelled := maker.expect(MessageCancelled)
+	if cancelled.FilledQty != 4 {
+		t.Errorf("expected a cancellation after 4 filled, got %+v", cancelled)
+	}
+	if len(ob.GetAsks()) != 0 {
+		t.Errorf("expected no asks")
+	}
+}
+
+func TestServer_IOCRemainderCancelled(t *testing.T) {
+	srv, _ := setup(t)
+	c := connect(t, srv)
+
+	c.send(Message{Type: MessageNew, Instrument: instrument, OrderType: "Limit", Side: "BUY", Qty: 5, Price: "20", Params: []string{"IOC"}})
+	accepted := c.expect(MessageAccepted)
+	cancelled := c.expect(MessageCancelled)
+	if cancelled.OrderID != accepted.OrderID {
+		t.Errorf("expected the IOC order to be cancelled, got %+v", cancelled)
+	}
+}
+
+func TestServer_Reject(t *testing.T) {
+	srv, _ := setup(t)
+	c := connect(t, srv)
+	other := connect(t, srv)
+
+	c.send(Message{Type: MessageNew, ClientOrderID: "x", Instrument: instrument, OrderType: "Limit", Side: "BUY", Qty: 5})
+	if rejected := c.expect(MessageRejected); rejected.Reason != tome.ErrInvalidLimitPrice.Error() || rejected.ClientOrderID != "x" {
+		t.Errorf("expected a rejection with %q, got %+v", tome.ErrInvalidLimitPrice, rejected)
+	}
+	c.send(Message{Type: MessageNew, Instrument: "OTHER", OrderType: "Limit", Side: "BUY", Qty: 5, Price: "20"})
+	if rejected := c.expect(MessageRejected); rejected.Reason != ErrUnknownInstrument.Error() {
+		t.Errorf("expected a rejection with %q, got %+v", ErrUnknownInstrument, rejected)
+	}
+
+	c.send(Message{Type: MessageNew, Instrument: instrument, OrderType: "Limit", Side: "BUY", Qty: 5, Price: "19"})
+	accepted := c.expect(MessageAccepted)
+	other.send(Message{Type: MessageCancel, OrderID: accepted.OrderID})
+	if rejected := other.expect(MessageRejected); rejected.Reason != tome.ErrOrderNotFound.Error() {
+		t.Errorf("expected other sessions not to cancel the order, got %+v", rejected)
+	}
+	c.send(Message{Type: MessageAmend, OrderID: accepted.OrderID, Qty: 0, Price: "19"})
+	if rejected := c.expect(MessageRejected); rejected.Reason != tome.ErrInvalidQty.Error() {
+		t.Errorf("expected a rejection with %q, got %+v", tome.ErrInvalidQty, rejected)
+	}
+}
+
+func TestServer_NotLoggedIn(t *testing.T) {
+	srv, _ := setup(t)
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := &testClient{t: t, conn: conn, encoder: json.NewEncoder(conn), decoder: json.NewDecoder(conn)}
+	c.send(Message{Type: MessageNew, Instrument: instrument})
+	if rejected := c.expect(MessageRejected); rejected.Reason != ErrNotLoggedIn.Error() {
+		t.Errorf("expected a rejection with %q, got %+v", ErrNotLoggedIn, rejected)
+	}
+}
+
+func TestServer_CancelOnDisconnect(t *testing.T) {
+	const grace = 50 * time.Millisecond
+	srv, ob := setup(t, WithCancelOnDisconnect(grace))
+	customerID := uuid.New()
+	messages := make(chan Message, 10)
+	c := srv.Connect(customerID, func(msg Message) {
+		messages <- msg
+	})
+
+	orderID := c.Enter(Message{Type: MessageNew, Instrument: instrument, OrderType: "Limit", Side: "SELL", Qty: 10, Price: "20.25"})
+	if accepted := <-messages; accepted.Type != MessageAccepted {
+		t.Fatalf("expected an acceptance, got %+v", accepted)
+	}
+
+	// a reconnected client keeps its orders
+	c.Disconnect()
+	c.Reconnect()
+	time.Sleep(2 * grace)
+	if _, ok := ob.GetOrder(orderID); !ok {
+		t.Fatalf("expected the order to stay active after a reconnect")
+	}
+
+	c.Disconnect()
+	select {
+	case cancelled := <-messages:
+		if cancelled.Type != MessageCancelled || cancelled.OrderID != orderID {
+			t.Errorf("expected the order to be cancelled, got %+v", cancelled)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the order to be cancelled after the grace period")
+	}
+	if _, ok := ob.GetOrder(orderID); ok {
+		t.Errorf("expected the order not to be active")
+	}
+}
+
+func TestServer_SessionCancelOnDisconnect(t *testing.T) {
+	srv, ob := setup(t, WithCancelOnDisconnect(0))
+	c := connect(t, srv)
+	c.send(Message{Type: MessageNew, Instrument: instrument, OrderType: "Limit", Side: "BUY", Qty: 10, Price: "20"})
+	accepted := c.expect(MessageAccepted)
+	c.conn.Close()
+
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if _, ok := ob.GetOrder(accepted.OrderID); !ok {
+			return
+		}
+	}
+	t.Errorf("expected orders of a closed session to be cancelled")
+}
+
+func TestServer_SessionReconnect(t *testing.T) {
+	const grace = 100 * time.Millisecond
+	srv, ob := setup(t, WithCancelOnDisconnect(grace))
+	customerID := uuid.New()
+	other := connectAs(t, srv, customerID)
+	other.send(Message{Type: MessageNew, Instrument: instrument, OrderType: "Limit", Side: "BUY", Qty: 10, Price: "19"})
+	kept := other.expect(MessageAccepted)
+
+	c := connectAs(t, srv, customerID)
+	c.send(Message{Type: MessageNew, ClientOrderID: "r1", Instrument: instrument, OrderType: "Limit", Side: "BUY", Qty: 10, Price: "20"})
+	accepted := c.expect(MessageAccepted)
+	c.conn.Close()
+
+	// the reconnected session takes over the orders of the closed one
+	time.Sleep(grace / 2)
+	c = connectAs(t, srv, customerID)
+	time.Sleep(2 * grace)
+	if _, ok := ob.GetOrder(accepted.OrderID); !ok {
+		t.Fatalf("expected the order to stay active after a reconnect within the grace period")
+	}
+	c.send(Message{Type: MessageCancel, OrderID: accepted.OrderID})
+	if cancelled := c.expect(MessageCancelled); cancelled.OrderID != accepted.OrderID || cancelled.ClientOrderID != "r1" {
+		t.Errorf("expected the reconnected session to cancel the order, got %+v", cancelled)
+	}
+
+	// orders of connected sessions of the customer aren't cancelled when another one disconnects
+	c.send(Message{Type: MessageNew, Instrument: instrument, OrderType: "Limit", Side: "BUY", Qty: 10, Price: "20"})
+	dropped := c.expect(MessageAccepted)
+	c.conn.Close()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if _, ok := ob.GetOrder(dropped.OrderID); !ok {
+			break
+		}
+	}
+	if _, ok := ob.GetOrder(dropped.OrderID); ok {
+		t.Errorf("expected the order of the closed session to be cancelled")
+	}
+	if _, ok := ob.GetOrder(kept.OrderID); !ok {
+		t.Errorf("expected the order of the connected session to stay active")
+	}
+}
+
+func TestServer_Expiry(t *testing.T) {
+	srv, ob := setup(t, WithExpiryInterval(10*time.Millisecond))
+	c := connect(t, srv)
+
+	expireTime := time.Now().Add(50 * time.Millisecond)
+	c.send(Message{Type: MessageNew, Instrument: instrument, OrderType: "Limit", Side: "BUY", Qty: 10, Price: "20", Params: []string{"GTD"}, ExpireTime: &expireTime})
+	accepted := c.expect(MessageAccepted)
+	expired := c.expect(MessageCancelled)
+	if expired.OrderID != accepted.OrderID || expired.Reason != ErrOrderExpired.Error() {
+		t.Errorf("expected the GTD order to expire, got %+v", expired)
+	}
+	if _, ok := ob.GetOrder(accepted.OrderID); ok {
+		t.Errorf("expected the expired order not to be active")
+	}
+}
+
+func TestServer_Watch(t *testing.T) {
+	srv, _ := setup(t)
+	customerID := uuid.New()
+	watched := make(chan Message, 16)
+	stop := srv.Watch(customerID, func(msg Message) {
+		watched <- msg
+	})
+	c := connectAs(t, srv, customerID)
+	other := connect(t, srv)
+
+	other.send(Message{Type: MessageNew, Instrument: instrument, OrderType: "Limit", Side: "SELL", Qty: 5, Price: "20"})
+	other.expect(MessageAccepted)
+	c.send(Message{Type: MessageNew, ClientOrderID: "w1", Instrument: instrument, OrderType: "Limit", Side: "BUY", Qty: 10, Price: "20"})
+	accepted := c.expect(MessageAccepted)
+	c.send(Message{Type: MessageCancel, OrderID: accepted.OrderID + 100})
+	c.expect(MessageFill)
+	c.expect(MessageRejected)
+
+	for _, expected := range []MessageType{MessageAccepted, MessageFill, MessageRejected} {
+		select {
+		case msg := <-watched:
+			if msg.Type != expected || (expected != MessageRejected && msg.OrderID != accepted.OrderID) {
+				t.Errorf("expected a watched %s of order %d, got %+v", expected, accepted.OrderID, msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected a watched %s", expected)
+		}
+	}
+
+	stop()
+	c.send(Message{Type: MessageCancel, OrderID: accepted.OrderID})
+	c.expect(MessageCancelled)
+	select {
+	case msg := <-watched:
+		t.Errorf("expected no messages after the watch stopped, got %+v", msg)
+	default:
+	}
+}
+
+func TestServer_CancelOnDisconnectGracePerSession(t *testing.T) {
+	const grace = 200 * time.Millisecond
+	srv, ob := setup(t, WithCancelOnDisconnect(grace))
+	customerID := uuid.New()
+	first, second := connectAs(t, srv, customerID), connectAs(t, srv, customerID)
+	first.send(Message{Type: MessageNew, Instrument: instrument, OrderType: "Limit", Side: "BUY", Qty: 10, Price: "19"})
+	firstOrder := first.expect(MessageAccepted)
+	second.send(Message{Type: MessageNew, Instrument: instrument, OrderType: "Limit", Side: "BUY", Qty: 10, Price: "18"})
+	secondOrder := second.expect(MessageAccepted)
+
+	// the second session drops during the grace period of the first one and gets its own
+	first.conn.Close()
+	time.Sleep(grace / 2)
+	second.conn.Close()
+	time.Sleep(grace/2 + grace/4)
+	if _, ok := ob.GetOrder(firstOrder.OrderID); ok {
+		t.Errorf("expected the order of the first session to be cancelled after its grace period")
+	}
+	if _, ok := ob.GetOrder(secondOrder.OrderID); !ok {
+		t.Errorf("expected the order of the second session to stay active during its grace period")
+	}
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if _, ok := ob.GetOrder(secondOrder.OrderID); !ok {
+			return
+		}
+	}
+	t.Errorf("expected the order of the second session to be cancelled after its grace period")
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/google/uuid"
+	"net"
+	"sync"
+)
+
+// Client session over a TCP connection. Messages are read and processed sequentially by the session goroutine and
+// written by a separate writer goroutine, so slow clients don't block matching.
+type session struct {
+	server *Server
+	conn   net.Conn
+	client *Client // set by the logon
+
+	mutex  sync.Mutex
+	out    chan Message
+	done   chan struct{}
+	closed bool
+}
+
+func newSession(server *Server, conn net.Conn) *session {
+	return &session{
+		server: server,
+		conn:   conn,
+		out:    make(chan Message, server.sendBuffer),
+		done:   make(chan struct{}),
+	}
+}
+
+// Queue a message without waiting. A session which can't keep up is closed.
+func (s *session) send(msg Message) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.out <- msg:
+	default:
+		s.server.logf("closing session %s: send buffer is full\n", s.conn.RemoteAddr())
+		s.closeLocked()
+		s.conn.Close()
+	}
+}
+
+func (s *session) close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.closed {
+		s.closeLocked()
+	}
+}
+
+func (s *session) closeLocked() {
+	s.closed = true
+	close(s.done)
+}
+
+func (s *session) run() {
+	defer s.server.removeSession(s)
+	writerDone := make(chan struct{})
+	go s.write(writerDone)
+
+	decoder := json.NewDecoder(bufio.NewReader(s.conn))
+	for {
+		var msg Message
+		if err := decoder.Decode(&msg); err != nil {
+			break
+		}
+		s.handle(msg)
+	}
+	s.close()
+	<-writerDone
+	if s.client != nil {
+		s.client.Disconnect()
+	}
+}
+
+// write queued messages until the session is closed, then flush the remaining ones and close the connection
+func (s *session) write(writerDone chan struct{}) {
+	defer close(writerDone)
+	defer s.conn.Close()
+	writer := bufio.NewWriter(s.conn)
+	encoder := json.NewEncoder(writer)
+	for {
+		select {
+		case msg := <-s.out:
+			if encoder.Encode(msg) != nil {
+				return
+			}
+			if len(s.out) == 0 && writer.Flush() != nil { // batch writes while messages are queued
+				return
+			}
+		case <-s.done:
+			for {
+				select {
+				case msg := <-s.out:
+					if encoder.Encode(msg) != nil {
+						return
+					}
+				default:
+					writer.Flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *session) handle(msg Message) {
+	if msg.Type == MessageLogon {
+		s.logon(msg)
+		return
+	}
+	if s.client == nil {
+		s.reject(msg, ErrNotLoggedIn)
+		return
+	}
+	s.client.Handle(msg)
+}
+
+func (s *session) reject(msg Message, err error) {
+	s.send(Message{
+		Type:          MessageRejected,
+		RefType:       msg.Type,
+		ClientOrderID: msg.ClientOrderID,
+		OrderID:       msg.OrderID,
+		Reason:        err.Error(),
+	})
+}
+
+func (s *session) logon(msg Message) {
+	customerID, err := uuid.Parse(msg.CustomerID)
+	if err != nil || s.client != nil {
+		s.reject(msg, ErrInvalidCustomerID)
+		return
+	}
+	s.client = s.server.Connect(customerID, s.send)
+	s.client.Reconnect()
+	s.send(Message{Type: MessageLoggedIn, CustomerID: customerID.String()})
+}
@@ -0,0 +1,202 @@
+package tome
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"sort"
+)
+
+const (
+	snapshotMagic = "TOMESNAP"
+	// Snapshots of older versions aren't decoded. Versions:
+	// 1 - initial format
+	// 2 - orders with a display quantity, display peaks are restored from them
+	// 3 - trades with an aggressor side
+	// 4 - trades with buyer and seller fees
+	// 5 - orders with an expire time
+	snapshotVersion = 5
+)
+
+// container of a snapshot entry
+const (
+	containerOrders byte = iota
+	containerStopOrders
+)
+
+var (
+	ErrSnapshotCorrupted   = errors.New("snapshot is corrupted")
+	ErrUnsupportedSnapshot = errors.New("unsupported snapshot version")
+)
+
+// Write a snapshot of the full order book state - market price, active orders in their exact time priority, stop orders
+// and daily trades of the trade book. The snapshot waits for the current command to finish.
+//
+// Format: magic "TOMESNAP", version byte, body, CRC-32C of the body (4B). The body contains the instrument, price scale,
+// journal sequence, market price, container entries (container, tracker, unfilled quantity, order) in iteration order
+// and trades sorted by ID.
+func (o *OrderBook) Snapshot(w io.Writer) error {
+	o.matchMutex.Lock()
+	defer o.matchMutex.Unlock()
+
+	var e encoder
+	e.string(o.Instrument)
+	e.uint32(uint32(o.priceScale))
+	var sequence uint64
+	if o.journal != nil {
+		sequence = o.journal.Sequence()
+	}
+	e.uint64(sequence)
+	e.decimal(o.MarketPrice())
+
+	o.orderMutex.RLock()
+	e.uint32(uint32(len(o.activeOrders)))
+	written := 0
+	for _, c := range []struct {
+		id        byte
+		container *orderContainer
+	}{{containerOrders, o.orders}, {containerStopOrders, o.stopOrders}} {
+		for _, side := range []OrderSide{SideBuy, SideSell} {
+			for iter := c.container.Iterator(side); iter.Valid(); iter.Next() {
+				tracker := iter.Key()
+				order, ok := o.activeOrders[tracker.OrderID]
+				if !ok {
+					o.orderMutex.RUnlock()
+					return fmt.Errorf("order with ID %d not found", tracker.OrderID)
+				}
+				e.uint8(c.id)
+				e.tracker(tracker)
+				e.int64(iter.entry.qty)
+				e.order(order)
+				written += 1
+			}
+		}
+	}
+	o.orderMutex.RUnlock()
+	if written != len(o.activeOrders) {
+		return fmt.Errorf("%d active orders aren't in the books", len(o.activeOrders)-written)
+	}
+
+	o.tradeBook.tradeMutex.RLock()
+	e.uint64(o.tradeBook.lastTradeID)
+	trades := make([]Trade, 0, len(o.tradeBook.trades))
+	for _, trade := range o.tradeBook.trades {
+		trades = append(trades, trade)
+	}
+	o.tradeBook.tradeMutex.RUnlock()
+	sort.Slice(trades, func(i, j int) bool {
+		return trades[i].ID < trades[j].ID
+	})
+	e.uint32(uint32(len(trades)))
+	for _, trade := range trades {
+		e.trade(trade)
+	}
+
+	body := e.buf
+	e = encoder{buf: append([]byte(snapshotMagic), snapshotVersion)}
+	e.buf = append(e.buf, body...)
+	e.uint32(crc32.Checksum(body, crcTable))
+	_, err := w.Write(e.buf)
+	return err
+}
+
+// Restore an order book and its trade book from a snapshot. Snapshot trades are entered to the empty trade book without
+// storing them to its trade repository or calling its trade callbacks, daily statistics are rebuilt from them. Options
+// are applied the same way as in NewOrderBook, but the price scale has to match the snapshot.
+// Returns the journal sequence of the last command included in the snapshot, the journal tail after it can be replayed
+// with ReplayFrom.
+func RestoreOrderBook(r io.Reader, tradeBook *TradeBook, orderRepo OrderRepository, opts ...OrderBookOption) (*OrderBook, uint64, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	headerSize := len(snapshotMagic) + 1
+	if len(data) < headerSize+4 || string(data[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, 0, ErrSnapshotCorrupted
+	}
+	if data[len(snapshotMagic)] != snapshotVersion {
+		return nil, 0, fmt.Errorf("%w %d", ErrUnsupportedSnapshot, data[len(snapshotMagic)])
+	}
+	body := data[headerSize : len(data)-4]
+	checksum := decoder{buf: data[len(data)-4:]}
+	if crc32.Checksum(body, crcTable) != checksum.uint32() {
+		return nil, 0, ErrSnapshotCorrupted
+	}
+
+	d := decoder{buf: body}
+	instrument := d.string()
+	scale := int32(d.uint32())
+	sequence := d.uint64()
+	marketPrice := d.decimal()
+	if d.err != nil {
+		return nil, 0, d.err
+	}
+	if tradeBook.Instrument != instrument {
+		return nil, 0, fmt.Errorf("snapshot instrument %s doesn't match the trade book instrument %s", instrument, tradeBook.Instrument)
+	}
+
+	tradeBook.tradeMutex.Lock()
+	defer tradeBook.tradeMutex.Unlock()
+	if len(tradeBook.trades) != 0 {
+		return nil, 0, errors.New("trade book has to be empty")
+	}
+	book := NewOrderBook(instrument, marketPrice, tradeBook, orderRepo, append([]OrderBookOption{WithPriceScale(scale)}, opts...)...)
+	if book.priceScale != scale {
+		return nil, 0, fmt.Errorf("snapshot price scale %d doesn't match the order book price scale %d", scale, book.priceScale)
+	}
+
+	n := int(d.uint32())
+	for i := 0; i < n && d.err == nil; i++ {
+		container := d.uint8()
+		tracker := d.tracker()
+		qty := d.int64()
+		order := d.order()
+		if d.err != nil {
+			break
+		}
+		switch container {
+		case containerOrders:
+			book.orders.Add(tracker, qty) // trackers are added in iteration order, so equal timestamps keep their priority
+			book.orders.SetPeak(order.ID, displayPeak(order))
+		case containerStopOrders:
+			book.stopOrders.Add(tracker, qty)
+		default:
+			return nil, 0, ErrSnapshotCorrupted
+		}
+		book.activeOrders[order.ID] = order
+		book.trackExpiry(order)
+	}
+
+	book.resetPublished() // market data continues from the restored books
+	tradeBook.lastTradeID = d.uint64()
+	n = int(d.uint32())
+	for i := 0; i < n && d.err == nil; i++ {
+		trade := d.trade()
+		tradeBook.trades[trade.ID] = trade
+		tradeBook.daily.add(trade) // trades are sorted by ID, so statistics are rebuilt in execution order
+	}
+	if err := d.finish(); err != nil {
+		return nil, 0, err
+	}
+	return book, sequence, nil
+}
+
+func (e *encoder) tracker(t OrderTracker) {
+	e.uint64(t.OrderID)
+	e.uint8(uint8(t.Type))
+	e.int64(t.Price)
+	e.bool(bool(t.Side))
+	e.int64(t.Timestamp)
+}
+
+func (d *decoder) tracker() OrderTracker {
+	var t OrderTracker
+	t.OrderID = d.uint64()
+	t.Type = OrderType(d.uint8())
+	t.Price = d.int64()
+	t.Side = OrderSide(d.bool())
+	t.Timestamp = d.int64()
+	return t
+}
@@ -0,0 +1,205 @@
+package tome
+
+import (
+	"bytes"
+	"errors"
+	"github.com/cockroachdb/apd"
+	"os"
+	"testing"
+	"time"
+)
+
+func orderIDs(orders []Order) []uint64 {
+	ids := make([]uint64, len(orders))
+	for i, order := range orders {
+		ids[i] = order.ID
+	}
+	return ids
+}
+
+func assertSameBooks(t *testing.T, expected, actual *OrderBook) {
+	for _, books := range [][2][]Order{
+		{expected.GetBids(), actual.GetBids()},
+		{expected.GetAsks(), actual.GetAsks()},
+		{expected.GetStopBids(), actual.GetStopBids()},
+		{expected.GetStopAsks(), actual.GetStopAsks()},
+	} {
+		if len(books[0]) != len(books[1]) {
+			t.Fatalf("expected orders %v, got %v", orderIDs(books[0]), orderIDs(books[1]))
+		}
+		for i := range books[0] {
+			if !bytes.Equal(mustMarshal(books[0][i]), mustMarshal(books[1][i])) {
+				t.Errorf("expected order %+v, got %+v", books[0][i], books[1][i])
+			}
+		}
+	}
+	expectedPrice, actualPrice := expected.MarketPrice(), actual.MarketPrice()
+	if expectedPrice.Cmp(&actualPrice) != 0 {
+		t.Errorf("expected market price %s, got %s", expectedPrice.String(), actualPrice.String())
+	}
+	if len(expected.tradeBook.trades) != len(actual.tradeBook.trades) || expected.tradeBook.lastTradeID != actual.tradeBook.lastTradeID {
+		t.Errorf("expected %d trades, got %d", len(expected.tradeBook.trades), len(actual.tradeBook.trades))
+	}
+	expectedStats, actualStats := expected.Statistics(), actual.Statistics()
+	if expectedStats.Trades == 0 || expectedStats.Trades != actualStats.Trades || expectedStats.Volume != actualStats.Volume ||
+		expectedStats.Open.Cmp(&actualStats.Open) != 0 || expectedStats.Last.Cmp(&actualStats.Last) != 0 ||
+		expectedStats.Turnover.Cmp(&actualStats.Turnover) != 0 || expectedStats.VWAP.Cmp(&actualStats.VWAP) != 0 {
+		t.Errorf("expected statistics %+v, got %+v", expectedStats, actualStats)
+	}
+}
+
+func TestOrderBook_SnapshotRestore(t *testing.T) {
+	_, ob := setup(2025, -2)
+
+	now := time.Now()
+	orders := []Order{
+		createOrder(1, TypeLimit, 0, 5, *apd.New(2010, -2), apd.Decimal{}, SideSell),
+		createOrder(2, TypeLimit, 0, 5, *apd.New(2010, -2), apd.Decimal{}, SideSell),
+		createOrder(3, TypeLimit, 0, 5, *apd.New(2010, -2), apd.Decimal{}, SideSell),
+		createOrder(4, TypeLimit, ParamStop, 2, *apd.New(2030, -2), *apd.New(2030, -2), SideBuy),
+		createOrder(5, TypeLimit, 0, 4, *apd.New(2000, -2), apd.Decimal{}, SideBuy),
+		createOrder(6, TypeLimit, 0, 2, *apd.New(2010, -2), apd.Decimal{}, SideBuy),
+	}
+	for i, order := range orders {
+		if i < 3 {
+			order.Timestamp = now // equal timestamps, priority is determined by insertion order
+		}
+		if _, err := ob.Add(order); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := ob.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	restored, sequence, err := RestoreOrderBook(bytes.NewReader(buf.Bytes()), NewTradeBook(instrument), NOPOrderRepository)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sequence != 0 {
+		t.Errorf("expected journal sequence 0, got %d", sequence)
+	}
+	assertSameBooks(t, ob, restored)
+
+	// both books match the same way after restoring
+	for _, book := range []*OrderBook{ob, restored} {
+		if _, err := book.Add(createOrder(7, TypeMarket, 0, 6, apd.Decimal{}, apd.Decimal{}, SideBuy)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertSameBooks(t, ob, restored)
+
+	data := buf.Bytes()
+	data[len(data)/2] ^= 0xFF
+	if _, _, err := RestoreOrderBook(bytes.NewReader(data), NewTradeBook(instrument), NOPOrderRepository); err != ErrSnapshotCorrupted {
+		t.Errorf("expected %v, got %v", ErrSnapshotCorrupted, err)
+	}
+}
+
+func TestOrderBook_SnapshotJournalTail(t *testing.T) {
+	j, path := openTestJournal(t, JournalConfig{Sync: SyncNone})
+	clock := &SimulatedClock{}
+	clock.Set(time.Unix(1600000000, 0))
+	ob := NewOrderBook(instrument, *apd.New(2025, -2), NewTradeBook(instrument), NOPOrderRepository,
+		WithJournal(j), WithClock(clock.Now))
+
+	add := func(order Order) {
+		clock.Set(clock.Now().Add(time.Second))
+		if _, err := ob.Add(order); err != nil {
+			t.Fatal(err)
+		}
+	}
+	add(createOrder(1, TypeLimit, 0, 5, *apd.New(2010, -2), apd.Decimal{}, SideSell))
+	add(createOrder(2, TypeLimit, 0, 5, *apd.New(2011, -2), apd.Decimal{}, SideSell))
+	add(createOrder(3, TypeLimit, 0, 2, *apd.New(2010, -2), apd.Decimal{}, SideBuy))
+
+	var buf bytes.Buffer
+	if err := ob.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	add(createOrder(4, TypeLimit, 0, 5, *apd.New(2011, -2), apd.Decimal{}, SideBuy))
+	add(createOrder(5, TypeLimit, 0, 3, *apd.New(2005, -2), apd.Decimal{}, SideBuy))
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	replayClock := &SimulatedClock{}
+	restored, sequence, err := RestoreOrderBook(&buf, NewTradeBook(instrument), NOPOrderRepository, WithClock(replayClock.Now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sequence == 0 {
+		t.Fatal("expected the snapshot to contain the journal sequence")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	result, err := ReplayFrom(file, sequence, restored, replayClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Commands != 2 {
+		t.Errorf("expected 2 replayed commands, got %d", result.Commands)
+	}
+	assertSameBooks(t, ob, restored)
+}
+
+func TestRestoreOrderBook_UnsupportedVersion(t *testing.T) {
+	_, ob := setup(2025, -2)
+	var buf bytes.Buffer
+	if err := ob.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for version := byte(1); version < snapshotVersion; version++ {
+		data := append([]byte(nil), buf.Bytes()...)
+		data[len(snapshotMagic)] = version
+		if _, _, err := RestoreOrderBook(bytes.NewReader(data), NewTradeBook(instrument), NOPOrderRepository); !errors.Is(err, ErrUnsupportedSnapshot) {
+			t.Errorf("version %d: expected %v, got %v", version, ErrUnsupportedSnapshot, err)
+		}
+	}
+}
+
+func TestRestoreOrderBook_MarketData(t *testing.T) {
+	_, ob := setup(2025, -2)
+	for _, order := range []Order{
+		createOrder(1, TypeLimit, 0, 5, *apd.New(2010, -2), apd.Decimal{}, SideSell),
+		createOrder(2, TypeLimit, 0, 4, *apd.New(2000, -2), apd.Decimal{}, SideBuy),
+	} {
+		if _, err := ob.Add(order); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := ob.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	updates := make([]MarketDataUpdate, 0)
+	restored, _, err := RestoreOrderBook(&buf, NewTradeBook(instrument), NOPOrderRepository, WithMarketDataHandler(func(update MarketDataUpdate) {
+		updates = append(updates, update)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restored.Add(createOrder(3, TypeLimit, 0, 2, *apd.New(2000, -2), apd.Decimal{}, SideBuy)); err != nil {
+		t.Fatal(err)
+	}
+	// the restored bid level changes and remains the best bid
+	expected := []MarketDataType{UpdateOrderAdd, UpdateLevelChange, UpdateBest}
+	if len(updates) != len(expected) {
+		t.Fatalf("expected updates %v, got %+v", expected, updates)
+	}
+	for i, update := range updates {
+		if update.Type != expected[i] {
+			t.Errorf("expected update %d to be %s, got %s", i, expected[i], update.Type)
+		}
+	}
+	if updates[1].Qty != 6 || updates[1].Count != 2 {
+		t.Errorf("expected a level of 6 in 2 orders, got %+v", updates[1])
+	}
+}
@@ -0,0 +1,56 @@
+package tome
+
+import (
+	"github.com/cockroachdb/apd"
+)
+
+var hundred = apd.New(100, 0)
+
+// Daily trading statistics of an instrument.
+type Statistics struct {
+	Open, High, Low, Last apd.Decimal // zero if there were no trades
+	PreviousClose         apd.Decimal // set with WithPreviousClose, zero if unknown
+	Change                apd.Decimal // Last - PreviousClose, zero if there were no trades or the previous close is unknown
+	ChangePercent         apd.Decimal // Change relative to PreviousClose in percent
+	Volume                int64       // traded quantity
+	Turnover              apd.Decimal // sum of trade totals
+	VWAP                  apd.Decimal // volume weighted average price
+	Trades                int         // number of trades
+}
+
+// Set the previous close price used to calculate the daily change.
+func WithPreviousClose(price apd.Decimal) TradeBookOption {
+	return func(t *TradeBook) {
+		t.previousClose = price
+	}
+}
+
+// Returns daily trading statistics. Statistics are updated with every entered trade.
+func (t *TradeBook) Statistics() Statistics {
+	t.tradeMutex.RLock()
+	daily := t.daily
+	t.tradeMutex.RUnlock()
+
+	stats := Statistics{
+		Open:          daily.Open,
+		High:          daily.High,
+		Low:           daily.Low,
+		Last:          daily.Close,
+		PreviousClose: t.previousClose,
+		Volume:        daily.Volume,
+		Turnover:      daily.Turnover,
+		VWAP:          daily.VWAP,
+		Trades:        daily.Trades,
+	}
+	if daily.Trades > 0 && !t.previousClose.IsZero() {
+		_, _ = BaseContext.Sub(&stats.Change, &stats.Last, &stats.PreviousClose)
+		_, _ = divisionContext.Quo(&stats.ChangePercent, &stats.Change, &stats.PreviousClose)
+		_, _ = divisionContext.Mul(&stats.ChangePercent, &stats.ChangePercent, hundred)
+	}
+	return stats
+}
+
+// Returns daily trading statistics of the order book instrument.
+func (o *OrderBook) Statistics() Statistics {
+	return o.tradeBook.Statistics()
+}
@@ -0,0 +1,8 @@
+// Package tomepb contains the protobuf definition of the matching engine gRPC service (tome.proto) and its generated
+// Go code, the service is implemented by package grpcserver. Regenerate the code after changing the definition with
+// protoc and the protoc-gen-go and protoc-gen-go-grpc plugins:
+//
+//	go generate ./tomepb
+package tomepb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative tome.proto
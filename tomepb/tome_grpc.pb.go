@@ -0,0 +1,293 @@
+// Matching engine service. Orders are entered through the order entry server (package server), so order IDs and
+// ownership are shared with the other gateways. Decimals (prices, totals) are strings, e.g. "20.25".
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: tome.proto
+
+package tomepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	MatchingEngine_NewOrder_FullMethodName         = "/tome.v1.MatchingEngine/NewOrder"
+	MatchingEngine_CancelOrder_FullMethodName      = "/tome.v1.MatchingEngine/CancelOrder"
+	MatchingEngine_AmendOrder_FullMethodName       = "/tome.v1.MatchingEngine/AmendOrder"
+	MatchingEngine_ExecutionReports_FullMethodName = "/tome.v1.MatchingEngine/ExecutionReports"
+	MatchingEngine_MarketData_FullMethodName       = "/tome.v1.MatchingEngine/MarketData"
+)
+
+// MatchingEngineClient is the client API for MatchingEngine service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type MatchingEngineClient interface {
+	// Order entry - a rejected request fails with INVALID_ARGUMENT (NOT_FOUND for unknown orders) and the order book
+	// error as the status message.
+	NewOrder(ctx context.Context, in *NewOrderRequest, opts ...grpc.CallOption) (*OrderResponse, error)
+	CancelOrder(ctx context.Context, in *CancelOrderRequest, opts ...grpc.CallOption) (*OrderResponse, error)
+	AmendOrder(ctx context.Context, in *AmendOrderRequest, opts ...grpc.CallOption) (*OrderResponse, error)
+	// Execution reports of all orders of a customer, including orders entered through other gateways.
+	ExecutionReports(ctx context.Context, in *ExecutionReportsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ExecutionReport], error)
+	// Market data of an instrument - a snapshot of displayed price levels followed by sequenced trades and L1, L2 and
+	// L3 updates.
+	MarketData(ctx context.Context, in *MarketDataRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[MarketDataMessage], error)
+}
+
+type matchingEngineClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMatchingEngineClient(cc grpc.ClientConnInterface) MatchingEngineClient {
+	return &matchingEngineClient{cc}
+}
+
+func (c *matchingEngineClient) NewOrder(ctx context.Context, in *NewOrderRequest, opts ...grpc.CallOption) (*OrderResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(OrderResponse)
+	err := c.cc.Invoke(ctx, MatchingEngine_NewOrder_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *matchingEngineClient) CancelOrder(ctx context.Context, in *CancelOrderRequest, opts ...grpc.CallOption) (*OrderResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(OrderResponse)
+	err := c.cc.Invoke(ctx, MatchingEngine_CancelOrder_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *matchingEngineClient) AmendOrder(ctx context.Context, in *AmendOrderRequest, opts ...grpc.CallOption) (*OrderResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(OrderResponse)
+	err := c.cc.Invoke(ctx, MatchingEngine_AmendOrder_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *matchingEngineClient) ExecutionReports(ctx context.Context, in *ExecutionReportsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ExecutionReport], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &MatchingEngine_ServiceDesc.Streams[0], MatchingEngine_ExecutionReports_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ExecutionReportsRequest, ExecutionReport]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MatchingEngine_ExecutionReportsClient = grpc.ServerStreamingClient[ExecutionReport]
+
+func (c *matchingEngineClient) MarketData(ctx context.Context, in *MarketDataRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[MarketDataMessage], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &MatchingEngine_ServiceDesc.Streams[1], MatchingEngine_MarketData_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[MarketDataRequest, MarketDataMessage]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MatchingEngine_MarketDataClient = grpc.ServerStreamingClient[MarketDataMessage]
+
+// MatchingEngineServer is the server API for MatchingEngine service.
+// All implementations must embed UnimplementedMatchingEngineServer
+// for forward compatibility.
+type MatchingEngineServer interface {
+	// Order entry - a rejected request fails with INVALID_ARGUMENT (NOT_FOUND for unknown orders) and the order book
+	// error as the status message.
+	NewOrder(context.Context, *NewOrderRequest) (*OrderResponse, error)
+	CancelOrder(context.Context, *CancelOrderRequest) (*OrderResponse, error)
+	AmendOrder(context.Context, *AmendOrderRequest) (*OrderResponse, error)
+	// Execution reports of all orders of a customer, including orders entered through other gateways.
+	ExecutionReports(*ExecutionReportsRequest, grpc.ServerStreamingServer[ExecutionReport]) error
+	// Market data of an instrument - a snapshot of displayed price levels followed by sequenced trades and L1, L2 and
+	// L3 updates.
+	MarketData(*MarketDataRequest, grpc.ServerStreamingServer[MarketDataMessage]) error
+	mustEmbedUnimplementedMatchingEngineServer()
+}
+
+// UnimplementedMatchingEngineServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedMatchingEngineServer struct{}
+
+func (UnimplementedMatchingEngineServer) NewOrder(context.Context, *NewOrderRequest) (*OrderResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method NewOrder not implemented")
+}
+func (UnimplementedMatchingEngineServer) CancelOrder(context.Context, *CancelOrderRequest) (*OrderResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelOrder not implemented")
+}
+func (UnimplementedMatchingEngineServer) AmendOrder(context.Context, *AmendOrderRequest) (*OrderResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AmendOrder not implemented")
+}
+func (UnimplementedMatchingEngineServer) ExecutionReports(*ExecutionReportsRequest, grpc.ServerStreamingServer[ExecutionReport]) error {
+	return status.Errorf(codes.Unimplemented, "method ExecutionReports not implemented")
+}
+func (UnimplementedMatchingEngineServer) MarketData(*MarketDataRequest, grpc.ServerStreamingServer[MarketDataMessage]) error {
+	return status.Errorf(codes.Unimplemented, "method MarketData not implemented")
+}
+func (UnimplementedMatchingEngineServer) mustEmbedUnimplementedMatchingEngineServer() {}
+func (UnimplementedMatchingEngineServer) testEmbeddedByValue()                        {}
+
+// UnsafeMatchingEngineServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MatchingEngineServer will
+// result in compilation errors.
+type UnsafeMatchingEngineServer interface {
+	mustEmbedUnimplementedMatchingEngineServer()
+}
+
+func RegisterMatchingEngineServer(s grpc.ServiceRegistrar, srv MatchingEngineServer) {
+	// If the following call pancis, it indicates UnimplementedMatchingEngineServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&MatchingEngine_ServiceDesc, srv)
+}
+
+func _MatchingEngine_NewOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NewOrderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MatchingEngineServer).NewOrder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MatchingEngine_NewOrder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MatchingEngineServer).NewOrder(ctx, req.(*NewOrderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MatchingEngine_CancelOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelOrderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MatchingEngineServer).CancelOrder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MatchingEngine_CancelOrder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MatchingEngineServer).CancelOrder(ctx, req.(*CancelOrderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MatchingEngine_AmendOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AmendOrderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MatchingEngineServer).AmendOrder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MatchingEngine_AmendOrder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MatchingEngineServer).AmendOrder(ctx, req.(*AmendOrderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MatchingEngine_ExecutionReports_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExecutionReportsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MatchingEngineServer).ExecutionReports(m, &grpc.GenericServerStream[ExecutionReportsRequest, ExecutionReport]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MatchingEngine_ExecutionReportsServer = grpc.ServerStreamingServer[ExecutionReport]
+
+func _MatchingEngine_MarketData_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(MarketDataRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MatchingEngineServer).MarketData(m, &grpc.GenericServerStream[MarketDataRequest, MarketDataMessage]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MatchingEngine_MarketDataServer = grpc.ServerStreamingServer[MarketDataMessage]
+
+// MatchingEngine_ServiceDesc is the grpc.ServiceDesc for MatchingEngine service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var MatchingEngine_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "tome.v1.MatchingEngine",
+	HandlerType: (*MatchingEngineServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "NewOrder",
+			Handler:    _MatchingEngine_NewOrder_Handler,
+		},
+		{
+			MethodName: "CancelOrder",
+			Handler:    _MatchingEngine_CancelOrder_Handler,
+		},
+		{
+			MethodName: "AmendOrder",
+			Handler:    _MatchingEngine_AmendOrder_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ExecutionReports",
+			Handler:       _MatchingEngine_ExecutionReports_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "MarketData",
+			Handler:       _MatchingEngine_MarketData_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "tome.proto",
+}
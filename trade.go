@@ -6,6 +6,25 @@ import (
 	"time"
 )
 
+// Liquidity role of a trade participant.
+type Liquidity byte
+
+const (
+	LiquidityMaker Liquidity = iota + 1 // resting order which provided liquidity
+	LiquidityTaker                      // incoming order which removed liquidity
+)
+
+func (l Liquidity) String() string {
+	switch l {
+	case LiquidityMaker:
+		return "Maker"
+	case LiquidityTaker:
+		return "Taker"
+	default:
+		return "invalid"
+	}
+}
+
 // Trade represents two opposed matched orders.
 type Trade struct {
 	ID            uint64
@@ -13,9 +32,35 @@ type Trade struct {
 	Instrument    string
 	Qty           int64
 	Price         apd.Decimal
-	Total         apd.Decimal
+	Total         apd.Decimal // exact Price * Qty
 	Timestamp     time.Time
 
 	BidOrderID uint64
 	AskOrderID uint64
+
+	AggressorSide OrderSide // side of the incoming (taker) order
+
+	BuyerFee  apd.Decimal // set by the order book fee model, negative fees are rebates
+	SellerFee apd.Decimal
+}
+
+// Returns the liquidity role of the buyer.
+func (t Trade) BuyerLiquidity() Liquidity {
+	if t.AggressorSide == SideBuy {
+		return LiquidityTaker
+	}
+	return LiquidityMaker
+}
+
+// Returns the liquidity role of the seller.
+func (t Trade) SellerLiquidity() Liquidity {
+	if t.AggressorSide == SideSell {
+		return LiquidityTaker
+	}
+	return LiquidityMaker
+}
+
+// sets total to the exact trade total - price * quantity
+func tradeTotal(total *apd.Decimal, trade Trade) {
+	_, _ = BaseContext.Mul(total, &trade.Price, apd.New(trade.Qty, 0))
 }
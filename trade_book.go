@@ -1,36 +1,132 @@
 package tome
 
 import (
+	"errors"
+	"fmt"
+	"github.com/cockroachdb/apd"
+	"log"
 	"sort"
 	"sync"
+	"time"
 )
 
+const (
+	DefaultFlushInterval = time.Second
+	DefaultFlushSize     = 100
+)
+
+var ErrTradeBookClosed = errors.New("trade book is closed")
+
 // Trade book stores all daily trades in-memory.
-// It flushes new trades periodically to persistent storage. (TODO)
+// If it has a trade repository, new trades are flushed to it in batches from a background goroutine.
 type TradeBook struct {
 	Instrument string
 
 	trades      map[uint64]Trade
 	tradeMutex  sync.RWMutex
 	lastTradeID uint64
+
+	repo          TradeRepository // persistent trade storage, can be nil
+	flushInterval time.Duration   // pending trades are flushed at least once per flushInterval
+	flushSize     int             // pending trades are flushed as soon as there are flushSize of them
+	onFlushError  func(err error) // called from the background goroutine when a flush fails
+	pending       []Trade         // trades which haven't been stored yet, guarded by tradeMutex
+	flushMutex    sync.Mutex      // ensures that flushes are sequential
+	flushSignal   chan struct{}   // wakes up the background goroutine when flushSize is reached
+	stop, done    chan struct{}
+	closed        bool // guarded by flushMutex
+
+	callbacks []TradeCallback // called with every entered trade
+
+	daily         Candle      // accumulated daily statistics, guarded by tradeMutex
+	previousClose apd.Decimal // used to calculate the daily change
 }
 
-// Create a new trade book.
-func NewTradeBook(instrument string) *TradeBook {
-	return &TradeBook{
-		Instrument: instrument,
-		trades:     make(map[uint64]Trade),
+// TradeBookOption changes the default behaviour of a trade book.
+type TradeBookOption func(t *TradeBook)
+
+// Store all entered trades to the repository. Trades are stored at least once - a trade which failed to be stored is
+// retried on the next flush.
+func WithTradeRepository(repo TradeRepository) TradeBookOption {
+	return func(t *TradeBook) {
+		t.repo = repo
+	}
+}
+
+// Flush pending trades at least once per interval and as soon as there are size pending trades.
+func WithFlushPolicy(interval time.Duration, size int) TradeBookOption {
+	return func(t *TradeBook) {
+		t.flushInterval = interval
+		t.flushSize = size
+	}
+}
+
+// Report errors of background flushes, errors are logged by default.
+func WithFlushErrorHandler(handler func(err error)) TradeBookOption {
+	return func(t *TradeBook) {
+		t.onFlushError = handler
+	}
+}
+
+// Execute the callback with every entered trade (e.g. CandleAggregator). Callbacks are called synchronously while the
+// order book is matching, so they can't call order book commands.
+func WithTradeCallback(callback TradeCallback) TradeBookOption {
+	return func(t *TradeBook) {
+		t.callbacks = append(t.callbacks, callback)
+	}
+}
+
+// Create a new trade book. Trade books with a trade repository have to be closed.
+func NewTradeBook(instrument string, opts ...TradeBookOption) *TradeBook {
+	t := &TradeBook{
+		Instrument:    instrument,
+		trades:        make(map[uint64]Trade),
+		flushInterval: DefaultFlushInterval,
+		flushSize:     DefaultFlushSize,
+		onFlushError: func(err error) {
+			log.Println(err)
+		},
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	if t.repo != nil {
+		t.flushSignal = make(chan struct{}, 1)
+		t.stop = make(chan struct{})
+		t.done = make(chan struct{})
+		go t.flushPeriodically()
 	}
+	return t
 }
 
-// Enter a new trade.
-func (t *TradeBook) Enter(trade Trade) {
+// Enter a new trade. Returns the trade with its assigned ID.
+func (t *TradeBook) Enter(trade Trade) Trade {
+	trade = t.enter(trade)
+	for _, callback := range t.callbacks {
+		callback.Execute(trade)
+	}
+	return trade
+}
+
+func (t *TradeBook) enter(trade Trade) Trade {
 	t.tradeMutex.Lock()
 	defer t.tradeMutex.Unlock()
 
 	trade.ID = t.lastTradeID
 	t.trades[t.lastTradeID] = trade
 	t.lastTradeID += 1
+	t.daily.add(trade)
+
+	if t.repo != nil {
+		t.pending = append(t.pending, trade)
+		if t.flushSize > 0 && len(t.pending) >= t.flushSize {
+			select {
+			case t.flushSignal <- struct{}{}:
+			default: // a flush is already requested
+			}
+		}
+	}
+	return trade
 }
 
 // Return all daily trades in a trade book.
@@ -49,3 +145,65 @@ func (t *TradeBook) DailyTrades() []Trade {
 	})
 	return tradesCopy
 }
+
+// Store all pending trades to the trade repository. Trades which weren't stored stay pending.
+func (t *TradeBook) Flush() error {
+	if t.repo == nil {
+		return nil
+	}
+	t.flushMutex.Lock()
+	defer t.flushMutex.Unlock()
+
+	t.tradeMutex.Lock()
+	pending := t.pending
+	t.pending = nil
+	t.tradeMutex.Unlock()
+
+	for i, trade := range pending {
+		if err := t.repo.Store(trade); err != nil {
+			t.tradeMutex.Lock()
+			t.pending = append(pending[i:len(pending):len(pending)], t.pending...) // retry in the original order
+			t.tradeMutex.Unlock()
+			return fmt.Errorf("cannot store trade %d: %w", trade.ID, err)
+		}
+	}
+	return nil
+}
+
+func (t *TradeBook) flushPeriodically() {
+	defer close(t.done)
+	var tick <-chan time.Time
+	if t.flushInterval > 0 {
+		ticker := time.NewTicker(t.flushInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-tick:
+		case <-t.flushSignal:
+		}
+		if err := t.Flush(); err != nil {
+			t.onFlushError(err)
+		}
+	}
+}
+
+// Stop background flushing and flush all pending trades. Trades entered after closing aren't stored.
+func (t *TradeBook) Close() error {
+	if t.repo == nil {
+		return nil
+	}
+	t.flushMutex.Lock()
+	closed := t.closed
+	t.closed = true
+	t.flushMutex.Unlock()
+	if closed {
+		return ErrTradeBookClosed
+	}
+	close(t.stop)
+	<-t.done
+	return t.Flush()
+}
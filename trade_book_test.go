@@ -0,0 +1,138 @@
+package tome
+
+import (
+	"errors"
+	"github.com/cockroachdb/apd"
+	"sync"
+	"testing"
+	"time"
+)
+
+// in-memory trade repository which fails the first failures stores
+type testTradeRepository struct {
+	mutex    sync.Mutex
+	failures int
+	stored   []uint64
+}
+
+func (r *testTradeRepository) Store(trade Trade) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.failures > 0 {
+		r.failures -= 1
+		return errors.New("repository unavailable")
+	}
+	r.stored = append(r.stored, trade.ID)
+	return nil
+}
+
+func (r *testTradeRepository) GetByID(id uint64) (Trade, error) {
+	return Trade{}, ErrTradeNotFound
+}
+
+func (r *testTradeRepository) storedIDs() []uint64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]uint64(nil), r.stored...)
+}
+
+func TestTradeBook_FlushBySize(t *testing.T) {
+	repo := &testTradeRepository{}
+	tb := NewTradeBook(instrument, WithTradeRepository(repo), WithFlushPolicy(time.Hour, 3))
+	defer tb.Close()
+
+	for i := 0; i < 3; i++ {
+		tb.Enter(Trade{Instrument: instrument, Qty: 1})
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(repo.storedIDs()) != 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 3 trades to be flushed, got %v", repo.storedIDs())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTradeBook_RetryAndFlushOnClose(t *testing.T) {
+	repo := &testTradeRepository{failures: 1}
+	errs := make(chan error, 10)
+	tb := NewTradeBook(instrument, WithTradeRepository(repo), WithFlushPolicy(time.Millisecond, 0),
+		WithFlushErrorHandler(func(err error) {
+			errs <- err
+		}))
+
+	tb.Enter(Trade{Instrument: instrument, Qty: 1})
+	tb.Enter(Trade{Instrument: instrument, Qty: 1})
+	select {
+	case <-errs:
+	case <-time.After(time.Second):
+		t.Fatal("expected a flush error to be reported")
+	}
+	tb.Enter(Trade{Instrument: instrument, Qty: 1})
+	if err := tb.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := repo.storedIDs()
+	expected := []uint64{0, 1, 2}
+	if len(stored) != len(expected) {
+		t.Fatalf("expected stored trades %v, got %v", expected, stored)
+	}
+	for i := range expected {
+		if stored[i] != expected[i] {
+			t.Fatalf("expected stored trades %v, got %v", expected, stored)
+		}
+	}
+	if err := tb.Close(); err != ErrTradeBookClosed {
+		t.Errorf("expected %v, got %v", ErrTradeBookClosed, err)
+	}
+}
+
+func TestTradeBook_Statistics(t *testing.T) {
+	tb := NewTradeBook(instrument, WithPreviousClose(*apd.New(2000, -2)))
+	if stats := tb.Statistics(); stats.Trades != 0 || !stats.Last.IsZero() || !stats.Change.IsZero() {
+		t.Errorf("expected empty statistics, got %+v", stats)
+	}
+	for _, trade := range []struct {
+		price int64
+		qty   int64
+	}{{2010, 10}, {1990, 30}, {2030, 10}, {2025, 50}} {
+		tb.Enter(Trade{Instrument: instrument, Qty: trade.qty, Price: *apd.New(trade.price, -2), Total: *apd.New(trade.price*trade.qty, -2)})
+	}
+
+	stats := tb.Statistics()
+	for _, d := range []struct {
+		name     string
+		actual   apd.Decimal
+		expected *apd.Decimal
+	}{
+		{"open", stats.Open, apd.New(2010, -2)},
+		{"high", stats.High, apd.New(2030, -2)},
+		{"low", stats.Low, apd.New(1990, -2)},
+		{"last", stats.Last, apd.New(2025, -2)},
+		{"previous close", stats.PreviousClose, apd.New(2000, -2)},
+		{"change", stats.Change, apd.New(25, -2)},
+		{"change percent", stats.ChangePercent, apd.New(125, -2)},
+		{"turnover", stats.Turnover, apd.New(201350, -2)},
+		{"vwap", stats.VWAP, apd.New(20135, -3)},
+	} {
+		if d.actual.Cmp(d.expected) != 0 {
+			t.Errorf("expected %s %s, got %s", d.name, d.expected.String(), d.actual.String())
+		}
+	}
+	if stats.Volume != 100 || stats.Trades != 4 {
+		t.Errorf("expected volume 100 from 4 trades, got %d from %d", stats.Volume, stats.Trades)
+	}
+}
+
+func TestTradeBook_StatisticsDontChange(t *testing.T) {
+	tb := NewTradeBook(instrument)
+	tb.Enter(Trade{Instrument: instrument, Qty: 10, Price: *apd.New(2010, -2), Total: *apd.New(20100, -2)})
+	stats := tb.Statistics()
+	turnover, vwap := stats.Turnover.String(), stats.VWAP.String()
+
+	tb.Enter(Trade{Instrument: instrument, Qty: 10, Price: *apd.New(2030, -2), Total: *apd.New(20300, -2)})
+	if stats.Turnover.String() != turnover || stats.VWAP.String() != vwap {
+		t.Errorf("expected returned statistics to keep turnover %s and VWAP %s, got %s and %s", turnover, vwap, stats.Turnover.String(), stats.VWAP.String())
+	}
+}
@@ -0,0 +1,320 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Frame opcodes.
+const (
+	OpContinuation byte = 0x0
+	OpText         byte = 0x1
+	OpBinary       byte = 0x2
+	OpClose        byte = 0x8
+	OpPing         byte = 0x9
+	OpPong         byte = 0xA
+)
+
+// Maximum size of a received message, larger messages close the connection.
+const DefaultMaxMessageSize = 1 << 16
+
+// close status of a normal closure
+const closeNormal = 1000
+
+// appended to the handshake key to compute the accept key
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var (
+	ErrBadHandshake    = errors.New("bad websocket handshake")
+	ErrProtocol        = errors.New("websocket protocol error")
+	ErrMessageTooLarge = errors.New("websocket message too large")
+	ErrClosed          = errors.New("websocket connection is closed")
+)
+
+// RFC 6455 WebSocket connection. Messages can be read from one goroutine while they are written from others. Control
+// frames are handled while reading - pings are answered and close frames are echoed.
+type Conn struct {
+	conn           net.Conn
+	reader         *bufio.Reader
+	client         bool // clients mask their frames, servers don't
+	maxMessageSize int
+
+	writeMutex sync.Mutex
+	closeSent  bool
+}
+
+func newConn(conn net.Conn, reader *bufio.Reader, client bool) *Conn {
+	return &Conn{conn: conn, reader: reader, client: client, maxMessageSize: DefaultMaxMessageSize}
+}
+
+// Upgrade an HTTP request to a WebSocket connection. Invalid handshakes are answered with 400 Bad Request.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || key == "" || r.Header.Get("Sec-WebSocket-Version") != "13" ||
+		!headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, ErrBadHandshake.Error(), http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, ErrBadHandshake.Error(), http.StatusInternalServerError)
+		return nil, ErrBadHandshake
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	response := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return newConn(conn, rw.Reader, false), nil
+}
+
+// Connect to a ws:// or wss:// URL.
+func Dial(rawURL string) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	var conn net.Conn
+	switch u.Scheme {
+	case "ws":
+		conn, err = net.Dial("tcp", hostPort(u, "80"))
+	case "wss":
+		conn, err = tls.Dial("tcp", hostPort(u, "443"), &tls.Config{ServerName: u.Hostname()})
+	default:
+		return nil, ErrBadHandshake
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+	request := "GET " + u.RequestURI() + " HTTP/1.1\r\nHost: " + u.Host + "\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\nSec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := conn.Write([]byte(request)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, &http.Request{Method: http.MethodGet})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusSwitchingProtocols || response.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		conn.Close()
+		return nil, ErrBadHandshake
+	}
+	return newConn(conn, reader, true), nil
+}
+
+func hostPort(u *url.URL, defaultPort string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), defaultPort)
+}
+
+func acceptKey(key string) string {
+	hash := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// reports whether a comma separated header contains the token
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header[name] {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Set the maximum size of received messages.
+func (c *Conn) SetMaxMessageSize(size int) {
+	c.maxMessageSize = size
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Write a message in a single frame. Nothing can be written after a close frame.
+func (c *Conn) WriteMessage(opcode byte, data []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	if c.closeSent {
+		return ErrClosed
+	}
+	if opcode == OpClose {
+		c.closeSent = true
+	}
+
+	frame := make([]byte, 2, 14+len(data))
+	frame[0] = 0x80 | opcode
+	switch length := len(data); {
+	case length < 126:
+		frame[1] = byte(length)
+	case length <= 0xFFFF:
+		frame[1] = 126
+		frame = frame[:4]
+		binary.BigEndian.PutUint16(frame[2:], uint16(length))
+	default:
+		frame[1] = 127
+		frame = frame[:10]
+		binary.BigEndian.PutUint64(frame[2:], uint64(length))
+	}
+	if !c.client {
+		_, err := c.conn.Write(append(frame, data...))
+		return err
+	}
+	frame[1] |= 0x80
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return err
+	}
+	frame = append(frame, mask[:]...)
+	start := len(frame)
+	frame = append(frame, data...)
+	maskBytes(mask, frame[start:])
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// Read the next text or binary message, fragmented messages are reassembled. Returns ErrClosed after a close frame.
+func (c *Conn) ReadMessage() (byte, []byte, error) {
+	var opcode byte
+	var message []byte
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch op {
+		case OpPing:
+			if err := c.WriteMessage(OpPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case OpPong:
+			continue
+		case OpClose:
+			status := make([]byte, 2)
+			binary.BigEndian.PutUint16(status, closeNormal)
+			if len(payload) >= 2 {
+				copy(status, payload)
+			}
+			c.WriteMessage(OpClose, status)
+			return 0, nil, ErrClosed
+		case OpContinuation:
+			if opcode == 0 {
+				return 0, nil, ErrProtocol
+			}
+		case OpText, OpBinary:
+			if opcode != 0 {
+				return 0, nil, ErrProtocol
+			}
+			opcode = op
+		default:
+			return 0, nil, ErrProtocol
+		}
+		if len(message)+len(payload) > c.maxMessageSize {
+			return 0, nil, ErrMessageTooLarge
+		}
+		message = append(message, payload...)
+		if fin {
+			return opcode, message, nil
+		}
+	}
+}
+
+func (c *Conn) readFrame() (bool, byte, []byte, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(c.reader, header[:2]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, opcode := header[0]&0x80 != 0, header[0]&0x0F
+	masked, length := header[1]&0x80 != 0, uint64(header[1]&0x7F)
+	if header[0]&0x70 != 0 || masked == c.client {
+		return false, 0, nil, ErrProtocol // no extensions are negotiated, only clients mask frames
+	}
+	switch length {
+	case 126:
+		if _, err := io.ReadFull(c.reader, header[:2]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(header[:2]))
+	case 127:
+		if _, err := io.ReadFull(c.reader, header[:8]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(header[:8])
+	}
+	if opcode >= OpClose && (!fin || length > 125) {
+		return false, 0, nil, ErrProtocol // control frames can't be fragmented
+	}
+	if length > uint64(c.maxMessageSize) {
+		return false, 0, nil, ErrMessageTooLarge
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		maskBytes(mask, payload)
+	}
+	return fin, opcode, payload, nil
+}
+
+func maskBytes(mask [4]byte, data []byte) {
+	for i := range data {
+		data[i] ^= mask[i%4]
+	}
+}
+
+// Send a close frame (unless it was already sent) and close the connection.
+func (c *Conn) Close() error {
+	status := make([]byte, 2)
+	binary.BigEndian.PutUint16(status, closeNormal)
+	c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	c.WriteMessage(OpClose, status)
+	return c.conn.Close()
+}
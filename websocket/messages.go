@@ -0,0 +1,88 @@
+package websocket
+
+import (
+	"errors"
+	"github.com/cockroachdb/apd"
+	"github.com/ffhan/tome"
+	"time"
+)
+
+type MessageType string
+
+const (
+	// client requests
+	MessageSubscribe   MessageType = "subscribe"
+	MessageUnsubscribe MessageType = "unsubscribe"
+
+	// server messages
+	MessageSnapshot     MessageType = "snapshot"     // displayed price levels and top of book after the update with the sequence
+	MessageTrade        MessageType = "trade"        // trade of the instrument
+	MessageTop          MessageType = "top"          // best displayed price level of a side changed
+	MessageDepth        MessageType = "depth"        // displayed price level was added, changed or deleted
+	MessageUnsubscribed MessageType = "unsubscribed" // no updates of the instrument follow
+	MessageError        MessageType = "error"        // request failed
+)
+
+// Actions of depth updates.
+const (
+	ActionAdd    = "add"
+	ActionChange = "change"
+	ActionDelete = "delete"
+)
+
+var (
+	ErrInvalidRequest    = errors.New("invalid request")
+	ErrUnknownInstrument = errors.New("unknown instrument")
+	ErrAlreadySubscribed = errors.New("already subscribed")
+	ErrNotSubscribed     = errors.New("not subscribed")
+)
+
+// JSON text message of the streaming protocol, fields are set according to the message type. Updates of an instrument
+// (trade, top and depth) are sequenced, the first update after a snapshot has the sequence following the snapshot.
+type Message struct {
+	Type       MessageType `json:"type"`
+	Instrument string      `json:"instrument,omitempty"`
+	Sequence   uint64      `json:"sequence,omitempty"`
+
+	Bids []Level `json:"bids,omitempty"` // snapshot, best first
+	Asks []Level `json:"asks,omitempty"` // snapshot, best first
+	Bid  *Level  `json:"bid,omitempty"`  // snapshot top of book
+	Ask  *Level  `json:"ask,omitempty"`  // snapshot top of book
+
+	Side   string `json:"side,omitempty"`   // top, depth
+	Action string `json:"action,omitempty"` // depth
+	Level  *Level `json:"level,omitempty"`  // top (missing if the side is empty), depth (zero quantity if deleted)
+	Trade  *Trade `json:"trade,omitempty"`  // trade
+
+	Error string `json:"error,omitempty"`
+}
+
+// Displayed price level, prices are decimal strings.
+type Level struct {
+	Price string `json:"price"`
+	Qty   int64  `json:"qty"`
+	Count int    `json:"count"`
+}
+
+// Trade without order and customer data.
+type Trade struct {
+	ID            uint64    `json:"id"`
+	Qty           int64     `json:"qty"`
+	Price         string    `json:"price"`
+	Timestamp     time.Time `json:"timestamp"`
+	AggressorSide string    `json:"aggressor_side"`
+}
+
+func newLevel(price apd.Decimal, qty int64, count int) *Level {
+	return &Level{Price: price.Text('f'), Qty: qty, Count: count}
+}
+
+func newTrade(t tome.Trade) *Trade {
+	return &Trade{
+		ID:            t.ID,
+		Qty:           t.Qty,
+		Price:         t.Price.Text('f'),
+		Timestamp:     t.Timestamp,
+		AggressorSide: t.AggressorSide.String(),
+	}
+}
@@ -0,0 +1,396 @@
+// Package websocket streams trades, top of book and depth updates of order books to WebSocket subscribers.
+//
+// Clients subscribe to instruments with {"type":"subscribe","instrument":"..."} text messages. A subscription starts
+// with a snapshot of displayed price levels, followed by sequenced trade, top and depth updates of the instrument.
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/cockroachdb/apd"
+	"github.com/ffhan/tome"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Number of messages buffered for a subscriber, subscribers which fall further behind are disconnected.
+const DefaultSendBuffer = 1024
+
+// Time allowed to write a message to a subscriber.
+const DefaultWriteTimeout = 10 * time.Second
+
+// maximum size of a subscription request
+const maxRequestSize = 4096
+
+var ErrStreamerClosed = errors.New("streamer is closed")
+
+// Streams market data of order books. Register order books with WithMarketDataHandler(streamer.Handler(instrument)) and
+// their trade books with WithTradeCallback(streamer), seed the replicas of books which already have orders (e.g.
+// restored ones) with Seed, then serve WebSocket upgrade requests with the streamer. Handlers are called synchronously
+// while matching, so the streamer only updates its replica of the books and never waits for subscribers.
+type Streamer struct {
+	sendBuffer   int
+	writeTimeout time.Duration
+
+	mutex       sync.Mutex
+	books       map[string]*book // by instrument
+	subscribers map[*subscriber]struct{}
+	closed      bool
+	wg          sync.WaitGroup
+}
+
+// Option changes the default behaviour of a streamer.
+type Option func(s *Streamer)
+
+// Buffer up to size messages for each subscriber.
+func WithSendBuffer(size int) Option {
+	return func(s *Streamer) {
+		s.sendBuffer = size
+	}
+}
+
+// Disconnect subscribers which don't receive a message in time.
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(s *Streamer) {
+		s.writeTimeout = timeout
+	}
+}
+
+// Create a new streamer.
+func NewStreamer(opts ...Option) *Streamer {
+	s := &Streamer{
+		sendBuffer:   DefaultSendBuffer,
+		writeTimeout: DefaultWriteTimeout,
+		books:        make(map[string]*book),
+		subscribers:  make(map[*subscriber]struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// replica of the displayed price levels of an instrument
+type book struct {
+	instrument  string
+	sequence    uint64 // sequence of the last update
+	bids, asks  map[string]level
+	bid, ask    *Level
+	subscribers map[*subscriber]struct{}
+}
+
+type level struct {
+	price apd.Decimal
+	qty   int64
+	count int
+}
+
+func (b *book) levels(side tome.OrderSide) map[string]level {
+	if side == tome.SideBuy {
+		return b.bids
+	}
+	return b.asks
+}
+
+func (b *book) snapshot() Message {
+	msg := Message{Type: MessageSnapshot, Instrument: b.instrument, Sequence: b.sequence, Bid: b.bid, Ask: b.ask}
+	msg.Bids = sortedLevels(b.bids, true)
+	msg.Asks = sortedLevels(b.asks, false)
+	return msg
+}
+
+func sortedLevels(levels map[string]level, descending bool) []Level {
+	sorted := make([]level, 0, len(levels))
+	for _, l := range levels {
+		sorted = append(sorted, l)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		cmp := sorted[i].price.Cmp(&sorted[j].price)
+		return (descending && cmp > 0) || (!descending && cmp < 0)
+	})
+	result := make([]Level, len(sorted))
+	for i, l := range sorted {
+		result[i] = *newLevel(l.price, l.qty, l.count)
+	}
+	return result
+}
+
+// WebSocket connection subscribed to instruments
+type subscriber struct {
+	conn        *Conn
+	send        chan []byte
+	instruments map[string]struct{}
+	dropped     bool
+}
+
+// Returns the market data handler of an instrument, subscribers can subscribe to the instrument from now on.
+func (s *Streamer) Handler(instrument string) tome.MarketDataHandler {
+	s.mutex.Lock()
+	b := s.bookLocked(instrument)
+	s.mutex.Unlock()
+	return func(update tome.MarketDataUpdate) {
+		s.update(b, update)
+	}
+}
+
+// Replace the replica of an order book with its current displayed price levels. The book has to be registered with the
+// handler of its instrument, so updates following the seed are applied to the replica. Subscribers of the instrument
+// receive a new snapshot.
+func (s *Streamer) Seed(ob *tome.OrderBook) {
+	depth := ob.Depth(0)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	b := s.bookLocked(ob.Instrument)
+	b.bids, b.asks = seedLevels(depth.Bids), seedLevels(depth.Asks)
+	b.bid, b.ask = nil, nil
+	if len(depth.Bids) > 0 { // the first displayed level is the top of book
+		b.bid = newLevel(depth.Bids[0].Price, depth.Bids[0].Qty, depth.Bids[0].Count)
+	}
+	if len(depth.Asks) > 0 {
+		b.ask = newLevel(depth.Asks[0].Price, depth.Asks[0].Qty, depth.Asks[0].Count)
+	}
+	b.sequence += 1
+	snapshot := b.snapshot()
+	for sub := range b.subscribers {
+		s.sendMessageLocked(sub, snapshot)
+	}
+}
+
+func seedLevels(depth []tome.DepthLevel) map[string]level {
+	levels := make(map[string]level, len(depth))
+	for _, l := range depth {
+		levels[l.Price.Text('f')] = level{price: l.Price, qty: l.Qty, count: l.Count}
+	}
+	return levels
+}
+
+func (s *Streamer) bookLocked(instrument string) *book {
+	b, ok := s.books[instrument]
+	if !ok {
+		b = &book{
+			instrument:  instrument,
+			bids:        make(map[string]level),
+			asks:        make(map[string]level),
+			subscribers: make(map[*subscriber]struct{}),
+		}
+		s.books[instrument] = b
+	}
+	return b
+}
+
+// publish L1 and L2 updates, L3 updates aren't streamed
+func (s *Streamer) update(b *book, update tome.MarketDataUpdate) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	msg := Message{Type: MessageDepth, Side: update.Side.String()}
+	switch update.Type {
+	case tome.UpdateBest:
+		msg.Type = MessageTop
+		if update.Qty > 0 {
+			msg.Level = newLevel(update.Price, update.Qty, update.Count)
+		}
+		if update.Side == tome.SideBuy {
+			b.bid = msg.Level
+		} else {
+			b.ask = msg.Level
+		}
+	case tome.UpdateLevelAdd, tome.UpdateLevelChange:
+		msg.Action = ActionChange
+		if update.Type == tome.UpdateLevelAdd {
+			msg.Action = ActionAdd
+		}
+		msg.Level = newLevel(update.Price, update.Qty, update.Count)
+		b.levels(update.Side)[msg.Level.Price] = level{price: update.Price, qty: update.Qty, count: update.Count}
+	case tome.UpdateLevelDelete:
+		msg.Action = ActionDelete
+		msg.Level = newLevel(update.Price, 0, 0)
+		delete(b.levels(update.Side), msg.Level.Price)
+	default:
+		return
+	}
+	s.publishLocked(b, msg)
+}
+
+// Publish a trade, implements tome.TradeCallback.
+func (s *Streamer) Execute(trade tome.Trade) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.publishLocked(s.bookLocked(trade.Instrument), Message{Type: MessageTrade, Trade: newTrade(trade)})
+}
+
+// assign the next sequence number to an update and send it to subscribers of the instrument
+func (s *Streamer) publishLocked(b *book, msg Message) {
+	b.sequence += 1
+	if len(b.subscribers) == 0 {
+		return
+	}
+	msg.Instrument, msg.Sequence = b.instrument, b.sequence
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	for sub := range b.subscribers {
+		s.sendLocked(sub, data)
+	}
+}
+
+// send a message without waiting, a subscriber with a full buffer is disconnected
+func (s *Streamer) sendLocked(sub *subscriber, data []byte) {
+	if sub.dropped {
+		return
+	}
+	select {
+	case sub.send <- data:
+	default:
+		s.dropLocked(sub)
+	}
+}
+
+func (s *Streamer) sendMessageLocked(sub *subscriber, msg Message) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	s.sendLocked(sub, data)
+}
+
+// unsubscribe and disconnect a subscriber after its buffered messages are written
+func (s *Streamer) dropLocked(sub *subscriber) {
+	if sub.dropped {
+		return
+	}
+	sub.dropped = true
+	for name := range sub.instruments {
+		delete(s.books[name].subscribers, sub)
+	}
+	delete(s.subscribers, sub)
+	close(sub.send)
+}
+
+func (s *Streamer) drop(sub *subscriber) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.dropLocked(sub)
+}
+
+// Upgrade a request to a WebSocket connection and serve its subscriptions until it's closed.
+func (s *Streamer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	closed := s.closed
+	s.mutex.Unlock()
+	if closed {
+		http.Error(w, ErrStreamerClosed.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	conn, err := Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	conn.SetMaxMessageSize(maxRequestSize)
+	sub := &subscriber{conn: conn, send: make(chan []byte, s.sendBuffer), instruments: make(map[string]struct{})}
+
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		conn.Close()
+		return
+	}
+	s.subscribers[sub] = struct{}{}
+	s.wg.Add(2)
+	s.mutex.Unlock()
+
+	go s.write(sub)
+	defer s.wg.Done()
+	s.read(sub)
+}
+
+func (s *Streamer) read(sub *subscriber) {
+	for {
+		_, data, err := sub.conn.ReadMessage()
+		if err != nil {
+			s.drop(sub)
+			return
+		}
+		var request Message
+		if err := json.Unmarshal(data, &request); err != nil {
+			request = Message{}
+		}
+		s.handle(sub, request)
+	}
+}
+
+func (s *Streamer) handle(sub *subscriber, request Message) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var err error
+	switch request.Type {
+	case MessageSubscribe:
+		err = s.subscribeLocked(sub, request.Instrument)
+	case MessageUnsubscribe:
+		err = s.unsubscribeLocked(sub, request.Instrument)
+	default:
+		err = ErrInvalidRequest
+	}
+	if err != nil {
+		s.sendMessageLocked(sub, Message{Type: MessageError, Instrument: request.Instrument, Error: err.Error()})
+	}
+}
+
+// send a snapshot of the instrument, updates following it are sent until the subscriber unsubscribes
+func (s *Streamer) subscribeLocked(sub *subscriber, name string) error {
+	b, ok := s.books[name]
+	if !ok {
+		return ErrUnknownInstrument
+	}
+	if _, ok := sub.instruments[name]; ok {
+		return ErrAlreadySubscribed
+	}
+	sub.instruments[name] = struct{}{}
+	b.subscribers[sub] = struct{}{}
+	s.sendMessageLocked(sub, b.snapshot())
+	return nil
+}
+
+func (s *Streamer) unsubscribeLocked(sub *subscriber, name string) error {
+	if _, ok := sub.instruments[name]; !ok {
+		return ErrNotSubscribed
+	}
+	delete(sub.instruments, name)
+	delete(s.books[name].subscribers, sub)
+	s.sendMessageLocked(sub, Message{Type: MessageUnsubscribed, Instrument: name})
+	return nil
+}
+
+// write buffered messages until the subscriber is dropped, then close the connection
+func (s *Streamer) write(sub *subscriber) {
+	defer s.wg.Done()
+	defer sub.conn.Close()
+	for data := range sub.send {
+		sub.conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+		if err := sub.conn.WriteMessage(OpText, data); err != nil {
+			s.drop(sub)
+			return
+		}
+	}
+}
+
+// Disconnect all subscribers and wait until their connections are closed.
+func (s *Streamer) Close() error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return ErrStreamerClosed
+	}
+	s.closed = true
+	for sub := range s.subscribers {
+		s.dropLocked(sub)
+	}
+	s.mutex.Unlock()
+	s.wg.Wait()
+	return nil
+}
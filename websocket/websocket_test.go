@@ -0,0 +1,311 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/cockroachdb/apd"
+	"github.com/ffhan/tome"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const instrument = "TEST"
+
+func setup(t *testing.T) (*Streamer, *tome.OrderBook, string) {
+	streamer := NewStreamer()
+	tb := tome.NewTradeBook(instrument, tome.WithTradeCallback(streamer))
+	ob := tome.NewOrderBook(instrument, *apd.New(2000, -2), tb, tome.NOPOrderRepository, tome.WithMarketDataHandler(streamer.Handler(instrument)))
+	ts := httptest.NewServer(streamer)
+	t.Cleanup(func() {
+		streamer.Close()
+		ts.Close()
+	})
+	return streamer, ob, "ws" + strings.TrimPrefix(ts.URL, "http")
+}
+
+func limitOrder(id uint64, side tome.OrderSide, qty int64, price string) tome.Order {
+	p, _, _ := apd.NewFromString(price)
+	return tome.Order{
+		ID:         id,
+		Instrument: instrument,
+		Timestamp:  time.Now(),
+		Type:       tome.TypeLimit,
+		Qty:        qty,
+		Price:      *p,
+		Side:       side,
+	}
+}
+
+func add(t *testing.T, ob *tome.OrderBook, orders ...tome.Order) {
+	for _, order := range orders {
+		if _, err := ob.Add(order); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func dial(t *testing.T, url string) *Conn {
+	conn, err := Dial(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	return conn
+}
+
+func send(t *testing.T, conn *Conn, msg Message) {
+	data, _ := json.Marshal(msg)
+	if err := conn.WriteMessage(OpText, data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func receive(t *testing.T, conn *Conn, messageType MessageType) Message {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("expected %s, got %v", messageType, err)
+	}
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != messageType {
+		t.Fatalf("expected %s, got %+v", messageType, msg)
+	}
+	return msg
+}
+
+// local replica of displayed price levels, maintained from a snapshot and depth updates
+type replica map[string]map[string]Level
+
+func (r replica) apply(msg Message) {
+	levels := r[msg.Side]
+	if msg.Action == ActionDelete {
+		delete(levels, msg.Level.Price)
+	} else {
+		levels[msg.Level.Price] = *msg.Level
+	}
+}
+
+func (r replica) equals(side string, levels []tome.DepthLevel) bool {
+	if len(r[side]) != len(levels) {
+		return false
+	}
+	for _, l := range levels {
+		if replicated := r[side][l.Price.Text('f')]; replicated.Qty != l.Qty || replicated.Count != l.Count {
+			return false
+		}
+	}
+	return true
+}
+
+func TestStreamer(t *testing.T) {
+	_, ob, url := setup(t)
+	add(t, ob,
+		limitOrder(1, tome.SideSell, 10, "20.25"),
+		limitOrder(2, tome.SideSell, 5, "20.30"),
+		limitOrder(3, tome.SideBuy, 4, "20.10"),
+	)
+
+	conn := dial(t, url)
+	send(t, conn, Message{Type: MessageSubscribe, Instrument: instrument})
+	snapshot := receive(t, conn, MessageSnapshot)
+	if len(snapshot.Bids) != 1 || len(snapshot.Asks) != 2 || snapshot.Asks[0].Price != "20.2500" || snapshot.Ask.Qty != 10 || snapshot.Bid.Qty != 4 {
+		t.Fatalf("expected 1 bid and 2 asks starting with 10 at 20.25, got %+v", snapshot)
+	}
+	book := replica{"BUY": {}, "SELL": {}}
+	for _, l := range snapshot.Bids {
+		book["BUY"][l.Price] = l
+	}
+	for _, l := range snapshot.Asks {
+		book["SELL"][l.Price] = l
+	}
+
+	add(t, ob, limitOrder(4, tome.SideBuy, 12, "20.25"), limitOrder(5, tome.SideSell, 3, "20.35"))
+	if err := ob.Cancel(3); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []MessageType{
+		MessageTrade,             // 10 at 20.25
+		MessageDepth, MessageTop, // ask level 20.25 deleted, best ask 20.30
+		MessageDepth, MessageTop, // bid level 20.25 with the rest of order 4 is the best bid
+		MessageDepth, // ask level 20.35
+		MessageDepth, // bid level 20.10 cancelled
+	}
+	sequence := snapshot.Sequence
+	var trade *Trade
+	for _, messageType := range expected {
+		msg := receive(t, conn, messageType)
+		if msg.Instrument != instrument || msg.Sequence != sequence+1 {
+			t.Fatalf("expected update %d of %s, got %+v", sequence+1, instrument, msg)
+		}
+		sequence = msg.Sequence
+		switch msg.Type {
+		case MessageTrade:
+			trade = msg.Trade
+		case MessageDepth:
+			book.apply(msg)
+		}
+	}
+	if trade == nil || trade.Qty != 10 || trade.Price != "20.25" || trade.AggressorSide != "BUY" {
+		t.Errorf("expected a trade of 10 at 20.25, got %+v", trade)
+	}
+	depth := ob.Depth(0)
+	if !book.equals("BUY", depth.Bids) || !book.equals("SELL", depth.Asks) {
+		t.Errorf("expected the replica to match the book %+v, got %+v", depth, book)
+	}
+
+	send(t, conn, Message{Type: MessageUnsubscribe, Instrument: instrument})
+	receive(t, conn, MessageUnsubscribed)
+	add(t, ob, limitOrder(6, tome.SideBuy, 2, "20.00"))
+	send(t, conn, Message{Type: MessageSubscribe, Instrument: instrument})
+	if resubscribed := receive(t, conn, MessageSnapshot); resubscribed.Sequence <= sequence {
+		t.Errorf("expected a snapshot after sequence %d, got %d", sequence, resubscribed.Sequence)
+	}
+}
+
+func TestStreamer_Seed(t *testing.T) {
+	original := tome.NewOrderBook(instrument, *apd.New(2000, -2), tome.NewTradeBook(instrument), tome.NOPOrderRepository)
+	add(t, original, limitOrder(1, tome.SideSell, 10, "20.25"), limitOrder(2, tome.SideBuy, 4, "20.10"))
+	var snapshot bytes.Buffer
+	if err := original.Snapshot(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	streamer := NewStreamer()
+	tb := tome.NewTradeBook(instrument, tome.WithTradeCallback(streamer))
+	ob, _, err := tome.RestoreOrderBook(&snapshot, tb, tome.NOPOrderRepository, tome.WithMarketDataHandler(streamer.Handler(instrument)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamer.Seed(ob)
+	ts := httptest.NewServer(streamer)
+	t.Cleanup(func() {
+		streamer.Close()
+		ts.Close()
+	})
+
+	conn := dial(t, "ws"+strings.TrimPrefix(ts.URL, "http"))
+	send(t, conn, Message{Type: MessageSubscribe, Instrument: instrument})
+	msg := receive(t, conn, MessageSnapshot)
+	if len(msg.Bids) != 1 || len(msg.Asks) != 1 || msg.Bid == nil || msg.Bid.Qty != 4 || msg.Ask == nil || msg.Ask.Qty != 10 {
+		t.Fatalf("expected a snapshot of the restored book, got %+v", msg)
+	}
+	book := replica{"BUY": {msg.Bids[0].Price: msg.Bids[0]}, "SELL": {msg.Asks[0].Price: msg.Asks[0]}}
+
+	add(t, ob, limitOrder(3, tome.SideSell, 5, "20.25"))
+	book.apply(receive(t, conn, MessageDepth))
+	depth := ob.Depth(0)
+	if !book.equals("BUY", depth.Bids) || !book.equals("SELL", depth.Asks) {
+		t.Errorf("expected the replica to match the book %+v, got %+v", depth, book)
+	}
+}
+
+func TestStreamer_Errors(t *testing.T) {
+	_, _, url := setup(t)
+	conn := dial(t, url)
+
+	send(t, conn, Message{Type: MessageSubscribe, Instrument: "NONE"})
+	if msg := receive(t, conn, MessageError); msg.Error != ErrUnknownInstrument.Error() {
+		t.Errorf("expected %q, got %q", ErrUnknownInstrument, msg.Error)
+	}
+	send(t, conn, Message{Type: MessageUnsubscribe, Instrument: instrument})
+	if msg := receive(t, conn, MessageError); msg.Error != ErrNotSubscribed.Error() {
+		t.Errorf("expected %q, got %q", ErrNotSubscribed, msg.Error)
+	}
+	send(t, conn, Message{Type: MessageSubscribe, Instrument: instrument})
+	receive(t, conn, MessageSnapshot)
+	send(t, conn, Message{Type: MessageSubscribe, Instrument: instrument})
+	if msg := receive(t, conn, MessageError); msg.Error != ErrAlreadySubscribed.Error() {
+		t.Errorf("expected %q, got %q", ErrAlreadySubscribed, msg.Error)
+	}
+	if err := conn.WriteMessage(OpText, []byte("{")); err != nil {
+		t.Fatal(err)
+	}
+	if msg := receive(t, conn, MessageError); msg.Error != ErrInvalidRequest.Error() {
+		t.Errorf("expected %q, got %q", ErrInvalidRequest, msg.Error)
+	}
+
+	resp, err := http.Get("http" + strings.TrimPrefix(url, "ws"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected a bad handshake to be rejected, got %d", resp.StatusCode)
+	}
+}
+
+func TestStreamer_Close(t *testing.T) {
+	streamer, _, url := setup(t)
+	conn := dial(t, url)
+	send(t, conn, Message{Type: MessageSubscribe, Instrument: instrument})
+	receive(t, conn, MessageSnapshot)
+
+	if err := streamer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := conn.ReadMessage(); err != ErrClosed {
+		t.Errorf("expected the connection to be closed, got %v", err)
+	}
+}
+
+func TestConn(t *testing.T) {
+	a, b := net.Pipe()
+	server, client := newConn(a, bufio.NewReader(a), false), newConn(b, bufio.NewReader(b), true)
+	server.SetMaxMessageSize(1 << 17)
+
+	messages := [][]byte{[]byte("short"), bytes.Repeat([]byte{'m'}, 1000), bytes.Repeat([]byte{'l'}, 1<<16+1)}
+	errs := make(chan error, 1)
+	go func() {
+		if err := client.WriteMessage(OpPing, []byte("ping")); err != nil {
+			errs <- err
+			return
+		}
+		for _, msg := range messages {
+			if err := client.WriteMessage(OpBinary, msg); err != nil {
+				errs <- err
+				return
+			}
+		}
+		errs <- nil
+	}()
+	go func() {
+		// reads the pong until the server closes the connection
+		if _, _, err := client.ReadMessage(); err != ErrClosed {
+			errs <- err
+		}
+	}()
+
+	for _, expected := range messages {
+		opcode, msg, err := server.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opcode != OpBinary || !bytes.Equal(msg, expected) {
+			t.Errorf("expected a binary message of %d bytes, got %d bytes with opcode %d", len(expected), len(msg), opcode)
+		}
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	server.SetMaxMessageSize(10)
+	go client.WriteMessage(OpText, []byte("too large message"))
+	if _, _, err := server.ReadMessage(); err != ErrMessageTooLarge {
+		t.Errorf("expected %v, got %v", ErrMessageTooLarge, err)
+	}
+	server.Close()
+	client.Close()
+}